@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 	"github.com/tidwall/gjson"
 )
 
@@ -185,6 +187,74 @@ func main() {
 
 	})
 
+	// returns a fixed embedding for every input
+	r.POST("/v1/embeddings", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		inputs := 1
+		if input := gjson.GetBytes(body, "input"); input.IsArray() {
+			inputs = len(input.Array())
+		}
+
+		data := make([]gin.H, 0, inputs)
+		for i := 0; i < inputs; i++ {
+			data = append(data, gin.H{"object": "embedding", "index": i, "embedding": []float64{0.1, 0.2, 0.3}})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"object": "list",
+			"model":  gjson.GetBytes(body, "model").String(),
+			"data":   data,
+			"usage": gin.H{
+				"prompt_tokens": 5 * inputs,
+				"total_tokens":  5 * inputs,
+			},
+		})
+	})
+
+	// llama-server compatibility: /tokenize, one token per word
+	r.POST("/tokenize", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		words := strings.Fields(gjson.GetBytes(body, "content").String())
+		tokens := make([]int, len(words))
+		for i := range tokens {
+			tokens[i] = i
+		}
+		c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+	})
+
+	// OpenAI realtime style WebSocket sessions
+	upgrader := websocket.Upgrader{Subprotocols: []string{"realtime"}}
+	r.GET("/v1/realtime", func(c *gin.Context) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteJSON(gin.H{
+			"type":     "session.created",
+			"session":  gin.H{"model": c.Query("model")},
+			"protocol": conn.Subprotocol(),
+			"auth":     c.GetHeader("Authorization"),
+		})
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if gjson.GetBytes(message, "type").String() == "response.create" {
+				conn.WriteJSON(gin.H{
+					"type": "response.done",
+					"response": gin.H{
+						"output": *responseMessage,
+						"usage":  gin.H{"input_tokens": 25, "output_tokens": 10},
+					},
+				})
+				continue
+			}
+			conn.WriteJSON(gin.H{"type": "echo", "message": json.RawMessage(message)})
+		}
+	})
+
 	// llama-server compatibility: /completion
 	r.POST("/completion", func(c *gin.Context) {
 		c.Header("Content-Type", "application/json")
@@ -233,8 +303,9 @@ func main() {
 
 		// Return a JSON response with the model and transcription text including file size
 		c.JSON(http.StatusOK, gin.H{
-			"text":  fmt.Sprintf("The length of the file is %d bytes", fileSize),
-			"model": model,
+			"text":   fmt.Sprintf("The length of the file is %d bytes", fileSize),
+			"model":  model,
+			"fields": c.Request.MultipartForm.Value,
 
 			// expose some header values for testing
 			"h_content_type":   c.GetHeader("Content-Type"),
@@ -97,6 +97,9 @@ func main() {
 			currentPM.Shutdown()
 			newPM := proxy.New(conf)
 			newPM.SetVersion(date, commit, version)
+			newPM.SetConfigPath(*configPath)
+			// keep ephemeral models added through the API across reloads
+			newPM.ApplyModelOverlays(currentPM.ModelOverlays())
 			srv.Handler = newPM
 			fmt.Println("Configuration Reloaded")
 
@@ -114,6 +117,7 @@ func main() {
 			}
 			newPM := proxy.New(conf)
 			newPM.SetVersion(date, commit, version)
+			newPM.SetConfigPath(*configPath)
 			srv.Handler = newPM
 		}
 	}
@@ -170,6 +174,23 @@ func main() {
 		}()
 	}
 
+	// optional listener for the Ollama API without a path prefix, it always
+	// serves through the current ProxyManager so config reloads apply to it.
+	// Changing ollama.listen requires a restart.
+	var ollamaSrv *http.Server
+	if conf.Ollama.Enabled && conf.Ollama.Listen != "" {
+		ollamaSrv = &http.Server{
+			Addr: conf.Ollama.Listen,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if pm, ok := srv.Handler.(*proxy.ProxyManager); ok {
+					pm.ServeOllamaHTTP(w, r)
+				} else {
+					http.NotFound(w, r)
+				}
+			}),
+		}
+	}
+
 	// shutdown on signal
 	go func() {
 		sig := <-sigChan
@@ -186,6 +207,11 @@ func main() {
 		if err := srv.Shutdown(ctx); err != nil {
 			fmt.Printf("Server shutdown error: %v\n", err)
 		}
+		if ollamaSrv != nil {
+			if err := ollamaSrv.Shutdown(ctx); err != nil {
+				fmt.Printf("Ollama server shutdown error: %v\n", err)
+			}
+		}
 		close(exitChan)
 	}()
 
@@ -204,6 +230,15 @@ func main() {
 		}
 	}()
 
+	if ollamaSrv != nil {
+		go func() {
+			fmt.Printf("llama-swap Ollama API listening on http://%s\n", ollamaSrv.Addr)
+			if err := ollamaSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Fatal Ollama server error: %v\n", err)
+			}
+		}()
+	}
+
 	// Wait for exit signal
 	<-exitChan
 }
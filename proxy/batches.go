@@ -0,0 +1,813 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Offline batches, see proxymanager_batches.go for the HTTP API. Uploaded
+// JSONL files are run by a single background worker that replays each line
+// through the normal inference routes. Requests are grouped by model to
+// avoid swapping and, with batches.idleOnly, only run while no interactive
+// requests are in flight.
+
+const (
+	batchStatusFailed     = "failed"
+	batchStatusInProgress = "in_progress"
+	batchStatusFinalizing = "finalizing"
+	batchStatusCompleted  = "completed"
+	batchStatusExpired    = "expired"
+	batchStatusCancelling = "cancelling"
+	batchStatusCancelled  = "cancelled"
+)
+
+// the endpoints batch requests can be sent to
+var batchEndpoints = []string{"/v1/chat/completions", "/v1/completions", "/v1/embeddings", "/v1/responses"}
+
+// how often the worker checks for work and for interactive requests to finish
+var batchPollInterval = time.Second
+
+// the longest JSONL line accepted in a batch input file
+const batchMaxLineBytes = 64 << 20
+
+type batchFile struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+}
+
+type batchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+type batchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   any    `json:"param"`
+	Line    *int   `json:"line"`
+}
+
+type batchErrors struct {
+	Object string       `json:"object"`
+	Data   []batchError `json:"data"`
+}
+
+// batchObject is a batch as returned by the API
+type batchObject struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *batchErrors       `json:"errors"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     *string            `json:"output_file_id"`
+	ErrorFileID      *string            `json:"error_file_id"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     *int64             `json:"in_progress_at"`
+	ExpiresAt        *int64             `json:"expires_at"`
+	FinalizingAt     *int64             `json:"finalizing_at"`
+	CompletedAt      *int64             `json:"completed_at"`
+	FailedAt         *int64             `json:"failed_at"`
+	ExpiredAt        *int64             `json:"expired_at"`
+	CancellingAt     *int64             `json:"cancelling_at"`
+	CancelledAt      *int64             `json:"cancelled_at"`
+	RequestCounts    batchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata"`
+}
+
+// batchJob is a batch with the state the worker needs to run it. It is saved
+// to disk so batches continue after a restart.
+type batchJob struct {
+	Batch batchObject `json:"batch"`
+
+	// results are written to these files while the batch runs and are
+	// published as files when it is finished
+	OutputFileID string `json:"output_file_id"`
+	ErrorFileID  string `json:"error_file_id"`
+
+	pending []batchLine
+}
+
+// batchLine is one request of a batch input file
+type batchLine struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+
+	// the model ID the request is grouped by
+	model string
+}
+
+// errBatchNotFound is returned for unknown file and batch ids
+var errBatchNotFound = fmt.Errorf("not found")
+
+type batchManager struct {
+	sync.Mutex
+
+	pm       *ProxyManager
+	dir      string
+	idleOnly bool
+
+	files map[string]*batchFile
+	jobs  map[string]*batchJob
+
+	// the model the worker ran last, it is kept until it has no pending requests
+	currentModel string
+	wake         chan struct{}
+
+	// stops the worker, stopped is closed once it returned
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+func newBatchManager(pm *ProxyManager, dir string, idleOnly bool) (*batchManager, error) {
+	m := &batchManager{
+		pm:       pm,
+		dir:      dir,
+		idleOnly: idleOnly,
+		files:    make(map[string]*batchFile),
+		jobs:     make(map[string]*batchJob),
+		wake:     make(chan struct{}, 1),
+	}
+	for _, sub := range []string{"files", "batches"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("unable to create batches directory: %w", err)
+		}
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *batchManager) fileMetaPath(id string) string {
+	return filepath.Join(m.dir, "files", id+".json")
+}
+
+func (m *batchManager) fileContentPath(id string) string {
+	return filepath.Join(m.dir, "files", id+".jsonl")
+}
+
+func (m *batchManager) jobPath(id string) string {
+	return filepath.Join(m.dir, "batches", id+".json")
+}
+
+// load reads files and batches saved by a previous run and resumes
+// unfinished batches
+func (m *batchManager) load() error {
+	metas, err := filepath.Glob(filepath.Join(m.dir, "files", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range metas {
+		var file batchFile
+		if err := readJSONFile(path, &file); err != nil {
+			m.pm.proxyLogger.Warnf("batches: skipping file %s: %v", path, err)
+			continue
+		}
+		m.files[file.ID] = &file
+	}
+
+	jobs, err := filepath.Glob(filepath.Join(m.dir, "batches", "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range jobs {
+		var job batchJob
+		if err := readJSONFile(path, &job); err != nil {
+			m.pm.proxyLogger.Warnf("batches: skipping batch %s: %v", path, err)
+			continue
+		}
+		if job.Batch.Status == batchStatusInProgress || job.Batch.Status == batchStatusCancelling {
+			if err := m.resume(&job); err != nil {
+				m.pm.proxyLogger.Errorf("batches: unable to resume %s: %v", job.Batch.ID, err)
+				m.fail(&job, []batchError{{Code: "resume_failed", Message: err.Error()}})
+			}
+		}
+		m.jobs[job.Batch.ID] = &job
+	}
+	return nil
+}
+
+// resume rebuilds the pending requests of a batch from its input file and
+// the results written so far
+func (m *batchManager) resume(job *batchJob) error {
+	lines, errs := m.parseInput(m.fileContentPath(job.Batch.InputFileID), job.Batch.Endpoint)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", errs[0].Message)
+	}
+
+	done := make(map[string]bool)
+	job.Batch.RequestCounts = batchRequestCounts{Total: len(lines)}
+	for _, id := range []string{job.OutputFileID, job.ErrorFileID} {
+		data, err := os.ReadFile(m.fileContentPath(id))
+		if err != nil {
+			continue
+		}
+		for _, result := range bytes.Split(data, []byte("\n")) {
+			if customID := gjson.GetBytes(result, "custom_id"); customID.Exists() {
+				done[customID.String()] = true
+				if id == job.OutputFileID {
+					job.Batch.RequestCounts.Completed++
+				} else {
+					job.Batch.RequestCounts.Failed++
+				}
+			}
+		}
+	}
+	for _, line := range lines {
+		if !done[line.CustomID] {
+			job.pending = append(job.pending, line)
+		}
+	}
+	return nil
+}
+
+// parseInput reads and validates a batch input file
+func (m *batchManager) parseInput(path, endpoint string) ([]batchLine, []batchError) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, []batchError{{Code: "invalid_file", Message: fmt.Sprintf("unable to read input file: %v", err)}}
+	}
+	defer file.Close()
+
+	var lines []batchLine
+	var errs []batchError
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), batchMaxLineBytes)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		lineError := func(code, message string) {
+			n := lineNumber
+			errs = append(errs, batchError{Code: code, Message: message, Line: &n})
+		}
+
+		var line batchLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			lineError("invalid_json_line", "line is not valid JSON")
+			continue
+		}
+		model := gjson.GetBytes(line.Body, "model").String()
+		switch {
+		case line.CustomID == "":
+			lineError("missing_required_parameter", "custom_id is required")
+		case seen[line.CustomID]:
+			lineError("duplicate_custom_id", fmt.Sprintf("custom_id %s is used more than once", line.CustomID))
+		case !strings.EqualFold(line.Method, http.MethodPost):
+			lineError("invalid_method", "method must be POST")
+		case line.URL != endpoint:
+			lineError("mismatched_url", fmt.Sprintf("url must match the batch endpoint %s", endpoint))
+		case model == "":
+			lineError("missing_required_parameter", "body.model is required")
+		default:
+			seen[line.CustomID] = true
+			line.model = model
+			if modelID, found := m.pm.snapshot.Load().config.RealModelName(model); found {
+				line.model = modelID
+			}
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, batchError{Code: "invalid_file", Message: fmt.Sprintf("unable to read input file: %v", err)})
+	}
+	if len(lines) == 0 && len(errs) == 0 {
+		errs = append(errs, batchError{Code: "empty_file", Message: "input file has no requests"})
+	}
+	return lines, errs
+}
+
+func (m *batchManager) createFile(filename, purpose string, content io.Reader) (batchFile, error) {
+	file := batchFile{
+		ID:        newTranslationID("file-"),
+		Object:    "file",
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+		Status:    "processed",
+	}
+
+	out, err := os.Create(m.fileContentPath(file.ID))
+	if err != nil {
+		return batchFile{}, err
+	}
+	file.Bytes, err = io.Copy(out, content)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(m.fileContentPath(file.ID))
+		return batchFile{}, err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if err := writeJSONFile(m.fileMetaPath(file.ID), file); err != nil {
+		return batchFile{}, err
+	}
+	m.files[file.ID] = &file
+	return file, nil
+}
+
+func (m *batchManager) listFiles() []batchFile {
+	m.Lock()
+	defer m.Unlock()
+	files := make([]batchFile, 0, len(m.files))
+	for _, file := range m.files {
+		files = append(files, *file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt > files[j].CreatedAt
+		}
+		return files[i].ID < files[j].ID
+	})
+	return files
+}
+
+func (m *batchManager) getFile(id string) (batchFile, bool) {
+	m.Lock()
+	defer m.Unlock()
+	file, found := m.files[id]
+	if !found {
+		return batchFile{}, false
+	}
+	return *file, true
+}
+
+func (m *batchManager) deleteFile(id string) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, found := m.files[id]; !found {
+		return errBatchNotFound
+	}
+	for _, job := range m.jobs {
+		if job.Batch.InputFileID == id && (job.Batch.Status == batchStatusInProgress || job.Batch.Status == batchStatusCancelling) {
+			return fmt.Errorf("file %s is used by batch %s which has not finished", id, job.Batch.ID)
+		}
+	}
+	delete(m.files, id)
+	os.Remove(m.fileContentPath(id))
+	return os.Remove(m.fileMetaPath(id))
+}
+
+// createBatch validates the input file and queues its requests. Invalid input
+// files result in a failed batch, like the OpenAI API.
+func (m *batchManager) createBatch(inputFileID, endpoint, completionWindow string, metadata map[string]string) (batchObject, error) {
+	if !isBatchEndpoint(endpoint) {
+		return batchObject{}, fmt.Errorf("endpoint must be one of: %s", strings.Join(batchEndpoints, ", "))
+	}
+	window, err := time.ParseDuration(completionWindow)
+	if err != nil || window <= 0 {
+		return batchObject{}, fmt.Errorf("invalid completion_window %q, e.g. 24h", completionWindow)
+	}
+	file, found := m.getFile(inputFileID)
+	if !found {
+		return batchObject{}, fmt.Errorf("input file %s not found", inputFileID)
+	}
+	if file.Purpose != "batch" {
+		return batchObject{}, fmt.Errorf("input file %s must have the purpose batch", inputFileID)
+	}
+
+	now := time.Now()
+	createdAt := now.Unix()
+	expiresAt := now.Add(window).Unix()
+	job := &batchJob{
+		Batch: batchObject{
+			ID:               newTranslationID("batch_"),
+			Object:           "batch",
+			Endpoint:         endpoint,
+			InputFileID:      inputFileID,
+			CompletionWindow: completionWindow,
+			Status:           batchStatusInProgress,
+			CreatedAt:        createdAt,
+			InProgressAt:     &createdAt,
+			ExpiresAt:        &expiresAt,
+			Metadata:         metadata,
+		},
+		OutputFileID: newTranslationID("file-"),
+		ErrorFileID:  newTranslationID("file-"),
+	}
+
+	lines, errs := m.parseInput(m.fileContentPath(inputFileID), endpoint)
+
+	m.Lock()
+	defer m.Unlock()
+	m.jobs[job.Batch.ID] = job
+	if len(errs) > 0 {
+		m.fail(job, errs)
+		return job.Batch, nil
+	}
+	job.pending = lines
+	job.Batch.RequestCounts.Total = len(lines)
+	m.save(job)
+	m.notify()
+	return job.Batch, nil
+}
+
+func (m *batchManager) getBatch(id string) (batchObject, bool) {
+	m.Lock()
+	defer m.Unlock()
+	job, found := m.jobs[id]
+	if !found {
+		return batchObject{}, false
+	}
+	return job.Batch, true
+}
+
+// listBatches returns batches, newest first
+func (m *batchManager) listBatches() []batchObject {
+	m.Lock()
+	defer m.Unlock()
+	batches := make([]batchObject, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		batches = append(batches, job.Batch)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		if batches[i].CreatedAt != batches[j].CreatedAt {
+			return batches[i].CreatedAt > batches[j].CreatedAt
+		}
+		return batches[i].ID > batches[j].ID
+	})
+	return batches
+}
+
+// cancelBatch stops a batch, the worker finishes it once any running request is done
+func (m *batchManager) cancelBatch(id string) (batchObject, error) {
+	m.Lock()
+	defer m.Unlock()
+	job, found := m.jobs[id]
+	if !found {
+		return batchObject{}, errBatchNotFound
+	}
+	if job.Batch.Status != batchStatusInProgress {
+		return job.Batch, fmt.Errorf("batch %s can not be cancelled, it is %s", id, job.Batch.Status)
+	}
+	now := time.Now().Unix()
+	job.Batch.Status = batchStatusCancelling
+	job.Batch.CancellingAt = &now
+	m.save(job)
+	m.notify()
+	return job.Batch, nil
+}
+
+func (m *batchManager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save writes the batch state to disk, the caller must hold the lock
+func (m *batchManager) save(job *batchJob) {
+	if err := writeJSONFile(m.jobPath(job.Batch.ID), job); err != nil {
+		m.pm.proxyLogger.Errorf("batches: unable to save %s: %v", job.Batch.ID, err)
+	}
+}
+
+func (m *batchManager) fail(job *batchJob, errs []batchError) {
+	now := time.Now().Unix()
+	job.Batch.Status = batchStatusFailed
+	job.Batch.FailedAt = &now
+	job.Batch.Errors = &batchErrors{Object: "list", Data: errs}
+	job.pending = nil
+	m.save(job)
+}
+
+// finish publishes the result files of a batch, the caller must hold the lock
+func (m *batchManager) finish(job *batchJob, status string) {
+	now := time.Now().Unix()
+	job.Batch.FinalizingAt = &now
+	job.pending = nil
+
+	publish := func(id, suffix string) *string {
+		info, err := os.Stat(m.fileContentPath(id))
+		if err != nil || info.Size() == 0 {
+			return nil
+		}
+		file := batchFile{
+			ID:        id,
+			Object:    "file",
+			Bytes:     info.Size(),
+			CreatedAt: now,
+			Filename:  job.Batch.ID + suffix,
+			Purpose:   "batch_output",
+			Status:    "processed",
+		}
+		if err := writeJSONFile(m.fileMetaPath(id), file); err != nil {
+			m.pm.proxyLogger.Errorf("batches: unable to save %s: %v", id, err)
+			return nil
+		}
+		m.files[id] = &file
+		return &file.ID
+	}
+	job.Batch.OutputFileID = publish(job.OutputFileID, "_output.jsonl")
+	job.Batch.ErrorFileID = publish(job.ErrorFileID, "_error.jsonl")
+
+	job.Batch.Status = status
+	switch status {
+	case batchStatusCompleted:
+		job.Batch.CompletedAt = &now
+	case batchStatusExpired:
+		job.Batch.ExpiredAt = &now
+	case batchStatusCancelled:
+		job.Batch.CancelledAt = &now
+	}
+	m.save(job)
+}
+
+// modelLoaded returns true when the model is running and requests for it do not need a swap
+func modelLoaded(snap *proxySnapshot, modelID string) bool {
+	process := snap.findProcessByModelName(modelID)
+	return process != nil && process.CurrentState() == StateReady
+}
+
+// next takes the next request to run. The model that ran last is kept while
+// it has pending requests, then a loaded model is preferred, then the model
+// with the most pending requests.
+func (m *batchManager) next() (*batchJob, batchLine, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now().Unix()
+	var jobs []*batchJob
+	pendingByModel := make(map[string]int)
+	for _, job := range m.jobs {
+		switch job.Batch.Status {
+		case batchStatusCancelling:
+			m.finish(job, batchStatusCancelled)
+		case batchStatusInProgress:
+			if job.Batch.ExpiresAt != nil && now > *job.Batch.ExpiresAt {
+				m.finish(job, batchStatusExpired)
+				continue
+			}
+			if len(job.pending) == 0 {
+				m.finish(job, batchStatusCompleted)
+				continue
+			}
+			jobs = append(jobs, job)
+			for _, line := range job.pending {
+				pendingByModel[line.model]++
+			}
+		}
+	}
+	if len(jobs) == 0 {
+		return nil, batchLine{}, false
+	}
+
+	if pendingByModel[m.currentModel] == 0 {
+		// models can be changed through the API while the batch worker runs
+		snap := m.pm.snapshot.Load()
+		models := make([]string, 0, len(pendingByModel))
+		loaded := make(map[string]bool, len(pendingByModel))
+		for model := range pendingByModel {
+			models = append(models, model)
+			loaded[model] = modelLoaded(snap, model)
+		}
+		sort.Slice(models, func(i, j int) bool {
+			iLoaded, jLoaded := loaded[models[i]], loaded[models[j]]
+			if iLoaded != jLoaded {
+				return iLoaded
+			}
+			if pendingByModel[models[i]] != pendingByModel[models[j]] {
+				return pendingByModel[models[i]] > pendingByModel[models[j]]
+			}
+			return models[i] < models[j]
+		})
+		m.currentModel = models[0]
+	}
+
+	// oldest batches first
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Batch.CreatedAt != jobs[j].Batch.CreatedAt {
+			return jobs[i].Batch.CreatedAt < jobs[j].Batch.CreatedAt
+		}
+		return jobs[i].Batch.ID < jobs[j].Batch.ID
+	})
+	for _, job := range jobs {
+		for i, line := range job.pending {
+			if line.model == m.currentModel {
+				job.pending = append(job.pending[:i], job.pending[i+1:]...)
+				return job, line, true
+			}
+		}
+	}
+	return nil, batchLine{}, false
+}
+
+// start runs the batch worker until stop is called or ctx is done
+func (m *batchManager) start(ctx context.Context) {
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.stopped = make(chan struct{})
+	go func() {
+		defer close(m.stopped)
+		m.run(ctx)
+	}()
+}
+
+// stop cancels the running request and waits for the worker to return. The
+// cancelled request is not recorded, it runs again when the batch resumes.
+func (m *batchManager) stop() {
+	m.cancel()
+	<-m.stopped
+}
+
+// run is the batch worker, it stops when ctx is done
+func (m *batchManager) run(ctx context.Context) {
+	ticker := time.NewTicker(batchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if !m.idleOnly || m.pm.interactiveRequests.Load() == 0 {
+			if job, line, found := m.next(); found {
+				m.execute(ctx, job, line)
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// execute runs a single batch request through the inference routes and
+// records the result
+func (m *batchManager) execute(ctx context.Context, job *batchJob, line batchLine) {
+	body := []byte(line.Body)
+	if gjson.GetBytes(body, "stream").Exists() {
+		body, _ = sjson.SetBytes(body, "stream", false)
+	}
+
+	// set when a higher priority request aborts this one, see priority.go
+	preempted := &atomic.Bool{}
+	ctx = context.WithValue(ctx, proxyCtxKey("batch"), job.Batch.ID)
+	ctx = context.WithValue(ctx, proxyCtxKey("preempted"), preempted)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, line.URL, bytes.NewReader(body))
+	if err != nil {
+		m.record(job, line, http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	w := newBatchResponseWriter()
+	m.pm.ServeHTTP(w, req)
+	if (preempted.Load() || ctx.Err() != nil) && m.requeue(job, line) {
+		return
+	}
+	m.record(job, line, w.status, w.body.Bytes())
+}
+
+// requeue puts back a request that was preempted or stopped so it runs again
+// later. It returns false when the batch is being cancelled.
+func (m *batchManager) requeue(job *batchJob, line batchLine) bool {
+	m.Lock()
+	defer m.Unlock()
+	if job.Batch.Status == batchStatusCancelling {
+		return false
+	}
+	job.pending = append([]batchLine{line}, job.pending...)
+	return true
+}
+
+func (m *batchManager) record(job *batchJob, line batchLine, status int, body []byte) {
+	var responseBody any = string(body)
+	if gjson.ValidBytes(body) {
+		responseBody = json.RawMessage(body)
+	}
+	result := map[string]any{
+		"id":        newTranslationID("batch_req_"),
+		"custom_id": line.CustomID,
+		"response": map[string]any{
+			"status_code": status,
+			"request_id":  "",
+			"body":        responseBody,
+		},
+		"error": nil,
+	}
+	data, _ := json.Marshal(result)
+
+	m.Lock()
+	defer m.Unlock()
+
+	fileID := job.OutputFileID
+	if status >= 200 && status < 300 {
+		job.Batch.RequestCounts.Completed++
+	} else {
+		fileID = job.ErrorFileID
+		job.Batch.RequestCounts.Failed++
+	}
+	if err := appendLine(m.fileContentPath(fileID), data); err != nil {
+		m.pm.proxyLogger.Errorf("batches: unable to write result for %s: %v", job.Batch.ID, err)
+	}
+
+	switch {
+	case job.Batch.Status == batchStatusCancelling:
+		m.finish(job, batchStatusCancelled)
+	case len(job.pending) == 0:
+		job.Batch.Status = batchStatusFinalizing
+		m.finish(job, batchStatusCompleted)
+	default:
+		m.save(job)
+	}
+}
+
+func isBatchEndpoint(endpoint string) bool {
+	for _, candidate := range batchEndpoints {
+		if candidate == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// isBatchRequest returns true for requests made by the batch worker
+func isBatchRequest(r *http.Request) bool {
+	return r.Context().Value(proxyCtxKey("batch")) != nil
+}
+
+// batchResponseWriter collects the response of a batch request
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *batchResponseWriter) Header() http.Header         { return w.header }
+func (w *batchResponseWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
+func (w *batchResponseWriter) WriteHeader(statusCode int)  { w.status = statusCode }
+func (w *batchResponseWriter) Flush()                      {}
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile replaces path through a temporary file so a crash does not
+// leave a partial file behind
+func writeJSONFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func appendLine(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func batchTestConfig(t *testing.T, dir string) config.Config {
+	t.Helper()
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+batches:
+  enabled: true
+  path: %s
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+  model2:
+    cmd: %s --port ${PORT} --silent --respond model2
+`, dir, simpleResponderPath, simpleResponderPath)))
+	require.NoError(t, err)
+	return cfg
+}
+
+func batchUpload(t *testing.T, proxy *ProxyManager, content string) string {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("purpose", "batch"))
+	part, err := form.CreateFormFile("file", "input.jsonl")
+	require.NoError(t, err)
+	part.Write([]byte(content))
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest("POST", "/v1/files", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	return gjson.Get(w.Body.String(), "id").String()
+}
+
+func batchServe(proxy *ProxyManager, method, path, body string) *TestResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	return w
+}
+
+func batchCreate(t *testing.T, proxy *ProxyManager, fileID string) string {
+	t.Helper()
+	w := batchServe(proxy, "POST", "/v1/batches", fmt.Sprintf(`{"input_file_id": %q, "endpoint": "/v1/chat/completions", "completion_window": "24h"}`, fileID))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	return gjson.Get(w.Body.String(), "id").String()
+}
+
+func batchWait(t *testing.T, proxy *ProxyManager, batchID string) gjson.Result {
+	t.Helper()
+	var batch gjson.Result
+	require.Eventually(t, func() bool {
+		w := batchServe(proxy, "GET", "/v1/batches/"+batchID, "")
+		batch = gjson.Parse(w.Body.String())
+		return batch.Get("completed_at").Exists() && batch.Get("completed_at").Type != gjson.Null
+	}, 10*time.Second, 20*time.Millisecond)
+	return batch
+}
+
+func batchLineJSON(customID, model string) string {
+	line, _ := json.Marshal(map[string]any{
+		"custom_id": customID,
+		"method":    "POST",
+		"url":       "/v1/chat/completions",
+		"body":      map[string]any{"model": model, "messages": []any{}},
+	})
+	return string(line) + "\n"
+}
+
+func TestProxyManager_Batches(t *testing.T) {
+	defer func(interval time.Duration) { batchPollInterval = interval }(batchPollInterval)
+	batchPollInterval = 10 * time.Millisecond
+
+	dir := t.TempDir()
+	proxy := New(batchTestConfig(t, dir))
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+	defer proxy.Shutdown()
+
+	fileID := batchUpload(t, proxy, batchLineJSON("a", "model1")+batchLineJSON("b", "model2")+batchLineJSON("c", "model1")+batchLineJSON("d", "nope"))
+	batchID := batchCreate(t, proxy, fileID)
+
+	batch := batchWait(t, proxy, batchID)
+	assert.Equal(t, batchStatusCompleted, batch.Get("status").String())
+	assert.Equal(t, int64(4), batch.Get("request_counts.total").Int())
+	assert.Equal(t, int64(3), batch.Get("request_counts.completed").Int())
+	assert.Equal(t, int64(1), batch.Get("request_counts.failed").Int())
+
+	t.Run("results are grouped by model", func(t *testing.T) {
+		w := batchServe(proxy, "GET", "/v1/files/"+batch.Get("output_file_id").String()+"/content", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		lines := ndjsonLines(t, w.Body.String())
+		require.Len(t, lines, 3)
+		assert.Equal(t, "a", lines[0].Get("custom_id").String())
+		assert.Equal(t, "c", lines[1].Get("custom_id").String())
+		assert.Equal(t, "b", lines[2].Get("custom_id").String())
+		assert.Equal(t, int64(200), lines[0].Get("response.status_code").Int())
+		assert.Equal(t, "model1", lines[0].Get("response.body.responseMessage").String())
+		assert.Equal(t, "model2", lines[2].Get("response.body.responseMessage").String())
+	})
+
+	t.Run("failed requests go to the error file", func(t *testing.T) {
+		w := batchServe(proxy, "GET", "/v1/files/"+batch.Get("error_file_id").String()+"/content", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		lines := ndjsonLines(t, w.Body.String())
+		require.Len(t, lines, 1)
+		assert.Equal(t, "d", lines[0].Get("custom_id").String())
+		assert.Equal(t, int64(http.StatusBadRequest), lines[0].Get("response.status_code").Int())
+	})
+
+	t.Run("invalid input fails the batch", func(t *testing.T) {
+		badID := batchUpload(t, proxy, batchLineJSON("a", "model1")+batchLineJSON("a", "model1")+"not json\n")
+		w := batchServe(proxy, "POST", "/v1/batches", fmt.Sprintf(`{"input_file_id": %q, "endpoint": "/v1/chat/completions", "completion_window": "24h"}`, badID))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		failed := gjson.Parse(w.Body.String())
+		assert.Equal(t, batchStatusFailed, failed.Get("status").String())
+		errs := failed.Get("errors.data").Array()
+		require.Len(t, errs, 2)
+		assert.Equal(t, "duplicate_custom_id", errs[0].Get("code").String())
+		assert.Equal(t, int64(2), errs[0].Get("line").Int())
+		assert.Equal(t, "invalid_json_line", errs[1].Get("code").String())
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		w := batchServe(proxy, "POST", "/v1/batches", fmt.Sprintf(`{"input_file_id": %q, "endpoint": "/v1/images/generations"}`, fileID))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = batchServe(proxy, "POST", "/v1/batches", `{"input_file_id": "file-nope", "endpoint": "/v1/chat/completions"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		w = batchServe(proxy, "GET", "/v1/batches/batch_nope", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "invalid_request_error", gjson.Get(w.Body.String(), "error.type").String())
+	})
+
+	t.Run("list", func(t *testing.T) {
+		w := batchServe(proxy, "GET", "/v1/batches?limit=1", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		list := gjson.Parse(w.Body.String())
+		assert.Len(t, list.Get("data").Array(), 1)
+		assert.True(t, list.Get("has_more").Bool())
+	})
+
+	t.Run("running page shows batches", func(t *testing.T) {
+		w := batchServe(proxy, "GET", "/ui/partials/running", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), batchID)
+	})
+
+	t.Run("batches are reloaded", func(t *testing.T) {
+		reloaded := New(batchTestConfig(t, dir))
+		defer reloaded.Shutdown()
+		got, found := reloaded.batches.getBatch(batchID)
+		require.True(t, found)
+		assert.Equal(t, batchStatusCompleted, got.Status)
+		_, found = reloaded.batches.getFile(*got.OutputFileID)
+		assert.True(t, found)
+	})
+}
+
+func TestProxyManager_BatchesWaitForIdle(t *testing.T) {
+	defer func(interval time.Duration) { batchPollInterval = interval }(batchPollInterval)
+	batchPollInterval = 10 * time.Millisecond
+
+	proxy := New(batchTestConfig(t, t.TempDir()))
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+	defer proxy.Shutdown()
+
+	// simulate an interactive request in flight
+	proxy.interactiveRequests.Add(1)
+	batchID := batchCreate(t, proxy, batchUpload(t, proxy, batchLineJSON("a", "model1")))
+
+	time.Sleep(100 * time.Millisecond)
+	batch, found := proxy.batches.getBatch(batchID)
+	require.True(t, found)
+	assert.Equal(t, batchStatusInProgress, batch.Status)
+	assert.Equal(t, 0, batch.RequestCounts.Completed)
+
+	proxy.interactiveRequests.Add(-1)
+	assert.Equal(t, batchStatusCompleted, batchWait(t, proxy, batchID).Get("status").String())
+}
+
+func TestProxyManager_BatchesReloadDuringRequest(t *testing.T) {
+	defer func(interval time.Duration) { batchPollInterval = interval }(batchPollInterval)
+	batchPollInterval = 10 * time.Millisecond
+
+	// the first request blocks until the worker is stopped
+	started := make(chan struct{})
+	var blocked atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/v1/chat/completions" && blocked.CompareAndSwap(false, true) {
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+batches:
+  enabled: true
+  path: %s
+models:
+  model1:
+    cmd: %s --port %d --silent
+    proxy: %s
+`, dir, simpleResponderPath, getTestPort(), upstream.URL)))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+	batchID := batchCreate(t, proxy, batchUpload(t, proxy, batchLineJSON("a", "model1")+batchLineJSON("b", "model1")+batchLineJSON("c", "model1")))
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the batch did not start")
+	}
+	proxy.Shutdown()
+
+	// the stopped request is not recorded, not even after Shutdown returned
+	time.Sleep(100 * time.Millisecond)
+	stopped, found := proxy.batches.getBatch(batchID)
+	require.True(t, found)
+	assert.Zero(t, stopped.RequestCounts.Failed)
+
+	reloaded := New(cfg)
+	defer reloaded.StopProcesses(StopWaitForInflightRequest)
+	defer reloaded.Shutdown()
+
+	batch := batchWait(t, reloaded, batchID)
+	assert.Equal(t, batchStatusCompleted, batch.Get("status").String())
+	assert.Equal(t, int64(3), batch.Get("request_counts.completed").Int())
+	assert.Equal(t, int64(0), batch.Get("request_counts.failed").Int())
+
+	w := batchServe(reloaded, "GET", "/v1/files/"+batch.Get("output_file_id").String()+"/content", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var customIDs []string
+	for _, line := range ndjsonLines(t, w.Body.String()) {
+		customIDs = append(customIDs, line.Get("custom_id").String())
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, customIDs, "each request has one result")
+}
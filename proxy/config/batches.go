@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BatchesConfig enables the OpenAI compatible /v1/files and /v1/batches APIs
+type BatchesConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// directory for uploaded files, results and batch state
+	Path string `yaml:"path"`
+
+	// only run batch requests while no interactive requests are in flight
+	IdleOnly bool `yaml:"idleOnly"`
+}
+
+func validateBatches(config *Config) error {
+	batches := &config.Batches
+	batches.Path = strings.TrimSpace(batches.Path)
+	if batches.Enabled && batches.Path == "" {
+		return fmt.Errorf("batches.path is required when batches are enabled")
+	}
+	return nil
+}
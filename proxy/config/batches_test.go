@@ -0,0 +1,22 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatches_LoadAndValidate(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader("batches:\n  enabled: true\n"))
+	require.NoError(t, err)
+	assert.Equal(t, BatchesConfig{Enabled: true, Path: "batches", IdleOnly: true}, config.Batches)
+
+	config, err = LoadConfigFromReader(strings.NewReader("batches:\n  enabled: true\n  path: \" /var/lib/batches \"\n  idleOnly: false\n"))
+	require.NoError(t, err)
+	assert.Equal(t, BatchesConfig{Enabled: true, Path: "/var/lib/batches", IdleOnly: false}, config.Batches)
+
+	_, err = LoadConfigFromReader(strings.NewReader("batches:\n  enabled: true\n  path: \"\"\n"))
+	assert.ErrorContains(t, err, "batches.path is required when batches are enabled")
+}
@@ -58,6 +58,23 @@ func (ml *MacroList) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+// MarshalYAML writes the macros back out as a mapping, preserving definition order
+func (ml MacroList) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, entry := range ml {
+		keyNode := &yaml.Node{}
+		if err := keyNode.Encode(entry.Name); err != nil {
+			return nil, fmt.Errorf("failed to encode macro name: %w", err)
+		}
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(entry.Value); err != nil {
+			return nil, fmt.Errorf("failed to encode macro value for '%s': %w", entry.Name, err)
+		}
+		node.Content = append(node.Content, keyNode, valueNode)
+	}
+	return node, nil
+}
+
 // Get retrieves a macro value by name
 func (ml MacroList) Get(name string) (any, bool) {
 	for _, entry := range ml {
@@ -156,6 +173,42 @@ type Config struct {
 
 	// support remote peers, see issue #433, #296
 	Peers PeerDictionaryConfig `yaml:"peers"`
+
+	// how a peer is picked when several peers serve a model
+	PeerSelection string `yaml:"peerSelection"`
+
+	// send requests to a peer instead of evicting local models
+	PeerOffload PeerOffloadConfig `yaml:"peerOffload"`
+
+	// virtual model names that pick a model based on the request
+	Routes RoutesConfig `yaml:"routes"`
+
+	// virtual model names that prefer an already loaded model from a list
+	Pools PoolsConfig `yaml:"pools"`
+
+	// storage for Responses API emulation, see apiTranslation
+	ResponsesStore ResponsesStoreConfig `yaml:"responsesStore"`
+
+	// Ollama compatible API
+	Ollama OllamaConfig `yaml:"ollama"`
+
+	// offline batch API
+	Batches BatchesConfig `yaml:"batches"`
+
+	// storage for models with responseCache enabled
+	ResponseCache ResponseCacheConfig `yaml:"responseCache"`
+
+	// request priority classes and preemption
+	Priority PriorityConfig `yaml:"priority"`
+
+	// hold requests for models that are not loaded to avoid swaps
+	SwapScheduler SwapSchedulerConfig `yaml:"swapScheduler"`
+
+	// loading milestones parsed from upstream logs
+	LoadingProgress LoadingProgressConfig `yaml:"loadingProgress"`
+
+	// HTTP hooks called around inference requests of every model
+	Webhooks WebhooksConfig `yaml:"webhooks"`
 }
 
 func (c *Config) RealModelName(search string) (string, bool) {
@@ -208,6 +261,34 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 		LogToStdout:        LogToStdoutProxy,
 		MetricsMaxInMemory: 1000,
 		CaptureBuffer:      5,
+		ResponsesStore: ResponsesStoreConfig{
+			Type:       ResponsesStoreMemory,
+			MaxEntries: 1000,
+		},
+		Ollama: OllamaConfig{
+			Prefix: "/ollama",
+		},
+		Batches: BatchesConfig{
+			Path:     "batches",
+			IdleOnly: true,
+		},
+		ResponseCache: ResponseCacheConfig{
+			Type:      ResponseCacheMemory,
+			MaxSizeMB: 256,
+			TTL:       3600,
+		},
+		Priority: PriorityConfig{
+			Batch:         -10,
+			DrainTimeout:  10,
+			AgingInterval: 10,
+		},
+		SwapScheduler: SwapSchedulerConfig{
+			MaxHoldMs:      3000,
+			MinResidencyMs: 5000,
+		},
+		LoadingProgress: LoadingProgressConfig{
+			Backends: defaultLoadingBackends(),
+		},
 	}
 	if err = yaml.Unmarshal([]byte(yamlStr), &config); err != nil {
 		return Config{}, err
@@ -251,7 +332,7 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 	// Populate the aliases map
 	config.aliases = make(map[string]string)
 	for modelName, modelConfig := range config.Models {
-		for _, alias := range modelConfig.Aliases {
+		for _, alias := range modelConfig.aliasNames() {
 			if _, found := config.aliases[alias]; found {
 				return Config{}, fmt.Errorf("duplicate alias %s found in model: %s", alias, modelName)
 			}
@@ -346,6 +427,14 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 			}
 		}
 
+		modelConfig.APITranslation, err = normalizeAPITranslation(modelConfig.APITranslation)
+		if err != nil {
+			return Config{}, fmt.Errorf("model %s: %w", modelId, err)
+		}
+		if err := normalizeEmbeddingBatch(&modelConfig.EmbeddingBatch); err != nil {
+			return Config{}, fmt.Errorf("model %s: %w", modelId, err)
+		}
+
 		injectedFlags, err := applyFitPolicy(&modelConfig)
 		if err != nil {
 			return Config{}, fmt.Errorf("model %s: %w", modelId, err)
@@ -502,6 +591,52 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 		config.Peers[peerName] = peerConfig
 	}
 
+	if err := validateResponsesStore(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateOllama(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateBatches(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateResponseCache(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validatePriority(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateSwapScheduler(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateLoadingProgress(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateTimeouts(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateLimits(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateWebhooks(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validateFilters(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validatePresets(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validatePeerSelection(&config); err != nil {
+		return Config{}, err
+	}
+	if err := validatePools(config); err != nil {
+		return Config{}, err
+	}
+	if err := validateRoutes(config); err != nil {
+		return Config{}, err
+	}
+
 	return config, nil
 }
 
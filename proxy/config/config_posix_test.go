@@ -216,6 +216,34 @@ groups:
 		HealthCheckTimeout: 15,
 		MetricsMaxInMemory: 1000,
 		CaptureBuffer:      5,
+		ResponsesStore: ResponsesStoreConfig{
+			Type:       ResponsesStoreMemory,
+			MaxEntries: 1000,
+		},
+		Ollama: OllamaConfig{
+			Prefix: "/ollama",
+		},
+		Batches: BatchesConfig{
+			Path:     "batches",
+			IdleOnly: true,
+		},
+		ResponseCache: ResponseCacheConfig{
+			Type:      ResponseCacheMemory,
+			MaxSizeMB: 256,
+			TTL:       3600,
+		},
+		Priority: PriorityConfig{
+			Batch:         -10,
+			DrainTimeout:  10,
+			AgingInterval: 10,
+		},
+		SwapScheduler: SwapSchedulerConfig{
+			MaxHoldMs:      3000,
+			MinResidencyMs: 5000,
+		},
+		LoadingProgress: LoadingProgressConfig{
+			Backends: defaultLoadingBackends(),
+		},
 		Profiles: map[string][]string{
 			"test": {"model1", "model2"},
 		},
@@ -0,0 +1,40 @@
+package config
+
+import "fmt"
+
+const (
+	defaultEmbeddingBatchWindowMs  = 10
+	defaultEmbeddingBatchMaxInputs = 64
+)
+
+// EmbeddingBatchConfig merges concurrent /v1/embeddings requests to a model
+// into a single upstream request
+type EmbeddingBatchConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// milliseconds to wait for more requests after the first one arrives
+	WindowMs int `yaml:"windowMs"`
+
+	// the most inputs sent upstream in one request
+	MaxInputs int `yaml:"maxInputs"`
+}
+
+// normalizeEmbeddingBatch fills in defaults for enabled batching
+func normalizeEmbeddingBatch(batch *EmbeddingBatchConfig) error {
+	if !batch.Enabled {
+		return nil
+	}
+	if batch.WindowMs == 0 {
+		batch.WindowMs = defaultEmbeddingBatchWindowMs
+	}
+	if batch.MaxInputs == 0 {
+		batch.MaxInputs = defaultEmbeddingBatchMaxInputs
+	}
+	if batch.WindowMs < 0 {
+		return fmt.Errorf("embeddingBatch.windowMs must be greater than 0")
+	}
+	if batch.MaxInputs < 1 {
+		return fmt.Errorf("embeddingBatch.maxInputs must be greater than 0")
+	}
+	return nil
+}
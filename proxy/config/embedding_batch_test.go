@@ -0,0 +1,36 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddingBatch_LoadAndValidate(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+models:
+  embed:
+    cmd: server --port ${PORT}
+    embeddingBatch:
+      enabled: true
+  custom:
+    cmd: server --port ${PORT}
+    embeddingBatch:
+      enabled: true
+      windowMs: 25
+      maxInputs: 128
+  off:
+    cmd: server --port ${PORT}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, EmbeddingBatchConfig{Enabled: true, WindowMs: 10, MaxInputs: 64}, config.Models["embed"].EmbeddingBatch)
+	assert.Equal(t, EmbeddingBatchConfig{Enabled: true, WindowMs: 25, MaxInputs: 128}, config.Models["custom"].EmbeddingBatch)
+	assert.Equal(t, EmbeddingBatchConfig{}, config.Models["off"].EmbeddingBatch)
+
+	_, err = LoadConfigFromReader(strings.NewReader("models:\n  embed:\n    cmd: server\n    embeddingBatch:\n      enabled: true\n      maxInputs: -1\n"))
+	assert.ErrorContains(t, err, "model embed: embeddingBatch.maxInputs must be greater than 0")
+	_, err = LoadConfigFromReader(strings.NewReader("models:\n  embed:\n    cmd: server\n    embeddingBatch:\n      enabled: true\n      windowMs: -5\n"))
+	assert.ErrorContains(t, err, "model embed: embeddingBatch.windowMs must be greater than 0")
+}
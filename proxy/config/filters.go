@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"maps"
 	"slices"
 	"sort"
 	"strings"
@@ -20,6 +22,172 @@ type Filters struct {
 	// SetParams is a dictionary of parameters to set/override in requests
 	// Protected params (like "model") cannot be set
 	SetParams map[string]any `yaml:"setParams"`
+
+	// RenameParams moves parameters to a new name, e.g. max_completion_tokens: max_tokens.
+	// The renamed value replaces a parameter that already has the new name.
+	RenameParams map[string]string `yaml:"renameParams"`
+
+	// DefaultParams sets parameters only when the request does not have them
+	DefaultParams map[string]any `yaml:"defaultParams"`
+
+	// ClampParams limits numeric parameters to a range
+	ClampParams map[string]ClampRange `yaml:"clampParams"`
+
+	// SystemMessage injects or replaces the system message of chat requests
+	SystemMessage SystemMessageFilter `yaml:"systemMessage"`
+
+	// Endpoints are more filters for requests to a path, e.g. /v1/chat/completions.
+	// They are applied together with the filters above.
+	Endpoints map[string]Filters `yaml:"endpoints"`
+
+	// Response transforms chat and text completion responses before they are
+	// sent to the client
+	Response ResponseFilters `yaml:"response"`
+}
+
+// ClampRange is the range of a numeric parameter, either bound is optional
+type ClampRange struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+const (
+	SystemMessageReplace = "replace"
+	SystemMessagePrepend = "prepend"
+	SystemMessageAppend  = "append"
+	SystemMessageMissing = "missing"
+)
+
+type SystemMessageFilter struct {
+	Content string `yaml:"content"`
+
+	// replace (default) replaces all system messages, prepend and append add
+	// the content to the existing system message and missing only adds a
+	// system message when the request does not have one
+	Mode string `yaml:"mode"`
+}
+
+type ResponseFilters struct {
+	// fields removed from the message or delta of every choice, e.g. reasoning_content
+	StripFields []string `yaml:"stripFields"`
+
+	// remove <think>...</think> blocks from the content
+	StripThink bool `yaml:"stripThink"`
+}
+
+// Enabled returns true when responses are transformed
+func (r ResponseFilters) Enabled() bool {
+	return len(r.StripFields) > 0 || r.StripThink
+}
+
+// ForPath returns the filters for a request to path, the filters of a
+// matching endpoint are added to the others
+func (f Filters) ForPath(path string) Filters {
+	endpoint, found := f.Endpoints[path]
+	if !found {
+		return f
+	}
+
+	merged := f.merge(endpoint)
+	merged.Endpoints = nil
+	return merged
+}
+
+// merge returns f with the overlay filters added, overlay values win
+func (f Filters) merge(overlay Filters) Filters {
+	merged := f
+	if overlay.StripParams != "" {
+		if merged.StripParams != "" {
+			merged.StripParams += ","
+		}
+		merged.StripParams += overlay.StripParams
+	}
+	merged.SetParams = mergeFilterMap(f.SetParams, overlay.SetParams)
+	merged.RenameParams = mergeFilterMap(f.RenameParams, overlay.RenameParams)
+	merged.DefaultParams = mergeFilterMap(f.DefaultParams, overlay.DefaultParams)
+	merged.ClampParams = mergeFilterMap(f.ClampParams, overlay.ClampParams)
+	if overlay.SystemMessage.Content != "" {
+		merged.SystemMessage = overlay.SystemMessage
+	}
+	merged.Endpoints = mergeFilterMap(f.Endpoints, overlay.Endpoints)
+	merged.Response.StripFields = append(slices.Clone(f.Response.StripFields), overlay.Response.StripFields...)
+	merged.Response.StripThink = f.Response.StripThink || overlay.Response.StripThink
+	return merged
+}
+
+// mergeFilterMap returns base with the values of overlay added, overlay wins
+func mergeFilterMap[V any](base, overlay map[string]V) map[string]V {
+	if len(overlay) == 0 {
+		return base
+	}
+	merged := make(map[string]V, len(base)+len(overlay))
+	maps.Copy(merged, base)
+	maps.Copy(merged, overlay)
+	return merged
+}
+
+// SortedKeys returns the keys of a filter map in a consistent order without
+// the protected params
+func SortedKeys[V any](params map[string]V) []string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if !slices.Contains(ProtectedParams, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f Filters) validate() error {
+	for from, to := range f.RenameParams {
+		if to == "" {
+			return fmt.Errorf("filters.renameParams.%s needs a new name", from)
+		}
+		if slices.Contains(ProtectedParams, to) {
+			return fmt.Errorf("filters.renameParams.%s can not rename to the protected param %s", from, to)
+		}
+	}
+	for param, clamp := range f.ClampParams {
+		if clamp.Min == nil && clamp.Max == nil {
+			return fmt.Errorf("filters.clampParams.%s needs a min or max", param)
+		}
+		if clamp.Min != nil && clamp.Max != nil && *clamp.Min > *clamp.Max {
+			return fmt.Errorf("filters.clampParams.%s min is greater than max", param)
+		}
+	}
+	switch f.SystemMessage.Mode {
+	case "", SystemMessageReplace, SystemMessagePrepend, SystemMessageAppend, SystemMessageMissing:
+	default:
+		return fmt.Errorf("filters.systemMessage.mode must be one of %s, %s, %s or %s",
+			SystemMessageReplace, SystemMessagePrepend, SystemMessageAppend, SystemMessageMissing)
+	}
+	for path, endpoint := range f.Endpoints {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("filters.endpoints.%s must be a path starting with /", path)
+		}
+		if len(endpoint.Endpoints) > 0 {
+			return fmt.Errorf("filters.endpoints.%s can not have endpoints", path)
+		}
+		if err := endpoint.validate(); err != nil {
+			return fmt.Errorf("endpoints.%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func validateFilters(config *Config) error {
+	for modelID, modelConfig := range config.Models {
+		if err := modelConfig.Filters.validate(); err != nil {
+			return fmt.Errorf("model %s: %w", modelID, err)
+		}
+	}
+	for peerID, peerConfig := range config.Peers {
+		if err := peerConfig.Filters.validate(); err != nil {
+			return fmt.Errorf("peer %s: %w", peerID, err)
+		}
+	}
+	return nil
 }
 
 // SanitizedStripParams returns a sorted list of parameters to strip,
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -166,3 +167,54 @@ func TestProtectedParams(t *testing.T) {
 	// Verify that "model" is protected
 	assert.Contains(t, ProtectedParams, "model")
 }
+
+func TestFilters_ForPath(t *testing.T) {
+	maxTokens := 4096.0
+	filters := Filters{
+		StripParams:  "top_k",
+		SetParams:    map[string]any{"temperature": 0.7},
+		RenameParams: map[string]string{"max_completion_tokens": "max_tokens"},
+		Response:     ResponseFilters{StripFields: []string{"reasoning_content"}},
+		Endpoints: map[string]Filters{
+			"/v1/chat/completions": {
+				StripParams:   "seed",
+				SetParams:     map[string]any{"temperature": 0.2},
+				ClampParams:   map[string]ClampRange{"max_tokens": {Max: &maxTokens}},
+				SystemMessage: SystemMessageFilter{Content: "be brief"},
+				Response:      ResponseFilters{StripThink: true},
+			},
+		},
+	}
+
+	assert.Equal(t, filters, filters.ForPath("/v1/embeddings"))
+
+	chat := filters.ForPath("/v1/chat/completions")
+	assert.Equal(t, []string{"seed", "top_k"}, chat.SanitizedStripParams())
+	assert.Equal(t, 0.2, chat.SetParams["temperature"], "endpoint values win")
+	assert.Equal(t, "max_tokens", chat.RenameParams["max_completion_tokens"])
+	assert.Equal(t, &maxTokens, chat.ClampParams["max_tokens"].Max)
+	assert.Equal(t, "be brief", chat.SystemMessage.Content)
+	assert.Equal(t, ResponseFilters{StripFields: []string{"reasoning_content"}, StripThink: true}, chat.Response)
+	assert.Nil(t, chat.Endpoints)
+	assert.Equal(t, 0.7, filters.SetParams["temperature"], "the base filters are not changed")
+}
+
+func TestFilters_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters string
+		err     string
+	}{
+		{"rename to model", "renameParams:\n        name: model", "model model1: filters.renameParams.name can not rename to the protected param model"},
+		{"clamp without bounds", "clampParams:\n        temperature: {}", "model model1: filters.clampParams.temperature needs a min or max"},
+		{"clamp range", "clampParams:\n        temperature: {min: 2, max: 1}", "model model1: filters.clampParams.temperature min is greater than max"},
+		{"system mode", "systemMessage:\n        content: hi\n        mode: insert", "model model1: filters.systemMessage.mode must be one of replace, prepend, append or missing"},
+		{"endpoint path", "endpoints:\n        v1/chat: {}", "model model1: filters.endpoints.v1/chat must be a path starting with /"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader("models:\n  model1:\n    cmd: ./server --port ${PORT}\n    filters:\n      " + tt.filters + "\n"))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import "fmt"
+
+// LimitsConfig rejects requests that are too large for a model before the
+// model is swapped in. 0 disables a limit.
+type LimitsConfig struct {
+	// size of the request body
+	MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+
+	// number of chat messages
+	MaxMessages int `yaml:"maxMessages"`
+
+	// estimated prompt tokens, counted with the upstream's /tokenize endpoint
+	// when the model is loaded and estimated from the prompt length otherwise
+	MaxPromptTokens int `yaml:"maxPromptTokens"`
+}
+
+func validateLimits(config *Config) error {
+	for modelID, modelConfig := range config.Models {
+		limits := modelConfig.Limits
+		if limits.MaxBodyBytes < 0 || limits.MaxMessages < 0 || limits.MaxPromptTokens < 0 {
+			return fmt.Errorf("model %s: limits must be 0 or greater", modelID)
+		}
+	}
+	return nil
+}
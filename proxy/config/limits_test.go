@@ -0,0 +1,32 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits_Load(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+    limits:
+      maxBodyBytes: 1048576
+      maxMessages: 200
+      maxPromptTokens: 32768
+`))
+	require.NoError(t, err)
+	assert.Equal(t, LimitsConfig{MaxBodyBytes: 1048576, MaxMessages: 200, MaxPromptTokens: 32768}, config.Models["model1"].Limits)
+
+	_, err = LoadConfigFromReader(strings.NewReader(`
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+    limits:
+      maxPromptTokens: -1
+`))
+	assert.EqualError(t, err, "model model1: limits must be 0 or greater")
+}
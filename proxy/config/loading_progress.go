@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// LoadingProgressConfig maps upstream log lines to loading milestones so a
+// swap can report its phase and progress instead of waiting silently.
+type LoadingProgressConfig struct {
+	// backends by name, see LoadingBackendConfig
+	Backends map[string]LoadingBackendConfig `yaml:"backends"`
+}
+
+// LoadingBackendConfig are the milestones of one upstream server
+type LoadingBackendConfig struct {
+	// regex matched against a model's cmd to select the backend, models can
+	// also name their backend with loadingBackend
+	Cmd string `yaml:"cmd"`
+
+	// milestones in the order the backend logs them
+	Milestones []LoadingMilestoneConfig `yaml:"milestones"`
+}
+
+type LoadingMilestoneConfig struct {
+	Phase string `yaml:"phase"`
+
+	// regex matched against each line the upstream logs
+	Regex string `yaml:"regex"`
+
+	// progress once the milestone is reached, 0 to 100
+	Percent int `yaml:"percent"`
+}
+
+// defaultLoadingBackends are the milestones of llama-server
+func defaultLoadingBackends() map[string]LoadingBackendConfig {
+	return map[string]LoadingBackendConfig{
+		"llama-server": {
+			Cmd: `llama-server`,
+			Milestones: []LoadingMilestoneConfig{
+				{Phase: "reading metadata", Regex: `llama_model_loader: loaded meta data`, Percent: 5},
+				{Phase: "loading tensors", Regex: `load_tensors: loading model tensors`, Percent: 15},
+				{Phase: "creating context", Regex: `llama_context: constructing llama_context|llama_new_context_with_model`, Percent: 70},
+				{Phase: "warming up", Regex: `warming up the model`, Percent: 85},
+				{Phase: "listening", Regex: `server is listening on`, Percent: 95},
+			},
+		},
+	}
+}
+
+// BackendFor returns the loading milestones of a model, nil when no backend
+// matches it
+func (c LoadingProgressConfig) BackendFor(modelConfig ModelConfig) []LoadingMilestoneConfig {
+	if modelConfig.LoadingBackend != "" {
+		return c.Backends[modelConfig.LoadingBackend].Milestones
+	}
+
+	// sorted so the same backend is picked when several match
+	names := make([]string, 0, len(c.Backends))
+	for name := range c.Backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		backend := c.Backends[name]
+		if backend.Cmd == "" {
+			continue
+		}
+		if matched, _ := regexp.MatchString(backend.Cmd, modelConfig.Cmd); matched {
+			return backend.Milestones
+		}
+	}
+	return nil
+}
+
+func validateLoadingProgress(config *Config) error {
+	for name, backend := range config.LoadingProgress.Backends {
+		if _, err := regexp.Compile(backend.Cmd); err != nil {
+			return fmt.Errorf("loadingProgress.backends.%s.cmd: %v", name, err)
+		}
+		for i, milestone := range backend.Milestones {
+			if milestone.Phase == "" {
+				return fmt.Errorf("loadingProgress.backends.%s.milestones[%d]: phase is required", name, i)
+			}
+			if milestone.Regex == "" {
+				return fmt.Errorf("loadingProgress.backends.%s.milestones[%d]: regex is required", name, i)
+			}
+			if _, err := regexp.Compile(milestone.Regex); err != nil {
+				return fmt.Errorf("loadingProgress.backends.%s.milestones[%d].regex: %v", name, i, err)
+			}
+			if milestone.Percent < 0 || milestone.Percent > 100 {
+				return fmt.Errorf("loadingProgress.backends.%s.milestones[%d].percent must be between 0 and 100", name, i)
+			}
+		}
+	}
+	for modelID, modelConfig := range config.Models {
+		if modelConfig.LoadingBackend == "" {
+			continue
+		}
+		if _, found := config.LoadingProgress.Backends[modelConfig.LoadingBackend]; !found {
+			return fmt.Errorf("model %s: loadingBackend %s is not defined in loadingProgress.backends", modelID, modelConfig.LoadingBackend)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadingProgress_BackendFor(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+loadingProgress:
+  backends:
+    vllm:
+      cmd: "vllm serve"
+      milestones:
+        - phase: loading weights
+          regex: "Loading weights"
+          percent: 30
+models:
+  llama:
+    cmd: /usr/bin/llama-server --port ${PORT}
+  vllm:
+    cmd: vllm serve model --port ${PORT}
+  named:
+    cmd: ./server --port ${PORT}
+    loadingBackend: vllm
+  unknown:
+    cmd: ./server --port ${PORT}
+`))
+	require.NoError(t, err)
+	require.Contains(t, config.LoadingProgress.Backends, "llama-server", "user backends are added to the defaults")
+
+	progress := config.LoadingProgress
+	assert.Equal(t, defaultLoadingBackends()["llama-server"].Milestones, progress.BackendFor(config.Models["llama"]))
+	assert.Equal(t, "loading weights", progress.BackendFor(config.Models["vllm"])[0].Phase)
+	assert.Equal(t, "loading weights", progress.BackendFor(config.Models["named"])[0].Phase)
+	assert.Nil(t, progress.BackendFor(config.Models["unknown"]))
+}
+
+func TestLoadingProgress_Validate(t *testing.T) {
+	tests := []struct {
+		yaml string
+		err  string
+	}{
+		{"loadingProgress:\n  backends:\n    b:\n      cmd: \"(\"\n", "loadingProgress.backends.b.cmd"},
+		{"loadingProgress:\n  backends:\n    b:\n      milestones:\n        - regex: x\n", "loadingProgress.backends.b.milestones[0]: phase is required"},
+		{"loadingProgress:\n  backends:\n    b:\n      milestones:\n        - phase: p\n          regex: \"[\"\n", "loadingProgress.backends.b.milestones[0].regex"},
+		{"loadingProgress:\n  backends:\n    b:\n      milestones:\n        - phase: p\n          regex: x\n          percent: 101\n", "percent must be between 0 and 100"},
+		{"models:\n  model1:\n    cmd: server --port ${PORT}\n    loadingBackend: nope\n", "model model1: loadingBackend nope is not defined in loadingProgress.backends"},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfigFromReader(strings.NewReader(tt.yaml))
+		assert.ErrorContains(t, err, tt.err)
+	}
+}
@@ -2,9 +2,36 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"runtime"
+	"strings"
 )
 
+const (
+	// APITranslationAnthropic translates Anthropic /v1/messages requests
+	APITranslationAnthropic = "anthropic"
+	// APITranslationResponses emulates the OpenAI /v1/responses API
+	APITranslationResponses = "responses"
+)
+
+// normalizeAPITranslation lower cases and validates apiTranslation values
+func normalizeAPITranslation(values []string) ([]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		switch value {
+		case APITranslationAnthropic, APITranslationResponses:
+		default:
+			return nil, fmt.Errorf("apiTranslation values must be one of: %s, %s", APITranslationAnthropic, APITranslationResponses)
+		}
+		normalized = append(normalized, value)
+	}
+	return normalized, nil
+}
+
 type ModelConfig struct {
 	Cmd           string   `yaml:"cmd"`
 	CmdStop       string   `yaml:"cmdStop"`
@@ -40,6 +67,37 @@ type ModelConfig struct {
 
 	// override global setting
 	SendLoadingState *bool `yaml:"sendLoadingState"`
+
+	// APIs llama-swap translates to OpenAI chat completions for this model
+	APITranslation []string `yaml:"apiTranslation"`
+
+	// cache deterministic responses, see the top level responseCache setting
+	ResponseCache bool `yaml:"responseCache"`
+
+	// merge concurrent embedding requests into one upstream request
+	EmbeddingBatch EmbeddingBatchConfig `yaml:"embeddingBatch"`
+
+	// default priority of requests to this model, see the top level priority setting
+	Priority int `yaml:"priority"`
+
+	// override the swapScheduler hold and residency times, 0 uses the top level setting
+	MaxHoldMs      int `yaml:"maxHoldMs"`
+	MinResidencyMs int `yaml:"minResidencyMs"`
+
+	// name of the loadingProgress backend, empty picks it by the cmd
+	LoadingBackend string `yaml:"loadingBackend"`
+
+	// limits and connection pool settings of requests to the upstream
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+
+	// reject requests that are too large for the model before swapping it in
+	Limits LimitsConfig `yaml:"limits"`
+
+	// replace the global webhooks for this model
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+
+	// aliases with their own filters, name, description and metadata
+	Presets map[string]PresetConfig `yaml:"presets"`
 }
 
 func DefaultModelConfig() ModelConfig {
@@ -81,6 +139,16 @@ func (m *ModelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// HasAPITranslation returns true when api is in the model's apiTranslation list
+func (m ModelConfig) HasAPITranslation(api string) bool {
+	for _, value := range m.APITranslation {
+		if value == api {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *ModelConfig) SanitizedCommand() ([]string, error) {
 	return SanitizeCommand(m.Cmd)
 }
@@ -104,3 +104,26 @@ models:
 	assert.Equal(t, 0.7, setParams["temperature"])
 	assert.Equal(t, 0.9, setParams["top_p"])
 }
+
+func TestConfig_ModelAPITranslation(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+models:
+  model1:
+    cmd: server --port ${PORT}
+    apiTranslation: [" Anthropic "]
+  model2:
+    cmd: server --port ${PORT}
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"anthropic"}, config.Models["model1"].APITranslation)
+	assert.True(t, config.Models["model1"].HasAPITranslation(APITranslationAnthropic))
+	assert.False(t, config.Models["model2"].HasAPITranslation(APITranslationAnthropic))
+
+	_, err = LoadConfigFromReader(strings.NewReader(`
+models:
+  model1:
+    cmd: server --port ${PORT}
+    apiTranslation: [gemini]
+`))
+	assert.ErrorContains(t, err, "model model1: apiTranslation values must be one of: anthropic")
+}
@@ -22,6 +22,17 @@ type ModelSourceConfig struct {
 	Macros           MacroList      `yaml:"macros"`
 	Metadata         map[string]any `yaml:"metadata"`
 	SendLoadingState *bool          `yaml:"sendLoadingState"`
+	APITranslation   []string       `yaml:"apiTranslation"`
+	ResponseCache    bool           `yaml:"responseCache"`
+	Priority         int            `yaml:"priority"`
+	MaxHoldMs        int            `yaml:"maxHoldMs"`
+	MinResidencyMs   int            `yaml:"minResidencyMs"`
+	LoadingBackend   string         `yaml:"loadingBackend"`
+
+	EmbeddingBatch EmbeddingBatchConfig `yaml:"embeddingBatch"`
+	Timeouts       TimeoutsConfig       `yaml:"timeouts"`
+	Limits         LimitsConfig         `yaml:"limits"`
+	Webhooks       WebhooksConfig       `yaml:"webhooks"`
 }
 
 type ParameterSetConfig struct {
@@ -42,4 +53,15 @@ type ParameterSetConfig struct {
 	Macros           MacroList      `yaml:"macros"`
 	Metadata         map[string]any `yaml:"metadata"`
 	SendLoadingState *bool          `yaml:"sendLoadingState"`
+	APITranslation   []string       `yaml:"apiTranslation"`
+	ResponseCache    bool           `yaml:"responseCache"`
+	Priority         int            `yaml:"priority"`
+	MaxHoldMs        int            `yaml:"maxHoldMs"`
+	MinResidencyMs   int            `yaml:"minResidencyMs"`
+	LoadingBackend   string         `yaml:"loadingBackend"`
+
+	EmbeddingBatch EmbeddingBatchConfig `yaml:"embeddingBatch"`
+	Timeouts       TimeoutsConfig       `yaml:"timeouts"`
+	Limits         LimitsConfig         `yaml:"limits"`
+	Webhooks       WebhooksConfig       `yaml:"webhooks"`
 }
@@ -131,6 +131,59 @@ func buildGeneratedModelConfig(sourceID, paramID string, source ModelSourceConfi
 		model.SendLoadingState = param.SendLoadingState
 	}
 
+	if len(source.APITranslation) > 0 {
+		model.APITranslation = source.APITranslation
+	}
+	if len(param.APITranslation) > 0 {
+		model.APITranslation = param.APITranslation
+	}
+	if source.ResponseCache || param.ResponseCache {
+		model.ResponseCache = true
+	}
+	if source.EmbeddingBatch.Enabled {
+		model.EmbeddingBatch = source.EmbeddingBatch
+	}
+	if param.EmbeddingBatch.Enabled {
+		model.EmbeddingBatch = param.EmbeddingBatch
+	}
+	if source.Priority != 0 {
+		model.Priority = source.Priority
+	}
+	if param.Priority != 0 {
+		model.Priority = param.Priority
+	}
+	if source.MaxHoldMs > 0 {
+		model.MaxHoldMs = source.MaxHoldMs
+	}
+	if param.MaxHoldMs > 0 {
+		model.MaxHoldMs = param.MaxHoldMs
+	}
+	if source.MinResidencyMs > 0 {
+		model.MinResidencyMs = source.MinResidencyMs
+	}
+	if param.MinResidencyMs > 0 {
+		model.MinResidencyMs = param.MinResidencyMs
+	}
+	if source.LoadingBackend != "" {
+		model.LoadingBackend = source.LoadingBackend
+	}
+	if param.LoadingBackend != "" {
+		model.LoadingBackend = param.LoadingBackend
+	}
+	if source.Timeouts != (TimeoutsConfig{}) {
+		model.Timeouts = source.Timeouts
+	}
+	if param.Timeouts != (TimeoutsConfig{}) {
+		model.Timeouts = param.Timeouts
+	}
+	if source.Limits != (LimitsConfig{}) {
+		model.Limits = source.Limits
+	}
+	if param.Limits != (LimitsConfig{}) {
+		model.Limits = param.Limits
+	}
+	model.Webhooks = model.Webhooks.merge(source.Webhooks).merge(param.Webhooks)
+
 	if model.Cmd == "" {
 		return ModelConfig{}, fmt.Errorf("modelSources.%s: cmd is required", sourceID)
 	}
@@ -159,6 +212,24 @@ func mergeModelFilters(base ModelFilters, overlay ModelFilters) ModelFilters {
 	if len(overlay.SetParams) > 0 {
 		merged.SetParams = overlay.SetParams
 	}
+	if len(overlay.RenameParams) > 0 {
+		merged.RenameParams = overlay.RenameParams
+	}
+	if len(overlay.DefaultParams) > 0 {
+		merged.DefaultParams = overlay.DefaultParams
+	}
+	if len(overlay.ClampParams) > 0 {
+		merged.ClampParams = overlay.ClampParams
+	}
+	if overlay.SystemMessage.Content != "" {
+		merged.SystemMessage = overlay.SystemMessage
+	}
+	if len(overlay.Endpoints) > 0 {
+		merged.Endpoints = overlay.Endpoints
+	}
+	if overlay.Response.Enabled() {
+		merged.Response = overlay.Response
+	}
 	return merged
 }
 
@@ -230,13 +301,44 @@ func mergeModelConfig(base, override ModelConfig) ModelConfig {
 	if override.SendLoadingState != nil {
 		merged.SendLoadingState = override.SendLoadingState
 	}
+	if len(override.APITranslation) > 0 {
+		merged.APITranslation = override.APITranslation
+	}
+	if override.ResponseCache {
+		merged.ResponseCache = override.ResponseCache
+	}
+	if override.EmbeddingBatch.Enabled {
+		merged.EmbeddingBatch = override.EmbeddingBatch
+	}
+	if override.Priority != 0 {
+		merged.Priority = override.Priority
+	}
+	if override.MaxHoldMs > 0 {
+		merged.MaxHoldMs = override.MaxHoldMs
+	}
+	if override.MinResidencyMs > 0 {
+		merged.MinResidencyMs = override.MinResidencyMs
+	}
+	if override.LoadingBackend != "" {
+		merged.LoadingBackend = override.LoadingBackend
+	}
+	if override.Timeouts != (TimeoutsConfig{}) {
+		merged.Timeouts = override.Timeouts
+	}
+	if override.Limits != (LimitsConfig{}) {
+		merged.Limits = override.Limits
+	}
+	merged.Webhooks = merged.Webhooks.merge(override.Webhooks)
 	if len(override.Macros) > 0 {
 		merged.Macros = override.Macros
 	}
 	if len(override.Metadata) > 0 {
 		merged.Metadata = override.Metadata
 	}
-	if override.Filters.StripParams != "" || len(override.Filters.SetParams) > 0 {
+	if override.Filters.StripParams != "" || len(override.Filters.SetParams) > 0 ||
+		len(override.Filters.RenameParams) > 0 || len(override.Filters.DefaultParams) > 0 ||
+		len(override.Filters.ClampParams) > 0 || override.Filters.SystemMessage.Content != "" ||
+		len(override.Filters.Endpoints) > 0 || override.Filters.Response.Enabled() {
 		merged.Filters = override.Filters
 	}
 
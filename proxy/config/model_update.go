@@ -0,0 +1,317 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseModelConfig validates a single model definition (YAML or JSON) through the
+// same pipeline as LoadConfigFromReader. Global settings that affect a model, like
+// macros, startPort, sendLoadingState and loadingProgress, are taken from base. ${PORT} is assigned
+// from a range that does not collide with any model already in base.
+func ParseModelConfig(base Config, modelID string, data []byte) (ModelConfig, error) {
+	modelNode, err := decodeModelNode(data)
+	if err != nil {
+		return ModelConfig{}, err
+	}
+
+	doc := struct {
+		HealthCheckTimeout int                   `yaml:"healthCheckTimeout"`
+		StartPort          int                   `yaml:"startPort"`
+		SendLoadingState   bool                  `yaml:"sendLoadingState"`
+		Macros             MacroList             `yaml:"macros,omitempty"`
+		LoadingProgress    LoadingProgressConfig `yaml:"loadingProgress"`
+		Models             map[string]*yaml.Node `yaml:"models"`
+	}{
+		HealthCheckTimeout: base.HealthCheckTimeout,
+		StartPort:          nextFreePort(base),
+		SendLoadingState:   base.SendLoadingState,
+		Macros:             base.Macros,
+		LoadingProgress:    base.LoadingProgress,
+		Models:             map[string]*yaml.Node{modelID: modelNode},
+	}
+
+	yamlData, err := yaml.Marshal(doc)
+	if err != nil {
+		return ModelConfig{}, fmt.Errorf("failed to encode model %s: %w", modelID, err)
+	}
+
+	parsed, err := LoadConfigFromReader(bytes.NewReader(yamlData))
+	if err != nil {
+		return ModelConfig{}, err
+	}
+
+	modelConfig, found := parsed.Models[modelID]
+	if !found {
+		return ModelConfig{}, fmt.Errorf("model %s not found after parsing", modelID)
+	}
+	return modelConfig, nil
+}
+
+// WithModel returns a copy of the config with modelID added or replaced.
+// The alias map and the default group are rebuilt for the new model set.
+func (c Config) WithModel(modelID string, model ModelConfig) (Config, error) {
+	if owner, found := c.aliases[modelID]; found && owner != modelID {
+		return Config{}, fmt.Errorf("model id %s is already used as an alias of model: %s", modelID, owner)
+	}
+	if _, found := c.Routes[modelID]; found {
+		return Config{}, fmt.Errorf("model id %s is already used as a route name", modelID)
+	}
+	if _, found := c.Pools[modelID]; found {
+		return Config{}, fmt.Errorf("model id %s is already used as a pool name", modelID)
+	}
+	for _, alias := range model.aliasNames() {
+		if owner, found := c.aliases[alias]; found && owner != modelID {
+			return Config{}, fmt.Errorf("duplicate alias %s found in model: %s", alias, owner)
+		}
+		if _, found := c.Models[alias]; found && alias != modelID {
+			return Config{}, fmt.Errorf("alias %s conflicts with an existing model id", alias)
+		}
+		if _, found := c.Routes[alias]; found {
+			return Config{}, fmt.Errorf("alias %s conflicts with a route name", alias)
+		}
+		if _, found := c.Pools[alias]; found {
+			return Config{}, fmt.Errorf("alias %s conflicts with a pool name", alias)
+		}
+	}
+
+	models := make(map[string]ModelConfig, len(c.Models)+1)
+	for id, m := range c.Models {
+		models[id] = m
+	}
+	models[modelID] = model
+
+	// a replaced model may have dropped an alias that is still targeted
+	updated := c.withModels(models)
+	if err := validateVirtualModels(updated); err != nil {
+		return Config{}, err
+	}
+	return updated, nil
+}
+
+// ErrModelInUse is returned when removing a model would leave a route or pool
+// without its target
+var ErrModelInUse = errors.New("model is used by a route or pool")
+
+// WithoutModel returns a copy of the config with modelID removed. Models that
+// routes or pools target can not be removed.
+func (c Config) WithoutModel(modelID string) (Config, error) {
+	models := make(map[string]ModelConfig, len(c.Models))
+	for id, m := range c.Models {
+		if id != modelID {
+			models[id] = m
+		}
+	}
+
+	updated := c.withModels(models)
+	if err := validateVirtualModels(updated); err != nil {
+		return Config{}, fmt.Errorf("%w: %v", ErrModelInUse, err)
+	}
+	return updated, nil
+}
+
+// validateVirtualModels checks that routes and pools still reach their models
+func validateVirtualModels(c Config) error {
+	if err := validatePools(c); err != nil {
+		return err
+	}
+	return validateRoutes(c)
+}
+
+func (c Config) withModels(models map[string]ModelConfig) Config {
+	c.Models = models
+
+	c.aliases = make(map[string]string)
+	for modelID, modelConfig := range models {
+		for _, alias := range modelConfig.aliasNames() {
+			c.aliases[alias] = modelID
+		}
+	}
+
+	groups := make(map[string]GroupConfig, len(c.Groups))
+	for groupID, group := range c.Groups {
+		if groupID == DEFAULT_GROUP_ID {
+			continue
+		}
+		members := make([]string, 0, len(group.Members))
+		for _, member := range group.Members {
+			if _, found := models[member]; found {
+				members = append(members, member)
+			}
+		}
+		group.Members = members
+		groups[groupID] = group
+	}
+	c.Groups = groups
+
+	return AddDefaultGroupToConfig(c)
+}
+
+// SaveModelToFile writes a model definition into the models section of the YAML
+// file at path. A nil data removes the model. Comments and formatting of the rest
+// of the file are preserved as much as the YAML encoder allows.
+func SaveModelToFile(path string, modelID string, data []byte) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: top level of the configuration must be a mapping", path)
+	}
+	root := doc.Content[0]
+
+	models := mappingValue(root, "models")
+	if models == nil {
+		if data == nil {
+			return nil
+		}
+		models = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "models"}, models)
+	}
+	if models.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: models must be a mapping", path)
+	}
+
+	index := -1
+	for i := 0; i+1 < len(models.Content); i += 2 {
+		if models.Content[i].Value == modelID {
+			index = i
+			break
+		}
+	}
+
+	if data == nil {
+		if index >= 0 {
+			models.Content = append(models.Content[:index], models.Content[index+2:]...)
+		}
+	} else {
+		modelNode, err := decodeModelNode(data)
+		if err != nil {
+			return err
+		}
+		clearFlowStyle(modelNode)
+		if index >= 0 {
+			models.Content[index+1] = modelNode
+		} else {
+			models.Content = append(models.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: modelID}, modelNode)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	// write to a temporary file first so a failed write never truncates the config
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, mode); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+// decodeModelNode parses a model definition and returns its mapping node
+func decodeModelNode(data []byte) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid model definition: %w", err)
+	}
+	node := &doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) == 1 {
+		node = node.Content[0]
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("model definition must be a mapping")
+	}
+	return node, nil
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// clearFlowStyle converts JSON style mappings and sequences to block style
+// so models added through the API look like the rest of the file
+func clearFlowStyle(node *yaml.Node) {
+	clearFlowStyleIn(node, false)
+}
+
+func clearFlowStyleIn(node *yaml.Node, inFlow bool) {
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		inFlow = inFlow || node.Style&yaml.FlowStyle != 0
+		node.Style &^= yaml.FlowStyle
+	case yaml.ScalarNode:
+		// JSON quotes every string, let the encoder decide when quotes are needed
+		if inFlow {
+			node.Style &^= yaml.DoubleQuotedStyle | yaml.SingleQuotedStyle
+		}
+	}
+	for _, child := range node.Content {
+		clearFlowStyleIn(child, inFlow)
+	}
+}
+
+// nextFreePort returns a port above every port already used by a model proxy
+func nextFreePort(c Config) int {
+	port := c.StartPort
+	if port < 1 {
+		port = 5800
+	}
+	for _, modelConfig := range c.Models {
+		proxyURL, err := url.Parse(strings.TrimSpace(modelConfig.Proxy))
+		if err != nil {
+			continue
+		}
+		if used, err := strconv.Atoi(proxyURL.Port()); err == nil && used >= port {
+			port = used + 1
+		}
+	}
+	return port
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseModelConfig(t *testing.T) {
+	base, err := LoadConfigFromReader(strings.NewReader(`
+startPort: 9000
+macros:
+  server: /usr/bin/llama-server
+  ctx: 4096
+models:
+  model1:
+    cmd: ${server} --port ${PORT}
+  model2:
+    cmd: ${server} --port 9500
+    proxy: http://localhost:9500
+`))
+	require.NoError(t, err)
+
+	t.Run("uses global macros and a free port", func(t *testing.T) {
+		model, err := ParseModelConfig(base, "model3", []byte(`{"cmd": "${server} --port ${PORT} -c ${ctx}", "ttl": 30}`))
+		require.NoError(t, err)
+		assert.Equal(t, "/usr/bin/llama-server --port 9501 -c 4096", model.Cmd)
+		assert.Equal(t, "http://localhost:9501", model.Proxy)
+		assert.Equal(t, 30, model.UnloadAfter)
+		assert.Equal(t, "/health", model.CheckEndpoint)
+	})
+
+	t.Run("accepts yaml", func(t *testing.T) {
+		model, err := ParseModelConfig(base, "model3", []byte("cmd: ${server} --port ${PORT}\nfitPolicy: spill\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "spill", model.FitPolicy)
+		assert.Contains(t, model.Cmd, "--fit")
+	})
+
+	t.Run("rejects unknown macros", func(t *testing.T) {
+		_, err := ParseModelConfig(base, "model3", []byte(`{"cmd": "${nope} --port ${PORT}"}`))
+		assert.ErrorContains(t, err, "unknown macro '${nope}'")
+	})
+
+	t.Run("rejects invalid fit policy", func(t *testing.T) {
+		_, err := ParseModelConfig(base, "model3", []byte(`{"cmd": "x --port ${PORT}", "fitPolicy": "bogus"}`))
+		assert.ErrorContains(t, err, "fitPolicy must be one of")
+	})
+
+	t.Run("uses global loading backends", func(t *testing.T) {
+		base, err := LoadConfigFromReader(strings.NewReader(`
+loadingProgress:
+  backends:
+    vllm:
+      cmd: vllm serve
+      milestones:
+        - phase: loading weights
+          regex: Loading weights
+          percent: 50
+models:
+  model1:
+    cmd: vllm serve --port ${PORT}
+`))
+		require.NoError(t, err)
+		model, err := ParseModelConfig(base, "model2", []byte(`{"cmd": "./server --port ${PORT}", "loadingBackend": "vllm"}`))
+		require.NoError(t, err)
+		assert.Equal(t, "vllm", model.LoadingBackend)
+	})
+
+	t.Run("rejects non mapping", func(t *testing.T) {
+		_, err := ParseModelConfig(base, "model3", []byte(`["a", "b"]`))
+		assert.ErrorContains(t, err, "must be a mapping")
+	})
+}
+
+func TestConfig_WithModel(t *testing.T) {
+	base, err := LoadConfigFromReader(strings.NewReader(`
+models:
+  model1:
+    cmd: server --port ${PORT}
+    aliases: [m1]
+  model2:
+    cmd: server --port ${PORT}
+    aliases: [m2]
+pools:
+  pool1:
+    models: [model2]
+routes:
+  route1:
+    default: m2
+`))
+	require.NoError(t, err)
+
+	t.Run("adds model and aliases", func(t *testing.T) {
+		updated, err := base.WithModel("model3", ModelConfig{Cmd: "server", Aliases: []string{"m3"}})
+		require.NoError(t, err)
+		realName, found := updated.RealModelName("m3")
+		assert.True(t, found)
+		assert.Equal(t, "model3", realName)
+		assert.Contains(t, updated.Groups[DEFAULT_GROUP_ID].Members, "model3")
+
+		// the original is not modified
+		_, found = base.Models["model3"]
+		assert.False(t, found)
+	})
+
+	t.Run("replacing a model can keep its aliases", func(t *testing.T) {
+		_, err := base.WithModel("model1", ModelConfig{Cmd: "server", Aliases: []string{"m1"}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("rejects duplicate aliases", func(t *testing.T) {
+		_, err := base.WithModel("model3", ModelConfig{Cmd: "server", Aliases: []string{"m1"}})
+		assert.ErrorContains(t, err, "duplicate alias m1")
+	})
+
+	t.Run("rejects ids that are aliases", func(t *testing.T) {
+		_, err := base.WithModel("m1", ModelConfig{Cmd: "server"})
+		assert.ErrorContains(t, err, "already used as an alias")
+	})
+
+	t.Run("removes model", func(t *testing.T) {
+		updated, err := base.WithoutModel("model1")
+		require.NoError(t, err)
+		_, found := updated.RealModelName("m1")
+		assert.False(t, found)
+		assert.Equal(t, []string{"model2"}, updated.Groups[DEFAULT_GROUP_ID].Members)
+	})
+
+	t.Run("rejects removing a targeted model", func(t *testing.T) {
+		_, err := base.WithoutModel("model2")
+		assert.ErrorIs(t, err, ErrModelInUse)
+	})
+
+	t.Run("rejects dropping a targeted alias", func(t *testing.T) {
+		_, err := base.WithModel("model2", ModelConfig{Cmd: "server"})
+		assert.ErrorContains(t, err, "routes.route1.default: unknown model m2")
+	})
+}
+
+func TestSaveModelToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`# top comment
+healthCheckTimeout: 30
+models:
+  # the first model
+  model1:
+    cmd: server --port ${PORT}
+`), 0600))
+
+	require.NoError(t, SaveModelToFile(path, "model2", []byte(`{"cmd": "server --port ${PORT}", "aliases": ["m2"]}`)))
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(saved), "# top comment")
+	assert.Contains(t, string(saved), "# the first model")
+	assert.Contains(t, string(saved), "  model2:\n    cmd: server --port ${PORT}\n    aliases:\n      - m2\n")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Len(t, loaded.Models, 2)
+
+	require.NoError(t, SaveModelToFile(path, "model1", nil))
+	loaded, err = LoadConfig(path)
+	require.NoError(t, err)
+	assert.Len(t, loaded.Models, 1)
+	_, found := loaded.Models["model2"]
+	assert.True(t, found)
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OllamaConfig enables the Ollama compatible API. Requests are translated to
+// OpenAI style upstream calls.
+type OllamaConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// the Ollama routes are served under this path, e.g. /ollama/api/tags
+	Prefix string `yaml:"prefix"`
+
+	// optional address of a second listener that serves the Ollama routes
+	// without the prefix, e.g. :11434
+	Listen string `yaml:"listen"`
+}
+
+// paths already used by llama-swap that the prefix must not shadow
+var reservedOllamaPrefixes = []string{"/api", "/v1", "/ui", "/upstream", "/logs"}
+
+func validateOllama(config *Config) error {
+	ollama := &config.Ollama
+	ollama.Prefix = strings.TrimRight(strings.TrimSpace(ollama.Prefix), "/")
+	ollama.Listen = strings.TrimSpace(ollama.Listen)
+	if !ollama.Enabled {
+		return nil
+	}
+
+	if !strings.HasPrefix(ollama.Prefix, "/") {
+		return fmt.Errorf("ollama.prefix must start with / and can not be the root path")
+	}
+	for _, reserved := range reservedOllamaPrefixes {
+		if ollama.Prefix == reserved || strings.HasPrefix(ollama.Prefix, reserved+"/") {
+			return fmt.Errorf("ollama.prefix %s conflicts with llama-swap's %s routes", ollama.Prefix, reserved)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOllama_LoadAndValidate(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader("ollama:\n  enabled: true\n  prefix: /compat/ollama/\n  listen: \":11434\"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, OllamaConfig{Enabled: true, Prefix: "/compat/ollama", Listen: ":11434"}, config.Ollama)
+
+	config, err = LoadConfigFromReader(strings.NewReader("ollama:\n  prefix: /api\n"))
+	require.NoError(t, err, "prefix is only checked when enabled")
+	assert.False(t, config.Ollama.Enabled)
+
+	tests := []struct {
+		name   string
+		prefix string
+		errMsg string
+	}{
+		{"root", "/", "ollama.prefix must start with / and can not be the root path"},
+		{"relative", "ollama", "ollama.prefix must start with / and can not be the root path"},
+		{"api group", "/api", "ollama.prefix /api conflicts with llama-swap's /api routes"},
+		{"under v1", "/v1/ollama", "ollama.prefix /v1/ollama conflicts with llama-swap's /v1 routes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader("ollama:\n  enabled: true\n  prefix: " + tt.prefix + "\n"))
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
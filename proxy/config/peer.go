@@ -3,8 +3,29 @@ package config
 import (
 	"fmt"
 	"net/url"
+	"regexp"
 )
 
+// ways to pick a peer when several peers serve a model
+const (
+	// the first peer by ID, the next ones only when it can not be reached
+	PeerSelectionFirst         = "first"
+	PeerSelectionRoundRobin    = "round-robin"
+	PeerSelectionLeastInFlight = "least-in-flight"
+	// a peer that has the model loaded according to its /running
+	PeerSelectionLoaded = "loaded"
+)
+
+// PeerOffloadConfig sends requests for a local model that a peer also serves
+// to the peer when starting the local model would evict other models or the
+// model does not fit
+type PeerOffloadConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// only offload to peers that have the model loaded according to their /running
+	RequireLoaded bool `yaml:"requireLoaded"`
+}
+
 type PeerDictionaryConfig map[string]PeerConfig
 type PeerConfig struct {
 	Proxy    string   `yaml:"proxy"`
@@ -12,6 +33,57 @@ type PeerConfig struct {
 	ApiKey   string   `yaml:"apiKey"`
 	Models   []string `yaml:"models"`
 	Filters  Filters  `yaml:"filters"`
+
+	// limits and connection pool settings of requests to the peer. The
+	// response header timeout defaults to 60 seconds, 0 disables it.
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+
+	// keep the models of the peer up to date from its /v1/models
+	Discover PeerDiscoverConfig `yaml:"discover"`
+
+	// poll the peer and stop routing to it while it is down
+	HealthCheck PeerHealthCheckConfig `yaml:"healthCheck"`
+}
+
+type PeerDiscoverConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// regular expressions of discovered model ids to use or skip
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// Matches is true when a discovered model passes the include and exclude
+// patterns
+func (d PeerDiscoverConfig) Matches(modelID string) bool {
+	included := len(d.Include) == 0
+	for _, pattern := range d.Include {
+		if matched, _ := regexp.MatchString(pattern, modelID); matched {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range d.Exclude {
+		if matched, _ := regexp.MatchString(pattern, modelID); matched {
+			return false
+		}
+	}
+	return true
+}
+
+type PeerHealthCheckConfig struct {
+	// seconds between checks, 0 disables health checks. Defaults to 60 when
+	// discovery is enabled.
+	Interval int `yaml:"interval"`
+
+	// seconds to wait for the peer to respond, default 5
+	Timeout int `yaml:"timeout"`
+
+	// seconds, checks of a peer that is down back off up to this, default 300
+	MaxBackoff int `yaml:"maxBackoff"`
 }
 
 func (c *PeerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -21,6 +93,9 @@ func (c *PeerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		ApiKey:  "",
 		Models:  []string{},
 		Filters: Filters{},
+
+		// hosted APIs that never answer should not hold requests forever
+		Timeouts: TimeoutsConfig{ResponseHeader: 60},
 	}
 
 	if err := unmarshal(&defaults); err != nil {
@@ -40,10 +115,43 @@ func (c *PeerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	defaults.ProxyURL = parsedURL
 
 	// Validate models is not empty
-	if len(defaults.Models) == 0 {
+	if len(defaults.Models) == 0 && !defaults.Discover.Enabled {
 		return fmt.Errorf("peer models can not be empty")
 	}
 
+	for _, pattern := range append(defaults.Discover.Include, defaults.Discover.Exclude...) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid discover pattern (%s): %w", pattern, err)
+		}
+	}
+
+	health := &defaults.HealthCheck
+	if health.Interval < 0 || health.Timeout < 0 || health.MaxBackoff < 0 {
+		return fmt.Errorf("healthCheck values can not be negative")
+	}
+	if health.Interval == 0 && defaults.Discover.Enabled {
+		health.Interval = 60
+	}
+	if health.Timeout == 0 {
+		health.Timeout = 5
+	}
+	if health.MaxBackoff == 0 {
+		health.MaxBackoff = 300
+	}
+	if health.MaxBackoff < health.Interval {
+		health.MaxBackoff = health.Interval
+	}
+
 	*c = PeerConfig(defaults)
 	return nil
 }
+
+func validatePeerSelection(config *Config) error {
+	switch config.PeerSelection {
+	case "", PeerSelectionFirst, PeerSelectionRoundRobin, PeerSelectionLeastInFlight, PeerSelectionLoaded:
+	default:
+		return fmt.Errorf("peerSelection must be one of %s, %s, %s or %s", PeerSelectionFirst,
+			PeerSelectionRoundRobin, PeerSelectionLeastInFlight, PeerSelectionLoaded)
+	}
+	return nil
+}
@@ -73,6 +73,36 @@ models: []
 `,
 			wantErr: "peer models can not be empty",
 		},
+		{
+			name: "discovered models",
+			yaml: `
+proxy: http://localhost:8080
+discover:
+  enabled: true
+  include: ["^qwen"]
+`,
+			wantErr: "",
+		},
+		{
+			name: "invalid discover pattern",
+			yaml: `
+proxy: http://localhost:8080
+discover:
+  enabled: true
+  exclude: ["("]
+`,
+			wantErr: "invalid discover pattern",
+		},
+		{
+			name: "negative health check",
+			yaml: `
+proxy: http://localhost:8080
+models: [model_a]
+healthCheck:
+  interval: -1
+`,
+			wantErr: "healthCheck values can not be negative",
+		},
 	}
 
 	for _, tt := range tests {
@@ -207,3 +237,46 @@ filters:
 		t.Errorf("expected max_tokens 1000, got %v", config.Filters.SetParams["max_tokens"])
 	}
 }
+
+func TestPeerConfig_HealthCheckDefaults(t *testing.T) {
+	var config PeerConfig
+	err := yaml.Unmarshal([]byte(`
+proxy: http://localhost:8080
+models: [model_a]
+`), &config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.HealthCheck != (PeerHealthCheckConfig{Interval: 0, Timeout: 5, MaxBackoff: 300}) {
+		t.Errorf("unexpected health check defaults: %+v", config.HealthCheck)
+	}
+
+	err = yaml.Unmarshal([]byte(`
+proxy: http://localhost:8080
+discover:
+  enabled: true
+`), &config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.HealthCheck.Interval != 60 {
+		t.Errorf("expected discovery to poll every 60 seconds, got %d", config.HealthCheck.Interval)
+	}
+}
+
+func TestPeerDiscoverConfig_Matches(t *testing.T) {
+	discover := PeerDiscoverConfig{Include: []string{"^qwen", "^llama"}, Exclude: []string{"embed"}}
+	for modelID, want := range map[string]bool{
+		"qwen-8b":    true,
+		"llama-3":    true,
+		"qwen-embed": false,
+		"gemma":      false,
+	} {
+		if got := discover.Matches(modelID); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", modelID, got, want)
+		}
+	}
+	if !(PeerDiscoverConfig{}).Matches("anything") {
+		t.Error("expected all models to match without patterns")
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// PresetConfig is an alias of a model that applies its own filters. A preset
+// uses the model's process, it is listed in /v1/models under its own name and
+// its metrics are recorded under that name.
+type PresetConfig struct {
+	// for /v1/models
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	Metadata    map[string]any `yaml:"metadata"`
+	Unlisted    bool           `yaml:"unlisted"`
+
+	// added to the model's filters, the preset's values win
+	Filters Filters `yaml:"filters"`
+}
+
+// PresetFor returns the preset that name refers to
+func (c *Config) PresetFor(name string) (PresetConfig, string, bool) {
+	modelID, found := c.RealModelName(name)
+	if !found {
+		return PresetConfig{}, "", false
+	}
+	preset, found := c.Models[modelID].Presets[name]
+	return preset, modelID, found
+}
+
+// FiltersFor returns the filters of a request for name to path. name is a
+// model ID, an alias or a preset.
+func (c *Config) FiltersFor(name, path string) Filters {
+	modelID, found := c.RealModelName(name)
+	if !found {
+		return Filters{}
+	}
+	filters := c.Models[modelID].Filters.Filters
+	if preset, found := c.Models[modelID].Presets[name]; found {
+		filters = filters.merge(preset.Filters)
+	}
+	return filters.ForPath(path)
+}
+
+func validatePresets(config *Config) error {
+	for modelID, modelConfig := range config.Models {
+		for name, preset := range modelConfig.Presets {
+			if strings.TrimSpace(name) == "" || name != strings.TrimSpace(name) {
+				return fmt.Errorf("model %s: preset name %q must not be empty or have leading or trailing whitespace", modelID, name)
+			}
+			if _, found := config.Models[name]; found {
+				return fmt.Errorf("model %s: preset %s conflicts with an existing model id", modelID, name)
+			}
+			if err := preset.Filters.validate(); err != nil {
+				return fmt.Errorf("model %s: presets.%s.%w", modelID, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// aliasNames returns the aliases and the preset names of a model, presets
+// are aliases with their own filters
+func (m ModelConfig) aliasNames() []string {
+	return append(slices.Clone(m.Aliases), slices.Sorted(maps.Keys(m.Presets))...)
+}
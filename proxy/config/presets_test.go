@@ -0,0 +1,91 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresets_FiltersFor(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+models:
+  qwen:
+    cmd: ./server --port ${PORT}
+    aliases: [qwen-latest]
+    filters:
+      stripParams: "seed"
+      setParams:
+        temperature: 0.7
+        top_p: 0.9
+    presets:
+      qwen-creative:
+        name: Qwen creative
+        filters:
+          setParams:
+            temperature: 1.2
+      qwen-precise:
+        filters:
+          stripParams: "top_k"
+          setParams:
+            temperature: 0.1
+          systemMessage:
+            content: Be precise.
+`))
+	require.NoError(t, err)
+
+	for _, name := range []string{"qwen-creative", "qwen-precise", "qwen-latest"} {
+		modelID, found := config.RealModelName(name)
+		assert.True(t, found)
+		assert.Equal(t, "qwen", modelID)
+	}
+
+	preset, modelID, found := config.PresetFor("qwen-creative")
+	require.True(t, found)
+	assert.Equal(t, "qwen", modelID)
+	assert.Equal(t, "Qwen creative", preset.Name)
+	_, _, found = config.PresetFor("qwen-latest")
+	assert.False(t, found, "plain aliases are not presets")
+
+	creative := config.FiltersFor("qwen-creative", "/v1/chat/completions")
+	assert.Equal(t, map[string]any{"temperature": 1.2, "top_p": 0.9}, creative.SetParams)
+
+	precise := config.FiltersFor("qwen-precise", "/v1/chat/completions")
+	assert.Equal(t, []string{"seed", "top_k"}, precise.SanitizedStripParams())
+	assert.Equal(t, 0.1, precise.SetParams["temperature"])
+	assert.Equal(t, "Be precise.", precise.SystemMessage.Content)
+
+	assert.Equal(t, config.Models["qwen"].Filters.Filters, config.FiltersFor("qwen-latest", "/v1/chat/completions"))
+	assert.Equal(t, Filters{}, config.FiltersFor("unknown", "/v1/chat/completions"))
+}
+
+func TestPresets_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		models string
+		err    string
+	}{
+		{
+			"duplicate alias",
+			"  model1:\n    cmd: ./server --port ${PORT}\n    aliases: [fast]\n    presets:\n      fast: {}",
+			"duplicate alias fast found in model: model1",
+		},
+		{
+			"model id",
+			"  model1:\n    cmd: ./server --port ${PORT}\n  model2:\n    cmd: ./server --port ${PORT}\n    presets:\n      model1: {}",
+			"model model2: preset model1 conflicts with an existing model id",
+		},
+		{
+			"filters",
+			"  model1:\n    cmd: ./server --port ${PORT}\n    presets:\n      fast:\n        filters:\n          systemMessage:\n            mode: never",
+			"model model1: presets.fast.filters.systemMessage.mode must be one of replace, prepend, append or missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader("models:\n" + tt.models + "\n"))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
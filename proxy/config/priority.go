@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PriorityConfig sets how request priorities are assigned. A request with a
+// higher priority can preempt processes that only serve lower priority
+// requests when it needs their memory or concurrency slots.
+type PriorityConfig struct {
+	// header clients use to set the priority of a request, off when empty
+	Header string `yaml:"header"`
+
+	// priority of requests made with these API keys
+	APIKeys map[string]int `yaml:"apiKeys"`
+
+	// priority of requests made by the batch API
+	Batch int `yaml:"batch"`
+
+	// seconds preempted requests get to finish before they are aborted
+	DrainTimeout int `yaml:"drainTimeout"`
+
+	// seconds a request waits for memory held by busy processes, 0 fails right away
+	MaxWait int `yaml:"maxWait"`
+
+	// a waiting request gains one priority level every agingInterval seconds, 0 disables aging
+	AgingInterval int `yaml:"agingInterval"`
+}
+
+func validatePriority(config *Config) error {
+	priority := &config.Priority
+	priority.Header = strings.TrimSpace(priority.Header)
+	if priority.DrainTimeout < 0 {
+		return fmt.Errorf("priority.drainTimeout must be 0 or greater")
+	}
+	if priority.MaxWait < 0 {
+		return fmt.Errorf("priority.maxWait must be 0 or greater")
+	}
+	if priority.AgingInterval < 0 {
+		return fmt.Errorf("priority.agingInterval must be 0 or greater")
+	}
+	for key := range priority.APIKeys {
+		found := false
+		for _, apiKey := range config.RequiredAPIKeys {
+			if key == apiKey {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("priority.apiKeys contains a key that is not listed in apiKeys")
+		}
+	}
+	return nil
+}
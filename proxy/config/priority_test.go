@@ -0,0 +1,47 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriority_LoadAndValidate(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+apiKeys: ["sk-chat", "sk-jobs"]
+priority:
+  apiKeys:
+    sk-chat: 10
+    sk-jobs: -5
+  maxWait: 60
+models:
+  model1:
+    cmd: server --port ${PORT}
+    priority: 5
+`))
+	require.NoError(t, err)
+	assert.Equal(t, PriorityConfig{
+		APIKeys:       map[string]int{"sk-chat": 10, "sk-jobs": -5},
+		Batch:         -10,
+		DrainTimeout:  10,
+		MaxWait:       60,
+		AgingInterval: 10,
+	}, config.Priority)
+	assert.Equal(t, 5, config.Models["model1"].Priority)
+
+	tests := []struct {
+		yaml string
+		err  string
+	}{
+		{"priority:\n  drainTimeout: -1\n", "priority.drainTimeout must be 0 or greater"},
+		{"priority:\n  maxWait: -1\n", "priority.maxWait must be 0 or greater"},
+		{"priority:\n  agingInterval: -1\n", "priority.agingInterval must be 0 or greater"},
+		{"apiKeys: [\"sk-chat\"]\npriority:\n  apiKeys:\n    sk-other: 1\n", "priority.apiKeys contains a key that is not listed in apiKeys"},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfigFromReader(strings.NewReader(tt.yaml))
+		assert.ErrorContains(t, err, tt.err)
+	}
+}
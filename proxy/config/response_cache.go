@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ResponseCacheMemory = "memory"
+	ResponseCacheDisk   = "disk"
+)
+
+// ResponseCacheConfig configures the storage of the response cache. Caching
+// is enabled per model with the model's responseCache setting.
+type ResponseCacheConfig struct {
+	// memory or disk
+	Type string `yaml:"type"`
+
+	// directory for the disk cache
+	Path string `yaml:"path"`
+
+	// the least recently used responses are removed once the cache is larger than this
+	MaxSizeMB int `yaml:"maxSizeMB"`
+
+	// seconds a response is served from the cache
+	TTL int `yaml:"ttl"`
+}
+
+func validateResponseCache(config *Config) error {
+	cache := &config.ResponseCache
+	cache.Type = strings.ToLower(strings.TrimSpace(cache.Type))
+	cache.Path = strings.TrimSpace(cache.Path)
+	switch cache.Type {
+	case ResponseCacheMemory:
+	case ResponseCacheDisk:
+		if cache.Path == "" {
+			return fmt.Errorf("responseCache.path is required for the disk cache")
+		}
+	default:
+		return fmt.Errorf("responseCache.type must be one of: %s, %s", ResponseCacheMemory, ResponseCacheDisk)
+	}
+	if cache.MaxSizeMB < 1 {
+		return fmt.Errorf("responseCache.maxSizeMB must be greater than 0")
+	}
+	if cache.TTL < 1 {
+		return fmt.Errorf("responseCache.ttl must be greater than 0")
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCache_LoadAndValidate(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader("responseCache:\n  type: DISK\n  path: /tmp/cache\n  maxSizeMB: 64\n  ttl: 60\nmodels:\n  model1:\n    cmd: server --port ${PORT}\n    responseCache: true\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ResponseCacheConfig{Type: ResponseCacheDisk, Path: "/tmp/cache", MaxSizeMB: 64, TTL: 60}, config.ResponseCache)
+	assert.True(t, config.Models["model1"].ResponseCache)
+
+	tests := []struct {
+		name   string
+		yaml   string
+		errMsg string
+	}{
+		{"unknown type", "responseCache:\n  type: redis\n", "responseCache.type must be one of: memory, disk"},
+		{"disk without path", "responseCache:\n  type: disk\n", "responseCache.path is required for the disk cache"},
+		{"no size", "responseCache:\n  maxSizeMB: 0\n", "responseCache.maxSizeMB must be greater than 0"},
+		{"no ttl", "responseCache:\n  ttl: 0\n", "responseCache.ttl must be greater than 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader(tt.yaml))
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ResponsesStoreMemory = "memory"
+	ResponsesStoreDisk   = "disk"
+)
+
+// ResponsesStoreConfig configures where responses emulated with the
+// "responses" apiTranslation are kept so they can be continued with
+// previous_response_id
+type ResponsesStoreConfig struct {
+	// memory or disk
+	Type string `yaml:"type"`
+
+	// directory for the disk store
+	Path string `yaml:"path"`
+
+	// oldest responses are removed after this many are stored
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+func validateResponsesStore(config *Config) error {
+	store := &config.ResponsesStore
+	store.Type = strings.ToLower(strings.TrimSpace(store.Type))
+	switch store.Type {
+	case ResponsesStoreMemory:
+	case ResponsesStoreDisk:
+		if strings.TrimSpace(store.Path) == "" {
+			return fmt.Errorf("responsesStore.path is required for the disk store")
+		}
+	default:
+		return fmt.Errorf("responsesStore.type must be one of: %s, %s", ResponsesStoreMemory, ResponsesStoreDisk)
+	}
+	if store.MaxEntries < 1 {
+		return fmt.Errorf("responsesStore.maxEntries must be greater than 0")
+	}
+	return nil
+}
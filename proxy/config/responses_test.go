@@ -0,0 +1,31 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponsesStore_LoadAndValidate(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader("responsesStore:\n  type: Disk\n  path: /tmp/responses\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ResponsesStoreConfig{Type: ResponsesStoreDisk, Path: "/tmp/responses", MaxEntries: 1000}, config.ResponsesStore)
+
+	tests := []struct {
+		name    string
+		content string
+		errMsg  string
+	}{
+		{"unknown type", "responsesStore:\n  type: redis\n", "responsesStore.type must be one of: memory, disk"},
+		{"disk without path", "responsesStore:\n  type: disk\n", "responsesStore.path is required for the disk store"},
+		{"max entries", "responsesStore:\n  maxEntries: 0\n", "responsesStore.maxEntries must be greater than 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader(tt.content))
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
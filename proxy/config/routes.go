@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RoutesConfig maps a virtual model name to the rules used to pick a real model
+type RoutesConfig map[string]RouteConfig
+
+// RouteConfig picks a model for a virtual model name. Rules are checked in
+// order and the first match wins. Default is used when no rule matches.
+type RouteConfig struct {
+	Rules   []RouteRule `yaml:"rules"`
+	Default string      `yaml:"default"`
+}
+
+// RouteRule matches when all of its conditions match. Unset conditions are ignored.
+type RouteRule struct {
+	// the model ID or alias to use when the rule matches
+	Model string `yaml:"model"`
+
+	MinPromptChars  int      `yaml:"minPromptChars"`
+	MinPromptTokens int      `yaml:"minPromptTokens"`
+	HasImages       *bool    `yaml:"hasImages"`
+	HasTools        *bool    `yaml:"hasTools"`
+	Paths           []string `yaml:"paths"`
+}
+
+// PoolsConfig maps a virtual model name to a pool of interchangeable models
+type PoolsConfig map[string]PoolConfig
+
+// PoolConfig lists candidate models in order of preference. A model that is
+// already loaded is preferred over starting another one.
+type PoolConfig struct {
+	Models []string `yaml:"models"`
+}
+
+// RouteRequest describes the parts of a request route rules can match on
+type RouteRequest struct {
+	Path         string
+	PromptChars  int
+	PromptTokens int
+	HasImages    bool
+	HasTools     bool
+}
+
+// Matches returns true when every condition set on the rule matches req
+func (r RouteRule) Matches(req RouteRequest) bool {
+	if r.MinPromptChars > 0 && req.PromptChars < r.MinPromptChars {
+		return false
+	}
+	if r.MinPromptTokens > 0 && req.PromptTokens < r.MinPromptTokens {
+		return false
+	}
+	if r.HasImages != nil && *r.HasImages != req.HasImages {
+		return false
+	}
+	if r.HasTools != nil && *r.HasTools != req.HasTools {
+		return false
+	}
+	if len(r.Paths) > 0 {
+		found := false
+		for _, path := range r.Paths {
+			if path == req.Path {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the model for req, or false if no rule matches and there is no default
+func (r RouteConfig) Select(req RouteRequest) (string, bool) {
+	for _, rule := range r.Rules {
+		if rule.Matches(req) {
+			return rule.Model, true
+		}
+	}
+	if r.Default != "" {
+		return r.Default, true
+	}
+	return "", false
+}
+
+// validateRoutes checks route names do not shadow models and that every target
+// is a local model, an alias, a pool or a peer model
+func validateRoutes(config Config) error {
+	isTarget := func(name string) bool {
+		if _, found := config.RealModelName(name); found {
+			return true
+		}
+		if _, found := config.Pools[name]; found {
+			return true
+		}
+		for _, peer := range config.Peers {
+			for _, model := range peer.Models {
+				if model == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for name, route := range config.Routes {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("routes key cannot be empty")
+		}
+		if _, found := config.RealModelName(name); found {
+			return fmt.Errorf("routes.%s: name conflicts with a model id or alias", name)
+		}
+		if _, found := config.Pools[name]; found {
+			return fmt.Errorf("routes.%s: name conflicts with a pool", name)
+		}
+		if len(route.Rules) == 0 && route.Default == "" {
+			return fmt.Errorf("routes.%s: needs at least one rule or a default", name)
+		}
+		for i, rule := range route.Rules {
+			if rule.Model == "" {
+				return fmt.Errorf("routes.%s.rules[%d]: model is required", name, i)
+			}
+			if !isTarget(rule.Model) {
+				return fmt.Errorf("routes.%s.rules[%d]: unknown model %s", name, i, rule.Model)
+			}
+			if rule.MinPromptChars < 0 || rule.MinPromptTokens < 0 {
+				return fmt.Errorf("routes.%s.rules[%d]: minPromptChars and minPromptTokens must not be negative", name, i)
+			}
+		}
+		if route.Default != "" && !isTarget(route.Default) {
+			return fmt.Errorf("routes.%s.default: unknown model %s", name, route.Default)
+		}
+	}
+	return nil
+}
+
+// validatePools checks pool names do not shadow models and that every member
+// is a local model or alias
+func validatePools(config Config) error {
+	for name, pool := range config.Pools {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("pools key cannot be empty")
+		}
+		if _, found := config.RealModelName(name); found {
+			return fmt.Errorf("pools.%s: name conflicts with a model id or alias", name)
+		}
+		if len(pool.Models) == 0 {
+			return fmt.Errorf("pools.%s: models can not be empty", name)
+		}
+		for _, model := range pool.Models {
+			if _, found := config.RealModelName(model); !found {
+				return fmt.Errorf("pools.%s: unknown model %s", name, model)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutes_LoadAndSelect(t *testing.T) {
+	content := `
+models:
+  small:
+    cmd: server --port ${PORT}
+    aliases: [small-alias]
+  long:
+    cmd: server --port ${PORT}
+  vision:
+    cmd: server --port ${PORT}
+  fim:
+    cmd: server --port ${PORT}
+peers:
+  remote:
+    proxy: http://peer:8080
+    models: [tools-model]
+routes:
+  auto:
+    rules:
+      - paths: [/infill]
+        model: fim
+      - hasImages: true
+        model: vision
+      - hasTools: true
+        model: tools-model
+      - minPromptTokens: 1000
+        model: long
+    default: small-alias
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	require.NoError(t, err)
+	route := config.Routes["auto"]
+
+	tests := []struct {
+		name     string
+		req      RouteRequest
+		expected string
+	}{
+		{"path", RouteRequest{Path: "/infill", HasImages: true}, "fim"},
+		{"images", RouteRequest{Path: "/v1/chat/completions", HasImages: true, HasTools: true}, "vision"},
+		{"tools", RouteRequest{HasTools: true}, "tools-model"},
+		{"long prompt", RouteRequest{PromptTokens: 1000}, "long"},
+		{"default", RouteRequest{PromptTokens: 999}, "small-alias"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, found := route.Select(tt.req)
+			assert.True(t, found)
+			assert.Equal(t, tt.expected, model)
+		})
+	}
+
+	_, found := RouteConfig{Rules: []RouteRule{{Model: "small", HasTools: new(bool)}}}.Select(RouteRequest{HasTools: true})
+	assert.False(t, found)
+}
+
+func TestRoutes_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		errMsg string
+	}{
+		{"shadows model", "model1:\n    default: model1", "routes.model1: name conflicts with a model id or alias"},
+		{"shadows alias", "m1:\n    default: model1", "routes.m1: name conflicts with a model id or alias"},
+		{"empty", "auto: {}", "routes.auto: needs at least one rule or a default"},
+		{"missing model", "auto:\n    rules:\n      - hasTools: true", "routes.auto.rules[0]: model is required"},
+		{"unknown model", "auto:\n    rules:\n      - model: nope", "routes.auto.rules[0]: unknown model nope"},
+		{"unknown default", "auto:\n    default: nope", "routes.auto.default: unknown model nope"},
+		{"negative", "auto:\n    rules:\n      - model: model1\n        minPromptChars: -1", "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "models:\n  model1:\n    cmd: server\n    proxy: http://localhost:8080\n    aliases: [m1]\nroutes:\n  " + tt.routes + "\n"
+			_, err := LoadConfigFromReader(strings.NewReader(content))
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestPools_Validation(t *testing.T) {
+	base := "models:\n  model1:\n    cmd: server\n    proxy: http://localhost:8080\n    aliases: [m1]\n"
+
+	config, err := LoadConfigFromReader(strings.NewReader(base + "pools:\n  coder:\n    models: [m1]\nroutes:\n  auto:\n    default: coder\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m1"}, config.Pools["coder"].Models)
+
+	tests := []struct {
+		name    string
+		content string
+		errMsg  string
+	}{
+		{"shadows model", "pools:\n  model1:\n    models: [m1]\n", "pools.model1: name conflicts with a model id or alias"},
+		{"empty", "pools:\n  coder: {}\n", "pools.coder: models can not be empty"},
+		{"unknown model", "pools:\n  coder:\n    models: [nope]\n", "pools.coder: unknown model nope"},
+		{"shadows route", "pools:\n  auto:\n    models: [m1]\nroutes:\n  auto:\n    default: m1\n", "routes.auto: name conflicts with a pool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader(base + tt.content))
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SwapSchedulerConfig holds requests for models that are not loaded while the
+// loaded models serve their requests, so alternating clients do not cause a
+// swap for every request.
+type SwapSchedulerConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// longest time in milliseconds a request is held before it swaps anyway
+	MaxHoldMs int `yaml:"maxHoldMs"`
+
+	// milliseconds a loaded model is kept before held requests can swap it out
+	MinResidencyMs int `yaml:"minResidencyMs"`
+}
+
+func validateSwapScheduler(config *Config) error {
+	if config.SwapScheduler.MaxHoldMs < 0 {
+		return fmt.Errorf("swapScheduler.maxHoldMs must be 0 or greater")
+	}
+	if config.SwapScheduler.MinResidencyMs < 0 {
+		return fmt.Errorf("swapScheduler.minResidencyMs must be 0 or greater")
+	}
+	if config.SwapScheduler.Enabled && !usesScheduler(config) {
+		return fmt.Errorf("swapScheduler needs the VRAM scheduler: set fitPolicy evict_to_fit on a model or a memory cap")
+	}
+	for modelID, modelConfig := range config.Models {
+		if modelConfig.MaxHoldMs < 0 {
+			return fmt.Errorf("model %s: maxHoldMs must be 0 or greater", modelID)
+		}
+		if modelConfig.MinResidencyMs < 0 {
+			return fmt.Errorf("model %s: minResidencyMs must be 0 or greater", modelID)
+		}
+	}
+	return nil
+}
+
+// usesScheduler is true when llama-swap places models with the VRAM
+// scheduler, see fitPolicy and the memory caps
+func usesScheduler(config *Config) bool {
+	if config.HostRamCapMB > 0 || config.GpuVramCapMB > 0 || len(config.GpuVramCapsMB) > 0 {
+		return true
+	}
+	for _, model := range config.Models {
+		if strings.EqualFold(model.FitPolicy, "evict_to_fit") {
+			return true
+		}
+		if strings.EqualFold(model.FitPolicy, "cpu_moe") && model.InitialVramMB > 0 {
+			return true
+		}
+	}
+	return false
+}
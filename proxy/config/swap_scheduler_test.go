@@ -0,0 +1,40 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapScheduler_LoadAndValidate(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+swapScheduler:
+  enabled: true
+  maxHoldMs: 1000
+models:
+  model1:
+    cmd: server --port ${PORT}
+    fitPolicy: evict_to_fit
+    minResidencyMs: 30000
+`))
+	require.NoError(t, err)
+	assert.Equal(t, SwapSchedulerConfig{Enabled: true, MaxHoldMs: 1000, MinResidencyMs: 5000}, config.SwapScheduler)
+	assert.Equal(t, 30000, config.Models["model1"].MinResidencyMs)
+	assert.Equal(t, 0, config.Models["model1"].MaxHoldMs)
+
+	tests := []struct {
+		yaml string
+		err  string
+	}{
+		{"swapScheduler:\n  maxHoldMs: -1\n", "swapScheduler.maxHoldMs must be 0 or greater"},
+		{"swapScheduler:\n  minResidencyMs: -1\n", "swapScheduler.minResidencyMs must be 0 or greater"},
+		{"models:\n  model1:\n    cmd: server --port ${PORT}\n    maxHoldMs: -5\n", "model model1: maxHoldMs must be 0 or greater"},
+		{"swapScheduler:\n  enabled: true\nmodels:\n  model1:\n    cmd: server --port ${PORT}\n", "swapScheduler needs the VRAM scheduler"},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfigFromReader(strings.NewReader(tt.yaml))
+		assert.ErrorContains(t, err, tt.err)
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeoutsConfig are the limits and connection pool settings of requests to
+// an upstream. Times are in seconds.
+type TimeoutsConfig struct {
+	// establishing a connection, 0 uses 30s
+	Connect int `yaml:"connect"`
+
+	// waiting for the response headers, this includes prompt processing of
+	// non streaming requests. 0 disables the limit.
+	ResponseHeader int `yaml:"responseHeader"`
+
+	// longest pause between two chunks of the response body, 0 disables the limit
+	Idle int `yaml:"idle"`
+
+	// whole request, 0 disables the limit
+	Total int `yaml:"total"`
+
+	// interval of TCP keep-alive probes, 0 uses 30s
+	KeepAlive int `yaml:"keepAlive"`
+
+	// how long an unused connection is kept in the pool, 0 uses 90s
+	IdleConnTimeout int `yaml:"idleConnTimeout"`
+
+	// connection pool sizes, 0 uses 100 idle connections, 10 idle connections
+	// per host and no limit of connections per host
+	MaxIdleConns        int `yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost int `yaml:"maxIdleConnsPerHost"`
+	MaxConnsPerHost     int `yaml:"maxConnsPerHost"`
+}
+
+func seconds(value int) time.Duration {
+	return time.Duration(value) * time.Second
+}
+
+func (t TimeoutsConfig) ConnectTimeout() time.Duration        { return seconds(t.Connect) }
+func (t TimeoutsConfig) ResponseHeaderTimeout() time.Duration { return seconds(t.ResponseHeader) }
+func (t TimeoutsConfig) IdleTimeout() time.Duration           { return seconds(t.Idle) }
+func (t TimeoutsConfig) TotalTimeout() time.Duration          { return seconds(t.Total) }
+func (t TimeoutsConfig) KeepAliveInterval() time.Duration     { return seconds(t.KeepAlive) }
+func (t TimeoutsConfig) IdleConnTimeoutDuration() time.Duration {
+	return seconds(t.IdleConnTimeout)
+}
+
+func (t TimeoutsConfig) validate() error {
+	values := []struct {
+		name  string
+		value int
+	}{
+		{"connect", t.Connect},
+		{"responseHeader", t.ResponseHeader},
+		{"idle", t.Idle},
+		{"total", t.Total},
+		{"keepAlive", t.KeepAlive},
+		{"idleConnTimeout", t.IdleConnTimeout},
+		{"maxIdleConns", t.MaxIdleConns},
+		{"maxIdleConnsPerHost", t.MaxIdleConnsPerHost},
+		{"maxConnsPerHost", t.MaxConnsPerHost},
+	}
+	for _, v := range values {
+		if v.value < 0 {
+			return fmt.Errorf("timeouts.%s must be 0 or greater", v.name)
+		}
+	}
+	return nil
+}
+
+func validateTimeouts(config *Config) error {
+	for modelID, modelConfig := range config.Models {
+		if err := modelConfig.Timeouts.validate(); err != nil {
+			return fmt.Errorf("model %s: %w", modelID, err)
+		}
+	}
+	for peerID, peerConfig := range config.Peers {
+		if err := peerConfig.Timeouts.validate(); err != nil {
+			return fmt.Errorf("peer %s: %w", peerID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeouts_Load(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+    timeouts:
+      connect: 5
+      idle: 120
+      total: 1800
+      maxConnsPerHost: 4
+  model2:
+    cmd: ./server --port ${PORT}
+peers:
+  peer1:
+    proxy: http://peer1:8080
+    models: [peer-model]
+    timeouts:
+      responseHeader: 600
+  peer2:
+    proxy: http://peer2:8080
+    models: [peer2-model]
+  peer3:
+    proxy: http://peer3:8080
+    models: [peer3-model]
+    timeouts:
+      responseHeader: 0
+`))
+	require.NoError(t, err)
+
+	timeouts := config.Models["model1"].Timeouts
+	assert.Equal(t, 5*time.Second, timeouts.ConnectTimeout())
+	assert.Equal(t, 2*time.Minute, timeouts.IdleTimeout())
+	assert.Equal(t, 30*time.Minute, timeouts.TotalTimeout())
+	assert.Equal(t, 4, timeouts.MaxConnsPerHost)
+	assert.Zero(t, timeouts.ResponseHeaderTimeout())
+
+	assert.Equal(t, TimeoutsConfig{}, config.Models["model2"].Timeouts)
+	assert.Equal(t, 10*time.Minute, config.Peers["peer1"].Timeouts.ResponseHeaderTimeout())
+	assert.Equal(t, time.Minute, config.Peers["peer2"].Timeouts.ResponseHeaderTimeout(), "peers default to 60 seconds")
+	assert.Zero(t, config.Peers["peer3"].Timeouts.ResponseHeaderTimeout())
+}
+
+func TestTimeouts_Negative(t *testing.T) {
+	_, err := LoadConfigFromReader(strings.NewReader(`
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+    timeouts:
+      idle: -1
+`))
+	assert.EqualError(t, err, "model model1: timeouts.idle must be 0 or greater")
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	WebhookFailClosed = "closed"
+	WebhookFailOpen   = "open"
+
+	defaultWebhookTimeoutMs = 5000
+)
+
+// WebhooksConfig calls HTTP services around inference requests. They are set
+// globally and per model, a hook set on a model replaces the global one.
+type WebhooksConfig struct {
+	// called before the request is forwarded, it can allow, deny or rewrite it
+	PreRequest WebhookConfig `yaml:"preRequest"`
+
+	// called after the request completed with its status and metrics
+	PostRequest WebhookConfig `yaml:"postRequest"`
+}
+
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+
+	// milliseconds to wait for the hook, 0 uses 5000
+	TimeoutMs int `yaml:"timeoutMs"`
+
+	// what happens to a request when the preRequest hook fails or times out,
+	// "closed" rejects it and "open" forwards it unchanged
+	FailMode string `yaml:"failMode"`
+
+	// extra headers sent to the hook, e.g. to authenticate llama-swap
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Enabled returns true when the hook has a URL
+func (w WebhookConfig) Enabled() bool {
+	return w.URL != ""
+}
+
+func (w WebhookConfig) Timeout() time.Duration {
+	if w.TimeoutMs > 0 {
+		return time.Duration(w.TimeoutMs) * time.Millisecond
+	}
+	return defaultWebhookTimeoutMs * time.Millisecond
+}
+
+// FailOpen returns true when requests are forwarded when the hook fails
+func (w WebhookConfig) FailOpen() bool {
+	return w.FailMode == WebhookFailOpen
+}
+
+// merge returns w with the hooks of override that are set
+func (w WebhooksConfig) merge(override WebhooksConfig) WebhooksConfig {
+	if override.PreRequest.Enabled() {
+		w.PreRequest = override.PreRequest
+	}
+	if override.PostRequest.Enabled() {
+		w.PostRequest = override.PostRequest
+	}
+	return w
+}
+
+// WebhooksFor returns the webhooks of a model, models without their own hooks
+// and peer models use the global ones
+func (c *Config) WebhooksFor(modelID string) WebhooksConfig {
+	if modelConfig, found := c.Models[modelID]; found {
+		return c.Webhooks.merge(modelConfig.Webhooks)
+	}
+	return c.Webhooks
+}
+
+func (w WebhookConfig) validate(name string) error {
+	if !w.Enabled() {
+		return nil
+	}
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhooks.%s.url must be an http or https URL", name)
+	}
+	if w.TimeoutMs < 0 {
+		return fmt.Errorf("webhooks.%s.timeoutMs must be 0 or greater", name)
+	}
+	switch w.FailMode {
+	case "", WebhookFailClosed, WebhookFailOpen:
+	default:
+		return fmt.Errorf("webhooks.%s.failMode must be %s or %s", name, WebhookFailClosed, WebhookFailOpen)
+	}
+	return nil
+}
+
+func (w WebhooksConfig) validate() error {
+	if err := w.PreRequest.validate("preRequest"); err != nil {
+		return err
+	}
+	return w.PostRequest.validate("postRequest")
+}
+
+func validateWebhooks(config *Config) error {
+	if err := config.Webhooks.validate(); err != nil {
+		return err
+	}
+	for modelID, modelConfig := range config.Models {
+		if err := modelConfig.Webhooks.validate(); err != nil {
+			return fmt.Errorf("model %s: %w", modelID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhooks_For(t *testing.T) {
+	config, err := LoadConfigFromReader(strings.NewReader(`
+webhooks:
+  preRequest:
+    url: http://policy.internal/pre
+  postRequest:
+    url: http://billing.internal/post
+    timeoutMs: 1000
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+  model2:
+    cmd: ./server --port ${PORT}
+    webhooks:
+      preRequest:
+        url: http://policy.internal/strict
+        failMode: open
+`))
+	require.NoError(t, err)
+
+	model1 := config.WebhooksFor("model1")
+	assert.Equal(t, "http://policy.internal/pre", model1.PreRequest.URL)
+	assert.False(t, model1.PreRequest.FailOpen(), "fail closed by default")
+	assert.Equal(t, 5*time.Second, model1.PreRequest.Timeout())
+	assert.Equal(t, time.Second, model1.PostRequest.Timeout())
+
+	model2 := config.WebhooksFor("model2")
+	assert.Equal(t, "http://policy.internal/strict", model2.PreRequest.URL)
+	assert.True(t, model2.PreRequest.FailOpen())
+	assert.Equal(t, "http://billing.internal/post", model2.PostRequest.URL, "the global postRequest hook is kept")
+
+	assert.Equal(t, config.Webhooks, config.WebhooksFor("peer-model"))
+}
+
+func TestWebhooks_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		webhooks string
+		err      string
+	}{
+		{"url", "preRequest:\n    url: policy.internal", "webhooks.preRequest.url must be an http or https URL"},
+		{"failMode", "preRequest:\n    url: http://policy\n    failMode: maybe", "webhooks.preRequest.failMode must be closed or open"},
+		{"timeout", "postRequest:\n    url: http://billing\n    timeoutMs: -1", "webhooks.postRequest.timeoutMs must be 0 or greater"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader("webhooks:\n  " + tt.webhooks + "\n"))
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
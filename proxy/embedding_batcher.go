@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// embeddingBatcher merges concurrent /v1/embeddings requests to a model into
+// one upstream request and splits the response back to the callers. Requests
+// are only merged with requests that have the same parameters.
+type embeddingBatcher struct {
+	sync.Mutex
+	window    time.Duration
+	maxInputs int
+
+	// batches waiting for their window to close, by request parameters
+	pending map[string]*embeddingBatch
+}
+
+type embeddingBatch struct {
+	key    string
+	inputs []json.RawMessage
+	calls  []*embeddingCall
+	timer  *time.Timer
+
+	// the first request, its body and headers are used for the upstream request
+	request  *http.Request
+	body     []byte
+	upstream func(modelID string, w http.ResponseWriter, r *http.Request) error
+	modelID  string
+}
+
+type embeddingCall struct {
+	offset int
+	count  int
+
+	// share of the batch's prompt tokens
+	weight int
+	result chan embeddingResult
+}
+
+type embeddingResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newEmbeddingBatcher(cfg config.EmbeddingBatchConfig) *embeddingBatcher {
+	return &embeddingBatcher{
+		window:    time.Duration(cfg.WindowMs) * time.Millisecond,
+		maxInputs: cfg.MaxInputs,
+		pending:   make(map[string]*embeddingBatch),
+	}
+}
+
+// embeddingInputs returns the items of an embeddings input. A list of token
+// ids is a single item.
+func embeddingInputs(input gjson.Result) ([]json.RawMessage, bool) {
+	switch {
+	case input.Type == gjson.String:
+		return []json.RawMessage{json.RawMessage(input.Raw)}, true
+	case input.IsArray():
+		items := input.Array()
+		if len(items) == 0 {
+			return nil, false
+		}
+		if items[0].Type == gjson.Number {
+			return []json.RawMessage{json.RawMessage(input.Raw)}, true
+		}
+		inputs := make([]json.RawMessage, 0, len(items))
+		for _, item := range items {
+			if item.Type != gjson.String && !item.IsArray() {
+				return nil, false
+			}
+			inputs = append(inputs, json.RawMessage(item.Raw))
+		}
+		return inputs, true
+	default:
+		return nil, false
+	}
+}
+
+// handler returns a handler for proxyInferenceHandler that batches requests
+// before passing them to upstream
+func (b *embeddingBatcher) handler(upstream func(modelID string, w http.ResponseWriter, r *http.Request) error) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		inputs, ok := embeddingInputs(gjson.GetBytes(body, "input"))
+		if !ok || len(inputs) >= b.maxInputs {
+			return upstream(modelID, w, r)
+		}
+
+		// requests are merged when everything except the input is the same
+		params, err := sjson.DeleteBytes(body, "input")
+		if err != nil {
+			return upstream(modelID, w, r)
+		}
+		key, ok := responseCacheKey(modelID, r.URL.Path, params)
+		if !ok {
+			return upstream(modelID, w, r)
+		}
+
+		call := &embeddingCall{count: len(inputs), result: make(chan embeddingResult, 1)}
+		for _, input := range inputs {
+			call.weight += len(input)
+		}
+		b.add(key, call, inputs, modelID, r, body, upstream)
+
+		select {
+		case result := <-call.result:
+			for name, values := range result.header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(result.status)
+			_, err := w.Write(result.body)
+			return err
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+	}
+}
+
+func (b *embeddingBatcher) add(key string, call *embeddingCall, inputs []json.RawMessage, modelID string, r *http.Request, body []byte, upstream func(modelID string, w http.ResponseWriter, r *http.Request) error) {
+	b.Lock()
+	batch := b.pending[key]
+	if batch != nil && len(batch.inputs)+len(inputs) > b.maxInputs {
+		// the batch is full, send it and start a new one
+		b.remove(batch)
+		go batch.send()
+		batch = nil
+	}
+	if batch == nil {
+		created := &embeddingBatch{
+			key:      key,
+			request:  r,
+			body:     body,
+			upstream: upstream,
+			modelID:  modelID,
+		}
+		created.timer = time.AfterFunc(b.window, func() {
+			b.Lock()
+			removed := b.remove(created)
+			b.Unlock()
+			if removed {
+				created.send()
+			}
+		})
+		b.pending[key] = created
+		batch = created
+	}
+	call.offset = len(batch.inputs)
+	batch.inputs = append(batch.inputs, inputs...)
+	batch.calls = append(batch.calls, call)
+
+	full := len(batch.inputs) >= b.maxInputs
+	if full {
+		b.remove(batch)
+	}
+	b.Unlock()
+
+	if full {
+		go batch.send()
+	}
+}
+
+// remove takes a batch out of pending, it returns false when it was already
+// removed. The caller must hold the lock.
+func (b *embeddingBatcher) remove(batch *embeddingBatch) bool {
+	if b.pending[batch.key] != batch {
+		return false
+	}
+	delete(b.pending, batch.key)
+	batch.timer.Stop()
+	return true
+}
+
+// send makes the upstream request and delivers the results to the callers
+func (batch *embeddingBatch) send() {
+	body, err := sjson.SetBytes(batch.body, "input", batch.inputs)
+	if err != nil {
+		batch.fail(http.StatusInternalServerError, fmt.Sprintf("error merging embedding requests: %v", err))
+		return
+	}
+
+	// callers that go away must not cancel the request for the others
+	req := batch.request.Clone(context.WithoutCancel(batch.request.Context()))
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	// the response is split, it must not be compressed
+	req.Header.Set("Accept-Encoding", "identity")
+
+	w := newBatchResponseWriter()
+	if err := batch.upstream(batch.modelID, w, req); err != nil {
+		batch.fail(http.StatusBadGateway, fmt.Sprintf("error proxying batched embedding request: %v", err))
+		return
+	}
+
+	header := http.Header{}
+	if contentType := w.header.Get("Content-Type"); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if w.status != http.StatusOK {
+		for _, call := range batch.calls {
+			call.result <- embeddingResult{status: w.status, header: header, body: w.body.Bytes()}
+		}
+		return
+	}
+
+	response := w.body.Bytes()
+	data := gjson.GetBytes(response, "data").Array()
+	if len(data) != len(batch.inputs) {
+		batch.fail(http.StatusBadGateway, fmt.Sprintf("upstream returned %d embeddings for %d inputs", len(data), len(batch.inputs)))
+		return
+	}
+	sort.SliceStable(data, func(i, j int) bool { return data[i].Get("index").Int() < data[j].Get("index").Int() })
+
+	promptTokens := int(gjson.GetBytes(response, "usage.prompt_tokens").Int())
+	totalWeight := 0
+	for _, call := range batch.calls {
+		totalWeight += call.weight
+	}
+
+	assigned := 0
+	for i, call := range batch.calls {
+		items := make([]json.RawMessage, 0, call.count)
+		for j, item := range data[call.offset : call.offset+call.count] {
+			raw, _ := sjson.SetBytes([]byte(item.Raw), "index", j)
+			items = append(items, raw)
+		}
+
+		// usage is split by the size of each caller's inputs, the last caller gets the remainder
+		tokens := promptTokens - assigned
+		if i < len(batch.calls)-1 && totalWeight > 0 {
+			tokens = promptTokens * call.weight / totalWeight
+		}
+		assigned += tokens
+
+		out, err := sjson.SetBytes(response, "data", items)
+		if err == nil && gjson.GetBytes(response, "usage").Exists() {
+			out, _ = sjson.SetBytes(out, "usage.prompt_tokens", tokens)
+			out, _ = sjson.SetBytes(out, "usage.total_tokens", tokens)
+		}
+		if err != nil {
+			call.result <- embeddingResult{status: http.StatusInternalServerError, header: header, body: []byte(err.Error())}
+			continue
+		}
+		call.result <- embeddingResult{status: http.StatusOK, header: header, body: out}
+	}
+}
+
+func (batch *embeddingBatch) fail(status int, message string) {
+	body, _ := json.Marshal(openAIError(status, message))
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	for _, call := range batch.calls {
+		call.result <- embeddingResult{status: status, header: header, body: body}
+	}
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+// fakeEmbeddingUpstream returns the input strings as embeddings, in reverse
+// order, so the callers can check they got their own results
+func fakeEmbeddingUpstream(calls *atomic.Int32, sizes chan<- int) func(string, http.ResponseWriter, *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		inputs := gjson.GetBytes(body, "input").Array()
+		if sizes != nil {
+			sizes <- len(inputs)
+		}
+		data := make([]string, 0, len(inputs))
+		for i := len(inputs) - 1; i >= 0; i-- {
+			data = append(data, fmt.Sprintf(`{"object":"embedding","index":%d,"embedding":[%q]}`, i, inputs[i].String()))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"object":"list","data":[%s],"usage":{"prompt_tokens":%d,"total_tokens":%d}}`, strings.Join(data, ","), 2*len(inputs), 2*len(inputs))
+		return nil
+	}
+}
+
+func TestEmbeddingBatcher_MergesConcurrentRequests(t *testing.T) {
+	batcher := newEmbeddingBatcher(config.EmbeddingBatchConfig{Enabled: true, WindowMs: 50, MaxInputs: 64})
+	var calls atomic.Int32
+	handler := batcher.handler(fakeEmbeddingUpstream(&calls, nil))
+
+	bodies := []string{
+		`{"model": "m", "input": "a"}`,
+		`{"model": "m", "input": ["b", "c"]}`,
+		`{"model": "m", "input": ["d", "e", "f"]}`,
+	}
+	results := make([]*httptest.ResponseRecorder, len(bodies))
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		go func(i int, body string) {
+			defer wg.Done()
+			results[i] = httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body))
+			assert.NoError(t, handler("m", results[i], req))
+		}(i, body)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	expected := [][]string{{"a"}, {"b", "c"}, {"d", "e", "f"}}
+	totalTokens := int64(0)
+	for i, rec := range results {
+		require.Equal(t, http.StatusOK, rec.Code)
+		data := gjson.Get(rec.Body.String(), "data").Array()
+		require.Len(t, data, len(expected[i]))
+		for j, item := range data {
+			assert.Equal(t, int64(j), item.Get("index").Int())
+			assert.Equal(t, expected[i][j], item.Get("embedding.0").String())
+		}
+		totalTokens += gjson.Get(rec.Body.String(), "usage.prompt_tokens").Int()
+	}
+	assert.Equal(t, int64(12), totalTokens, "usage is split without losing tokens")
+	assert.Equal(t, int64(2), gjson.Get(results[0].Body.String(), "usage.prompt_tokens").Int())
+}
+
+func TestEmbeddingBatcher_Limits(t *testing.T) {
+	batcher := newEmbeddingBatcher(config.EmbeddingBatchConfig{Enabled: true, WindowMs: 1000, MaxInputs: 2})
+	var calls atomic.Int32
+	sizes := make(chan int, 10)
+	handler := batcher.handler(fakeEmbeddingUpstream(&calls, sizes))
+
+	serve := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body))
+		require.NoError(t, handler("m", rec, req))
+		return rec
+	}
+
+	// full batches are sent without waiting for the window
+	var wg sync.WaitGroup
+	for _, input := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(input string) {
+			defer wg.Done()
+			serve(fmt.Sprintf(`{"model": "m", "input": %q}`, input))
+		}(input)
+	}
+	wg.Wait()
+	assert.Equal(t, 2, <-sizes)
+
+	// requests that fill a batch by themselves are passed through
+	rec := serve(`{"model": "m", "input": ["a", "b", "c"]}`)
+	assert.Len(t, gjson.Get(rec.Body.String(), "data").Array(), 3)
+	assert.Equal(t, 3, <-sizes)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestEmbeddingBatcher_DifferentParametersAreNotMerged(t *testing.T) {
+	batcher := newEmbeddingBatcher(config.EmbeddingBatchConfig{Enabled: true, WindowMs: 50, MaxInputs: 64})
+	var calls atomic.Int32
+	handler := batcher.handler(fakeEmbeddingUpstream(&calls, nil))
+
+	var wg sync.WaitGroup
+	for _, body := range []string{`{"model": "m", "input": "a"}`, `{"model": "m", "input": "b", "dimensions": 8}`} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body))
+			assert.NoError(t, handler("m", rec, req))
+			assert.Len(t, gjson.Get(rec.Body.String(), "data").Array(), 1)
+		}(body)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestProxyManager_EmbeddingBatch(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+    concurrencyLimit: 1
+    embeddingBatch:
+      enabled: true
+      windowMs: 100
+`, simpleResponderPath)))
+	require.NoError(t, err)
+	assert.Equal(t, 64, cfg.Models["model1"].EmbeddingBatch.MaxInputs)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	// start the model so the requests arrive within one window
+	req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"model": "model1", "input": "warmup"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	const requests = 5
+	codes := make([]int, requests)
+	bodies := make([]string, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"model": "model1", "input": ["a", "b"]}`))
+			w := CreateTestResponseRecorder()
+			proxy.ServeHTTP(w, req)
+			codes[i], bodies[i] = w.Code, w.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	// with a concurrencyLimit of 1 some requests would be rejected without batching
+	for i := 0; i < requests; i++ {
+		require.Equal(t, http.StatusOK, codes[i], bodies[i])
+		assert.Len(t, gjson.Get(bodies[i], "data").Array(), 2)
+		assert.Equal(t, int64(10), gjson.Get(bodies[i], "usage.prompt_tokens").Int())
+	}
+
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, requests+1)
+	assert.Equal(t, 10, metrics[len(metrics)-1].InputTokens)
+}
+
+func TestProxyManager_EmbeddingBatchModelAPI(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+    embeddingBatch:
+      enabled: true
+`))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	require.Contains(t, proxy.snapshot.Load().embeddingBatchers, "model1")
+
+	require.NoError(t, proxy.upsertModel("model2", []byte(`{"cmd": "./server --port ${PORT}", "embeddingBatch": {"enabled": true, "maxInputs": 8}}`), false, false))
+	require.Contains(t, proxy.snapshot.Load().embeddingBatchers, "model2")
+	assert.Equal(t, 8, proxy.snapshot.Load().embeddingBatchers["model2"].maxInputs)
+
+	require.NoError(t, proxy.upsertModel("model1", []byte(`{"cmd": "./server --port ${PORT}"}`), false, false))
+	assert.NotContains(t, proxy.snapshot.Load().embeddingBatchers, "model1")
+
+	require.NoError(t, proxy.deleteModel("model2", false))
+	assert.Empty(t, proxy.snapshot.Load().embeddingBatchers)
+}
@@ -1,5 +1,7 @@
 package proxy
 
+import "time"
+
 // package level registry of the different event types
 
 const ProcessStateChangeEventID = 0x01
@@ -8,6 +10,8 @@ const ConfigFileChangedEventID = 0x03
 const LogDataEventID = 0x04
 const TokenMetricsEventID = 0x05
 const ModelPreloadedEventID = 0x06
+const ModelConfigChangedEventID = 0x07
+const LoadingProgressEventID = 0x08
 
 type ProcessStateChangeEvent struct {
 	ProcessName string
@@ -58,3 +62,29 @@ type ModelPreloadedEvent struct {
 func (e ModelPreloadedEvent) Type() uint32 {
 	return ModelPreloadedEventID
 }
+
+// ModelConfigChangedEvent is emitted when a model is added, replaced or
+// removed through the runtime model management API
+type ModelConfigChangedEvent struct {
+	ModelID string
+	Removed bool
+}
+
+func (e ModelConfigChangedEvent) Type() uint32 {
+	return ModelConfigChangedEventID
+}
+
+// LoadingProgressEvent is emitted while a process starts when its loading
+// phase or percentage changes, and once with phase "ready" when it is loaded
+type LoadingProgressEvent struct {
+	ProcessName string
+	Phase       string
+	Percent     int
+
+	// time left until the process is expected to be ready, 0 when unknown
+	ETA time.Duration
+}
+
+func (e LoadingProgressEvent) Type() uint32 {
+	return LoadingProgressEventID
+}
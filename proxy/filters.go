@@ -0,0 +1,488 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// filterRequest applies the request filters to a JSON body. Parameters are
+// renamed, stripped, defaulted, set and clamped in that order, then the
+// system message is applied. path is the endpoint the body is sent to.
+func (pm *ProxyManager) filterRequest(id string, filters config.Filters, path string, body []byte) ([]byte, error) {
+	var err error
+
+	for _, from := range config.SortedKeys(filters.RenameParams) {
+		to := filters.RenameParams[from]
+		value := gjson.GetBytes(body, from)
+		if !value.Exists() {
+			continue
+		}
+		pm.proxyLogger.Debugf("<%s> renaming param: %s to %s", id, from, to)
+		if body, err = sjson.SetRawBytes(body, to, []byte(value.Raw)); err != nil {
+			return nil, fmt.Errorf("error renaming parameter %s in request", from)
+		}
+		if body, err = sjson.DeleteBytes(body, from); err != nil {
+			return nil, fmt.Errorf("error renaming parameter %s in request", from)
+		}
+	}
+
+	// issue #174 strip parameters from the JSON body
+	for _, param := range filters.SanitizedStripParams() {
+		pm.proxyLogger.Debugf("<%s> stripping param: %s", id, param)
+		if body, err = sjson.DeleteBytes(body, param); err != nil {
+			return nil, fmt.Errorf("error deleting parameter %s from request", param)
+		}
+	}
+
+	for _, key := range config.SortedKeys(filters.DefaultParams) {
+		if gjson.GetBytes(body, key).Exists() {
+			continue
+		}
+		pm.proxyLogger.Debugf("<%s> defaulting param: %s", id, key)
+		if body, err = sjson.SetBytes(body, key, filters.DefaultParams[key]); err != nil {
+			return nil, fmt.Errorf("error setting parameter %s in request", key)
+		}
+	}
+
+	// issue #453 set/override parameters in the JSON body
+	setParams, setParamKeys := filters.SanitizedSetParams()
+	for _, key := range setParamKeys {
+		pm.proxyLogger.Debugf("<%s> setting param: %s", id, key)
+		if body, err = sjson.SetBytes(body, key, setParams[key]); err != nil {
+			return nil, fmt.Errorf("error setting parameter %s in request", key)
+		}
+	}
+
+	for _, key := range config.SortedKeys(filters.ClampParams) {
+		value := gjson.GetBytes(body, key)
+		if value.Type != gjson.Number {
+			continue
+		}
+		clamp := filters.ClampParams[key]
+		clamped := value.Num
+		if clamp.Min != nil && clamped < *clamp.Min {
+			clamped = *clamp.Min
+		}
+		if clamp.Max != nil && clamped > *clamp.Max {
+			clamped = *clamp.Max
+		}
+		if clamped == value.Num {
+			continue
+		}
+		pm.proxyLogger.Debugf("<%s> clamping param: %s from %v to %v", id, key, value.Num, clamped)
+		if body, err = sjson.SetBytes(body, key, clamped); err != nil {
+			return nil, fmt.Errorf("error clamping parameter %s in request", key)
+		}
+	}
+
+	if filters.SystemMessage.Content != "" {
+		pm.proxyLogger.Debugf("<%s> applying system message", id)
+		if body, err = applySystemMessage(body, path, filters.SystemMessage); err != nil {
+			return nil, fmt.Errorf("error applying system message: %w", err)
+		}
+	}
+	return body, nil
+}
+
+// applySystemMessage puts the filter's system message into a chat request.
+// Anthropic requests have a system field and Responses API requests have
+// instructions instead of a system message.
+func applySystemMessage(body []byte, path string, filter config.SystemMessageFilter) ([]byte, error) {
+	switch {
+	case isAnthropicPath(path):
+		return applySystemField(body, "system", filter)
+	case path == "/v1/responses":
+		return applySystemField(body, "instructions", filter)
+	}
+
+	messages := gjson.GetBytes(body, "messages")
+	if !messages.IsArray() {
+		return body, nil
+	}
+
+	systemMessage, err := json.Marshal(map[string]string{"role": "system", "content": filter.Content})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []json.RawMessage
+	inserted := false
+	for _, message := range messages.Array() {
+		if message.Get("role").String() != "system" {
+			result = append(result, json.RawMessage(message.Raw))
+			continue
+		}
+		switch filter.Mode {
+		case config.SystemMessagePrepend, config.SystemMessageAppend:
+			if inserted {
+				result = append(result, json.RawMessage(message.Raw))
+				continue
+			}
+			combined, err := combineContent(message.Get("content"), filter.Content, filter.Mode == config.SystemMessagePrepend)
+			if err != nil {
+				return nil, err
+			}
+			updated, err := sjson.SetRawBytes([]byte(message.Raw), "content", combined)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, updated)
+			inserted = true
+		case config.SystemMessageMissing:
+			return body, nil
+		default:
+			// replaced by the filter's system message
+		}
+	}
+	if !inserted {
+		result = append([]json.RawMessage{systemMessage}, result...)
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetRawBytes(body, "messages", encoded)
+}
+
+// applySystemField applies the system message to a top level field that is a
+// string or a list of content parts
+func applySystemField(body []byte, field string, filter config.SystemMessageFilter) ([]byte, error) {
+	existing := gjson.GetBytes(body, field)
+	switch {
+	case !existing.Exists() || filter.Mode == "" || filter.Mode == config.SystemMessageReplace:
+		return sjson.SetBytes(body, field, filter.Content)
+	case filter.Mode == config.SystemMessageMissing:
+		return body, nil
+	}
+	combined, err := combineContent(existing, filter.Content, filter.Mode == config.SystemMessagePrepend)
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetRawBytes(body, field, combined)
+}
+
+// combineContent adds text before or after message content that is a string
+// or a list of content parts
+func combineContent(content gjson.Result, text string, before bool) ([]byte, error) {
+	if content.IsArray() {
+		part, err := json.Marshal(map[string]string{"type": "text", "text": text})
+		if err != nil {
+			return nil, err
+		}
+		parts := []json.RawMessage{}
+		for _, existing := range content.Array() {
+			parts = append(parts, json.RawMessage(existing.Raw))
+		}
+		if before {
+			parts = append([]json.RawMessage{part}, parts...)
+		} else {
+			parts = append(parts, part)
+		}
+		return json.Marshal(parts)
+	}
+
+	combined := text
+	if existing := content.String(); existing != "" {
+		if before {
+			combined = text + "\n\n" + existing
+		} else {
+			combined = existing + "\n\n" + text
+		}
+	}
+	return json.Marshal(combined)
+}
+
+// hasResponseFilters returns true for endpoints whose responses can be transformed
+func hasResponseFilters(filters config.ResponseFilters, path string) bool {
+	return filters.Enabled() && (path == "/v1/chat/completions" || path == "/v1/completions")
+}
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// thinkStripper removes <think> blocks from text that arrives in pieces. A
+// tag can be split across pieces so the end of a piece that could be the
+// start of a tag is held back until the next one.
+type thinkStripper struct {
+	inThink bool
+	pending string
+	// drops the whitespace that follows a closing tag
+	trimLeading bool
+}
+
+func (s *thinkStripper) write(text string) string {
+	text = s.pending + text
+	s.pending = ""
+
+	var out strings.Builder
+	for text != "" {
+		if s.inThink {
+			i := strings.Index(text, thinkCloseTag)
+			if i < 0 {
+				s.pending = partialTagSuffix(text, thinkCloseTag)
+				break
+			}
+			text = text[i+len(thinkCloseTag):]
+			s.inThink = false
+			s.trimLeading = true
+			continue
+		}
+
+		i := strings.Index(text, thinkOpenTag)
+		if i < 0 {
+			s.pending = partialTagSuffix(text, thinkOpenTag)
+			s.emit(&out, text[:len(text)-len(s.pending)])
+			break
+		}
+		s.emit(&out, text[:i])
+		text = text[i+len(thinkOpenTag):]
+		s.inThink = true
+	}
+	return out.String()
+}
+
+// flush returns the text that was held back at the end of the stream
+func (s *thinkStripper) flush() string {
+	pending := s.pending
+	s.pending = ""
+	if s.inThink {
+		return ""
+	}
+	var out strings.Builder
+	s.emit(&out, pending)
+	return out.String()
+}
+
+func (s *thinkStripper) emit(out *strings.Builder, text string) {
+	if s.trimLeading {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			return
+		}
+		s.trimLeading = false
+	}
+	out.WriteString(text)
+}
+
+// partialTagSuffix returns the end of text that is the start of tag
+func partialTagSuffix(text, tag string) string {
+	for n := min(len(tag)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return text[len(text)-n:]
+		}
+	}
+	return ""
+}
+
+// stripThinkBlocks removes <think> blocks from a complete text
+func stripThinkBlocks(text string) string {
+	var stripper thinkStripper
+	return stripper.write(text) + stripper.flush()
+}
+
+// filteringResponseWriter applies response filters to chat and text
+// completions. Streams are transformed event by event, other lines of the
+// stream like comments are passed on unchanged.
+type filteringResponseWriter struct {
+	gin.ResponseWriter
+
+	filters config.ResponseFilters
+
+	status      int
+	wroteHeader bool
+	streaming   bool
+	passthrough bool
+	body        bytes.Buffer
+
+	// a partial line of the stream
+	pending []byte
+	// by choice index
+	think map[int64]*thinkStripper
+	// the last chunk, used to send text held back by the think strippers
+	lastChunk []byte
+}
+
+func newFilteringResponseWriter(w gin.ResponseWriter, filters config.ResponseFilters) *filteringResponseWriter {
+	return &filteringResponseWriter{
+		ResponseWriter: w,
+		filters:        filters,
+		think:          make(map[int64]*thinkStripper),
+	}
+}
+
+func (w *filteringResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+
+	contentType := strings.ToLower(w.Header().Get("Content-Type"))
+	switch {
+	case statusCode != http.StatusOK || w.Header().Get("Content-Encoding") != "":
+		w.passthrough = true
+	case strings.Contains(contentType, "text/event-stream"):
+		w.streaming = true
+	case strings.Contains(contentType, "application/json"):
+		// written in finish()
+		w.Header().Del("Content-Length")
+	default:
+		w.passthrough = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *filteringResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.passthrough:
+		return w.ResponseWriter.Write(b)
+	case !w.streaming:
+		return w.body.Write(b)
+	}
+
+	w.pending = append(w.pending, b...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := w.pending[:i+1]
+		w.pending = w.pending[i+1:]
+		if _, err := w.ResponseWriter.Write(w.filterLine(line)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *filteringResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// filterLine transforms the data: lines of a stream
+func (w *filteringResponseWriter) filterLine(line []byte) []byte {
+	data, found := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), []byte("data:"))
+	if !found {
+		return line
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("[DONE]")) {
+		return append(w.flushThink(), line...)
+	}
+	if !gjson.ValidBytes(data) {
+		return line
+	}
+	w.lastChunk = data
+	return append(append([]byte("data: "), w.filterChoices(data, "delta")...), '\n')
+}
+
+// filterChoices applies the filters to the choices of a chunk or response,
+// container is delta for streams and message for complete responses
+func (w *filteringResponseWriter) filterChoices(data []byte, container string) []byte {
+	choices := gjson.GetBytes(data, "choices")
+	if !choices.IsArray() {
+		return data
+	}
+	for i, choice := range choices.Array() {
+		for _, field := range w.filters.StripFields {
+			data, _ = sjson.DeleteBytes(data, fmt.Sprintf("choices.%d.%s.%s", i, container, field))
+		}
+		if !w.filters.StripThink {
+			continue
+		}
+
+		// chat completions have content, text completions have text
+		path := fmt.Sprintf("choices.%d.%s.content", i, container)
+		content := choice.Get(container + ".content")
+		if content.Type != gjson.String {
+			path = fmt.Sprintf("choices.%d.text", i)
+			content = choice.Get("text")
+		}
+		if content.Type != gjson.String {
+			continue
+		}
+
+		var stripped string
+		if container == "delta" {
+			stripped = w.thinkStripper(choice.Get("index").Int()).write(content.Str)
+		} else {
+			stripped = stripThinkBlocks(content.Str)
+		}
+		data, _ = sjson.SetBytes(data, path, stripped)
+	}
+	return data
+}
+
+func (w *filteringResponseWriter) thinkStripper(index int64) *thinkStripper {
+	stripper, found := w.think[index]
+	if !found {
+		stripper = &thinkStripper{}
+		w.think[index] = stripper
+	}
+	return stripper
+}
+
+// flushThink returns a chunk with the text the think strippers held back
+func (w *filteringResponseWriter) flushThink() []byte {
+	var out []byte
+	for index, stripper := range w.think {
+		text := stripper.flush()
+		if text == "" || w.lastChunk == nil {
+			continue
+		}
+		choice := map[string]any{"index": index, "delta": map[string]any{"content": text}, "finish_reason": nil}
+		if gjson.GetBytes(w.lastChunk, "choices.0.text").Exists() {
+			choice = map[string]any{"index": index, "text": text, "finish_reason": nil}
+		}
+		chunk, err := sjson.SetBytes(w.lastChunk, "choices", []any{choice})
+		if err != nil {
+			continue
+		}
+		chunk, _ = sjson.DeleteBytes(chunk, "usage")
+		out = append(out, "data: "...)
+		out = append(out, chunk...)
+		out = append(out, "\n\n"...)
+	}
+	return out
+}
+
+func (w *filteringResponseWriter) Flush() {
+	if !w.passthrough && !w.streaming {
+		return
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish writes the rest of the response once the upstream is done
+func (w *filteringResponseWriter) finish() {
+	if !w.wroteHeader || w.passthrough {
+		return
+	}
+	if w.streaming {
+		if len(w.pending) > 0 {
+			w.ResponseWriter.Write(w.filterLine(w.pending))
+			w.pending = nil
+		}
+		w.ResponseWriter.Flush()
+		return
+	}
+
+	body := w.body.Bytes()
+	if gjson.ValidBytes(body) {
+		body = w.filterChoices(body, "message")
+	}
+	w.ResponseWriter.Write(body)
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestFilterRequest_Params(t *testing.T) {
+	pm := &ProxyManager{proxyLogger: testLogger}
+	filters := config.Filters{
+		RenameParams:  map[string]string{"max_completion_tokens": "max_tokens"},
+		StripParams:   "seed",
+		DefaultParams: map[string]any{"top_k": 40, "top_p": 0.9},
+		SetParams:     map[string]any{"cache_prompt": true},
+		ClampParams: map[string]config.ClampRange{
+			"temperature": {Min: floatPtr(0), Max: floatPtr(1.5)},
+			"max_tokens":  {Max: floatPtr(2048)},
+		},
+	}
+
+	body, err := pm.filterRequest("model1", filters, "/v1/chat/completions",
+		[]byte(`{"model":"model1","max_completion_tokens":8192,"seed":1,"top_p":0.5,"temperature":3}`))
+	require.NoError(t, err)
+
+	assert.False(t, gjson.GetBytes(body, "max_completion_tokens").Exists())
+	assert.Equal(t, int64(2048), gjson.GetBytes(body, "max_tokens").Int(), "renamed then clamped")
+	assert.False(t, gjson.GetBytes(body, "seed").Exists())
+	assert.Equal(t, int64(40), gjson.GetBytes(body, "top_k").Int())
+	assert.Equal(t, 0.5, gjson.GetBytes(body, "top_p").Float(), "defaults do not replace values")
+	assert.True(t, gjson.GetBytes(body, "cache_prompt").Bool())
+	assert.Equal(t, 1.5, gjson.GetBytes(body, "temperature").Float())
+	assert.Equal(t, "model1", gjson.GetBytes(body, "model").String())
+}
+
+func TestFilterRequest_SystemMessage(t *testing.T) {
+	pm := &ProxyManager{proxyLogger: testLogger}
+	chat := `{"messages":[{"role":"system","content":"original"},{"role":"user","content":"hi"}]}`
+	noSystem := `{"messages":[{"role":"user","content":"hi"}]}`
+
+	tests := []struct {
+		name   string
+		mode   string
+		path   string
+		body   string
+		result string
+		want   string
+	}{
+		{"replace", "", "/v1/chat/completions", chat, "messages.#.content", `["policy","hi"]`},
+		{"replace inserts", config.SystemMessageReplace, "/v1/chat/completions", noSystem, "messages.#.role", `["system","user"]`},
+		{"prepend", config.SystemMessagePrepend, "/v1/chat/completions", chat, "messages.0.content", `"policy\n\noriginal"`},
+		{"append", config.SystemMessageAppend, "/v1/chat/completions", chat, "messages.0.content", `"original\n\npolicy"`},
+		{"append to parts", config.SystemMessageAppend, "/v1/chat/completions",
+			`{"messages":[{"role":"system","content":[{"type":"text","text":"original"}]}]}`, "messages.0.content.#.text", `["original","policy"]`},
+		{"missing keeps", config.SystemMessageMissing, "/v1/chat/completions", chat, "messages.0.content", `"original"`},
+		{"missing adds", config.SystemMessageMissing, "/v1/chat/completions", noSystem, "messages.0.content", `"policy"`},
+		{"anthropic", config.SystemMessagePrepend, "/v1/messages", `{"system":"original","messages":[]}`, "system", `"policy\n\noriginal"`},
+		{"responses", "", "/v1/responses", `{"instructions":"original","input":"hi"}`, "instructions", `"policy"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := config.Filters{SystemMessage: config.SystemMessageFilter{Content: "policy", Mode: tt.mode}}
+			body, err := pm.filterRequest("model1", filters, tt.path, []byte(tt.body))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, gjson.GetBytes(body, tt.result).Raw, string(body))
+		})
+	}
+}
+
+func TestThinkStripper(t *testing.T) {
+	assert.Equal(t, "answer", stripThinkBlocks("<think>reasoning</think>\n\nanswer"))
+	assert.Equal(t, "a c", stripThinkBlocks("a <think>b</think>c"))
+	assert.Equal(t, "a < b", stripThinkBlocks("a < b"))
+
+	// tags split across chunks
+	var stripper thinkStripper
+	var out strings.Builder
+	for _, chunk := range []string{"<thi", "nk>reason", "ing</th", "ink>", "\n\nthe ", "answer <", "3"} {
+		out.WriteString(stripper.write(chunk))
+	}
+	out.WriteString(stripper.flush())
+	assert.Equal(t, "the answer <3", out.String())
+}
+
+func newFilteringTestWriter(filters config.ResponseFilters, contentType string) (*httptest.ResponseRecorder, *filteringResponseWriter) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := newFilteringResponseWriter(c.Writer, filters)
+	w.Header().Set("Content-Type", contentType)
+	return rec, w
+}
+
+func TestFilteringResponseWriter_JSON(t *testing.T) {
+	rec, w := newFilteringTestWriter(config.ResponseFilters{StripFields: []string{"reasoning_content"}, StripThink: true}, "application/json")
+	w.Header().Set("Content-Length", "1000")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"<think>hmm</think>\n\nhello","reasoning_content":"hmm"}}]}`))
+	w.finish()
+
+	assert.Empty(t, rec.Header().Get("Content-Length"))
+	assert.Equal(t, "hello", gjson.Get(rec.Body.String(), "choices.0.message.content").String())
+	assert.False(t, gjson.Get(rec.Body.String(), "choices.0.message.reasoning_content").Exists())
+}
+
+func TestFilteringResponseWriter_Stream(t *testing.T) {
+	rec, w := newFilteringTestWriter(config.ResponseFilters{StripFields: []string{"reasoning_content"}, StripThink: true}, "text/event-stream")
+	chunk := func(delta string) string {
+		return fmt.Sprintf(`data: {"id":"c1","model":"m","choices":[{"index":0,"delta":%s,"finish_reason":null}]}`+"\n\n", delta)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	stream := ": llama-swap loading model\n\n" +
+		chunk(`{"reasoning_content":"thinking"}`) +
+		chunk(`{"content":"<thi"}`) +
+		chunk(`{"content":"nk>secret</think>"}`) +
+		chunk(`{"content":"\n\nvisible <"}`) +
+		"data: [DONE]\n\n"
+	// arbitrary write boundaries
+	for _, part := range []string{stream[:37], stream[37:150], stream[150:]} {
+		w.Write([]byte(part))
+	}
+	w.finish()
+
+	body := rec.Body.String()
+	assert.True(t, strings.HasPrefix(body, ": llama-swap loading model\n\n"), "comments are passed on")
+	assert.NotContains(t, body, "thinking")
+	assert.NotContains(t, body, "secret")
+
+	var content strings.Builder
+	for _, data := range sseData(body) {
+		content.WriteString(data.Get("choices.0.delta.content").String())
+	}
+	assert.Equal(t, "visible <", content.String(), "held back text is sent before [DONE]")
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+}
+
+func TestProxyManager_EndpointFilters(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond "<think>plan</think>answer"
+    filters:
+      endpoints:
+        /v1/chat/completions:
+          renameParams:
+            max_completion_tokens: max_tokens
+          response:
+            stripThink: true
+`, simpleResponderPath)))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1","max_completion_tokens":10}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	forwarded := gjson.Get(w.Body.String(), "request_body").String()
+	assert.Equal(t, int64(10), gjson.Get(forwarded, "max_tokens").Int())
+	assert.False(t, gjson.Get(forwarded, "max_completion_tokens").Exists())
+}
@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+)
+
+// maxFormFieldBytes limits the size of a text field in a multipart form.
+// Uploaded files are streamed and have no limit. It is also how much of a form
+// is held in memory before routing, larger prefixes go to a temp file.
+const maxFormFieldBytes = 1 << 20
+
+// formStream forwards a multipart form to the upstream as the upstream reads
+// it so large audio and image uploads are not held in memory. Only the form up
+// to the model field is read before routing, its raw bytes are kept so the
+// form can be forwarded as it was sent or rebuilt with filters.
+type formStream struct {
+	body     io.Reader
+	boundary string
+	prefix   formPrefix
+	model    string
+}
+
+func newFormStream(r *http.Request) (*formStream, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, http.ErrNotMultipart
+	}
+	if params["boundary"] == "" {
+		return nil, http.ErrMissingBoundary
+	}
+
+	s := &formStream{body: r.Body, boundary: params["boundary"]}
+	if err := s.readUntilModel(); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *formStream) readUntilModel() error {
+	reader := multipart.NewReader(io.TeeReader(s.body, &s.prefix), s.boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if part.FormName() == "model" && part.FileName() == "" {
+			value, err := readFormField(part)
+			part.Close()
+			if err != nil {
+				return err
+			}
+			s.model = string(value)
+			return nil
+		}
+
+		_, err = io.Copy(io.Discard, part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// original returns the form as the client sent it. The parts that were not
+// read yet are read from the client.
+func (s *formStream) original() (io.Reader, error) {
+	prefix, err := s.prefix.reader()
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(prefix, s.body), nil
+}
+
+// rewrites is true when forwarding the form with model and filters changes
+// any field, otherwise the form can be sent as it is
+func (s *formStream) rewrites(model string, filters config.Filters) bool {
+	setParams, _ := filters.SanitizedSetParams()
+	return model != s.model ||
+		len(filters.SanitizedStripParams()) > 0 ||
+		len(setParams) > 0 ||
+		len(filters.RenameParams) > 0 ||
+		len(filters.DefaultParams) > 0 ||
+		len(filters.ClampParams) > 0
+}
+
+// limitBody applies the maxBodyBytes limit of a model to the upload. Uploads
+// known to be larger are rejected, otherwise the rest of the form is cut off
+// at the limit while it is forwarded.
+func (s *formStream) limitBody(modelID string, maxBodyBytes, contentLength int64) *limitViolation {
+	if maxBodyBytes == 0 {
+		return nil
+	}
+	if size := max(contentLength, s.prefix.size); size > maxBodyBytes {
+		return &limitViolation{
+			message: fmt.Sprintf("request body of %d bytes exceeds the maximum of %d bytes for %s", size, maxBodyBytes, modelID),
+		}
+	}
+	s.body = http.MaxBytesReader(nil, io.NopCloser(s.body), maxBodyBytes-s.prefix.size)
+	return nil
+}
+
+// forward writes the whole form to w with the filters applied and the model
+// field set to model. The parts that were not read yet are copied from the
+// client as the upstream reads them.
+func (s *formStream) forward(w *multipart.Writer, model string, filters config.Filters) error {
+	original, err := s.original()
+	if err != nil {
+		return err
+	}
+	reader := multipart.NewReader(original, s.boundary)
+	filter := newFormFilter(filters)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if part.FileName() != "" {
+			err = filter.writePart(w, part.FormName(), part.FileName(), part.Header, nil, part, model)
+		} else if value, readErr := readFormField(part); readErr != nil {
+			err = readErr
+		} else {
+			err = filter.writePart(w, part.FormName(), "", part.Header, value, nil, model)
+		}
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := filter.writeParams(w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// close removes the temp file of a large prefix
+func (s *formStream) close() {
+	s.prefix.close()
+}
+
+func readFormField(part *multipart.Part) ([]byte, error) {
+	value, err := io.ReadAll(io.LimitReader(part, maxFormFieldBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > maxFormFieldBytes {
+		return nil, fmt.Errorf("form field %s is larger than %d bytes", part.FormName(), maxFormFieldBytes)
+	}
+	return value, nil
+}
+
+// formPrefix holds the raw bytes of a form read before routing, in memory up
+// to maxFormFieldBytes and in a temp file beyond that
+type formPrefix struct {
+	buf  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (p *formPrefix) Write(b []byte) (int, error) {
+	if p.file == nil && p.buf.Len()+len(b) > maxFormFieldBytes {
+		file, err := os.CreateTemp("", "llama-swap-upload-*")
+		if err != nil {
+			return 0, err
+		}
+		p.file = file
+		if _, err := p.buf.WriteTo(file); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if p.file != nil {
+		n, err = p.file.Write(b)
+	} else {
+		n, err = p.buf.Write(b)
+	}
+	p.size += int64(n)
+	return n, err
+}
+
+func (p *formPrefix) reader() (io.Reader, error) {
+	if p.file == nil {
+		return bytes.NewReader(p.buf.Bytes()), nil
+	}
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return p.file, nil
+}
+
+func (p *formPrefix) close() {
+	if p.file != nil {
+		p.file.Close()
+		os.Remove(p.file.Name())
+	}
+}
+
+// formFilter applies request filters to the fields of a multipart form. The
+// values of form fields are strings so params are set with their JSON text
+// and only numeric strings are clamped.
+type formFilter struct {
+	filters   config.Filters
+	strip     map[string]bool
+	setParams map[string]any
+	setKeys   []string
+	seen      map[string]bool
+}
+
+func newFormFilter(filters config.Filters) *formFilter {
+	f := &formFilter{filters: filters, strip: map[string]bool{}, seen: map[string]bool{}}
+	for _, param := range filters.SanitizedStripParams() {
+		f.strip[param] = true
+	}
+	f.setParams, f.setKeys = filters.SanitizedSetParams()
+	return f
+}
+
+// writePart writes a field with value or a file read from file
+func (f *formFilter) writePart(w *multipart.Writer, name, filename string, header textproto.MIMEHeader, value []byte, file io.Reader, model string) error {
+	if name == "model" && filename == "" {
+		// issue #69 allow custom model names to be sent to upstream
+		value = []byte(model)
+	} else {
+		if to, found := f.filters.RenameParams[name]; found {
+			name = to
+		}
+		if _, set := f.setParams[name]; f.strip[name] || set {
+			return nil
+		}
+		if filename == "" {
+			value = f.clamp(name, value)
+		}
+	}
+	f.seen[name] = true
+
+	partHeader := make(textproto.MIMEHeader)
+	params := map[string]string{"name": name}
+	if filename != "" {
+		params["filename"] = filename
+		partHeader.Set("Content-Type", "application/octet-stream")
+	}
+	partHeader.Set("Content-Disposition", mime.FormatMediaType("form-data", params))
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		partHeader.Set("Content-Type", contentType)
+	}
+
+	pw, err := w.CreatePart(partHeader)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		_, err = io.Copy(pw, file)
+	} else {
+		_, err = pw.Write(value)
+	}
+	return err
+}
+
+func (f *formFilter) clamp(name string, value []byte) []byte {
+	clamp, found := f.filters.ClampParams[name]
+	if !found {
+		return value
+	}
+	number, err := strconv.ParseFloat(string(value), 64)
+	if err != nil {
+		return value
+	}
+	clamped := number
+	if clamp.Min != nil && clamped < *clamp.Min {
+		clamped = *clamp.Min
+	}
+	if clamp.Max != nil && clamped > *clamp.Max {
+		clamped = *clamp.Max
+	}
+	if clamped == number {
+		return value
+	}
+	return []byte(strconv.FormatFloat(clamped, 'f', -1, 64))
+}
+
+// writeParams adds the set params and the default params the form did not have
+func (f *formFilter) writeParams(w *multipart.Writer) error {
+	for _, key := range f.setKeys {
+		if err := writeFormParam(w, key, f.setParams[key]); err != nil {
+			return err
+		}
+	}
+	for _, key := range config.SortedKeys(f.filters.DefaultParams) {
+		if _, set := f.setParams[key]; f.seen[key] || set {
+			continue
+		}
+		if err := writeFormParam(w, key, f.filters.DefaultParams[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFormParam(w *multipart.Writer, key string, value any) error {
+	text, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("error setting parameter %s in form: %w", key, err)
+		}
+		text = string(encoded)
+	}
+	return w.WriteField(key, text)
+}
+
+// isClosedPipe is true when the upstream stopped reading the form
+func isClosedPipe(err error) bool {
+	return errors.Is(err, io.ErrClosedPipe)
+}
+
+func isMultipartRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
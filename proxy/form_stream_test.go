@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+// newTestForm builds a multipart form. Fields are name/value pairs, a name
+// starting with @ is added as a file.
+func newTestForm(t *testing.T, fields ...string) (*bytes.Buffer, string) {
+	t.Helper()
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	for i := 0; i < len(fields); i += 2 {
+		name, value := fields[i], fields[i+1]
+		if file, ok := strings.CutPrefix(name, "@"); ok {
+			fw, err := w.CreateFormFile(file, "upload.bin")
+			require.NoError(t, err)
+			fw.Write([]byte(value))
+		} else {
+			require.NoError(t, w.WriteField(name, value))
+		}
+	}
+	require.NoError(t, w.Close())
+	return &b, w.FormDataContentType()
+}
+
+func TestFormStream_ReadsOnlyUntilModel(t *testing.T) {
+	form, contentType := newTestForm(t, "@file", "audio data", "model", "model1", "language", "en")
+	prefix := form.Bytes()[:bytes.Index(form.Bytes(), []byte(`name="language"`))]
+
+	// the rest of the upload has not arrived yet
+	failed := errors.New("not sent yet")
+	req := httptest.NewRequest("POST", "/v1/audio/transcriptions", io.MultiReader(bytes.NewReader(prefix), iotest.ErrReader(failed)))
+	req.Header.Set("Content-Type", contentType)
+
+	stream, err := newFormStream(req)
+	require.NoError(t, err)
+	defer stream.close()
+
+	assert.Equal(t, "model1", stream.model)
+	assert.Equal(t, prefix, stream.prefix.buf.Bytes(), "the form read before routing is kept")
+
+	var out bytes.Buffer
+	err = stream.forward(multipart.NewWriter(&out), "model1", config.Filters{})
+	assert.ErrorIs(t, err, failed)
+}
+
+func TestFormStream_Original(t *testing.T) {
+	audio := strings.Repeat("x", maxFormFieldBytes+1)
+	form, contentType := newTestForm(t, "@file", audio, "model", "model1", "language", "en")
+	sent := bytes.Clone(form.Bytes())
+
+	req := httptest.NewRequest("POST", "/v1/audio/transcriptions", form)
+	req.Header.Set("Content-Type", contentType)
+	stream, err := newFormStream(req)
+	require.NoError(t, err)
+	defer stream.close()
+	assert.NotNil(t, stream.prefix.file, "large prefixes are spooled")
+
+	original, err := stream.original()
+	require.NoError(t, err)
+	body, err := io.ReadAll(original)
+	require.NoError(t, err)
+	assert.Equal(t, sent, body)
+
+	assert.False(t, stream.rewrites("model1", config.Filters{}))
+	assert.False(t, stream.rewrites("model1", config.Filters{StripParams: "model"}), "the model is never stripped")
+	assert.True(t, stream.rewrites("whisper-large", config.Filters{}))
+	assert.True(t, stream.rewrites("model1", config.Filters{DefaultParams: map[string]any{"language": "de"}}))
+}
+
+func TestProxyManager_MultipartFilters(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent
+    useModelName: whisper-large
+    filters:
+      stripParams: "prompt"
+      renameParams:
+        lang: language
+      defaultParams:
+        response_format: json
+      setParams:
+        temperature_inc: 0
+      clampParams:
+        temperature:
+          max: 0.5
+routes:
+  auto:
+    rules:
+      - paths: [/v1/audio/transcriptions]
+        model: model1
+`, simpleResponderPath)))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	audio := strings.Repeat("x", 100_000)
+	body, contentType := newTestForm(t,
+		"@file", audio,
+		"model", "auto",
+		"lang", "de",
+		"prompt", "remove me",
+		"temperature", "0.8",
+		"temperature_inc", "0.2",
+	)
+	req := httptest.NewRequest("POST", "/v1/audio/transcriptions", body)
+	req.Header.Set("Content-Type", contentType)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	response := w.Body.String()
+	assert.Equal(t, "whisper-large", gjson.Get(response, "model").String())
+	assert.Equal(t, fmt.Sprintf("The length of the file is %d bytes", len(audio)), gjson.Get(response, "text").String())
+	assert.Equal(t, "model1", w.Header().Get("X-Llama-Swap-Model"))
+
+	fields := gjson.Get(response, "fields")
+	assert.Equal(t, "de", fields.Get("language.0").String())
+	assert.False(t, fields.Get("lang").Exists())
+	assert.False(t, fields.Get("prompt").Exists())
+	assert.Equal(t, "0.5", fields.Get("temperature.0").String())
+	assert.JSONEq(t, `["0"]`, fields.Get("temperature_inc").Raw)
+	assert.Equal(t, "json", fields.Get("response_format.0").String())
+
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.NotEmpty(t, metrics)
+	assert.Equal(t, "model1", metrics[len(metrics)-1].Model)
+	assert.Equal(t, "auto", metrics[len(metrics)-1].Route)
+}
+
+func TestProxyManager_MultipartPeerFilters(t *testing.T) {
+	peerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"model":%q,"response_format":%q,"chunked":%t}`,
+			r.FormValue("model"), r.FormValue("response_format"), r.ContentLength == -1)
+	}))
+	defer peerServer.Close()
+
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+peers:
+  test-peer:
+    proxy: %s
+    models:
+      - peer-model
+    filters:
+      setParams:
+        response_format: verbose_json
+`, peerServer.URL)))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	body, contentType := newTestForm(t, "model", "peer-model", "response_format", "text", "@file", "audio")
+	req := httptest.NewRequest("POST", "/v1/audio/transcriptions", body)
+	req.Header.Set("Content-Type", contentType)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.JSONEq(t, `{"model":"peer-model","response_format":"verbose_json","chunked":true}`, w.Body.String())
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/event"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+)
+
+// how often the progress of a loading process is published
+var loadProgressInterval = time.Second
+
+// LoadTimeTracker remembers how long models took to load by signature so
+// later loads can estimate when they are done.
+type LoadTimeTracker struct {
+	mu        sync.RWMutex
+	durations map[string]time.Duration
+}
+
+func NewLoadTimeTracker() *LoadTimeTracker {
+	return &LoadTimeTracker{
+		durations: make(map[string]time.Duration),
+	}
+}
+
+// Observe records a load, the estimate moves halfway to every new measurement
+// so it follows changes like a cold page cache without jumping on outliers.
+func (t *LoadTimeTracker) Observe(signature string, duration time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if previous, found := t.durations[signature]; found {
+		duration = (previous + duration) / 2
+	}
+	t.durations[signature] = duration
+}
+
+func (t *LoadTimeTracker) Expected(signature string) (time.Duration, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	duration, found := t.durations[signature]
+	return duration, found
+}
+
+// LoadProgress is how far a starting process got
+type LoadProgress struct {
+	Phase   string
+	Percent int
+
+	// time left until the process is expected to be ready, 0 when unknown
+	ETA time.Duration
+}
+
+// String formats the progress for status messages
+func (lp LoadProgress) String() string {
+	if lp.ETA > 0 {
+		return fmt.Sprintf("%s %d%% (about %.0fs left)", lp.Phase, lp.Percent, lp.ETA.Seconds())
+	}
+	return fmt.Sprintf("%s %d%%", lp.Phase, lp.Percent)
+}
+
+type loadingMilestone struct {
+	phase   string
+	regex   *regexp.Regexp
+	percent int
+}
+
+// compileLoadingMilestones compiles milestones validated by the config
+func compileLoadingMilestones(milestones []config.LoadingMilestoneConfig) []loadingMilestone {
+	compiled := make([]loadingMilestone, 0, len(milestones))
+	for _, milestone := range milestones {
+		compiled = append(compiled, loadingMilestone{
+			phase:   milestone.Phase,
+			regex:   regexp.MustCompile(milestone.Regex),
+			percent: milestone.Percent,
+		})
+	}
+	return compiled
+}
+
+// loadProgressTracker follows one start of a process. The phase comes from the
+// milestones found in the upstream log, the percentage is the furthest of the
+// milestone and the time elapsed of the expected load time.
+type loadProgressTracker struct {
+	sync.Mutex
+	processID  string
+	milestones []loadingMilestone
+	begin      time.Time
+	expected   time.Duration
+
+	phase   string
+	percent int
+
+	// last published progress
+	published LoadProgress
+}
+
+func newLoadProgressTracker(processID string, milestones []loadingMilestone, expected time.Duration) *loadProgressTracker {
+	return &loadProgressTracker{
+		processID:  processID,
+		milestones: milestones,
+		begin:      time.Now(),
+		expected:   expected,
+		phase:      "starting",
+	}
+}
+
+// observe matches upstream log data against the milestones
+func (t *loadProgressTracker) observe(data []byte) {
+	t.Lock()
+	changed := false
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, milestone := range t.milestones {
+			if milestone.percent > t.percent && milestone.regex.MatchString(line) {
+				t.phase, t.percent = milestone.phase, milestone.percent
+				changed = true
+			}
+		}
+	}
+	t.Unlock()
+
+	if changed {
+		t.publish()
+	}
+}
+
+func (t *loadProgressTracker) progress() LoadProgress {
+	t.Lock()
+	defer t.Unlock()
+
+	progress := LoadProgress{Phase: t.phase, Percent: t.percent}
+	if t.expected > 0 {
+		elapsed := time.Since(t.begin)
+		// never 100% before the health check passed
+		if timed := min(int(elapsed*100/t.expected), 99); timed > progress.Percent {
+			progress.Percent = timed
+		}
+		if elapsed < t.expected {
+			progress.ETA = t.expected - elapsed
+		}
+	}
+	return progress
+}
+
+// publish emits a LoadingProgressEvent when the phase or percentage changed
+func (t *loadProgressTracker) publish() {
+	progress := t.progress()
+	t.Lock()
+	if progress.Phase == t.published.Phase && progress.Percent == t.published.Percent {
+		t.Unlock()
+		return
+	}
+	t.published = progress
+	t.Unlock()
+
+	event.Emit(LoadingProgressEvent{
+		ProcessName: t.processID,
+		Phase:       progress.Phase,
+		Percent:     progress.Percent,
+		ETA:         progress.ETA,
+	})
+}
+
+// SetLoadTimeTracker shares the measured load times by signature
+func (p *Process) SetLoadTimeTracker(tracker *LoadTimeTracker) {
+	p.loadTimeTracker = tracker
+}
+
+// LoadProgress returns the progress of a starting process, false when the
+// process is not starting.
+func (p *Process) LoadProgress() (LoadProgress, bool) {
+	tracker := p.loadProgress.Load()
+	if tracker == nil {
+		return LoadProgress{}, false
+	}
+	return tracker.progress(), true
+}
+
+// expectedLoadDuration returns how long the process is expected to take to
+// start, 0 when it was never measured.
+func (p *Process) expectedLoadDuration() time.Duration {
+	if p.loadTimeTracker != nil {
+		if expected, found := p.loadTimeTracker.Expected(signatureForModel(p.ID, p.config.Cmd)); found {
+			return expected
+		}
+	}
+	return p.LoadDuration()
+}
+
+// trackLoadProgress follows a start of the process until the returned
+// function is called with the outcome of the start.
+func (p *Process) trackLoadProgress() func(loaded time.Duration, err error) {
+	tracker := newLoadProgressTracker(p.ID, p.loadingMilestones, p.expectedLoadDuration())
+	p.loadProgress.Store(tracker)
+	tracker.publish()
+
+	stopObserving := func() {}
+	if len(tracker.milestones) > 0 && p.processLogger != nil {
+		stopObserving = p.processLogger.OnLogData(tracker.observe)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(loadProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				tracker.publish()
+			}
+		}
+	}()
+
+	return func(loaded time.Duration, err error) {
+		close(done)
+		stopObserving()
+		p.loadProgress.Store(nil)
+		if err != nil {
+			return
+		}
+		if p.loadTimeTracker != nil {
+			p.loadTimeTracker.Observe(signatureForModel(p.ID, p.config.Cmd), loaded)
+		}
+		event.Emit(LoadingProgressEvent{ProcessName: p.ID, Phase: "ready", Percent: 100})
+	}
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/event"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTimeTracker_Observe(t *testing.T) {
+	tracker := NewLoadTimeTracker()
+	_, found := tracker.Expected("model|cmd")
+	assert.False(t, found)
+
+	tracker.Observe("model|cmd", 10*time.Second)
+	tracker.Observe("model|cmd", 20*time.Second)
+	expected, found := tracker.Expected("model|cmd")
+	require.True(t, found)
+	assert.Equal(t, 15*time.Second, expected)
+}
+
+func TestLoadProgressTracker_Milestones(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(""))
+	require.NoError(t, err)
+	milestones := compileLoadingMilestones(cfg.LoadingProgress.BackendFor(config.ModelConfig{Cmd: "llama-server -m model.gguf"}))
+	require.NotEmpty(t, milestones)
+
+	tracker := newLoadProgressTracker("model1", milestones, 0)
+	progress := tracker.progress()
+	assert.Equal(t, LoadProgress{Phase: "starting", Percent: 0}, progress)
+
+	tracker.observe([]byte("llama_model_loader: loaded meta data with 34 key-value pairs\nload_tensors: loading model tensors, this can take a while... (mmap = true)\n"))
+	assert.Equal(t, LoadProgress{Phase: "loading tensors", Percent: 15}, tracker.progress())
+
+	// milestones do not go backwards
+	tracker.observe([]byte("llama_model_loader: loaded meta data with 34 key-value pairs\n"))
+	assert.Equal(t, "loading tensors", tracker.progress().Phase)
+
+	tracker.observe([]byte("main: server is listening on http://127.0.0.1:8080 - starting the main loop\n"))
+	assert.Equal(t, LoadProgress{Phase: "listening", Percent: 95}, tracker.progress())
+}
+
+func TestLoadProgressTracker_ETA(t *testing.T) {
+	tracker := newLoadProgressTracker("model1", nil, 10*time.Second)
+	tracker.begin = time.Now().Add(-4 * time.Second)
+
+	progress := tracker.progress()
+	assert.Equal(t, "starting", progress.Phase)
+	assert.InDelta(t, 40, progress.Percent, 1)
+	assert.InDelta(t, 6*time.Second, progress.ETA, float64(100*time.Millisecond))
+	assert.Contains(t, progress.String(), "starting 40% (about 6s left)")
+
+	tracker.begin = time.Now().Add(-20 * time.Second)
+	progress = tracker.progress()
+	assert.Equal(t, 99, progress.Percent, "not done before the health check passed")
+	assert.Zero(t, progress.ETA)
+	assert.Equal(t, "starting 99%", progress.String())
+}
+
+func TestProcess_LoadProgressEvents(t *testing.T) {
+	port := getTestPort()
+	modelConfig := config.ModelConfig{
+		Cmd:           fmt.Sprintf("%s --port %d --respond progress", filepath.ToSlash(simpleResponderPath), port),
+		Proxy:         fmt.Sprintf("http://127.0.0.1:%d", port),
+		CheckEndpoint: "/health",
+	}
+	process := NewProcess("progress", 5, modelConfig, NewLogMonitorWriter(io.Discard), debugLogger)
+	process.loadingMilestones = compileLoadingMilestones([]config.LoadingMilestoneConfig{
+		{Phase: "listening", Regex: `simple-responder listening on`, Percent: 90},
+	})
+	tracker := NewLoadTimeTracker()
+	process.SetLoadTimeTracker(tracker)
+	defer process.Stop()
+
+	var mu sync.Mutex
+	var events []LoadingProgressEvent
+	defer event.On(func(e LoadingProgressEvent) {
+		if e.ProcessName != "progress" {
+			return
+		}
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	})()
+
+	require.NoError(t, process.start())
+	_, loading := process.LoadProgress()
+	assert.False(t, loading)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) > 0 && events[len(events)-1].Phase == "ready"
+	}, time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	phases := []string{}
+	for _, e := range events {
+		phases = append(phases, e.Phase)
+	}
+	mu.Unlock()
+	assert.Equal(t, "starting", phases[0])
+	assert.Contains(t, phases, "listening")
+
+	expected, found := tracker.Expected(signatureForModel("progress", modelConfig.Cmd))
+	require.True(t, found)
+	assert.InDelta(t, process.LoadDuration(), expected, float64(10*time.Millisecond))
+}
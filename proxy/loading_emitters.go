@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadingEmitter writes the model loading status in the streaming format of
+// an endpoint. Requests translated by apiTranslation reach the process as chat
+// completions and their translators turn the reasoning deltas into thinking
+// blocks or reasoning items.
+type loadingEmitter interface {
+	// begin is written once after the response headers
+	begin(w http.ResponseWriter) error
+
+	// text is a piece of the status message
+	text(w http.ResponseWriter, text string) error
+
+	// end is written once the model is loaded or failed to load
+	end(w http.ResponseWriter) error
+}
+
+// loadingEmitterFor returns the emitter for a streaming request path, nil
+// when the endpoint has no streaming format for status messages
+func loadingEmitterFor(path, modelID string) loadingEmitter {
+	switch {
+	case strings.HasPrefix(path, "/v1/chat/completions"):
+		return &chatLoadingEmitter{}
+	case strings.HasPrefix(path, "/v1/completions"):
+		return &completionLoadingEmitter{}
+	case path == "/v1/messages":
+		return &anthropicLoadingEmitter{}
+	case path == "/v1/responses":
+		return &responsesLoadingEmitter{
+			responseID: newTranslationID("resp_"),
+			itemID:     newTranslationID("rs_"),
+			model:      modelID,
+		}
+	default:
+		return nil
+	}
+}
+
+// chatLoadingEmitter sends the status as reasoning_content deltas
+type chatLoadingEmitter struct{}
+
+func (e *chatLoadingEmitter) begin(w http.ResponseWriter) error { return nil }
+func (e *chatLoadingEmitter) end(w http.ResponseWriter) error   { return nil }
+
+func (e *chatLoadingEmitter) text(w http.ResponseWriter, text string) error {
+	return writeSSEEvent(w, "", gin.H{"choices": []gin.H{{"delta": gin.H{"reasoning_content": text}}}})
+}
+
+// completionLoadingEmitter sends the status as SSE comments, legacy
+// completions have no field for text that is not part of the completion
+type completionLoadingEmitter struct{}
+
+func (e *completionLoadingEmitter) begin(w http.ResponseWriter) error { return nil }
+func (e *completionLoadingEmitter) end(w http.ResponseWriter) error   { return nil }
+
+func (e *completionLoadingEmitter) text(w http.ResponseWriter, text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(": ")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// anthropicLoadingEmitter sends ping events. Thinking blocks can only be
+// sent after message_start, which the upstream sends once it is loaded.
+type anthropicLoadingEmitter struct{}
+
+func (e *anthropicLoadingEmitter) begin(w http.ResponseWriter) error { return nil }
+func (e *anthropicLoadingEmitter) end(w http.ResponseWriter) error   { return nil }
+
+func (e *anthropicLoadingEmitter) text(w http.ResponseWriter, text string) error {
+	return writeSSEEvent(w, "ping", gin.H{"type": "ping"})
+}
+
+// responsesLoadingEmitter sends the status as a reasoning item of a
+// response that is in progress. The upstream starts its own response once it
+// is loaded, clients replace the loading response with it.
+type responsesLoadingEmitter struct {
+	responseID string
+	itemID     string
+	model      string
+	sequence   int
+	summary    strings.Builder
+}
+
+func (e *responsesLoadingEmitter) event(w http.ResponseWriter, event string, payload gin.H) error {
+	payload["type"] = event
+	payload["sequence_number"] = e.sequence
+	e.sequence++
+	return writeSSEEvent(w, event, payload)
+}
+
+func (e *responsesLoadingEmitter) begin(w http.ResponseWriter) error {
+	response := gin.H{
+		"id":         e.responseID,
+		"object":     "response",
+		"created_at": time.Now().Unix(),
+		"status":     "in_progress",
+		"model":      e.model,
+		"output":     []any{},
+	}
+	if err := e.event(w, "response.created", gin.H{"response": response}); err != nil {
+		return err
+	}
+	if err := e.event(w, "response.output_item.added", gin.H{"output_index": 0, "item": gin.H{"type": "reasoning", "id": e.itemID, "summary": []any{}}}); err != nil {
+		return err
+	}
+	return e.event(w, "response.reasoning_summary_part.added", gin.H{"item_id": e.itemID, "output_index": 0, "summary_index": 0, "part": gin.H{"type": "summary_text", "text": ""}})
+}
+
+func (e *responsesLoadingEmitter) text(w http.ResponseWriter, text string) error {
+	e.summary.WriteString(text)
+	return e.event(w, "response.reasoning_summary_text.delta", gin.H{"item_id": e.itemID, "output_index": 0, "summary_index": 0, "delta": text})
+}
+
+func (e *responsesLoadingEmitter) end(w http.ResponseWriter) error {
+	summary := e.summary.String()
+	events := []struct {
+		name    string
+		payload gin.H
+	}{
+		{"response.reasoning_summary_text.done", gin.H{"item_id": e.itemID, "output_index": 0, "summary_index": 0, "text": summary}},
+		{"response.reasoning_summary_part.done", gin.H{"item_id": e.itemID, "output_index": 0, "summary_index": 0, "part": gin.H{"type": "summary_text", "text": summary}}},
+		{"response.output_item.done", gin.H{"output_index": 0, "item": gin.H{"type": "reasoning", "id": e.itemID, "summary": []gin.H{{"type": "summary_text", "text": summary}}}}},
+	}
+	for _, event := range events {
+		if err := e.event(w, event.name, event.payload); err != nil {
+			return fmt.Errorf("unable to write %s: %w", event.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+// loadingStatus returns what a status response writer sends for path while
+// a model loads
+func loadingStatus(t *testing.T, path string) string {
+	t.Helper()
+	emitter := loadingEmitterFor(path, "model1")
+	require.NotNil(t, emitter)
+
+	process := NewProcess("model1", 1, getTestSimpleResponderConfig("model1"), testLogger, testLogger)
+	w := CreateTestResponseRecorder()
+	srw := newStatusResponseWriter(process, w, emitter)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	srw.statusUpdates(ctx)
+	require.True(t, srw.waitForCompletion(time.Second))
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	return w.Body.String()
+}
+
+// sseData returns the data payloads of an SSE stream
+func sseData(body string) []gjson.Result {
+	var data []gjson.Result
+	for _, line := range strings.Split(body, "\n") {
+		if payload, found := strings.CutPrefix(line, "data: "); found {
+			data = append(data, gjson.Parse(payload))
+		}
+	}
+	return data
+}
+
+func TestLoadingEmitterFor(t *testing.T) {
+	assert.IsType(t, &chatLoadingEmitter{}, loadingEmitterFor("/v1/chat/completions", "m"))
+	assert.IsType(t, &completionLoadingEmitter{}, loadingEmitterFor("/v1/completions", "m"))
+	assert.IsType(t, &anthropicLoadingEmitter{}, loadingEmitterFor("/v1/messages", "m"))
+	assert.IsType(t, &responsesLoadingEmitter{}, loadingEmitterFor("/v1/responses", "m"))
+	assert.Nil(t, loadingEmitterFor("/v1/embeddings", "m"))
+	assert.Nil(t, loadingEmitterFor("/v1/messages/count_tokens", "m"))
+}
+
+func TestLoadingEmitter_ChatCompletions(t *testing.T) {
+	body := loadingStatus(t, "/v1/chat/completions")
+	var reasoning strings.Builder
+	for _, data := range sseData(body) {
+		reasoning.WriteString(data.Get("choices.0.delta.reasoning_content").String())
+	}
+	assert.Contains(t, reasoning.String(), "llama-swap loading model: model1")
+	assert.Contains(t, reasoning.String(), "Done!")
+}
+
+func TestLoadingEmitter_Completions(t *testing.T) {
+	body := loadingStatus(t, "/v1/completions")
+	assert.Empty(t, sseData(body), "no data a client would parse as a completion")
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" {
+			assert.True(t, strings.HasPrefix(line, ": "), "not a comment: %q", line)
+		}
+	}
+	assert.Contains(t, body, ": llama-swap loading model: model1\n")
+}
+
+func TestLoadingEmitter_AnthropicMessages(t *testing.T) {
+	body := loadingStatus(t, "/v1/messages")
+	events := sseEvents(body)
+	require.NotEmpty(t, events)
+	for _, event := range events {
+		assert.Equal(t, "ping", event)
+	}
+	for _, data := range sseData(body) {
+		assert.JSONEq(t, `{"type":"ping"}`, data.Raw)
+	}
+}
+
+func TestLoadingEmitter_Responses(t *testing.T) {
+	body := loadingStatus(t, "/v1/responses")
+	events := sseEvents(body)
+	require.GreaterOrEqual(t, len(events), 7)
+	assert.Equal(t, []string{
+		"response.created",
+		"response.output_item.added",
+		"response.reasoning_summary_part.added",
+	}, events[:3])
+	assert.Equal(t, []string{
+		"response.reasoning_summary_text.done",
+		"response.reasoning_summary_part.done",
+		"response.output_item.done",
+	}, events[len(events)-3:])
+	for _, event := range events[3 : len(events)-3] {
+		assert.Equal(t, "response.reasoning_summary_text.delta", event)
+	}
+
+	data := sseData(body)
+	require.Len(t, data, len(events))
+	for i, payload := range data {
+		assert.Equal(t, events[i], payload.Get("type").String())
+		assert.Equal(t, int64(i), payload.Get("sequence_number").Int())
+	}
+	assert.Equal(t, "in_progress", data[0].Get("response.status").String())
+	assert.Equal(t, "model1", data[0].Get("response.model").String())
+
+	summary := data[len(data)-1].Get("item.summary.0.text").String()
+	assert.Contains(t, summary, "llama-swap loading model: model1")
+	assert.Equal(t, summary, data[len(data)-3].Get("text").String())
+}
+
+func TestProxyManager_CompletionsLoadingState(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+sendLoadingState: true
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+`, simpleResponderPath)))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/completions", bytes.NewBufferString(`{"model":"model1","stream":true,"prompt":"hi"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	body := w.Body.String()
+	status := strings.Index(body, ": llama-swap loading model: model1")
+	upstream := strings.Index(body, `"responseMessage":"model1"`)
+	require.GreaterOrEqual(t, status, 0, body)
+	require.Greater(t, upstream, status, "loading status precedes the upstream response")
+}
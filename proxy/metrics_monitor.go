@@ -22,6 +22,7 @@ type TokenMetrics struct {
 	ID              int       `json:"id"`
 	Timestamp       time.Time `json:"timestamp"`
 	Model           string    `json:"model"`
+	Route           string    `json:"route,omitempty"`
 	CachedTokens    int       `json:"cache_tokens"`
 	InputTokens     int       `json:"input_tokens"`
 	OutputTokens    int       `json:"output_tokens"`
@@ -29,6 +30,10 @@ type TokenMetrics struct {
 	TokensPerSecond float64   `json:"tokens_per_second"`
 	DurationMs      int       `json:"duration_ms"`
 	HasCapture      bool      `json:"has_capture"`
+	CacheHit        bool      `json:"cache_hit,omitempty"`
+
+	// why a request for a local model was sent to a peer, see peer_offload.go
+	PeerOffload string `json:"peer_offload,omitempty"`
 }
 
 type ReqRespCapture struct {
@@ -179,7 +184,8 @@ func (mp *metricsMonitor) wrapHandler(
 	var reqBody []byte
 	var reqHeaders map[string]string
 	if mp.enableCaptures {
-		if request.Body != nil {
+		// multipart uploads stream through to the upstream and are not captured
+		if request.Body != nil && !isMultipartRequest(request) {
 			var err error
 			reqBody, err = io.ReadAll(request.Body)
 			if err != nil {
@@ -211,16 +217,39 @@ func (mp *metricsMonitor) wrapHandler(
 	// after this point we have to assume that data was sent to the client
 	// and we can only log errors but not send them to clients
 
+	// shared with the postRequest webhook, see webhooks.go
+	var tm TokenMetrics
+	if sink, ok := request.Context().Value(proxyCtxKey("metrics")).(*TokenMetrics); ok {
+		defer func() { *sink = tm }()
+	}
+
 	if recorder.Status() != http.StatusOK {
 		mp.logger.Warnf("metrics skipped, HTTP status=%d, path=%s", recorder.Status(), request.URL.Path)
 		return nil
 	}
 
+	// the virtual model name when the model was picked by a route
+	route, _ := request.Context().Value(proxyCtxKey("route")).(string)
+
+	// served from the response cache, see response_cache.go
+	cacheHit, _ := request.Context().Value(proxyCtxKey("cacheHit")).(bool)
+
+	// sent to a peer instead of the local model, see peer_offload.go
+	peerOffload, _ := request.Context().Value(proxyCtxKey("peerOffload")).(string)
+
+	// requests for a preset are recorded under the preset's name
+	if preset, _ := request.Context().Value(proxyCtxKey("preset")).(string); preset != "" {
+		modelID = preset
+	}
+
 	// Initialize default metrics - these will always be recorded
-	tm := TokenMetrics{
-		Timestamp:  time.Now(),
-		Model:      modelID,
-		DurationMs: int(time.Since(recorder.StartTime()).Milliseconds()),
+	tm = TokenMetrics{
+		Timestamp:   time.Now(),
+		Model:       modelID,
+		Route:       route,
+		DurationMs:  int(time.Since(recorder.StartTime()).Milliseconds()),
+		CacheHit:    cacheHit,
+		PeerOffload: peerOffload,
 	}
 
 	body := recorder.body.Bytes()
@@ -240,7 +269,7 @@ func (mp *metricsMonitor) wrapHandler(
 			return nil
 		}
 	}
-	if strings.Contains(recorder.Header().Get("Content-Type"), "text/event-stream") {
+	if strings.Contains(recorder.contentType, "text/event-stream") {
 		if parsed, err := processStreamingResponse(modelID, recorder.StartTime(), body); err != nil {
 			mp.logger.Warnf("error processing streaming response: %v, path=%s, recording minimal metrics", err, request.URL.Path)
 		} else {
@@ -272,6 +301,13 @@ func (mp *metricsMonitor) wrapHandler(
 		}
 	}
 
+	tm.Route = route
+	if cacheHit {
+		// the cached timings are from the original request
+		tm.CacheHit = true
+		tm.DurationMs = int(time.Since(recorder.StartTime()).Milliseconds())
+	}
+
 	// Build capture if enabled and determine if it will be stored
 	var capture *ReqRespCapture
 	if mp.enableCaptures {
@@ -442,6 +478,10 @@ type responseBodyCopier struct {
 	body  *bytes.Buffer
 	tee   io.Writer
 	start time.Time
+
+	// the upstream's Content-Type, a translating writer further down may
+	// change the header sent to the client
+	contentType string
 }
 
 func newBodyCopier(w gin.ResponseWriter) *responseBodyCopier {
@@ -457,12 +497,16 @@ func (w *responseBodyCopier) Write(b []byte) (int, error) {
 	if w.start.IsZero() {
 		w.start = time.Now()
 	}
+	if w.contentType == "" {
+		w.contentType = w.Header().Get("Content-Type")
+	}
 
 	// Single write operation that writes to both the response and buffer
 	return w.tee.Write(b)
 }
 
 func (w *responseBodyCopier) WriteHeader(statusCode int) {
+	w.contentType = w.Header().Get("Content-Type")
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
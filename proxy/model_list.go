@@ -0,0 +1,303 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+)
+
+// modelStatus is the live state of a model in its /v1/models record. Models
+// of health checked peers only have a state.
+type modelStatus struct {
+	State          string `json:"state"`
+	MeasuredVramMB uint64 `json:"measuredVramMB,omitempty"`
+	MeasuredCpuMB  uint64 `json:"measuredCpuMB,omitempty"`
+	GPU            *int   `json:"gpu,omitempty"`
+
+	// how long the last start took
+	LoadDurationMs int64 `json:"loadDurationMs,omitempty"`
+
+	// set for models that are not running when GPU scheduling is enabled and
+	// the records were requested with ?fit=true
+	Fits       *bool    `json:"fits,omitempty"`
+	WouldEvict []string `json:"wouldEvict,omitempty"`
+}
+
+// modelListOptions select the /v1/models records and what they include
+type modelListOptions struct {
+	includeUnlisted bool
+
+	// only the record with this id, all records when empty
+	id string
+
+	// whether stopped models fit and what starting them would evict, planning
+	// it queries the GPUs
+	fit bool
+}
+
+func (pm *ProxyManager) listModelsHandler(c *gin.Context) {
+	fit, _ := strconv.ParseBool(c.Query("fit"))
+	records := pm.modelRecords(modelListOptions{fit: fit})
+	data := make([]gin.H, 0, len(records))
+	for _, record := range records {
+		if matchesModelQuery(record, c.Request.URL.Query()) {
+			data = append(data, record)
+		}
+	}
+
+	// Set CORS headers if origin exists
+	if origin := c.GetHeader("Origin"); origin != "" {
+		c.Header("Access-Control-Allow-Origin", origin)
+	}
+
+	// Use gin's JSON method which handles content-type and encoding
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// retrieveModelHandler returns the record of a single model. Unlisted models
+// and aliases can be retrieved by their id.
+func (pm *ProxyManager) retrieveModelHandler(c *gin.Context) {
+	id := strings.TrimPrefix(c.Param("id"), "/")
+
+	if origin := c.GetHeader("Origin"); origin != "" {
+		c.Header("Access-Control-Allow-Origin", origin)
+	}
+
+	fit, _ := strconv.ParseBool(c.Query("fit"))
+	if records := pm.modelRecords(modelListOptions{includeUnlisted: true, id: id, fit: fit}); len(records) > 0 {
+		c.JSON(http.StatusOK, records[0])
+		return
+	}
+	c.JSON(http.StatusNotFound, openAIError(http.StatusNotFound, fmt.Sprintf("model %s not found", id)))
+}
+
+// modelRecords returns the OpenAI model records of local models, presets,
+// peer models, routes and pools sorted by id
+func (pm *ProxyManager) modelRecords(opts modelListOptions) []gin.H {
+	snap := pm.snapshot.Load()
+	data := make([]gin.H, 0, len(snap.config.Models))
+	createdTime := pm.startTime.Unix()
+	includeUnlisted := opts.includeUnlisted
+
+	// add builds the record of id when it was requested
+	add := func(id string, record func() gin.H) {
+		if opts.id == "" || opts.id == id {
+			data = append(data, record())
+		}
+	}
+
+	// one GPU query for all plans of the listing
+	var gpus func() ([]GPUInfo, error)
+	if opts.fit && pm.scheduler != nil {
+		gpus = sync.OnceValues(pm.scheduler.queryPlanGPUs)
+	}
+
+	newRecord := func(modelId string, modelConfig config.ModelConfig) gin.H {
+		record := gin.H{
+			"id":       modelId,
+			"object":   "model",
+			"created":  createdTime,
+			"owned_by": "llama-swap",
+		}
+
+		if name := strings.TrimSpace(modelConfig.Name); name != "" {
+			record["name"] = name
+		}
+		if desc := strings.TrimSpace(modelConfig.Description); desc != "" {
+			record["description"] = desc
+		}
+
+		// Add metadata if present
+		if len(modelConfig.Metadata) > 0 {
+			record["meta"] = gin.H{
+				"llamaswap": modelConfig.Metadata,
+			}
+		}
+		return record
+	}
+
+	for id, modelConfig := range snap.config.Models {
+		status := sync.OnceValues(func() (modelStatus, bool) {
+			return pm.modelStatus(snap, id, gpus)
+		})
+		newLocalRecord := func(name string, modelConfig config.ModelConfig) func() gin.H {
+			return func() gin.H {
+				record := newRecord(name, modelConfig)
+				if status, hasStatus := status(); hasStatus {
+					record["status"] = status
+				}
+				return record
+			}
+		}
+
+		// presets are listed even when their model is unlisted
+		for name, preset := range modelConfig.Presets {
+			if !preset.Unlisted || includeUnlisted {
+				add(name, newLocalRecord(name, config.ModelConfig{
+					Name:        preset.Name,
+					Description: preset.Description,
+					Metadata:    preset.Metadata,
+				}))
+			}
+		}
+
+		if modelConfig.Unlisted && !includeUnlisted {
+			continue
+		}
+
+		add(id, newLocalRecord(id, modelConfig))
+
+		// Include aliases
+		if snap.config.IncludeAliasesInList || includeUnlisted {
+			for _, alias := range modelConfig.Aliases {
+				if alias := strings.TrimSpace(alias); alias != "" {
+					add(alias, newLocalRecord(alias, modelConfig))
+				}
+			}
+		}
+	}
+
+	if pm.peerProxy != nil {
+		for peerID, peer := range pm.peerProxy.ListPeers() {
+			// add peer models
+			for _, modelID := range peer.Models {
+				add(modelID, func() gin.H {
+					record := newRecord(modelID, config.ModelConfig{
+						Name: fmt.Sprintf("%s: %s", peerID, modelID),
+						Metadata: map[string]any{
+							"peerID": peerID,
+						},
+					})
+					if state := pm.peerProxy.PeerStatus(peerID); state != "" {
+						record["status"] = modelStatus{State: state}
+					}
+					return record
+				})
+			}
+		}
+	}
+
+	for name := range snap.config.Routes {
+		add(name, func() gin.H {
+			return newRecord(name, config.ModelConfig{
+				Metadata: map[string]any{
+					"route": true,
+				},
+			})
+		})
+	}
+
+	for name, pool := range snap.config.Pools {
+		add(name, func() gin.H {
+			return newRecord(name, config.ModelConfig{
+				Metadata: map[string]any{
+					"pool": pool.Models,
+				},
+			})
+		})
+	}
+
+	// Sort by the "id" key
+	sort.Slice(data, func(i, j int) bool {
+		si, _ := data[i]["id"].(string)
+		sj, _ := data[j]["id"].(string)
+		return si < sj
+	})
+
+	return data
+}
+
+// modelStatus returns the live state of a local model. Whether the model fits
+// and what starting it would evict is planned on gpus, nil leaves it out.
+func (pm *ProxyManager) modelStatus(snap *proxySnapshot, modelID string, gpus func() ([]GPUInfo, error)) (modelStatus, bool) {
+	process := snap.findProcessByModelName(modelID)
+	if process == nil {
+		return modelStatus{}, false
+	}
+
+	status := modelStatus{
+		State:          string(process.CurrentState()),
+		MeasuredVramMB: process.MeasuredVramMB(),
+		MeasuredCpuMB:  process.MeasuredCpuMB(),
+		LoadDurationMs: process.LoadDuration().Milliseconds(),
+	}
+	if gpu := process.AssignedGPU(); gpu >= 0 {
+		status.GPU = &gpu
+	}
+
+	if gpus != nil && process.CurrentState() == StateStopped {
+		plan := pm.scheduler.planProcess(process, gpus)
+		status.Fits = &plan.Fits
+		for _, evicted := range plan.Evict {
+			status.WouldEvict = append(status.WouldEvict, evicted.ID)
+		}
+	}
+	return status, true
+}
+
+// matchesModelQuery filters /v1/models records by query parameters:
+//
+//	state=ready,starting  the state of a local model, or available and down
+//	                      for health checked peers
+//	peer=ID               models of a peer
+//	meta.KEY=VALUE        a metadata value, or a list containing the value
+//
+// fit=true is not a filter, it adds whether stopped models fit to the records.
+func matchesModelQuery(record gin.H, query url.Values) bool {
+	metadata := map[string]any{}
+	if meta, ok := record["meta"].(gin.H); ok {
+		if values, ok := meta["llamaswap"].(map[string]any); ok {
+			metadata = values
+		}
+	}
+
+	for key, values := range query {
+		for _, value := range values {
+			switch {
+			case key == "state":
+				status, ok := record["status"].(modelStatus)
+				if !ok || !slices.Contains(strings.Split(value, ","), status.State) {
+					return false
+				}
+			case key == "peer":
+				if peerID, _ := metadata["peerID"].(string); peerID != value {
+					return false
+				}
+			case strings.HasPrefix(key, "meta."):
+				if !metadataContains(metadata[strings.TrimPrefix(key, "meta.")], value) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func metadataContains(field any, value string) bool {
+	switch field := field.(type) {
+	case nil:
+		return false
+	case []any:
+		for _, item := range field {
+			if fmt.Sprint(item) == value {
+				return true
+			}
+		}
+		return false
+	case []string:
+		return slices.Contains(field, value)
+	default:
+		return fmt.Sprint(field) == value
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestProxyManager_ListModelsStatus(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+models:
+  small:
+    cmd: ./server --port ${PORT}
+    fitPolicy: evict_to_fit
+    initialVramMB: 8000
+  large:
+    cmd: ./server --port ${PORT}
+    fitPolicy: evict_to_fit
+    initialVramMB: 10000
+  medium:
+    cmd: ./server --port ${PORT}
+    fitPolicy: evict_to_fit
+    initialVramMB: 3000
+`))
+	require.NoError(t, err)
+	allocator := &fakeGPUAllocator{gpus: []GPUInfo{{Index: 0, FreeMB: 4000, TotalMB: 24576}}}
+	proxy := NewWithAllocator(cfg, allocator)
+	require.NotNil(t, proxy.scheduler)
+
+	small := proxy.findProcessByModelName("small")
+	readyOnGPU(small, 0)
+	small.loadDuration.Store(int64(2 * time.Second))
+	defer small.forceState(StateStopped)
+
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.JSONEq(t, `{"state":"ready","measuredVramMB":8000,"gpu":0,"loadDurationMs":2000}`,
+		gjson.Get(body, `data.#(id=="small").status`).Raw)
+	assert.JSONEq(t, `{"state":"stopped","measuredVramMB":10000}`,
+		gjson.Get(body, `data.#(id=="large").status`).Raw)
+	assert.Equal(t, proxy.startTime.Unix(), gjson.Get(body, "data.0.created").Int())
+	calls := allocator.calls
+
+	t.Run("fit is planned on one GPU query", func(t *testing.T) {
+		// a process that changed state always queries the GPUs again
+		defer func(ttl time.Duration) { planGPUsTTL = ttl }(planGPUsTTL)
+		planGPUsTTL = 0
+
+		req := httptest.NewRequest("GET", "/v1/models?fit=true", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		body := w.Body.String()
+		assert.JSONEq(t, `{"state":"stopped","measuredVramMB":10000,"fits":true,"wouldEvict":["small"]}`,
+			gjson.Get(body, `data.#(id=="large").status`).Raw)
+		assert.JSONEq(t, `{"state":"stopped","measuredVramMB":3000,"fits":true}`,
+			gjson.Get(body, `data.#(id=="medium").status`).Raw)
+		assert.Equal(t, calls+1, allocator.calls)
+
+		req = httptest.NewRequest("GET", "/v1/models/large?fit=true", nil)
+		w = CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, gjson.Get(w.Body.String(), "status.fits").Bool())
+		assert.Equal(t, calls+2, allocator.calls)
+	})
+}
+
+func TestProxyManager_ListModelsQuery(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+    metadata:
+      tags: [vision, chat]
+      family: llama
+  model2:
+    cmd: ./server --port ${PORT}
+    metadata:
+      tags: [chat]
+peers:
+  peer1:
+    proxy: http://peer1:8080
+    models: [peer-model]
+`))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	proxy.findProcessByModelName("model2").forceState(StateReady)
+	defer proxy.findProcessByModelName("model2").forceState(StateStopped)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", `["model1","model2","peer-model"]`},
+		{"?meta.tags=chat", `["model1","model2"]`},
+		{"?meta.tags=chat&meta.tags=vision", `["model1"]`},
+		{"?meta.family=llama", `["model1"]`},
+		{"?state=ready", `["model2"]`},
+		{"?state=stopped,starting", `["model1"]`},
+		{"?peer=peer1", `["peer-model"]`},
+		{"?peer=other", `[]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/models"+tt.query, nil)
+			w := CreateTestResponseRecorder()
+			proxy.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, tt.want, gjson.Get(w.Body.String(), "data.#.id").Raw)
+		})
+	}
+}
+
+func TestProxyManager_RetrieveModel(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+models:
+  author/model:
+    cmd: ./server --port ${PORT}
+    name: Author Model
+    aliases: [short]
+  hidden:
+    cmd: ./server --port ${PORT}
+    unlisted: true
+`))
+	require.NoError(t, err)
+	proxy := New(cfg)
+
+	for _, id := range []string{"author/model", "short", "hidden"} {
+		req := httptest.NewRequest("GET", "/v1/models/"+id, nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, id)
+		assert.Equal(t, id, gjson.Get(w.Body.String(), "id").String())
+		assert.Equal(t, "model", gjson.Get(w.Body.String(), "object").String())
+		assert.Equal(t, "stopped", gjson.Get(w.Body.String(), "status.state").String())
+	}
+
+	req := httptest.NewRequest("GET", "/v1/models/missing", nil)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "invalid_request_error", gjson.Get(w.Body.String(), "error.type").String())
+}
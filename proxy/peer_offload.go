@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"strings"
+)
+
+// peerOffload decides if a request for a local model is sent to a peer that
+// also serves it because starting the local model would evict other models or
+// the model does not fit. It returns the name of the model on the peer and why
+// the local model is not used.
+func (pm *ProxyManager) peerOffload(modelID, requestedModel string) (peerModel string, reason string, offload bool) {
+	offloadConfig := pm.snapshot.Load().config.PeerOffload
+	if !offloadConfig.Enabled || pm.peerProxy == nil || pm.scheduler == nil {
+		return "", "", false
+	}
+
+	// running and starting models are used
+	process := pm.findProcessByModelName(modelID)
+	if process == nil || process.CurrentState() != StateStopped {
+		return "", "", false
+	}
+
+	for _, name := range []string{requestedModel, modelID} {
+		if pm.peerProxy.HasPeerModel(name) {
+			peerModel = name
+			break
+		}
+	}
+	if peerModel == "" {
+		return "", "", false
+	}
+
+	// requests share the GPU query of the plan while the running models stay
+	// the same, see queryPlanGPUs
+	plan := pm.scheduler.PlanProcess(process)
+	switch {
+	case !plan.Fits:
+		reason = "does not fit"
+	case len(plan.Evict) > 0:
+		evict := make([]string, len(plan.Evict))
+		for i, evicted := range plan.Evict {
+			evict[i] = evicted.ID
+		}
+		reason = "would evict " + strings.Join(evict, ", ")
+	default:
+		return "", "", false
+	}
+
+	if offloadConfig.RequireLoaded && !pm.peerProxy.IsModelLoaded(peerModel) {
+		pm.proxyLogger.Debugf("<%s> starting locally %s, no peer has %s loaded", modelID, reason, peerModel)
+		return "", "", false
+	}
+
+	pm.proxyLogger.Infof("<%s> sending request to peer model %s, starting locally %s", modelID, peerModel, reason)
+	return peerModel, reason, true
+}
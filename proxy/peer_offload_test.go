@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func newPeerOffloadTestProxy(t *testing.T, peerOffload string, peerHandler http.HandlerFunc) *ProxyManager {
+	t.Helper()
+	peer := httptest.NewServer(peerHandler)
+	t.Cleanup(peer.Close)
+
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  loaded:
+    cmd: ./server --port ${PORT}
+    fitPolicy: evict_to_fit
+    initialVramMB: 8000
+  large:
+    cmd: ./server --port ${PORT}
+    fitPolicy: evict_to_fit
+    initialVramMB: 10000
+  small:
+    cmd: ./server --port ${PORT}
+    fitPolicy: evict_to_fit
+    initialVramMB: 3000
+peers:
+  peer1:
+    proxy: %s
+    models: [large, small]
+peerOffload:
+  %s
+`, peer.URL, peerOffload)))
+	require.NoError(t, err)
+
+	proxy := NewWithAllocator(cfg, &fakeGPUAllocator{gpus: []GPUInfo{{Index: 0, FreeMB: 4000, TotalMB: 24576}}})
+	require.NotNil(t, proxy.scheduler)
+	loaded := proxy.findProcessByModelName("loaded")
+	readyOnGPU(loaded, 0)
+	t.Cleanup(func() { loaded.forceState(StateStopped) })
+	return proxy
+}
+
+func TestProxyManager_PeerOffload(t *testing.T) {
+	proxy := newPeerOffloadTestProxy(t, "enabled: true", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"from":"peer1"}`))
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"large"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "peer1", gjson.Get(w.Body.String(), "from").String())
+
+	// the loaded model was not evicted
+	assert.Equal(t, StateReady, proxy.findProcessByModelName("loaded").CurrentState())
+	assert.Equal(t, StateStopped, proxy.findProcessByModelName("large").CurrentState())
+
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.NotEmpty(t, metrics)
+	assert.Equal(t, "large", metrics[len(metrics)-1].Model)
+	assert.Equal(t, "would evict loaded", metrics[len(metrics)-1].PeerOffload)
+}
+
+func TestProxyManager_PeerOffloadDecision(t *testing.T) {
+	running := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"running":[{"model":"small"}]}`))
+	}
+
+	t.Run("fits without evictions", func(t *testing.T) {
+		proxy := newPeerOffloadTestProxy(t, "enabled: true", running)
+		_, _, offload := proxy.peerOffload("small", "small")
+		assert.False(t, offload)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		proxy := newPeerOffloadTestProxy(t, "enabled: false", running)
+		_, _, offload := proxy.peerOffload("large", "large")
+		assert.False(t, offload)
+	})
+
+	t.Run("running locally", func(t *testing.T) {
+		proxy := newPeerOffloadTestProxy(t, "enabled: true", running)
+		_, _, offload := proxy.peerOffload("loaded", "loaded")
+		assert.False(t, offload)
+	})
+
+	t.Run("requires a loaded peer model", func(t *testing.T) {
+		proxy := newPeerOffloadTestProxy(t, "enabled: true\n  requireLoaded: true", running)
+		_, _, offload := proxy.peerOffload("large", "large")
+		assert.False(t, offload, "large is not loaded on the peer")
+	})
+
+	t.Run("requests share one GPU query", func(t *testing.T) {
+		proxy := newPeerOffloadTestProxy(t, "enabled: true", running)
+		allocator := proxy.scheduler.allocator.(*fakeGPUAllocator)
+		calls := allocator.calls
+		for range 5 {
+			_, _, offload := proxy.peerOffload("large", "large")
+			require.True(t, offload)
+		}
+		assert.Equal(t, calls+1, allocator.calls)
+	})
+
+	t.Run("does not fit", func(t *testing.T) {
+		proxy := newPeerOffloadTestProxy(t, "enabled: true", running)
+		proxy.findProcessByModelName("loaded").inFlightRequestsCount.Add(1)
+		defer proxy.findProcessByModelName("loaded").inFlightRequestsCount.Add(-1)
+
+		peerModel, reason, offload := proxy.peerOffload("large", "large")
+		assert.True(t, offload)
+		assert.Equal(t, "large", peerModel)
+		assert.Equal(t, "does not fit", reason)
+	})
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/tidwall/gjson"
+)
+
+// how long the models loaded on a peer are cached for the loaded selection
+const peerRunningCacheTTL = 2 * time.Second
+
+// peerModelRoute is the list of peers that serve a model
+type peerModelRoute struct {
+	members []*peerProxyMember
+
+	// the next member for round-robin selection
+	next atomic.Uint64
+}
+
+// peerFailover is set in the context of a request that can be sent to another
+// peer. The error handler of the reverse proxy records connection errors in
+// it instead of writing an error response.
+type peerFailover struct {
+	err error
+}
+
+type peerFailoverCtxKey struct{}
+
+// SetSelection sets how a peer is picked when several peers serve a model,
+// one of the config.PeerSelection values
+func (p *PeerProxy) SetSelection(selection string) {
+	if selection == "" {
+		selection = config.PeerSelectionFirst
+	}
+	p.selection = selection
+}
+
+// selectPeers returns the peers that serve a model in the order they should
+// be tried
+func (p *PeerProxy) selectPeers(modelID string) []*peerProxyMember {
+	p.mu.RLock()
+	route, found := p.proxyMap[modelID]
+	p.mu.RUnlock()
+	if !found {
+		return nil
+	}
+
+	members := slices.Clone(route.members)
+	if len(members) < 2 {
+		return members
+	}
+
+	switch p.selection {
+	case config.PeerSelectionRoundRobin:
+		start := int((route.next.Add(1) - 1) % uint64(len(members)))
+		members = append(members[start:], members[:start]...)
+	case config.PeerSelectionLeastInFlight:
+		slices.SortStableFunc(members, func(a, b *peerProxyMember) int {
+			return int(a.inFlight.Load() - b.inFlight.Load())
+		})
+	case config.PeerSelectionLoaded:
+		loaded := make(map[*peerProxyMember]bool, len(members))
+		for _, pp := range members {
+			loaded[pp] = slices.Contains(p.loadedModels(pp), modelID)
+		}
+		// loaded peers first, then the least busy
+		slices.SortStableFunc(members, func(a, b *peerProxyMember) int {
+			if loaded[a] != loaded[b] {
+				if loaded[a] {
+					return -1
+				}
+				return 1
+			}
+			return int(a.inFlight.Load() - b.inFlight.Load())
+		})
+	}
+	return members
+}
+
+// IsModelLoaded is true when a peer that serves the model has it loaded
+func (p *PeerProxy) IsModelLoaded(modelID string) bool {
+	p.mu.RLock()
+	route, found := p.proxyMap[modelID]
+	p.mu.RUnlock()
+	if !found {
+		return false
+	}
+	for _, pp := range route.members {
+		if slices.Contains(p.loadedModels(pp), modelID) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadedModels returns the models loaded on a peer according to its /running.
+// Peers that do not have /running, like hosted APIs, have none.
+func (p *PeerProxy) loadedModels(pp *peerProxyMember) []string {
+	pp.runningMutex.Lock()
+	defer pp.runningMutex.Unlock()
+	if time.Since(pp.runningAt) < peerRunningCacheTTL {
+		return pp.running
+	}
+
+	pp.running = nil
+	pp.runningAt = time.Now()
+	body, err := p.fetchPeerJSON(context.Background(), pp.peerID, "running")
+	if err != nil {
+		p.proxyLogger.Debugf("peer %s: could not get running models: %v", pp.peerID, err)
+		return nil
+	}
+	for _, model := range gjson.GetBytes(body, "running.#.model").Array() {
+		pp.running = append(pp.running, model.String())
+	}
+	return pp.running
+}
+
+// bufferRequestBody makes the body of r readable again with GetBody. Bodies of
+// unknown length, multipart forms and bodies larger than maxFormFieldBytes are
+// streamed uploads and are not buffered.
+func bufferRequestBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil {
+		return true
+	}
+	if r.ContentLength < 0 || r.ContentLength > maxFormFieldBytes || isMultipartRequest(r) {
+		return false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return true
+}
+
+// isConnectError is true when the peer could not be reached, nothing was sent
+// to it yet
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPeer returns a peer served by handler
+func newTestPeer(t *testing.T, handler http.HandlerFunc, models ...string) config.PeerConfig {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	proxyURL, _ := url.Parse(server.URL)
+	return config.PeerConfig{Proxy: server.URL, ProxyURL: proxyURL, Models: models}
+}
+
+// newUnreachablePeer returns a peer that refuses connections
+func newUnreachablePeer(t *testing.T, models ...string) config.PeerConfig {
+	t.Helper()
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	proxyURL, _ := url.Parse(server.URL)
+	return config.PeerConfig{Proxy: server.URL, ProxyURL: proxyURL, Models: models}
+}
+
+func peerIDs(members []*peerProxyMember) []string {
+	ids := make([]string, len(members))
+	for i, pp := range members {
+		ids[i] = pp.peerID
+	}
+	return ids
+}
+
+func TestPeerProxy_SelectPeers(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	peers := config.PeerDictionaryConfig{
+		"peer1": newTestPeer(t, ok, "llama-70b", "only-peer1"),
+		"peer2": newTestPeer(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/running" {
+				w.Write([]byte(`{"running":[{"model":"llama-70b","state":"ready"}]}`))
+			}
+		}, "llama-70b"),
+		"peer3": newTestPeer(t, ok, "llama-70b"),
+	}
+	pm, err := NewPeerProxy(peers, testLogger)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"peer1", "peer2", "peer3"}, peerIDs(pm.selectPeers("llama-70b")))
+	assert.Equal(t, []string{"peer1"}, peerIDs(pm.selectPeers("only-peer1")))
+
+	pm.SetSelection(config.PeerSelectionRoundRobin)
+	assert.Equal(t, []string{"peer1", "peer2", "peer3"}, peerIDs(pm.selectPeers("llama-70b")))
+	assert.Equal(t, []string{"peer2", "peer3", "peer1"}, peerIDs(pm.selectPeers("llama-70b")))
+	assert.Equal(t, []string{"peer3", "peer1", "peer2"}, peerIDs(pm.selectPeers("llama-70b")))
+
+	pm.SetSelection(config.PeerSelectionLeastInFlight)
+	pm.members["peer1"].inFlight.Store(2)
+	pm.members["peer3"].inFlight.Store(1)
+	assert.Equal(t, []string{"peer2", "peer3", "peer1"}, peerIDs(pm.selectPeers("llama-70b")))
+
+	pm.SetSelection(config.PeerSelectionLoaded)
+	pm.members["peer2"].inFlight.Store(5)
+	assert.Equal(t, []string{"peer2", "peer3", "peer1"}, peerIDs(pm.selectPeers("llama-70b")),
+		"loaded peers first, then the least busy")
+}
+
+func TestPeerProxy_Failover(t *testing.T) {
+	var received string
+	peer2 := newTestPeer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		assert.Empty(t, r.Header.Get("Authorization"), "the key of the first peer is not sent")
+		w.Write([]byte("from peer2"))
+	}, "llama-70b")
+	peer1 := newUnreachablePeer(t, "llama-70b")
+	peer1.ApiKey = "peer1-key"
+
+	pm, err := NewPeerProxy(config.PeerDictionaryConfig{"peer1": peer1, "peer2": peer2}, testLogger)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"llama-70b"}`))
+	w := httptest.NewRecorder()
+	require.NoError(t, pm.ProxyRequest("llama-70b", w, req))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "from peer2", w.Body.String())
+	assert.Equal(t, `{"model":"llama-70b"}`, received)
+	assert.Zero(t, pm.members["peer1"].inFlight.Load())
+
+	// streamed uploads are only sent to one peer
+	req = httptest.NewRequest("POST", "/v1/audio/transcriptions", io.NopCloser(strings.NewReader("form")))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	require.NoError(t, pm.ProxyRequest("llama-70b", w, req))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	// so are forms and large bodies, they are not buffered
+	form, contentType := newTestForm(t, "model", "llama-70b", "@file", "audio")
+	req = httptest.NewRequest("POST", "/v1/audio/transcriptions", io.NopCloser(form))
+	req.Header.Set("Content-Type", contentType)
+	req.ContentLength = int64(form.Len())
+	w = httptest.NewRecorder()
+	require.NoError(t, pm.ProxyRequest("llama-70b", w, req))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	large := strings.Repeat("x", maxFormFieldBytes+1)
+	req = httptest.NewRequest("POST", "/v1/chat/completions", io.NopCloser(strings.NewReader(large)))
+	req.ContentLength = int64(len(large))
+	w = httptest.NewRecorder()
+	require.NoError(t, pm.ProxyRequest("llama-70b", w, req))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func TestProxyManager_PeerSelectionConfig(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+peerSelection: round-robin
+peers:
+  peer1:
+    proxy: http://peer1:8080
+    models: [llama-70b]
+`))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	assert.Equal(t, config.PeerSelectionRoundRobin, proxy.peerProxy.selection)
+
+	_, err = config.LoadConfigFromReader(strings.NewReader("peerSelection: random\n"))
+	assert.ErrorContains(t, err, "peerSelection must be one of")
+}
@@ -1,59 +1,89 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/tidwall/gjson"
 )
 
 type peerProxyMember struct {
 	peerID       string
 	reverseProxy *httputil.ReverseProxy
+	client       *http.Client
 	apiKey       string
+	timeouts     upstreamTimeouts
+
+	inFlight atomic.Int32
+
+	// models loaded on the peer from its /running, see peer_selection.go
+	runningMutex sync.Mutex
+	running      []string
+	runningAt    time.Time
 }
 
+// peerHealth is the result of the health checks of a peer
+type peerHealth struct {
+	checked    bool
+	down       bool
+	failures   int
+	discovered []string
+}
+
+// peer states reported by PeerStatus
+const (
+	PeerStatusAvailable = "available"
+	PeerStatusDown      = "down"
+)
+
 type PeerProxy struct {
-	peers    config.PeerDictionaryConfig
-	proxyMap map[string]*peerProxyMember
+	peers       config.PeerDictionaryConfig
+	peerIDs     []string
+	members     map[string]*peerProxyMember
+	proxyLogger *LogMonitor
+
+	// one of the config.PeerSelection values
+	selection string
+
+	// guards health and proxyMap, which change with health checks
+	mu       sync.RWMutex
+	health   map[string]*peerHealth
+	proxyMap map[string]*peerModelRoute
 }
 
 func NewPeerProxy(peers config.PeerDictionaryConfig, proxyLogger *LogMonitor) (*PeerProxy, error) {
-	proxyMap := make(map[string]*peerProxyMember)
+	p := &PeerProxy{
+		peers:       peers,
+		members:     make(map[string]*peerProxyMember),
+		proxyLogger: proxyLogger,
+		selection:   config.PeerSelectionFirst,
+		health:      make(map[string]*peerHealth),
+	}
 
 	// Sort peer IDs for consistent iteration order
-	peerIDs := make([]string, 0, len(peers))
 	for peerID := range peers {
-		peerIDs = append(peerIDs, peerID)
-	}
-	sort.Strings(peerIDs)
-
-	// Create a shared transport with reasonable timeouts for peer connections
-	// these can be tuned with feedback later
-	peerTransport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second, // Connection timeout
-			KeepAlive: 30 * time.Second,
-		}).DialContext,
-		TLSHandshakeTimeout:   10 * time.Second,
-		ResponseHeaderTimeout: 60 * time.Second, // Time to wait for response headers
-		ExpectContinueTimeout: 1 * time.Second,
-		MaxIdleConns:          100,
-		MaxIdleConnsPerHost:   10,
-		IdleConnTimeout:       90 * time.Second,
-	}
-
-	for _, peerID := range peerIDs {
+		p.peerIDs = append(p.peerIDs, peerID)
+	}
+	sort.Strings(p.peerIDs)
+
+	for _, peerID := range p.peerIDs {
 		peer := peers[peerID]
+		transport := newUpstreamTransport(peer.Timeouts)
+
 		// Create reverse proxy for this peer
 		reverseProxy := httputil.NewSingleHostReverseProxy(peer.ProxyURL)
-		reverseProxy.Transport = peerTransport
+		reverseProxy.Transport = transport
 
 		// Wrap Director to set Host header for remote hosts (not localhost)
 		originalDirector := reverseProxy.Director
@@ -71,7 +101,16 @@ func NewPeerProxy(peers config.PeerDictionaryConfig, proxyLogger *LogMonitor) (*
 		}
 
 		reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			// nothing was written yet, leave the response to the next peer
+			if failover, ok := r.Context().Value(peerFailoverCtxKey{}).(*peerFailover); ok && isConnectError(err) {
+				failover.err = err
+				return
+			}
 			proxyLogger.Warnf("peer %s: proxy error: %v", peerID, err)
+			if timeout := upstreamTimeoutCause(r, err); timeout != nil {
+				writeUpstreamTimeout(w, r, timeout)
+				return
+			}
 			errMsg := fmt.Sprintf("peer proxy error: %v", err)
 			if runtime.GOOS == "darwin" && strings.Contains(err.Error(), "connect: no route to host") {
 				errMsg += " (hint: on macOS, check System Settings > Privacy & Security > Local Network permissions)"
@@ -79,63 +118,284 @@ func NewPeerProxy(peers config.PeerDictionaryConfig, proxyLogger *LogMonitor) (*
 			http.Error(w, errMsg, http.StatusBadGateway)
 		}
 
-		pp := &peerProxyMember{
+		p.members[peerID] = &peerProxyMember{
 			peerID:       peerID,
 			reverseProxy: reverseProxy,
+			client:       &http.Client{Transport: transport},
 			apiKey:       peer.ApiKey,
+			timeouts:     newUpstreamTimeouts(peer.Timeouts),
 		}
+		p.health[peerID] = &peerHealth{}
+	}
 
-		// Map each model to this peer's proxy
-		for _, modelID := range peer.Models {
-			if _, found := proxyMap[modelID]; found {
-				proxyLogger.Warnf("peer %s: model %s already mapped to another peer, skipping", peerID, modelID)
-				continue
+	p.updateProxyMap()
+	return p, nil
+}
+
+// updateProxyMap maps each model to the peers that have it and are not down,
+// in order of their ID. It must be called with p.mu held.
+func (p *PeerProxy) updateProxyMap() {
+	proxyMap := make(map[string]*peerModelRoute)
+	for _, peerID := range p.peerIDs {
+		if p.health[peerID].down {
+			continue
+		}
+		for _, modelID := range p.peerModels(peerID) {
+			route, found := proxyMap[modelID]
+			if !found {
+				route = &peerModelRoute{}
+				proxyMap[modelID] = route
 			}
-			proxyMap[modelID] = pp
+			route.members = append(route.members, p.members[peerID])
 		}
 	}
+	p.proxyMap = proxyMap
+}
 
-	return &PeerProxy{
-		peers:    peers,
-		proxyMap: proxyMap,
-	}, nil
+// peerModels returns the configured and the discovered models of a peer. It
+// must be called with p.mu held.
+func (p *PeerProxy) peerModels(peerID string) []string {
+	models := slices.Clone(p.peers[peerID].Models)
+	for _, modelID := range p.health[peerID].discovered {
+		if !slices.Contains(models, modelID) {
+			models = append(models, modelID)
+		}
+	}
+	return models
 }
 
 func (p *PeerProxy) HasPeerModel(modelID string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	_, found := p.proxyMap[modelID]
 	return found
 }
 
-// GetPeerFilters returns the filters for a peer model, or empty filters if not found
+// GetPeerFilters returns the filters for a peer model, or empty filters if not
+// found. When several peers serve the model the filters of the first peer by
+// ID are used.
 func (p *PeerProxy) GetPeerFilters(modelID string) config.Filters {
-	pp, found := p.proxyMap[modelID]
+	p.mu.RLock()
+	route, found := p.proxyMap[modelID]
+	p.mu.RUnlock()
 	if !found {
 		return config.Filters{}
 	}
 	// Get the peer config using the peerID
-	peer, found := p.peers[pp.peerID]
+	peer, found := p.peers[route.members[0].peerID]
 	if !found {
 		return config.Filters{}
 	}
 	return peer.Filters
 }
 
+// ListPeers returns the peers with their configured and discovered models.
+// Peers that are down are included, see PeerStatus.
 func (p *PeerProxy) ListPeers() config.PeerDictionaryConfig {
-	return p.peers
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	peers := make(config.PeerDictionaryConfig, len(p.peers))
+	for peerID, peer := range p.peers {
+		peer.Models = p.peerModels(peerID)
+		peers[peerID] = peer
+	}
+	return peers
+}
+
+// PeerStatus returns PeerStatusAvailable or PeerStatusDown, or an empty
+// string when the peer is not health checked or was not checked yet
+func (p *PeerProxy) PeerStatus(peerID string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	health, found := p.health[peerID]
+	switch {
+	case !found || !health.checked:
+		return ""
+	case health.down:
+		return PeerStatusDown
+	default:
+		return PeerStatusAvailable
+	}
 }
 
+// ProxyRequest sends the request to a peer that serves the model. When a peer
+// can not be reached the request is sent to the next one.
 func (p *PeerProxy) ProxyRequest(model_id string, writer http.ResponseWriter, request *http.Request) error {
-	pp, found := p.proxyMap[model_id]
-	if !found {
+	candidates := p.selectPeers(model_id)
+	if len(candidates) == 0 {
 		return fmt.Errorf("no peer proxy found for model %s", model_id)
 	}
 
-	// Inject API key if configured for this peer
-	if pp.apiKey != "" {
-		request.Header.Set("Authorization", "Bearer "+pp.apiKey)
-		request.Header.Set("x-api-key", pp.apiKey)
+	// the body is sent again to the next peer, streamed uploads can not be
+	if len(candidates) > 1 && !bufferRequestBody(request) {
+		candidates = candidates[:1]
 	}
 
-	pp.reverseProxy.ServeHTTP(writer, request)
+	for i, pp := range candidates {
+		attempt := request.Clone(request.Context())
+		if i > 0 && request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return err
+			}
+			attempt.Body = body
+		}
+
+		var failover *peerFailover
+		if i < len(candidates)-1 {
+			failover = &peerFailover{}
+			attempt = attempt.WithContext(context.WithValue(attempt.Context(), peerFailoverCtxKey{}, failover))
+		}
+
+		// Inject API key if configured for this peer
+		if pp.apiKey != "" {
+			attempt.Header.Set("Authorization", "Bearer "+pp.apiKey)
+			attempt.Header.Set("x-api-key", pp.apiKey)
+		}
+
+		pp.inFlight.Add(1)
+		err := pp.timeouts.serve(pp.reverseProxy, writer, attempt)
+		pp.inFlight.Add(-1)
+		if err != nil {
+			p.proxyLogger.Warnf("peer %s: %v", pp.peerID, err)
+		}
+		if failover == nil || failover.err == nil {
+			return nil
+		}
+		p.proxyLogger.Warnf("peer %s: %v, trying peer %s", pp.peerID, failover.err, candidates[i+1].peerID)
+	}
 	return nil
 }
+
+// StartHealthChecks polls the peers with health checks enabled until ctx is
+// done
+func (p *PeerProxy) StartHealthChecks(ctx context.Context) {
+	for _, peerID := range p.peerIDs {
+		if p.peers[peerID].HealthCheck.Interval > 0 {
+			go p.healthCheckLoop(ctx, peerID)
+		}
+	}
+}
+
+func (p *PeerProxy) healthCheckLoop(ctx context.Context, peerID string) {
+	for {
+		timer := time.NewTimer(p.checkPeer(ctx, peerID))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// checkPeer fetches the models of a peer, updates its health and returns how
+// long to wait until the next check. Checks of a peer that is down back off
+// exponentially up to healthCheck.maxBackoff.
+func (p *PeerProxy) checkPeer(ctx context.Context, peerID string) time.Duration {
+	peer := p.peers[peerID]
+	models, err := p.fetchPeerModels(ctx, peerID)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	health := p.health[peerID]
+	health.checked = true
+	wasDown := health.down
+	changed := false
+
+	if err != nil {
+		health.failures++
+		health.down = true
+		if !wasDown {
+			p.proxyLogger.Warnf("peer %s: marked down: %v", peerID, err)
+			changed = true
+		}
+	} else {
+		health.failures = 0
+		health.down = false
+		if wasDown {
+			p.proxyLogger.Infof("peer %s: available again", peerID)
+			changed = true
+		}
+		if peer.Discover.Enabled {
+			discovered := make([]string, 0, len(models))
+			for _, modelID := range models {
+				if peer.Discover.Matches(modelID) {
+					discovered = append(discovered, modelID)
+				}
+			}
+			sort.Strings(discovered)
+			if !slices.Equal(discovered, health.discovered) {
+				p.proxyLogger.Infof("peer %s: discovered %d models", peerID, len(discovered))
+				health.discovered = discovered
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		p.updateProxyMap()
+	}
+
+	interval := time.Duration(peer.HealthCheck.Interval) * time.Second
+	maxBackoff := max(time.Duration(peer.HealthCheck.MaxBackoff)*time.Second, interval)
+	delay := interval
+	for i := 1; i < health.failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// fetchPeerModels returns the ids of the models in the peer's /v1/models
+func (p *PeerProxy) fetchPeerModels(ctx context.Context, peerID string) ([]string, error) {
+	body, err := p.fetchPeerJSON(ctx, peerID, "v1/models")
+	if err != nil {
+		return nil, err
+	}
+	models := []string{}
+	for _, id := range gjson.GetBytes(body, "data.#.id").Array() {
+		if id.String() != "" {
+			models = append(models, id.String())
+		}
+	}
+	return models, nil
+}
+
+// fetchPeerJSON gets a JSON document from the peer within the health check
+// timeout
+func (p *PeerProxy) fetchPeerJSON(ctx context.Context, peerID string, path string) ([]byte, error) {
+	peer := p.peers[peerID]
+	timeout := time.Duration(peer.HealthCheck.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer.ProxyURL.JoinPath(path).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if peer.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+peer.ApiKey)
+		req.Header.Set("x-api-key", peer.ApiKey)
+	}
+
+	resp, err := p.members[peerID].client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("invalid /%s response", path)
+	}
+	return body, nil
+}
@@ -1,11 +1,14 @@
 package proxy
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mostlygeek/llama-swap/proxy/config"
 	"github.com/stretchr/testify/assert"
@@ -64,9 +67,9 @@ func TestNewPeerProxy_MultiplePeers(t *testing.T) {
 	assert.True(t, pm.HasPeerModel("model-d"))
 }
 
-func TestNewPeerProxy_DuplicateModelWarning(t *testing.T) {
-	// When the same model is in multiple peers, only the first (lexicographically by peer ID)
-	// should be mapped, and a warning should be logged
+func TestNewPeerProxy_DuplicateModel(t *testing.T) {
+	// When the same model is in multiple peers it is mapped to all of them,
+	// in order of their peer ID
 	proxyURL1, _ := url.Parse("http://peer1.example.com:8080")
 	proxyURL2, _ := url.Parse("http://peer2.example.com:8080")
 	peers := config.PeerDictionaryConfig{
@@ -87,6 +90,7 @@ func TestNewPeerProxy_DuplicateModelWarning(t *testing.T) {
 	// Should only have one entry for the duplicate model
 	assert.Len(t, pm.proxyMap, 1)
 	assert.True(t, pm.HasPeerModel("duplicate-model"))
+	assert.Equal(t, []string{"alpha-peer", "beta-peer"}, peerIDs(pm.proxyMap["duplicate-model"].members))
 }
 
 func TestHasPeerModel(t *testing.T) {
@@ -266,3 +270,99 @@ func TestProxyRequest_SSEHeaderModification(t *testing.T) {
 	// The X-Accel-Buffering header should be set to "no" for SSE
 	assert.Equal(t, "no", w.Header().Get("X-Accel-Buffering"))
 }
+
+func TestPeerProxy_Discovery(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/models", r.URL.Path)
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"object":"list","data":[{"id":"qwen-8b"},{"id":"qwen-embed"},{"id":"llama-3"}]}`))
+	}))
+	defer testServer.Close()
+
+	proxyURL, _ := url.Parse(testServer.URL + "/api")
+	peers := config.PeerDictionaryConfig{
+		"peer1": config.PeerConfig{
+			Proxy:    testServer.URL + "/api",
+			ProxyURL: proxyURL,
+			ApiKey:   "test-key",
+			Models:   []string{"static-model"},
+			Discover: config.PeerDiscoverConfig{
+				Enabled: true,
+				Include: []string{"^qwen"},
+				Exclude: []string{"embed"},
+			},
+			HealthCheck: config.PeerHealthCheckConfig{Interval: 60},
+		},
+	}
+
+	pm, err := NewPeerProxy(peers, testLogger)
+	require.NoError(t, err)
+	assert.True(t, pm.HasPeerModel("static-model"))
+	assert.False(t, pm.HasPeerModel("qwen-8b"))
+	assert.Empty(t, pm.PeerStatus("peer1"), "not checked yet")
+
+	assert.Equal(t, time.Minute, pm.checkPeer(context.Background(), "peer1"))
+	assert.Equal(t, PeerStatusAvailable, pm.PeerStatus("peer1"))
+	assert.True(t, pm.HasPeerModel("qwen-8b"))
+	assert.False(t, pm.HasPeerModel("qwen-embed"))
+	assert.False(t, pm.HasPeerModel("llama-3"))
+	assert.Equal(t, []string{"static-model", "qwen-8b"}, pm.ListPeers()["peer1"].Models)
+}
+
+func TestPeerProxy_HealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	peer1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer peer1.Close()
+	peer2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("from peer2"))
+	}))
+	defer peer2.Close()
+
+	proxyURL1, _ := url.Parse(peer1.URL)
+	proxyURL2, _ := url.Parse(peer2.URL)
+	peers := config.PeerDictionaryConfig{
+		"peer1": config.PeerConfig{
+			Proxy:       peer1.URL,
+			ProxyURL:    proxyURL1,
+			Models:      []string{"shared", "only-peer1"},
+			HealthCheck: config.PeerHealthCheckConfig{Interval: 10, MaxBackoff: 30},
+		},
+		"peer2": config.PeerConfig{
+			Proxy:    peer2.URL,
+			ProxyURL: proxyURL2,
+			Models:   []string{"shared"},
+		},
+	}
+
+	pm, err := NewPeerProxy(peers, testLogger)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	assert.Equal(t, 10*time.Second, pm.checkPeer(ctx, "peer1"))
+	assert.Equal(t, PeerStatusAvailable, pm.PeerStatus("peer1"))
+
+	healthy.Store(false)
+	assert.Equal(t, 10*time.Second, pm.checkPeer(ctx, "peer1"))
+	assert.Equal(t, 20*time.Second, pm.checkPeer(ctx, "peer1"))
+	assert.Equal(t, 30*time.Second, pm.checkPeer(ctx, "peer1"))
+	assert.Equal(t, 30*time.Second, pm.checkPeer(ctx, "peer1"))
+	assert.Equal(t, PeerStatusDown, pm.PeerStatus("peer1"))
+
+	// models of a down peer are listed but not routed
+	assert.False(t, pm.HasPeerModel("only-peer1"))
+	assert.Contains(t, pm.ListPeers()["peer1"].Models, "only-peer1")
+	w := httptest.NewRecorder()
+	require.NoError(t, pm.ProxyRequest("shared", w, httptest.NewRequest("POST", "/v1/chat/completions", nil)))
+	assert.Equal(t, "from peer2", w.Body.String())
+
+	healthy.Store(true)
+	assert.Equal(t, 10*time.Second, pm.checkPeer(ctx, "peer1"))
+	assert.True(t, pm.HasPeerModel("only-peer1"))
+}
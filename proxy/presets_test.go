@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestProxyManager_Presets(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  qwen:
+    cmd: %s --port ${PORT} --silent --respond qwen
+    unlisted: true
+    filters:
+      setParams:
+        top_p: 0.9
+    presets:
+      qwen-creative:
+        name: Qwen (creative)
+        description: high temperature for writing
+        metadata:
+          style: creative
+        filters:
+          setParams:
+            temperature: 1.2
+      qwen-precise:
+        filters:
+          stripParams: "top_k"
+          setParams:
+            temperature: 0.1
+            thinking_budget_tokens: 0
+      qwen-hidden:
+        unlisted: true
+`, simpleResponderPath)))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	chat := func(model string) gjson.Result {
+		t.Helper()
+		body := fmt.Sprintf(`{"model":%q,"top_k":20,"messages":[{"role":"user","content":"hi"}]}`, model)
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "qwen", w.Header().Get("X-Llama-Swap-Model"))
+		return gjson.Parse(gjson.Get(w.Body.String(), "request_body").String())
+	}
+
+	creative := chat("qwen-creative")
+	assert.Equal(t, 1.2, creative.Get("temperature").Float())
+	assert.Equal(t, 0.9, creative.Get("top_p").Float(), "the model's filters apply too")
+	assert.Equal(t, int64(20), creative.Get("top_k").Int())
+
+	precise := chat("qwen-precise")
+	assert.Equal(t, 0.1, precise.Get("temperature").Float())
+	assert.True(t, precise.Get("thinking_budget_tokens").Exists())
+	assert.False(t, precise.Get("top_k").Exists())
+
+	base := chat("qwen")
+	assert.False(t, base.Get("temperature").Exists())
+
+	t.Run("one process", func(t *testing.T) {
+		assert.Len(t, proxy.snapshot.Load().processGroups["qwen"].processes, 1)
+	})
+
+	t.Run("metrics are recorded under the preset", func(t *testing.T) {
+		var models []string
+		for _, metric := range proxy.metricsMonitor.getMetrics() {
+			models = append(models, metric.Model)
+		}
+		assert.Equal(t, []string{"qwen-creative", "qwen-precise", "qwen"}, models)
+	})
+
+	t.Run("presets are listed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/models", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		ids := gjson.Get(w.Body.String(), "data.#.id").Array()
+		assert.Len(t, ids, 2)
+		creative := gjson.Get(w.Body.String(), `data.#(id=="qwen-creative")`)
+		assert.Equal(t, "Qwen (creative)", creative.Get("name").String())
+		assert.Equal(t, "high temperature for writing", creative.Get("description").String())
+		assert.Equal(t, "creative", creative.Get("meta.llamaswap.style").String())
+		assert.True(t, gjson.Get(w.Body.String(), `data.#(id=="qwen-precise")`).Exists())
+	})
+}
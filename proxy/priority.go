@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Requests carry a priority, higher numbers are more important. A request
+// can take the memory or the concurrency slots of a process that only serves
+// lower priority requests: those requests get a drain timeout to finish and
+// are then aborted. Requests waiting for a process to start gain priority
+// over time so they are not starved by a steady stream of important work.
+
+// errPreempted is the cancel cause of requests aborted for a higher priority request
+var errPreempted = errors.New("request preempted by a higher priority request")
+
+// how often a preempting request checks if the preempted requests are done
+var preemptPollInterval = 25 * time.Millisecond
+
+// priorityRequest is an in-flight request of a process
+type priorityRequest struct {
+	priority int
+	queuedAt time.Time
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+
+	// set once the process is ready and the request was sent upstream
+	started atomic.Bool
+
+	// set when the request is aborted, see proxyCtxKey("preempted")
+	preempted *atomic.Bool
+}
+
+// abort cancels the request so it frees its slot in the process
+func (pr *priorityRequest) abort() {
+	if pr.preempted != nil {
+		pr.preempted.Store(true)
+	}
+	pr.cancel(errPreempted)
+}
+
+// requestPriority returns the priority set by the proxy manager or the
+// priority of the model.
+func requestPriority(r *http.Request, modelPriority int) int {
+	if priority, ok := r.Context().Value(proxyCtxKey("priority")).(int); ok {
+		return priority
+	}
+	return modelPriority
+}
+
+// requestPriority returns the priority of a request from the priority header,
+// then the API key it was made with. False means the model's priority is used.
+func (pm *ProxyManager) requestPriority(r *http.Request) (int, bool) {
+	priorityConfig := pm.snapshot.Load().config.Priority
+	if isBatchRequest(r) {
+		return priorityConfig.Batch, true
+	}
+	if header := priorityConfig.Header; header != "" {
+		if value := strings.TrimSpace(r.Header.Get(header)); value != "" {
+			if priority, err := strconv.Atoi(value); err == nil {
+				return priority, true
+			}
+		}
+	}
+	if apiKey, ok := r.Context().Value(proxyCtxKey("apiKey")).(string); ok {
+		if priority, found := priorityConfig.APIKeys[apiKey]; found {
+			return priority, true
+		}
+	}
+	return 0, false
+}
+
+// trackRequest registers an in-flight request and returns it with a context
+// that is cancelled when the request is preempted.
+func (p *Process) trackRequest(r *http.Request, priority int) (*http.Request, *priorityRequest) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	pr := &priorityRequest{
+		priority: priority,
+		queuedAt: time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	pr.preempted, _ = r.Context().Value(proxyCtxKey("preempted")).(*atomic.Bool)
+
+	p.priorityMutex.Lock()
+	p.priorityRequests[pr] = struct{}{}
+	p.priorityMutex.Unlock()
+	return r.WithContext(ctx), pr
+}
+
+func (p *Process) untrackRequest(pr *priorityRequest) {
+	p.priorityMutex.Lock()
+	delete(p.priorityRequests, pr)
+	p.priorityMutex.Unlock()
+	pr.cancel(nil)
+}
+
+// WaitingPriority returns the highest priority of the requests waiting for the
+// process to start. A waiting request gains one level every agingInterval.
+// False means no request is waiting.
+func (p *Process) WaitingPriority(agingInterval time.Duration) (int, bool) {
+	p.priorityMutex.Lock()
+	defer p.priorityMutex.Unlock()
+
+	highest, found := 0, false
+	for pr := range p.priorityRequests {
+		if pr.started.Load() || pr.ctx.Err() != nil {
+			continue
+		}
+		priority := pr.priority
+		if agingInterval > 0 {
+			priority += int(time.Since(pr.queuedAt) / agingInterval)
+		}
+		if !found || priority > highest {
+			highest, found = priority, true
+		}
+	}
+	return highest, found
+}
+
+// BusyPriority returns the highest priority of the in-flight requests, false
+// means the process is idle.
+func (p *Process) BusyPriority() (int, bool) {
+	p.priorityMutex.Lock()
+	defer p.priorityMutex.Unlock()
+
+	highest, found := 0, false
+	for pr := range p.priorityRequests {
+		if !found || pr.priority > highest {
+			highest, found = pr.priority, true
+		}
+	}
+	return highest, found
+}
+
+// lowestPriorityRequest returns the in-flight request with the lowest
+// priority below priority.
+func (p *Process) lowestPriorityRequest(priority int) *priorityRequest {
+	p.priorityMutex.Lock()
+	defer p.priorityMutex.Unlock()
+
+	var lowest *priorityRequest
+	for pr := range p.priorityRequests {
+		if pr.priority >= priority || pr.ctx.Err() != nil {
+			continue
+		}
+		if lowest == nil || pr.priority < lowest.priority || (pr.priority == lowest.priority && pr.queuedAt.After(lowest.queuedAt)) {
+			lowest = pr
+		}
+	}
+	return lowest
+}
+
+// acquireSlot takes a concurrency slot. When the process is full a request
+// with a higher priority than an in-flight request waits up to the drain
+// timeout for a free slot before it aborts that request.
+func (p *Process) acquireSlot(ctx context.Context, priority int) bool {
+	select {
+	case p.concurrencyLimitSemaphore <- struct{}{}:
+		return true
+	default:
+	}
+
+	victim := p.lowestPriorityRequest(priority)
+	if victim == nil {
+		return false
+	}
+
+	drain := time.NewTimer(p.preemptDrainTimeout)
+	defer drain.Stop()
+	select {
+	case p.concurrencyLimitSemaphore <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-drain.C:
+	}
+
+	p.proxyLogger.Infof("<%s> aborting a request with priority %d for a request with priority %d", p.ID, victim.priority, priority)
+	victim.abort()
+	select {
+	case p.concurrencyLimitSemaphore <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Preempt stops a busy process for a higher priority request. New requests
+// are rejected while the in-flight requests get drainTimeout to finish, the
+// ones that are left are aborted.
+func (p *Process) Preempt(drainTimeout time.Duration) {
+	p.draining.Store(true)
+	defer p.draining.Store(false)
+
+	p.proxyLogger.Infof("<%s> preempting process, draining %d in-flight requests", p.ID, p.InFlightRequestsCount())
+	if !p.waitForIdle(drainTimeout) {
+		p.priorityMutex.Lock()
+		for pr := range p.priorityRequests {
+			pr.abort()
+		}
+		p.priorityMutex.Unlock()
+		if !p.waitForIdle(p.gracefulStopTimeout) {
+			p.proxyLogger.Warnf("<%s> aborted requests did not finish within %v, stopping process", p.ID, p.gracefulStopTimeout)
+		}
+	}
+	p.StopImmediately()
+}
+
+// waitForIdle returns true once the process has no in-flight requests, false
+// if it is still busy after timeout.
+func (p *Process) waitForIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for p.InFlightRequestsCount() > 0 {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(preemptPollInterval)
+	}
+	return true
+}
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withPriority(r *http.Request, priority int) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), proxyCtxKey("priority"), priority))
+}
+
+// busyWith registers an in-flight request on process that finishes when it is aborted
+func busyWith(process *Process, priority int) *priorityRequest {
+	process.inFlightRequestsCount.Add(1)
+	_, tracked := process.trackRequest(withPriority(httptest.NewRequest("POST", "/v1/chat/completions", nil), priority), priority)
+	tracked.started.Store(true)
+	go func() {
+		<-tracked.ctx.Done()
+		process.untrackRequest(tracked)
+		process.inFlightRequestsCount.Add(-1)
+	}()
+	return tracked
+}
+
+func TestProcess_WaitingPriorityAges(t *testing.T) {
+	process := NewProcess("aging", 1, getTestSimpleResponderConfig("aging"), testLogger, testLogger)
+
+	_, waiting := process.WaitingPriority(time.Second)
+	assert.False(t, waiting)
+
+	_, tracked := process.trackRequest(httptest.NewRequest("POST", "/v1/chat/completions", nil), -10)
+	defer process.untrackRequest(tracked)
+	tracked.queuedAt = time.Now().Add(-35 * time.Second)
+
+	priority, waiting := process.WaitingPriority(10 * time.Second)
+	require.True(t, waiting)
+	assert.Equal(t, -7, priority)
+
+	priority, _ = process.WaitingPriority(0)
+	assert.Equal(t, -10, priority, "aging is disabled")
+
+	tracked.started.Store(true)
+	_, waiting = process.WaitingPriority(10 * time.Second)
+	assert.False(t, waiting, "started requests are not waiting")
+	busy, _ := process.BusyPriority()
+	assert.Equal(t, -10, busy, "running requests do not age")
+}
+
+func TestSchedulerScheduleProcess_PreemptsLowerPriority(t *testing.T) {
+	tracker := NewMemoryTracker()
+	background := newTestProcess(t, "background", "evict_to_fit", 600, 0, tracker)
+	readyOnGPU(background, 0)
+	background.preemptDrainTimeout = 0
+	aborted := busyWith(background, -10)
+
+	allocator := &scenarioGPUAllocator{gpus: []GPUInfo{{Index: 0, TotalMB: 1000}}}
+	allocator.provider = func() []*Process { return []*Process{background} }
+	scheduler := NewScheduler(allocator, testLogger, allocator.provider, SchedulerOptions{DrainTimeout: 50 * time.Millisecond})
+
+	// requests with the same priority do not preempt
+	equal := newTestProcess(t, "equal", "evict_to_fit", 600, 0, tracker)
+	_, waiting := equal.trackRequest(httptest.NewRequest("POST", "/v1/chat/completions", nil), -10)
+	defer equal.untrackRequest(waiting)
+	require.ErrorIs(t, scheduler.ScheduleProcess(equal), ErrInsufficientVRAM)
+	assert.Equal(t, StateReady, background.CurrentState())
+
+	interactive := newTestProcess(t, "interactive", "evict_to_fit", 600, 0, tracker)
+	_, waiting = interactive.trackRequest(httptest.NewRequest("POST", "/v1/chat/completions", nil), 0)
+	defer interactive.untrackRequest(waiting)
+
+	plan := scheduler.PlanProcess(interactive)
+	require.True(t, plan.Fits)
+	assert.Equal(t, []*Process{background}, plan.Evict)
+
+	require.NoError(t, scheduler.ScheduleProcess(interactive))
+	assert.ErrorIs(t, context.Cause(aborted.ctx), errPreempted)
+	assert.Equal(t, StateStopped, background.CurrentState())
+	assert.Equal(t, 0, interactive.AssignedGPU())
+}
+
+func TestSchedulerScheduleProcess_DrainsWithoutLock(t *testing.T) {
+	tracker := NewMemoryTracker()
+	background := newTestProcess(t, "background", "evict_to_fit", 600, 0, tracker)
+	readyOnGPU(background, 0)
+	background.preemptDrainTimeout = 0
+	busyWith(background, -10)
+
+	allocator := &scenarioGPUAllocator{gpus: []GPUInfo{{Index: 0, TotalMB: 1000}}}
+	allocator.provider = func() []*Process { return []*Process{background} }
+	scheduler := NewScheduler(allocator, testLogger, allocator.provider, SchedulerOptions{DrainTimeout: 500 * time.Millisecond})
+
+	interactive := newTestProcess(t, "interactive", "evict_to_fit", 600, 0, tracker)
+	_, waiting := interactive.trackRequest(httptest.NewRequest("POST", "/v1/chat/completions", nil), 0)
+	defer interactive.untrackRequest(waiting)
+
+	scheduled := make(chan error, 1)
+	go func() { scheduled <- scheduler.ScheduleProcess(interactive) }()
+	require.Eventually(t, background.draining.Load, time.Second, 5*time.Millisecond)
+
+	// planning is not blocked while the background process drains
+	other := newTestProcess(t, "other", "evict_to_fit", 300, 0, tracker)
+	planned := time.Now()
+	scheduler.PlanProcess(other)
+	assert.Less(t, time.Since(planned), 250*time.Millisecond)
+
+	require.NoError(t, <-scheduled)
+	assert.Equal(t, StateStopped, background.CurrentState())
+	assert.Equal(t, 0, interactive.AssignedGPU())
+	swaps, evictions := scheduler.SwapCounts()
+	assert.Equal(t, int64(1), swaps)
+	assert.Equal(t, int64(1), evictions)
+}
+
+func TestSchedulerScheduleProcess_WaitsForMemory(t *testing.T) {
+	defer func(interval time.Duration) { scheduleRetryInterval = interval }(scheduleRetryInterval)
+	scheduleRetryInterval = 10 * time.Millisecond
+
+	tracker := NewMemoryTracker()
+	running := newTestProcess(t, "running", "evict_to_fit", 600, 0, tracker)
+	readyOnGPU(running, 0)
+	running.inFlightRequestsCount.Add(1)
+
+	allocator := &scenarioGPUAllocator{gpus: []GPUInfo{{Index: 0, TotalMB: 1000}}}
+	allocator.provider = func() []*Process { return []*Process{running} }
+	scheduler := NewScheduler(allocator, testLogger, allocator.provider, SchedulerOptions{MaxWait: 5 * time.Second})
+
+	candidate := newTestProcess(t, "candidate", "evict_to_fit", 600, 0, tracker)
+	_, waiting := candidate.trackRequest(httptest.NewRequest("POST", "/v1/chat/completions", nil), 0)
+	defer candidate.untrackRequest(waiting)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		running.inFlightRequestsCount.Add(-1)
+	}()
+	require.NoError(t, scheduler.ScheduleProcess(candidate))
+	assert.Equal(t, StateStopped, running.CurrentState())
+}
+
+func TestProcess_PreemptsConcurrencySlot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping long preemption test")
+	}
+
+	cfg := getTestSimpleResponderConfig("preempt_test")
+	cfg.ConcurrencyLimit = 1
+	process := NewProcess("preempt_test", 5, cfg, debugLogger, debugLogger)
+	process.preemptDrainTimeout = 50 * time.Millisecond
+	defer process.Stop()
+
+	body := `{"model": "preempt_test", "messages": []}`
+	lowDone := make(chan *httptest.ResponseRecorder)
+	preempted := &atomic.Bool{}
+	go func() {
+		req := httptest.NewRequest("POST", "/v1/chat/completions?wait=5s", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), proxyCtxKey("preempted"), preempted))
+		w := httptest.NewRecorder()
+		process.ProxyRequest(w, withPriority(req, -10))
+		lowDone <- w
+	}()
+	require.Eventually(t, func() bool {
+		_, busy := process.BusyPriority()
+		return busy && process.CurrentState() == StateReady
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the same priority is rejected like before
+	w := httptest.NewRecorder()
+	process.ProxyRequest(w, withPriority(httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)), -10))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	w = httptest.NewRecorder()
+	process.ProxyRequest(w, withPriority(httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)), 5))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	low := <-lowDone
+	assert.Equal(t, http.StatusServiceUnavailable, low.Code)
+	assert.Contains(t, low.Body.String(), errPreempted.Error())
+	assert.True(t, preempted.Load())
+}
+
+func TestProxyManager_RequestPriority(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+apiKeys: ["sk-chat", "sk-other"]
+priority:
+  header: X-Llama-Swap-Priority
+  apiKeys:
+    sk-chat: 10
+models:
+  model1:
+    cmd: server --port ${PORT}
+    priority: 3
+`))
+	require.NoError(t, err)
+	pm := &ProxyManager{}
+	pm.snapshot.Store(&proxySnapshot{config: cfg})
+
+	request := func(header, apiKey string, batch bool) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		if header != "" {
+			req.Header.Set("X-Llama-Swap-Priority", header)
+		}
+		ctx := req.Context()
+		if apiKey != "" {
+			ctx = context.WithValue(ctx, proxyCtxKey("apiKey"), apiKey)
+		}
+		if batch {
+			ctx = context.WithValue(ctx, proxyCtxKey("batch"), "batch_1")
+		}
+		return req.WithContext(ctx)
+	}
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		priority int
+		found    bool
+	}{
+		{"header", request("7", "sk-chat", false), 7, true},
+		{"api key", request("", "sk-chat", false), 10, true},
+		{"invalid header", request("high", "sk-chat", false), 10, true},
+		{"batch", request("7", "", true), -10, true},
+		{"model default", request("", "sk-other", false), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priority, found := pm.requestPriority(tt.req)
+			assert.Equal(t, tt.found, found)
+			assert.Equal(t, tt.priority, priority)
+			if !found {
+				assert.Equal(t, 3, requestPriority(tt.req, cfg.Models["model1"].Priority))
+			}
+		})
+	}
+
+	// clients can not set a priority unless the header is configured
+	cfg.Priority.Header = ""
+	pm.snapshot.Store(&proxySnapshot{config: cfg})
+	priority, found := pm.requestPriority(request("7", "sk-chat", false))
+	assert.True(t, found)
+	assert.Equal(t, 10, priority)
+}
@@ -2,10 +2,8 @@ package proxy
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -74,6 +72,30 @@ type Process struct {
 	// for managing concurrency limits
 	concurrencyLimitSemaphore chan struct{}
 
+	// in-flight requests and their priorities, see priority.go
+	priorityMutex       sync.Mutex
+	priorityRequests    map[*priorityRequest]struct{}
+	preemptDrainTimeout time.Duration
+
+	// set while the process is preempted, new requests are rejected
+	draining atomic.Bool
+
+	// requests waiting for the current start, see start_waiters.go
+	startMutex     sync.Mutex
+	startWaiters   int
+	startAbandoned bool
+	startEvictions []string
+
+	// unix nanoseconds of the last time the process became ready and how long
+	// it took to load, see swap_scheduler.go
+	readyAt      atomic.Int64
+	loadDuration atomic.Int64
+
+	// progress of the current start, see load_progress.go
+	loadingMilestones []loadingMilestone
+	loadTimeTracker   *LoadTimeTracker
+	loadProgress      atomic.Pointer[loadProgressTracker]
+
 	// used for testing to override the default value
 	gracefulStopTimeout time.Duration
 
@@ -105,6 +127,9 @@ func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, pr
 	var reverseProxy *httputil.ReverseProxy
 	if proxyURL != nil {
 		reverseProxy = httputil.NewSingleHostReverseProxy(proxyURL)
+		transport := newUpstreamTransport(config.Timeouts)
+		transport.Proxy = http.ProxyFromEnvironment
+		reverseProxy.Transport = transport
 		reverseProxy.ModifyResponse = func(resp *http.Response) error {
 			// prevent nginx from buffering streaming responses (e.g., SSE)
 			if strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream") {
@@ -112,6 +137,18 @@ func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, pr
 			}
 			return nil
 		}
+		reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(context.Cause(r.Context()), errPreempted) {
+				http.Error(w, errPreempted.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			proxyLogger.Warnf("<%s> proxy error: %v", ID, err)
+			if timeout := upstreamTimeoutCause(r, err); timeout != nil {
+				writeUpstreamTimeout(w, r, timeout)
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		}
 	}
 
 	observedFootprint := MemoryFootprint{
@@ -133,6 +170,9 @@ func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, pr
 		// concurrency limit
 		concurrencyLimitSemaphore: make(chan struct{}, concurrentLimit),
 
+		priorityRequests:    make(map[*priorityRequest]struct{}),
+		preemptDrainTimeout: 10 * time.Second,
+
 		// To be removed when migration over exec.CommandContext is complete
 		// stop timeout
 		gracefulStopTimeout: 10 * time.Second,
@@ -191,6 +231,17 @@ func (p *Process) InFlightRequestsCount() int32 {
 	return p.inFlightRequestsCount.Load()
 }
 
+// ReadySince returns when the process last became ready
+func (p *Process) ReadySince() time.Time {
+	return time.Unix(0, p.readyAt.Load())
+}
+
+// LoadDuration returns how long the last start took, from running the command
+// until the health check passed
+func (p *Process) LoadDuration() time.Duration {
+	return time.Duration(p.loadDuration.Load())
+}
+
 func (p *Process) FitPolicy() string {
 	return p.config.FitPolicy
 }
@@ -328,7 +379,7 @@ func (p *Process) forceState(newState ProcessState) {
 // start starts the upstream command, checks the health endpoint, and sets the state to Ready
 // it is a private method because starting is automatic but stopping can be called
 // at any time.
-func (p *Process) start() error {
+func (p *Process) start() (err error) {
 
 	if p.config.Proxy == "" {
 		return fmt.Errorf("can not start(), upstream proxy missing")
@@ -360,7 +411,8 @@ func (p *Process) start() error {
 
 	// waitStarting.Add(1) is now called atomically in swapState() when transitioning to StateStarting
 	defer p.waitStarting.Done()
-	cmdContext, ctxCancelUpstream := context.WithCancel(context.Background())
+	p.resetStartEvictions()
+	defer p.clearStartAbandoned()
 
 	if p.preStartHook != nil {
 		if err := p.preStartHook(p); err != nil {
@@ -372,6 +424,19 @@ func (p *Process) start() error {
 		}
 	}
 
+	// the scheduler can wait for memory, the requests may be gone by now
+	if p.isStartAbandoned() {
+		return p.abortStart(false)
+	}
+
+	cmdContext, ctxCancelUpstream := context.WithCancel(context.Background())
+
+	loadBeginTime := time.Now()
+	finishLoadProgress := p.trackLoadProgress()
+	defer func() {
+		finishLoadProgress(time.Since(loadBeginTime), err)
+	}()
+
 	p.cmd = exec.CommandContext(cmdContext, args[0], args[1:]...)
 	p.cmd.Stdout = p.processLogger
 	p.cmd.Stderr = p.processLogger
@@ -439,6 +504,10 @@ func (p *Process) start() error {
 				return errors.New("health check interrupted due to shutdown")
 			}
 
+			if p.isStartAbandoned() {
+				return p.abortStart(true)
+			}
+
 			if time.Since(checkStartTime) > maxDuration {
 				p.stopCommand()
 				return fmt.Errorf("health check timed out after %vs", maxDuration.Seconds())
@@ -488,6 +557,8 @@ func (p *Process) start() error {
 		return fmt.Errorf("failed to set Process state to ready: current state: %v, error: %v", curState, err)
 	} else {
 		p.failedStartCount = 0
+		p.readyAt.Store(time.Now().UnixNano())
+		p.loadDuration.Store(int64(time.Since(loadBeginTime)))
 		return nil
 	}
 }
@@ -629,17 +700,23 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	select {
-	case p.concurrencyLimitSemaphore <- struct{}{}:
-		defer func() { <-p.concurrencyLimitSemaphore }()
-	default:
+	if p.draining.Load() {
+		http.Error(w, fmt.Sprintf("Process %s is being preempted by a higher priority request", p.ID), http.StatusServiceUnavailable)
+		return
+	}
+
+	priority := requestPriority(r, p.config.Priority)
+	if !p.acquireSlot(r.Context(), priority) {
 		http.Error(w, "Too many requests", http.StatusTooManyRequests)
 		return
 	}
+	defer func() { <-p.concurrencyLimitSemaphore }()
 
 	p.inFlightRequests.Add(1)
 	p.inFlightRequestsCount.Add(1)
+	r, tracked := p.trackRequest(r, priority)
 	defer func() {
+		p.untrackRequest(tracked)
 		p.setLastRequestHandled(time.Now())
 		p.inFlightRequestsCount.Add(-1)
 		p.inFlightRequests.Done()
@@ -656,21 +733,24 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 
 		isStreaming, _ := r.Context().Value(proxyCtxKey("streaming")).(bool)
 
-		// PR #417 (no support for anthropic v1/messages yet)
-		isChatCompletions := strings.HasPrefix(r.URL.Path, "/v1/chat/completions")
-		if p.config.SendLoadingState != nil && *p.config.SendLoadingState && isStreaming && isChatCompletions {
-			srw = newStatusResponseWriter(p, w)
+		// PR #417, each streaming endpoint has its own format for the status
+		emitter := loadingEmitterFor(r.URL.Path, p.ID)
+		if p.config.SendLoadingState != nil && *p.config.SendLoadingState && isStreaming && emitter != nil {
+			srw = newStatusResponseWriter(p, w, emitter)
 			go srw.statusUpdates(swapCtx)
 		} else {
 			p.proxyLogger.Debugf("<%s> SendLoadingState is nil or false, not streaming loading state", p.ID)
 		}
 
 		beginStartTime := time.Now()
-		if err := p.start(); err != nil {
+		if err := p.startFor(r.Context()); err != nil {
 			errstr := fmt.Sprintf("unable to start process: %s", err)
-			cancelLoadCtx()
 			if srw != nil {
+				// written before the status updates end so it is part of the status message
 				srw.sendData(fmt.Sprintf("Unable to swap model err: %s\n", errstr))
+			}
+			cancelLoadCtx()
+			if srw != nil {
 				// Wait for statusUpdates goroutine to finish writing its deferred "Done!" messages
 				// before closing the connection. Without this, the connection would close before
 				// the goroutine can write its cleanup messages, causing incomplete SSE output.
@@ -689,6 +769,7 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 
 	// should trigger srw to stop sending loading events ...
 	cancelLoadCtx()
+	tracked.started.Store(true)
 
 	// recover from http.ErrAbortHandler panics that can occur when the client
 	// disconnects before the response is sent
@@ -708,9 +789,10 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 		if !srw.waitForCompletion(completionTimeout) {
 			p.proxyLogger.Warnf("<%s> status updates goroutine did not complete within %v, proceeding with proxy request", p.ID, completionTimeout)
 		}
-		p.reverseProxy.ServeHTTP(srw, r)
-	} else {
-		p.reverseProxy.ServeHTTP(w, r)
+		w = srw
+	}
+	if err := newUpstreamTimeouts(p.config.Timeouts).serve(p.reverseProxy, w, r); err != nil {
+		p.proxyLogger.Warnf("<%s> %v", p.ID, err)
 	}
 
 	totalTime := time.Since(requestBeginTime)
@@ -803,88 +885,32 @@ func (p *Process) Logger() *LogMonitor {
 	return p.processLogger
 }
 
-var loadingRemarks = []string{
-	"Still faster than your last standup meeting...",
-	"Reticulating splines...",
-	"Waking up the hamsters...",
-	"Teaching the model manners...",
-	"Convincing the GPU to participate...",
-	"Loading weights (they're heavy)...",
-	"Herding electrons...",
-	"Compiling excuses for the delay...",
-	"Downloading more RAM...",
-	"Asking the model nicely to boot up...",
-	"Bribing CUDA with cookies...",
-	"Still loading (blame VRAM)...",
-	"The model is fashionably late...",
-	"Warming up those tensors...",
-	"Making the neural net do push-ups...",
-	"Your patience is appreciated (really)...",
-	"Almost there (probably)...",
-	"Loading like it's 1999...",
-	"The model forgot where it put its keys...",
-	"Quantum tunneling through layers...",
-	"Negotiating with the PCIe bus...",
-	"Defrosting frozen parameters...",
-	"Teaching attention heads to focus...",
-	"Running the matrix (slowly)...",
-	"Untangling transformer blocks...",
-	"Calibrating the flux capacitor...",
-	"Spinning up the probability wheels...",
-	"Waiting for the GPU to wake from its nap...",
-	"Converting caffeine to compute...",
-	"Allocating virtual patience...",
-	"Performing arcane CUDA rituals...",
-	"The model is stuck in traffic...",
-	"Inflating embeddings...",
-	"Summoning computational demons...",
-	"Pleading with the OOM killer...",
-	"Calculating the meaning of life (still at 42)...",
-	"Training the training wheels...",
-	"Optimizing the optimizer...",
-	"Bootstrapping the bootstrapper...",
-	"Loading loading screen...",
-	"Processing processing logs...",
-	"Buffering buffer overflow jokes...",
-	"The model hit snooze...",
-	"Debugging the debugger...",
-	"Compiling the compiler...",
-	"Parsing the parser (meta)...",
-	"Tokenizing tokens...",
-	"Encoding the encoder...",
-	"Hashing hash browns...",
-	"Forking spoons (not forks)...",
-	"The model is contemplating existence...",
-	"Transcending dimensional barriers...",
-	"Invoking elder tensor gods...",
-	"Unfurling probability clouds...",
-	"Synchronizing parallel universes...",
-	"The GPU is having second thoughts...",
-	"Recalibrating reality matrices...",
-	"Time is an illusion, loading doubly so...",
-	"Convincing bits to flip themselves...",
-	"The model is reading its own documentation...",
-}
-
 type statusResponseWriter struct {
 	hasWritten bool
 	writer     http.ResponseWriter
 	process    *Process
+	emitter    loadingEmitter
+	writeMutex sync.Mutex     // status messages are written by two goroutines
 	wg         sync.WaitGroup // Track goroutine completion
 	start      time.Time
 }
 
-func newStatusResponseWriter(p *Process, w http.ResponseWriter) *statusResponseWriter {
+func newStatusResponseWriter(p *Process, w http.ResponseWriter, emitter loadingEmitter) *statusResponseWriter {
 	s := &statusResponseWriter{
 		writer:  w,
 		process: p,
+		emitter: emitter,
 		start:   time.Now(),
 	}
 
+	// added here so waitForCompletion can not return before statusUpdates started
+	s.wg.Add(1)
+
 	s.Header().Set("Content-Type", "text/event-stream") // SSE
 	s.Header().Set("Cache-Control", "no-cache")         // no-cache
 	s.Header().Set("Connection", "keep-alive")          // keep-alive
 	s.WriteHeader(http.StatusOK)                        // send status code 200
+	s.write(s.emitter.begin)
 	s.sendLine("━━━━━")
 	s.sendLine(fmt.Sprintf("llama-swap loading model: %s", p.ID))
 	return s
@@ -892,7 +918,6 @@ func newStatusResponseWriter(p *Process, w http.ResponseWriter) *statusResponseW
 
 // statusUpdates sends status updates to the client while the model is loading
 func (s *statusResponseWriter) statusUpdates(ctx context.Context) {
-	s.wg.Add(1)
 	defer s.wg.Done()
 
 	// Recover from panics caused by client disconnection
@@ -908,20 +933,11 @@ func (s *statusResponseWriter) statusUpdates(ctx context.Context) {
 		s.sendLine(fmt.Sprintf("\nDone! (%.2fs)", duration.Seconds()))
 		s.sendLine("━━━━━")
 		s.sendLine(" ")
+		s.write(s.emitter.end)
 	}()
 
-	// Create a shuffled copy of loadingRemarks
-	remarks := make([]string, len(loadingRemarks))
-	copy(remarks, loadingRemarks)
-	rand.Shuffle(len(remarks), func(i, j int) {
-		remarks[i], remarks[j] = remarks[j], remarks[i]
-	})
-	ri := 0
-
-	// Pick a random duration to send a remark
-	nextRemarkIn := time.Duration(2+rand.Intn(4)) * time.Second
-	lastRemarkTime := time.Now()
-
+	// the phase is written on its own line followed by the percentages
+	var last LoadProgress
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop() // Ensure ticker is stopped to prevent resource leak
 	for {
@@ -933,17 +949,19 @@ func (s *statusResponseWriter) statusUpdates(ctx context.Context) {
 				return
 			}
 
-			// Check if it's time for a snarky remark
-			if time.Since(lastRemarkTime) >= nextRemarkIn {
-				remark := remarks[ri%len(remarks)]
-				ri++
-				s.sendLine(fmt.Sprintf("\n%s", remark))
-				lastRemarkTime = time.Now()
-				// Pick a new random duration for the next remark
-				nextRemarkIn = time.Duration(5+rand.Intn(5)) * time.Second
-			} else {
+			progress, loading := s.process.LoadProgress()
+			switch {
+			case !loading:
+				// waiting for the scheduler to make room
+				s.sendData(".")
+			case progress.Phase != last.Phase:
+				s.sendData(fmt.Sprintf("\n%s", progress))
+			case progress.Percent != last.Percent:
+				s.sendData(fmt.Sprintf(" %d%%", progress.Percent))
+			default:
 				s.sendData(".")
 			}
+			last = progress
 		}
 	}
 }
@@ -969,36 +987,16 @@ func (s *statusResponseWriter) sendLine(line string) {
 }
 
 func (s *statusResponseWriter) sendData(data string) {
-	// Create the proper SSE JSON structure
-	type Delta struct {
-		ReasoningContent string `json:"reasoning_content"`
-	}
-	type Choice struct {
-		Delta Delta `json:"delta"`
-	}
-	type SSEMessage struct {
-		Choices []Choice `json:"choices"`
-	}
-
-	msg := SSEMessage{
-		Choices: []Choice{
-			{
-				Delta: Delta{
-					ReasoningContent: data,
-				},
-			},
-		},
-	}
-
-	jsonData, err := json.Marshal(msg)
-	if err != nil {
-		s.process.proxyLogger.Errorf("<%s> Failed to marshal SSE message: %v", s.process.ID, err)
-		return
-	}
+	s.write(func(w http.ResponseWriter) error {
+		return s.emitter.text(w, data)
+	})
+}
 
-	// Write SSE formatted data, panic if not able to write
-	_, err = fmt.Fprintf(s.writer, "data: %s\n\n", jsonData)
-	if err != nil {
+// write sends a status message, it panics if not able to write
+func (s *statusResponseWriter) write(emit func(w http.ResponseWriter) error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	if err := emit(s.writer); err != nil {
 		panic(fmt.Sprintf("<%s> Failed to write SSE data: %v", s.process.ID, err))
 	}
 	s.Flush()
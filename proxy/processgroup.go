@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/mostlygeek/llama-swap/proxy/config"
 )
@@ -39,6 +40,8 @@ func NewProcessGroup(modelID string, cfg config.Config, proxyLogger *LogMonitor,
 
 	processLogger := NewLogMonitorWriter(upstreamLogger)
 	process := NewProcess(modelID, cfg.HealthCheckTimeout, modelConfig, processLogger, pg.proxyLogger)
+	process.preemptDrainTimeout = time.Duration(cfg.Priority.DrainTimeout) * time.Second
+	process.loadingMilestones = compileLoadingMilestones(cfg.LoadingProgress.BackendFor(modelConfig))
 	if pg.tracker != nil {
 		process.SetMemoryTracker(pg.tracker, signatureForModel(modelID, modelConfig.Cmd))
 	}
@@ -69,6 +72,12 @@ func (pg *ProcessGroup) SetMemoryTracker(tracker *MemoryTracker) {
 	}
 }
 
+func (pg *ProcessGroup) SetLoadTimeTracker(tracker *LoadTimeTracker) {
+	for _, process := range pg.processes {
+		process.SetLoadTimeTracker(tracker)
+	}
+}
+
 // ProxyRequest proxies a request to the specified model
 func (pg *ProcessGroup) ProxyRequest(modelID string, writer http.ResponseWriter, request *http.Request) error {
 	if !pg.HasMember(modelID) {
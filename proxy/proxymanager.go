@@ -4,15 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -28,10 +29,46 @@ const (
 
 type proxyCtxKey string
 
+// proxySnapshot is the configuration and the process groups of its models.
+// Requests load it once so they see a consistent view while models are
+// changed through the API.
+type proxySnapshot struct {
+	config        config.Config
+	processGroups map[string]*ProcessGroup
+
+	// models with embeddingBatch enabled, see embedding_batcher.go
+	embeddingBatchers map[string]*embeddingBatcher
+
+	// responses of models with responseCache enabled, see response_cache.go.
+	// Created when the first model that uses it is configured.
+	responseCache responseCache
+}
+
+func (s *proxySnapshot) swapProcessGroup(realModelName string) (*ProcessGroup, error) {
+	processGroup, ok := s.processGroups[realModelName]
+	if !ok {
+		return nil, fmt.Errorf("could not find process for model %s", realModelName)
+	}
+	return processGroup, nil
+}
+
+func (s *proxySnapshot) findProcessByModelName(modelName string) *Process {
+	if processGroup, ok := s.processGroups[modelName]; ok {
+		if process, ok := processGroup.processes[modelName]; ok {
+			return process
+		}
+	}
+	return nil
+}
+
 type ProxyManager struct {
 	sync.Mutex
 
-	config    config.Config
+	// the configuration and process groups, replaced as a whole when models
+	// are changed through the API. modelsMu serializes those changes.
+	snapshot atomic.Pointer[proxySnapshot]
+	modelsMu sync.Mutex
+
 	ginEngine *gin.Engine
 
 	// logging
@@ -41,10 +78,12 @@ type ProxyManager struct {
 
 	metricsMonitor *metricsMonitor
 
-	processGroups map[string]*ProcessGroup
 	scheduler     *Scheduler
 	memoryTracker *MemoryTracker
 
+	// measured load times for the loading progress ETA
+	loadTimeTracker *LoadTimeTracker
+
 	// WebSocket hub for real-time updates
 	wsHub *WSHub
 
@@ -64,6 +103,25 @@ type ProxyManager struct {
 
 	// peer proxy see: #296, #433
 	peerProxy *PeerProxy
+
+	// runtime model management, see proxymanager_modelapi.go
+	configPath    string
+	modelOverlays map[string][]byte
+
+	// conversations of emulated Responses API responses
+	responseStore responseStore
+
+	// offline batches, see batches.go
+	batches *batchManager
+
+	// holds requests to avoid swaps, see swap_scheduler.go
+	swapScheduler *swapScheduler
+
+	// inference requests in flight that were not made by the batch worker
+	interactiveRequests atomic.Int32
+
+	// used as the created time of /v1/models records
+	startTime time.Time
 }
 
 func New(proxyConfig config.Config) *ProxyManager {
@@ -159,10 +217,29 @@ func NewWithAllocator(proxyConfig config.Config, allocator GPUAllocator) *ProxyM
 	if err != nil {
 		proxyLogger.Errorf("Disabling Peering. Failed to create proxy peers: %v", err)
 		peerProxy = nil
+	} else {
+		peerProxy.SetSelection(proxyConfig.PeerSelection)
+	}
+
+	responseStore, err := newResponseStore(proxyConfig.ResponsesStore)
+	if err != nil {
+		proxyLogger.Errorf("Using in memory responses store: %v", err)
+		responseStore = newMemoryResponseStore(proxyConfig.ResponsesStore.MaxEntries)
+	}
+
+	var cache responseCache
+	for _, modelConfig := range proxyConfig.Models {
+		if modelConfig.ResponseCache {
+			cache, err = newResponseCache(proxyConfig.ResponseCache)
+			if err != nil {
+				proxyLogger.Errorf("Disabling response cache: %v", err)
+				cache = nil
+			}
+			break
+		}
 	}
 
 	pm := &ProxyManager{
-		config:    proxyConfig,
 		ginEngine: gin.New(),
 
 		proxyLogger:    proxyLogger,
@@ -171,9 +248,10 @@ func NewWithAllocator(proxyConfig config.Config, allocator GPUAllocator) *ProxyM
 
 		metricsMonitor: newMetricsMonitor(proxyLogger, maxMetrics, proxyConfig.CaptureBuffer),
 
-		processGroups: make(map[string]*ProcessGroup),
 		memoryTracker: NewMemoryTracker(),
 
+		loadTimeTracker: NewLoadTimeTracker(),
+
 		wsHub: NewWSHub(),
 
 		playgroundSessions: NewPlaygroundSessionManager(),
@@ -181,16 +259,53 @@ func NewWithAllocator(proxyConfig config.Config, allocator GPUAllocator) *ProxyM
 		shutdownCtx:    shutdownCtx,
 		shutdownCancel: shutdownCancel,
 
+		startTime: time.Now(),
+
 		buildDate: "unknown",
 		commit:    "abcd1234",
 		version:   "0",
 
 		peerProxy: peerProxy,
+
+		modelOverlays: make(map[string][]byte),
+
+		responseStore: responseStore,
 	}
 
+	processGroups := make(map[string]*ProcessGroup, len(proxyConfig.Models))
+	embeddingBatchers := make(map[string]*embeddingBatcher)
+	for modelID, modelConfig := range proxyConfig.Models {
+		if modelConfig.EmbeddingBatch.Enabled {
+			embeddingBatchers[modelID] = newEmbeddingBatcher(modelConfig.EmbeddingBatch)
+		}
+		processGroup := NewProcessGroup(modelID, proxyConfig, proxyLogger, upstreamLogger)
+		processGroup.SetMemoryTracker(pm.memoryTracker)
+		processGroup.SetLoadTimeTracker(pm.loadTimeTracker)
+		processGroups[modelID] = processGroup
+	}
+	pm.snapshot.Store(&proxySnapshot{
+		config:            proxyConfig,
+		processGroups:     processGroups,
+		embeddingBatchers: embeddingBatchers,
+		responseCache:     cache,
+	})
+
 	// Start WebSocket hub
 	go pm.wsHub.Run()
 
+	if peerProxy != nil {
+		peerProxy.StartHealthChecks(shutdownCtx)
+	}
+
+	if proxyConfig.Batches.Enabled {
+		batches, err := newBatchManager(pm, proxyConfig.Batches.Path, proxyConfig.Batches.IdleOnly)
+		if err != nil {
+			proxyLogger.Errorf("Disabling batches: %v", err)
+		} else {
+			pm.batches = batches
+		}
+	}
+
 	uiTemplates, err := loadUITemplates()
 	if err != nil {
 		proxyLogger.Errorf("Failed to load UI templates: %v", err)
@@ -198,12 +313,6 @@ func NewWithAllocator(proxyConfig config.Config, allocator GPUAllocator) *ProxyM
 		pm.uiTemplates = uiTemplates
 	}
 
-	for modelID := range proxyConfig.Models {
-		processGroup := NewProcessGroup(modelID, proxyConfig, proxyLogger, upstreamLogger)
-		processGroup.SetMemoryTracker(pm.memoryTracker)
-		pm.processGroups[modelID] = processGroup
-	}
-
 	shouldScheduleVram := hasVramModels(proxyConfig.Models)
 	shouldScheduleHostRAM := proxyConfig.HostRamCapMB > 0
 	hasVramCaps := proxyConfig.GpuVramCapMB > 0 || len(proxyConfig.GpuVramCapsMB) > 0
@@ -212,6 +321,9 @@ func NewWithAllocator(proxyConfig config.Config, allocator GPUAllocator) *ProxyM
 			GpuVramCapMB:  proxyConfig.GpuVramCapMB,
 			GpuVramCapsMB: proxyConfig.GpuVramCapsMB,
 			HostRamCapMB:  proxyConfig.HostRamCapMB,
+			DrainTimeout:  time.Duration(proxyConfig.Priority.DrainTimeout) * time.Second,
+			MaxWait:       time.Duration(proxyConfig.Priority.MaxWait) * time.Second,
+			AgingInterval: time.Duration(proxyConfig.Priority.AgingInterval) * time.Second,
 		})
 		if shouldScheduleVram {
 			if _, err := scheduler.allocator.GetGPUs(); err != nil {
@@ -223,11 +335,17 @@ func NewWithAllocator(proxyConfig config.Config, allocator GPUAllocator) *ProxyM
 		}
 		if scheduler != nil {
 			pm.scheduler = scheduler
-			for _, processGroup := range pm.processGroups {
+			for _, processGroup := range processGroups {
 				processGroup.SetScheduler(scheduler)
 			}
+			if proxyConfig.SwapScheduler.Enabled {
+				pm.swapScheduler = newSwapScheduler(proxyConfig.SwapScheduler, scheduler)
+			}
 		}
 	}
+	if proxyConfig.SwapScheduler.Enabled && pm.swapScheduler == nil {
+		proxyLogger.Warn("swapScheduler disabled: it needs the VRAM scheduler which is not running")
+	}
 
 	pm.setupGinEngine()
 
@@ -266,6 +384,10 @@ func NewWithAllocator(proxyConfig config.Config, allocator GPUAllocator) *ProxyM
 		}()
 	}
 
+	if pm.batches != nil {
+		pm.batches.start(shutdownCtx)
+	}
+
 	return pm
 }
 
@@ -366,7 +488,11 @@ func (pm *ProxyManager) setupGinEngine() {
 	pm.ginEngine.POST("/v1/images/generations", pm.apiKeyAuth(), pm.proxyInferenceHandler)
 	pm.ginEngine.POST("/v1/images/edits", pm.apiKeyAuth(), pm.proxyOAIPostFormHandler)
 
+	// OpenAI realtime style WebSocket sessions
+	pm.ginEngine.GET("/v1/realtime", pm.apiKeyAuth(), pm.proxyRealtimeHandler)
+
 	pm.ginEngine.GET("/v1/models", pm.apiKeyAuth(), pm.listModelsHandler)
+	pm.ginEngine.GET("/v1/models/*id", pm.apiKeyAuth(), pm.retrieveModelHandler)
 
 	// in proxymanager_loghandlers.go
 	pm.ginEngine.GET("/logs", pm.apiKeyAuth(), pm.sendLogsHandlers)
@@ -453,6 +579,16 @@ func (pm *ProxyManager) setupGinEngine() {
 	// add API handler functions
 	addApiHandlers(pm)
 
+	// see: proxymanager_ollama.go
+	if pm.snapshot.Load().config.Ollama.Enabled {
+		addOllamaHandlers(pm)
+	}
+
+	// see: proxymanager_batches.go
+	if pm.batches != nil {
+		addBatchHandlers(pm)
+	}
+
 	// Disable console color for testing
 	gin.DisableConsoleColor()
 }
@@ -472,7 +608,7 @@ func (pm *ProxyManager) StopProcesses(strategy StopStrategy) {
 
 	// stop Processes in parallel
 	var wg sync.WaitGroup
-	for _, processGroup := range pm.processGroups {
+	for _, processGroup := range pm.snapshot.Load().processGroups {
 		wg.Add(1)
 		go func(processGroup *ProcessGroup) {
 			defer wg.Done()
@@ -488,7 +624,7 @@ func (pm *ProxyManager) runningProcesses() []*Process {
 	defer pm.Unlock()
 
 	processes := make([]*Process, 0)
-	for _, processGroup := range pm.processGroups {
+	for _, processGroup := range pm.snapshot.Load().processGroups {
 		for _, process := range processGroup.processes {
 			if processUsesSchedulerCapacity(process) {
 				processes = append(processes, process)
@@ -518,6 +654,15 @@ func hasVramModels(models map[string]config.ModelConfig) bool {
 
 // Shutdown stops all processes managed by this ProxyManager
 func (pm *ProxyManager) Shutdown() {
+	// the batch worker stops before the processes so its running request is
+	// not recorded as failed, see batchManager.stop
+	if pm.batches != nil {
+		pm.batches.stop()
+	}
+
+	// no model can be added through the API while shutting down
+	pm.modelsMu.Lock()
+	defer pm.modelsMu.Unlock()
 	pm.Lock()
 	defer pm.Unlock()
 
@@ -525,7 +670,7 @@ func (pm *ProxyManager) Shutdown() {
 
 	var wg sync.WaitGroup
 	// Send shutdown signal to all process in groups
-	for _, processGroup := range pm.processGroups {
+	for _, processGroup := range pm.snapshot.Load().processGroups {
 		wg.Add(1)
 		go func(processGroup *ProcessGroup) {
 			defer wg.Done()
@@ -537,92 +682,28 @@ func (pm *ProxyManager) Shutdown() {
 }
 
 func (pm *ProxyManager) swapProcessGroup(realModelName string) (*ProcessGroup, error) {
-	processGroup, ok := pm.processGroups[realModelName]
-	if !ok {
-		return nil, fmt.Errorf("could not find process for model %s", realModelName)
-	}
-	return processGroup, nil
+	return pm.snapshot.Load().swapProcessGroup(realModelName)
 }
 
-func (pm *ProxyManager) listModelsHandler(c *gin.Context) {
-	data := make([]gin.H, 0, len(pm.config.Models))
-	createdTime := time.Now().Unix()
-
-	newRecord := func(modelId string, modelConfig config.ModelConfig) gin.H {
-		record := gin.H{
-			"id":       modelId,
-			"object":   "model",
-			"created":  createdTime,
-			"owned_by": "llama-swap",
-		}
-
-		if name := strings.TrimSpace(modelConfig.Name); name != "" {
-			record["name"] = name
-		}
-		if desc := strings.TrimSpace(modelConfig.Description); desc != "" {
-			record["description"] = desc
-		}
-
-		// Add metadata if present
-		if len(modelConfig.Metadata) > 0 {
-			record["meta"] = gin.H{
-				"llamaswap": modelConfig.Metadata,
-			}
-		}
-		return record
-	}
-
-	for id, modelConfig := range pm.config.Models {
-		if modelConfig.Unlisted {
-			continue
-		}
-
-		data = append(data, newRecord(id, modelConfig))
-
-		// Include aliases
-		if pm.config.IncludeAliasesInList {
-			for _, alias := range modelConfig.Aliases {
-				if alias := strings.TrimSpace(alias); alias != "" {
-					data = append(data, newRecord(alias, modelConfig))
-				}
-			}
-		}
-	}
-
-	if pm.peerProxy != nil {
-		for peerID, peer := range pm.peerProxy.ListPeers() {
-			// add peer models
-			for _, modelID := range peer.Models {
-				// Skip unlisted models if not showing them
-				record := newRecord(modelID, config.ModelConfig{
-					Name: fmt.Sprintf("%s: %s", peerID, modelID),
-					Metadata: map[string]any{
-						"peerID": peerID,
-					},
-				})
-
-				data = append(data, record)
-			}
+// localHandler returns the handler for requests to a local model. With the
+// swap scheduler enabled requests that would swap out a loaded model are held
+// first.
+func (pm *ProxyManager) localHandler(modelID string, processGroup *ProcessGroup) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	if pm.swapScheduler != nil {
+		if process, found := processGroup.GetMember(modelID); found {
+			return pm.swapScheduler.handler(process, processGroup.ProxyRequest)
 		}
 	}
+	return processGroup.ProxyRequest
+}
 
-	// Sort by the "id" key
-	sort.Slice(data, func(i, j int) bool {
-		si, _ := data[i]["id"].(string)
-		sj, _ := data[j]["id"].(string)
-		return si < sj
-	})
-
-	// Set CORS headers if origin exists
-	if origin := c.GetHeader("Origin"); origin != "" {
-		c.Header("Access-Control-Allow-Origin", origin)
+// presetName returns name when it is a preset, metrics of presets are
+// recorded under the preset's name
+func (pm *ProxyManager) presetName(name string) string {
+	if _, _, found := pm.snapshot.Load().config.PresetFor(name); found {
+		return name
 	}
-
-	// Use gin's JSON method which handles content-type and encoding
-	c.JSON(http.StatusOK, gin.H{
-		"object": "list",
-		"data":   data,
-	})
+	return ""
 }
 
 // findModelInPath searches for a valid model name in a path with slashes.
@@ -630,6 +711,7 @@ func (pm *ProxyManager) listModelsHandler(c *gin.Context) {
 // Returns: (searchModelName, realModelName, remainingPath, found)
 // Example: "/author/model/endpoint" with model "author/model" -> ("author/model", "author/model", "/endpoint", true)
 func (pm *ProxyManager) findModelInPath(path string) (searchName string, realName string, remainingPath string, found bool) {
+	snap := pm.snapshot.Load()
 	parts := strings.Split(strings.TrimSpace(path), "/")
 	searchModelName := ""
 
@@ -644,7 +726,7 @@ func (pm *ProxyManager) findModelInPath(path string) (searchName string, realNam
 			searchModelName = searchModelName + "/" + part
 		}
 
-		if modelID, ok := pm.config.RealModelName(searchModelName); ok {
+		if modelID, ok := snap.config.RealModelName(searchModelName); ok {
 			return searchModelName, modelID, "/" + strings.Join(parts[i+1:], "/"), true
 		}
 	}
@@ -679,7 +761,7 @@ func (pm *ProxyManager) proxyToUpstream(c *gin.Context) {
 		return
 	}
 
-	processGroup, err := pm.swapProcessGroup(modelID)
+	processGroup, err := pm.snapshot.Load().swapProcessGroup(modelID)
 	if err != nil {
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
 		return
@@ -706,94 +788,188 @@ func (pm *ProxyManager) proxyToUpstream(c *gin.Context) {
 }
 
 func (pm *ProxyManager) proxyInferenceHandler(c *gin.Context) {
+	if !isBatchRequest(c.Request) {
+		pm.interactiveRequests.Add(1)
+		defer pm.interactiveRequests.Add(-1)
+	}
+
+	snap := pm.snapshot.Load()
+
+	// bodies no model accepts are rejected before they are read
+	maxBodyBytes := snap.maxRequestBodyBytes()
+	if maxBodyBytes > 0 {
+		if c.Request.ContentLength > maxBodyBytes {
+			writeLimitViolation(c, bodyTooLarge(maxBodyBytes))
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+	}
+
 	bodyBytes, err := io.ReadAll(c.Request.Body)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeLimitViolation(c, bodyTooLarge(maxBodyBytes))
+			return
+		}
 		pm.sendErrorResponse(c, http.StatusBadRequest, "could not ready request body")
 		return
 	}
 
+	// the endpoint the client requested, before any API translation
+	clientPath := c.Request.URL.Path
+
 	requestedModel := gjson.GetBytes(bodyBytes, "model").String()
 	if requestedModel == "" {
 		pm.sendErrorResponse(c, http.StatusBadRequest, "missing or invalid 'model' key")
 		return
 	}
 
+	// the name the client asked for, echoed back in translated responses
+	clientModel := requestedModel
+
+	// virtual model names (routes and pools) pick a real model based on the request
+	routeName := ""
+	if chosenModel, err := pm.resolveVirtualModel(requestedModel, c.Request.URL.Path, bodyBytes); err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	} else if chosenModel != requestedModel {
+		routeName = requestedModel
+		requestedModel = chosenModel
+
+		bodyBytes, err = sjson.SetBytes(bodyBytes, "model", chosenModel)
+		if err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error rewriting model name in JSON: %s", err.Error()))
+			return
+		}
+	}
+
+	// the policy service can deny or rewrite the request before anything is swapped
+	hookModel := requestedModel
+	if realName, found := snap.config.RealModelName(requestedModel); found {
+		hookModel = realName
+	}
+	bodyBytes, ok := pm.preRequestWebhook(c, hookModel, clientModel, bodyBytes)
+	if !ok {
+		return
+	}
+	requestMetrics := &TokenMetrics{}
+	defer pm.postRequestWebhook(c, hookModel, clientModel, clientPath, time.Now(), requestMetrics)
+
 	// Look for a matching local model first
 	var nextHandler func(modelID string, w http.ResponseWriter, r *http.Request) error
+	var translatingWriter *translatingResponseWriter
+	var filters config.Filters
+	var cacheKey string
+	var cacheHit bool
+
+	// the model is sent to a peer when starting it would evict other models
+	peerModel, offloadReason := requestedModel, ""
+	modelID, found := snap.config.RealModelName(requestedModel)
+	if found {
+		if offloadModel, reason, offload := pm.peerOffload(modelID, requestedModel); offload {
+			peerModel, offloadReason, found = offloadModel, reason, false
+		}
+	}
 
-	modelID, found := pm.config.RealModelName(requestedModel)
 	if found {
-		processGroup, err := pm.swapProcessGroup(modelID)
-		if err != nil {
-			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
+		// requests that are too large for the model are rejected before a swap
+		if violation := pm.checkRequestLimits(c.Request.Context(), modelID, bodyBytes); violation != nil {
+			pm.proxyLogger.Infof("<%s> rejected request: %s", modelID, violation.message)
+			writeLimitViolation(c, violation)
 			return
 		}
 
-		// issue #69 allow custom model names to be sent to upstream
-		useModelName := pm.config.Models[modelID].UseModelName
-		if useModelName != "" {
-			bodyBytes, err = sjson.SetBytes(bodyBytes, "model", useModelName)
+		// translate Anthropic requests for upstreams that only implement OpenAI chat completions
+		if snap.config.Models[modelID].HasAPITranslation(config.APITranslationAnthropic) && isAnthropicPath(c.Request.URL.Path) {
+			if c.Request.URL.Path == "/v1/messages/count_tokens" {
+				c.JSON(http.StatusOK, gin.H{"input_tokens": estimateAnthropicInputTokens(bodyBytes)})
+				return
+			}
+
+			bodyBytes, err = anthropicToOpenAIRequest(bodyBytes)
 			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error rewriting model name in JSON: %s", err.Error()))
+				c.JSON(http.StatusBadRequest, anthropicError(http.StatusBadRequest, err.Error()))
 				return
 			}
+			rewriteToChatCompletions(c.Request)
+			translatingWriter = newAnthropicResponseWriter(c.Writer, clientModel)
 		}
 
-		// issue #174 strip parameters from the JSON body
-		stripParams, err := pm.config.Models[modelID].Filters.SanitizedStripParams()
-		if err != nil { // just log it and continue
-			pm.proxyLogger.Errorf("Error sanitizing strip params string: %s, %s", pm.config.Models[modelID].Filters.StripParams, err.Error())
-		} else {
-			for _, param := range stripParams {
-				pm.proxyLogger.Debugf("<%s> stripping param: %s", modelID, param)
-				bodyBytes, err = sjson.DeleteBytes(bodyBytes, param)
-				if err != nil {
-					pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error deleting parameter %s from request", param))
-					return
+		// emulate the Responses API for upstreams that only implement OpenAI chat completions
+		if snap.config.Models[modelID].HasAPITranslation(config.APITranslationResponses) && c.Request.URL.Path == "/v1/responses" {
+			var conversation *responsesConversation
+			bodyBytes, conversation, err = responsesToOpenAIRequest(bodyBytes, pm.responseStore)
+			if err != nil {
+				status := http.StatusBadRequest
+				if errors.Is(err, errPreviousResponseNotFound) {
+					status = http.StatusNotFound
 				}
+				c.JSON(status, openAIError(status, err.Error()))
+				return
 			}
+			rewriteToChatCompletions(c.Request)
+			translatingWriter = newResponsesResponseWriter(c.Writer, clientModel, conversation, pm.responseStore, pm.proxyLogger)
 		}
 
-		// issue #453 set/override parameters in the JSON body
-		setParams, setParamKeys := pm.config.Models[modelID].Filters.SanitizedSetParams()
-		for _, key := range setParamKeys {
-			pm.proxyLogger.Debugf("<%s> setting param: %s", modelID, key)
-			bodyBytes, err = sjson.SetBytes(bodyBytes, key, setParams[key])
+		// issue #69 allow custom model names to be sent to upstream
+		useModelName := snap.config.Models[modelID].UseModelName
+		if useModelName != "" {
+			bodyBytes, err = sjson.SetBytes(bodyBytes, "model", useModelName)
 			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error setting parameter %s in request", key))
+				pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error rewriting model name in JSON: %s", err.Error()))
 				return
 			}
 		}
 
-		pm.proxyLogger.Debugf("ProxyManager using local Process for model: %s", requestedModel)
-		nextHandler = processGroup.ProxyRequest
-	} else if pm.peerProxy != nil && pm.peerProxy.HasPeerModel(requestedModel) {
-		pm.proxyLogger.Debugf("ProxyManager using ProxyPeer for model: %s", requestedModel)
-		modelID = requestedModel
-
-		// issue #453 apply filters for peer requests
-		peerFilters := pm.peerProxy.GetPeerFilters(requestedModel)
+		// presets add their own filters to the model's
+		filters = snap.config.FiltersFor(requestedModel, clientPath)
+		bodyBytes, err = pm.filterRequest(modelID, filters, c.Request.URL.Path, bodyBytes)
+		if err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
 
-		// Apply stripParams - remove specified parameters from request
-		stripParams := peerFilters.SanitizedStripParams()
-		for _, param := range stripParams {
-			pm.proxyLogger.Debugf("<%s> stripping param: %s", requestedModel, param)
-			bodyBytes, err = sjson.DeleteBytes(bodyBytes, param)
-			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error stripping parameter %s from request", param))
-				return
+		// deterministic requests are served from the cache without waking or swapping the model
+		if snap.responseCache != nil && snap.config.Models[modelID].ResponseCache {
+			if key, cacheable := responseCacheKey(modelID, c.Request.URL.Path, bodyBytes); cacheable {
+				cacheKey = key
+				if cached, found := snap.responseCache.Get(key); found {
+					pm.proxyLogger.Debugf("<%s> serving response from cache", modelID)
+					c.Header("X-Llama-Swap-Cache", "HIT")
+					cacheHit = true
+					nextHandler = cached.serve
+				} else {
+					c.Header("X-Llama-Swap-Cache", "MISS")
+				}
 			}
 		}
 
-		// Apply setParams - set/override specified parameters in request
-		setParams, setParamKeys := peerFilters.SanitizedSetParams()
-		for _, key := range setParamKeys {
-			pm.proxyLogger.Debugf("<%s> setting param: %s", requestedModel, key)
-			bodyBytes, err = sjson.SetBytes(bodyBytes, key, setParams[key])
+		if !cacheHit {
+			processGroup, err := snap.swapProcessGroup(modelID)
 			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error setting parameter %s in request", key))
+				pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
 				return
 			}
+
+			pm.proxyLogger.Debugf("ProxyManager using local Process for model: %s", requestedModel)
+			nextHandler = pm.localHandler(modelID, processGroup)
+
+			// concurrent embedding requests share one upstream request
+			if batcher, found := snap.embeddingBatchers[modelID]; found && c.Request.URL.Path == "/v1/embeddings" {
+				nextHandler = batcher.handler(nextHandler)
+			}
+		}
+	} else if pm.peerProxy != nil && pm.peerProxy.HasPeerModel(peerModel) {
+		pm.proxyLogger.Debugf("ProxyManager using ProxyPeer for model: %s", peerModel)
+		modelID = peerModel
+
+		// issue #453 apply filters for peer requests
+		filters = pm.peerProxy.GetPeerFilters(peerModel).ForPath(clientPath)
+		bodyBytes, err = pm.filterRequest(peerModel, filters, c.Request.URL.Path, bodyBytes)
+		if err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
 		}
 
 		nextHandler = pm.peerProxy.ProxyRequest
@@ -805,6 +981,10 @@ func (pm *ProxyManager) proxyInferenceHandler(c *gin.Context) {
 	}
 
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	// the body is already in memory, it can be sent again to another peer
+	c.Request.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(bodyBytes)), nil
+	}
 
 	// dechunk it as we already have all the body bytes see issue #11
 	c.Request.Header.Del("transfer-encoding")
@@ -815,55 +995,164 @@ func (pm *ProxyManager) proxyInferenceHandler(c *gin.Context) {
 	isStreaming := gjson.GetBytes(bodyBytes, "stream").Bool()
 	ctx := context.WithValue(c.Request.Context(), proxyCtxKey("streaming"), isStreaming)
 	ctx = context.WithValue(ctx, proxyCtxKey("model"), modelID)
+	ctx = context.WithValue(ctx, proxyCtxKey("route"), routeName)
+	ctx = context.WithValue(ctx, proxyCtxKey("preset"), pm.presetName(requestedModel))
+	ctx = context.WithValue(ctx, proxyCtxKey("cacheHit"), cacheHit)
+	ctx = context.WithValue(ctx, proxyCtxKey("peerOffload"), offloadReason)
+	ctx = context.WithValue(ctx, proxyCtxKey("metrics"), requestMetrics)
+	if priority, ok := pm.requestPriority(c.Request); ok {
+		ctx = context.WithValue(ctx, proxyCtxKey("priority"), priority)
+	}
 	c.Request = c.Request.WithContext(ctx)
 
+	c.Header("X-Llama-Swap-Model", modelID)
+
+	// metrics are recorded from the upstream's OpenAI response, before translation
+	var writer gin.ResponseWriter = c.Writer
+	if translatingWriter != nil {
+		writer = translatingWriter
+		defer translatingWriter.finish()
+	}
+
+	// response filters see the upstream's OpenAI response, before translation
+	if hasResponseFilters(filters.Response, c.Request.URL.Path) {
+		c.Request.Header.Set("Accept-Encoding", "identity")
+		filteringWriter := newFilteringResponseWriter(writer, filters.Response)
+		writer = filteringWriter
+		defer filteringWriter.finish()
+	}
+
+	// responses are cached as the upstream sent them, before translation
+	var cacheWriter *responseCacheWriter
+	if cacheKey != "" && !cacheHit {
+		cacheWriter = newResponseCacheWriter(writer)
+		writer = cacheWriter
+	}
+
 	if pm.metricsMonitor != nil && c.Request.Method == "POST" {
-		if err := pm.metricsMonitor.wrapHandler(modelID, c.Writer, c.Request, nextHandler); err != nil {
+		if err := pm.metricsMonitor.wrapHandler(modelID, writer, c.Request, nextHandler); err != nil {
 			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying metrics wrapped request: %s", err.Error()))
 			pm.proxyLogger.Errorf("Error Proxying Metrics Wrapped Request model %s", modelID)
 			return
 		}
 	} else {
-		if err := nextHandler(modelID, c.Writer, c.Request); err != nil {
+		if err := nextHandler(modelID, writer, c.Request); err != nil {
 			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying request: %s", err.Error()))
 			pm.proxyLogger.Errorf("Error Proxying Request for model %s", modelID)
 			return
 		}
 	}
+
+	// an interrupted stream is incomplete and not cached
+	if cacheWriter != nil && c.Request.Context().Err() == nil {
+		if response, ok := cacheWriter.response(); ok {
+			if err := snap.responseCache.Put(cacheKey, response); err != nil {
+				pm.proxyLogger.Warnf("<%s> unable to cache response: %v", modelID, err)
+			}
+		}
+	}
 }
 
 func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
-	// Parse multipart form
-	if err := c.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB max memory, larger files go to tmp disk
+	pm.interactiveRequests.Add(1)
+	defer pm.interactiveRequests.Add(-1)
+
+	snap := pm.snapshot.Load()
+
+	// uploads no model accepts are rejected before they are read
+	maxBodyBytes := snap.maxRequestBodyBytes()
+	if maxBodyBytes > 0 {
+		if c.Request.ContentLength > maxBodyBytes {
+			writeLimitViolation(c, bodyTooLarge(maxBodyBytes))
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+	}
+
+	// the form streams through to the upstream, only the parts up to the
+	// model field are read before routing
+	form, err := newFormStream(c.Request)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeLimitViolation(c, bodyTooLarge(maxBodyBytes))
+			return
+		}
 		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("error parsing multipart form: %s", err.Error()))
 		return
 	}
+	defer form.close()
 
 	// Get model parameter from the form
-	requestedModel := c.Request.FormValue("model")
+	requestedModel := form.model
 	if requestedModel == "" {
 		pm.sendErrorResponse(c, http.StatusBadRequest, "missing or invalid 'model' parameter in form data")
 		return
 	}
+	clientModel := requestedModel
+
+	// only path rules can match form requests
+	routeName := ""
+	if chosenModel, err := pm.resolveVirtualModel(requestedModel, c.Request.URL.Path, nil); err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	} else if chosenModel != requestedModel {
+		routeName = requestedModel
+		requestedModel = chosenModel
+	}
+
+	// the policy service can deny the request before anything is swapped, the
+	// form is not sent to it
+	hookModel := requestedModel
+	if realName, found := snap.config.RealModelName(requestedModel); found {
+		hookModel = realName
+	}
+	if _, ok := pm.preRequestWebhook(c, hookModel, clientModel, nil); !ok {
+		return
+	}
+	requestMetrics := &TokenMetrics{}
+	defer pm.postRequestWebhook(c, hookModel, clientModel, c.Request.URL.Path, time.Now(), requestMetrics)
 
 	// Look for a matching local model first, then check peers
 	var nextHandler func(modelID string, w http.ResponseWriter, r *http.Request) error
-	var useModelName string
+	var filters config.Filters
+	upstreamModel := requestedModel
+
+	// the model is sent to a peer when starting it would evict other models
+	peerModel, offloadReason := requestedModel, ""
+	modelID, found := snap.config.RealModelName(requestedModel)
+	if found {
+		if offloadModel, reason, offload := pm.peerOffload(modelID, requestedModel); offload {
+			peerModel, offloadReason, found = offloadModel, reason, false
+		}
+	}
 
-	modelID, found := pm.config.RealModelName(requestedModel)
 	if found {
-		processGroup, err := pm.swapProcessGroup(modelID)
+		// uploads that are too large for the model are rejected before a swap
+		if violation := form.limitBody(modelID, snap.config.Models[modelID].Limits.MaxBodyBytes, c.Request.ContentLength); violation != nil {
+			pm.proxyLogger.Infof("<%s> rejected request: %s", modelID, violation.message)
+			writeLimitViolation(c, violation)
+			return
+		}
+
+		processGroup, err := snap.swapProcessGroup(modelID)
 		if err != nil {
 			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
 			return
 		}
 
-		useModelName = pm.config.Models[modelID].UseModelName
+		// issue #69 allow custom model names to be sent to upstream
+		if useModelName := snap.config.Models[modelID].UseModelName; useModelName != "" {
+			upstreamModel = useModelName
+		}
+		filters = snap.config.FiltersFor(requestedModel, c.Request.URL.Path)
+
 		pm.proxyLogger.Debugf("ProxyManager using local Process for model: %s", requestedModel)
-		nextHandler = processGroup.ProxyRequest
-	} else if pm.peerProxy != nil && pm.peerProxy.HasPeerModel(requestedModel) {
-		pm.proxyLogger.Debugf("ProxyManager using ProxyPeer for model: %s", requestedModel)
-		modelID = requestedModel
+		nextHandler = pm.localHandler(modelID, processGroup)
+	} else if pm.peerProxy != nil && pm.peerProxy.HasPeerModel(peerModel) {
+		pm.proxyLogger.Debugf("ProxyManager using ProxyPeer for model: %s", peerModel)
+		modelID, upstreamModel = peerModel, peerModel
+		filters = pm.peerProxy.GetPeerFilters(peerModel).ForPath(c.Request.URL.Path)
 		nextHandler = pm.peerProxy.ProxyRequest
 	}
 
@@ -872,91 +1161,85 @@ func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
 		return
 	}
 
-	// We need to reconstruct the multipart form in any case since the body is consumed
-	// Create a new buffer for the reconstructed request
-	var requestBuffer bytes.Buffer
-	multipartWriter := multipart.NewWriter(&requestBuffer)
-
-	// Copy all form values
-	for key, values := range c.Request.MultipartForm.Value {
-		for _, value := range values {
-			fieldValue := value
-			// If this is the model field and we have a profile, use just the model name
-			if key == "model" {
-				// # issue #69 allow custom model names to be sent to upstream
-				if useModelName != "" {
-					fieldValue = useModelName
-				} else {
-					fieldValue = requestedModel
-				}
-			}
-			field, err := multipartWriter.CreateFormField(key)
-			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, "error recreating form field")
-				return
-			}
-			if _, err = field.Write([]byte(fieldValue)); err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, "error writing form field")
-				return
-			}
+	// forms nothing rewrites are sent as they are with their length, others are
+	// rebuilt with the filters applied while the upstream reads them
+	var body io.Reader
+	contentType := c.Request.Header.Get("Content-Type")
+	contentLength := c.Request.ContentLength
+	if contentLength > 0 && !form.rewrites(upstreamModel, filters) {
+		if body, err = form.original(); err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error reading multipart form: %s", err.Error()))
+			return
 		}
-	}
-
-	// Copy all files from the original request
-	for key, fileHeaders := range c.Request.MultipartForm.File {
-		for _, fileHeader := range fileHeaders {
-			formFile, err := multipartWriter.CreateFormFile(key, fileHeader.Filename)
-			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, "error recreating form file")
+	} else {
+		bodyReader, bodyWriter := io.Pipe()
+		multipartWriter := multipart.NewWriter(bodyWriter)
+		forwarded := make(chan struct{})
+		go func() {
+			defer close(forwarded)
+			if err := form.forward(multipartWriter, upstreamModel, filters); err != nil {
+				if !isClosedPipe(err) {
+					pm.proxyLogger.Warnf("<%s> error forwarding multipart form: %v", modelID, err)
+				}
+				bodyWriter.CloseWithError(err)
 				return
 			}
+			bodyWriter.Close()
+		}()
 
-			file, err := fileHeader.Open()
-			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, "error opening uploaded file")
-				return
-			}
+		// the client's body can not be read after the handler returns
+		defer func() {
+			bodyReader.Close()
+			<-forwarded
+		}()
 
-			if _, err = io.Copy(formFile, file); err != nil {
-				file.Close()
-				pm.sendErrorResponse(c, http.StatusInternalServerError, "error copying file data")
-				return
-			}
-			file.Close()
-		}
+		body = bodyReader
+		contentType = multipartWriter.FormDataContentType()
+		contentLength = -1
 	}
 
-	// Close the multipart writer to finalize the form
-	if err := multipartWriter.Close(); err != nil {
-		pm.sendErrorResponse(c, http.StatusInternalServerError, "error finalizing multipart form")
-		return
+	ctx := context.WithValue(c.Request.Context(), proxyCtxKey("model"), modelID)
+	ctx = context.WithValue(ctx, proxyCtxKey("route"), routeName)
+	ctx = context.WithValue(ctx, proxyCtxKey("preset"), pm.presetName(requestedModel))
+	ctx = context.WithValue(ctx, proxyCtxKey("peerOffload"), offloadReason)
+	ctx = context.WithValue(ctx, proxyCtxKey("metrics"), requestMetrics)
+	if priority, ok := pm.requestPriority(c.Request); ok {
+		ctx = context.WithValue(ctx, proxyCtxKey("priority"), priority)
 	}
 
 	// Create a new request with the reconstructed form data
 	modifiedReq, err := http.NewRequestWithContext(
-		c.Request.Context(),
+		ctx,
 		c.Request.Method,
 		c.Request.URL.String(),
-		&requestBuffer,
+		body,
 	)
 	if err != nil {
 		pm.sendErrorResponse(c, http.StatusInternalServerError, "error creating modified request")
 		return
 	}
 
-	// Copy the headers from the original request
+	// Copy the headers from the original request, the length of a rebuilt
+	// form is not known so it is sent chunked
 	modifiedReq.Header = c.Request.Header.Clone()
-	modifiedReq.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	modifiedReq.Header.Set("Content-Type", contentType)
+	modifiedReq.Header.Del("Content-Length")
+	modifiedReq.ContentLength = contentLength
 
-	// set the content length of the body
-	modifiedReq.Header.Set("Content-Length", strconv.Itoa(requestBuffer.Len()))
-	modifiedReq.ContentLength = int64(requestBuffer.Len())
+	c.Header("X-Llama-Swap-Model", modelID)
 
-	// Use the modified request for proxying
-	if err := nextHandler(modelID, c.Writer, modifiedReq); err != nil {
-		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying request: %s", err.Error()))
-		pm.proxyLogger.Errorf("Error Proxying Request for model %s", modelID)
-		return
+	if pm.metricsMonitor != nil {
+		if err := pm.metricsMonitor.wrapHandler(modelID, c.Writer, modifiedReq, nextHandler); err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying metrics wrapped request: %s", err.Error()))
+			pm.proxyLogger.Errorf("Error Proxying Metrics Wrapped Request model %s", modelID)
+			return
+		}
+	} else {
+		if err := nextHandler(modelID, c.Writer, modifiedReq); err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying request: %s", err.Error()))
+			pm.proxyLogger.Errorf("Error Proxying Request for model %s", modelID)
+			return
+		}
 	}
 }
 
@@ -970,8 +1253,9 @@ func (pm *ProxyManager) proxyGETModelHandler(c *gin.Context) {
 	var nextHandler func(modelID string, w http.ResponseWriter, r *http.Request) error
 	var modelID string
 
-	if realModelID, found := pm.config.RealModelName(requestedModel); found {
-		processGroup, err := pm.swapProcessGroup(realModelID)
+	snap := pm.snapshot.Load()
+	if realModelID, found := snap.config.RealModelName(requestedModel); found {
+		processGroup, err := snap.swapProcessGroup(realModelID)
 		if err != nil {
 			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
 			return
@@ -1010,11 +1294,18 @@ func (pm *ProxyManager) sendErrorResponse(c *gin.Context, statusCode int, messag
 // apiKeyAuth returns a middleware that validates API keys if configured.
 // Returns a pass-through handler if no API keys are configured.
 func (pm *ProxyManager) apiKeyAuth() gin.HandlerFunc {
-	if len(pm.config.RequiredAPIKeys) == 0 {
+	requiredAPIKeys := pm.snapshot.Load().config.RequiredAPIKeys
+	if len(requiredAPIKeys) == 0 {
 		return func(c *gin.Context) { c.Next() }
 	}
 
 	return func(c *gin.Context) {
+		// the batch worker was authorized when the batch was created
+		if isBatchRequest(c.Request) {
+			c.Next()
+			return
+		}
+
 		xApiKey := c.GetHeader("x-api-key")
 
 		var bearerKey string
@@ -1046,7 +1337,7 @@ func (pm *ProxyManager) apiKeyAuth() gin.HandlerFunc {
 
 		// Validate key
 		valid := false
-		for _, key := range pm.config.RequiredAPIKeys {
+		for _, key := range requiredAPIKeys {
 			if providedKey == key {
 				valid = true
 				break
@@ -1060,6 +1351,9 @@ func (pm *ProxyManager) apiKeyAuth() gin.HandlerFunc {
 			return
 		}
 
+		// kept for the priority of the request
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), proxyCtxKey("apiKey"), providedKey))
+
 		// Strip auth headers to prevent leakage to upstream
 		c.Request.Header.Del("Authorization")
 		c.Request.Header.Del("x-api-key")
@@ -1077,7 +1371,7 @@ func (pm *ProxyManager) listRunningProcessesHandler(context *gin.Context) {
 	context.Header("Content-Type", "application/json")
 	runningProcesses := make([]gin.H, 0) // Default to an empty response.
 
-	for _, processGroup := range pm.processGroups {
+	for _, processGroup := range pm.snapshot.Load().processGroups {
 		for _, process := range processGroup.processes {
 			if process.CurrentState() == StateReady {
 				runningProcesses = append(runningProcesses, gin.H{
@@ -1102,16 +1396,11 @@ func (pm *ProxyManager) listRunningProcessesHandler(context *gin.Context) {
 }
 
 func (pm *ProxyManager) findProcessByModelName(modelName string) *Process {
-	if processGroup, ok := pm.processGroups[modelName]; ok {
-		if process, ok := processGroup.processes[modelName]; ok {
-			return process
-		}
-	}
-	return nil
+	return pm.snapshot.Load().findProcessByModelName(modelName)
 }
 
 func (pm *ProxyManager) findProcessGroupByModelID(modelID string) *ProcessGroup {
-	if pg, ok := pm.processGroups[modelID]; ok {
+	if pg, ok := pm.snapshot.Load().processGroups[modelID]; ok {
 		return pg
 	}
 	return nil
@@ -25,6 +25,11 @@ type Model struct {
 	FitPolicy      string `json:"fitPolicy,omitempty"`
 	InitialVramMB  uint64 `json:"initialVramMB,omitempty"`
 	InitialCpuMB   uint64 `json:"initialCpuMB,omitempty"`
+
+	// set while the model is starting
+	LoadingPhase   string `json:"loadingPhase,omitempty"`
+	LoadingPercent int    `json:"loadingPercent,omitempty"`
+	LoadingEtaMs   int64  `json:"loadingEtaMs,omitempty"`
 }
 
 func addApiHandlers(pm *ProxyManager) {
@@ -38,17 +43,23 @@ func addApiHandlers(pm *ProxyManager) {
 		apiGroup.POST("/models/load/*model", pm.apiLoadSingleModelHandler)
 		apiGroup.GET("/events", pm.apiSendEvents)
 		apiGroup.GET("/metrics", pm.apiGetMetrics)
+		apiGroup.GET("/scheduler/stats", pm.apiGetSchedulerStats)
 		apiGroup.GET("/version", pm.apiGetVersion)
 		apiGroup.GET("/captures/:id", pm.apiGetCapture)
 		apiGroup.GET("/ws", pm.HandleWebSocket)
 
-	// Playground endpoints
-	apiGroup.POST("/playground/chat", pm.apiPlaygroundChat)
-	apiGroup.POST("/playground/chat/clear", pm.apiPlaygroundClearChat)
-	apiGroup.GET("/playground/chat/history", pm.apiPlaygroundGetHistory)
-	apiGroup.POST("/playground/images", pm.apiPlaygroundGenerateImage)
-	apiGroup.POST("/playground/speech", pm.apiPlaygroundGenerateSpeech)
-	apiGroup.POST("/playground/transcribe", pm.apiPlaygroundTranscribeAudio)
+		// runtime model management, see proxymanager_modelapi.go
+		apiGroup.POST("/config/models/*id", pm.apiCreateModelHandler)
+		apiGroup.PUT("/config/models/*id", pm.apiReplaceModelHandler)
+		apiGroup.DELETE("/config/models/*id", pm.apiDeleteModelHandler)
+
+		// Playground endpoints
+		apiGroup.POST("/playground/chat", pm.apiPlaygroundChat)
+		apiGroup.POST("/playground/chat/clear", pm.apiPlaygroundClearChat)
+		apiGroup.GET("/playground/chat/history", pm.apiPlaygroundGetHistory)
+		apiGroup.POST("/playground/images", pm.apiPlaygroundGenerateImage)
+		apiGroup.POST("/playground/speech", pm.apiPlaygroundGenerateSpeech)
+		apiGroup.POST("/playground/transcribe", pm.apiPlaygroundTranscribeAudio)
 	}
 
 }
@@ -66,8 +77,9 @@ func (pm *ProxyManager) getModelStatus() []Model {
 	// Extract keys and sort them
 	models := []Model{}
 
-	modelIDs := make([]string, 0, len(pm.config.Models))
-	for modelID := range pm.config.Models {
+	snap := pm.snapshot.Load()
+	modelIDs := make([]string, 0, len(snap.config.Models))
+	for modelID := range snap.config.Models {
 		modelIDs = append(modelIDs, modelID)
 	}
 	sort.Strings(modelIDs)
@@ -75,11 +87,13 @@ func (pm *ProxyManager) getModelStatus() []Model {
 	// Iterate over sorted keys
 	for _, modelID := range modelIDs {
 		// Get process state
-		process := pm.findProcessByModelName(modelID)
+		process := snap.findProcessByModelName(modelID)
 		state := "unknown"
 		var measuredVramMB uint64
 		var measuredCpuMB uint64
+		var progress LoadProgress
 		if process != nil {
+			progress, _ = process.LoadProgress()
 			measuredVramMB = process.MeasuredVramMB()
 			measuredCpuMB = process.MeasuredCpuMB()
 			var stateStr string
@@ -101,15 +115,18 @@ func (pm *ProxyManager) getModelStatus() []Model {
 		}
 		models = append(models, Model{
 			Id:             modelID,
-			Name:           pm.config.Models[modelID].Name,
-			Description:    pm.config.Models[modelID].Description,
+			Name:           snap.config.Models[modelID].Name,
+			Description:    snap.config.Models[modelID].Description,
 			State:          state,
-			Unlisted:       pm.config.Models[modelID].Unlisted,
+			Unlisted:       snap.config.Models[modelID].Unlisted,
 			MeasuredVramMB: measuredVramMB,
 			MeasuredCpuMB:  measuredCpuMB,
-			FitPolicy:      pm.config.Models[modelID].FitPolicy,
-			InitialVramMB:  pm.config.Models[modelID].InitialVramMB,
-			InitialCpuMB:   pm.config.Models[modelID].InitialCpuMB,
+			FitPolicy:      snap.config.Models[modelID].FitPolicy,
+			InitialVramMB:  snap.config.Models[modelID].InitialVramMB,
+			InitialCpuMB:   snap.config.Models[modelID].InitialCpuMB,
+			LoadingPhase:   progress.Phase,
+			LoadingPercent: progress.Percent,
+			LoadingEtaMs:   progress.ETA.Milliseconds(),
 		})
 	}
 
@@ -119,6 +136,7 @@ func (pm *ProxyManager) getModelStatus() []Model {
 			for _, modelID := range peer.Models {
 				models = append(models, Model{
 					Id:     modelID,
+					State:  pm.peerProxy.PeerStatus(peerID),
 					PeerID: peerID,
 				})
 			}
@@ -134,6 +152,8 @@ const (
 	msgTypeModelStatus messageType = "modelStatus"
 	msgTypeLogData     messageType = "logData"
 	msgTypeMetrics     messageType = "metrics"
+
+	msgTypeLoadingProgress messageType = "loadingProgress"
 )
 
 type messageEnvelope struct {
@@ -193,6 +213,23 @@ func (pm *ProxyManager) apiSendEvents(c *gin.Context) {
 		}
 	}
 
+	sendLoadingProgress := func(e LoadingProgressEvent) {
+		data, err := json.Marshal(gin.H{
+			"model":   e.ProcessName,
+			"phase":   e.Phase,
+			"percent": e.Percent,
+			"etaMs":   e.ETA.Milliseconds(),
+		})
+		if err == nil {
+			select {
+			case sendBuffer <- messageEnvelope{Type: msgTypeLoadingProgress, Data: string(data)}:
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}
+
 	/**
 	 * Send updated models list
 	 */
@@ -202,6 +239,16 @@ func (pm *ProxyManager) apiSendEvents(c *gin.Context) {
 	defer event.On(func(e ConfigFileChangedEvent) {
 		sendModels()
 	})()
+	defer event.On(func(e ModelConfigChangedEvent) {
+		sendModels()
+	})()
+
+	/**
+	 * Send loading progress of starting models
+	 */
+	defer event.On(func(e LoadingProgressEvent) {
+		sendLoadingProgress(e)
+	})()
 
 	/**
 	 * Send Log data
@@ -250,15 +297,28 @@ func (pm *ProxyManager) apiGetMetrics(c *gin.Context) {
 	c.Data(http.StatusOK, "application/json", jsonData)
 }
 
+func (pm *ProxyManager) apiGetSchedulerStats(c *gin.Context) {
+	if pm.swapScheduler != nil {
+		c.JSON(http.StatusOK, pm.swapScheduler.stats())
+		return
+	}
+	stats := SwapSchedulerStats{Holding: map[string]int{}}
+	if pm.scheduler != nil {
+		stats.Swaps, stats.Evictions = pm.scheduler.SwapCounts()
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
 func (pm *ProxyManager) apiUnloadSingleModelHandler(c *gin.Context) {
 	requestedModel := strings.TrimPrefix(c.Param("model"), "/")
-	realModelName, found := pm.config.RealModelName(requestedModel)
+	snap := pm.snapshot.Load()
+	realModelName, found := snap.config.RealModelName(requestedModel)
 	if !found {
 		pm.sendErrorResponse(c, http.StatusNotFound, "Model not found")
 		return
 	}
 
-	process := pm.findProcessByModelName(realModelName)
+	process := snap.findProcessByModelName(realModelName)
 	if process == nil {
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("process not found for model %s", requestedModel))
 		return
@@ -270,14 +330,15 @@ func (pm *ProxyManager) apiUnloadSingleModelHandler(c *gin.Context) {
 
 func (pm *ProxyManager) apiLoadSingleModelHandler(c *gin.Context) {
 	requestedModel := strings.TrimPrefix(c.Param("model"), "/")
-	realModelName, found := pm.config.RealModelName(requestedModel)
+	snap := pm.snapshot.Load()
+	realModelName, found := snap.config.RealModelName(requestedModel)
 	if !found {
 		pm.sendErrorResponse(c, http.StatusNotFound, "Model not found")
 		return
 	}
 
 	// Use swapProcessGroup to load the model
-	_, err := pm.swapProcessGroup(realModelName)
+	_, err := snap.swapProcessGroup(realModelName)
 	if err != nil {
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("failed to load model: %v", err))
 		return
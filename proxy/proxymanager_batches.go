@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI compatible Files and Batches API, see batches.go
+
+func addBatchHandlers(pm *ProxyManager) {
+	filesGroup := pm.ginEngine.Group("/v1/files", pm.apiKeyAuth())
+	{
+		filesGroup.POST("", pm.batchFileUploadHandler)
+		filesGroup.GET("", pm.batchFileListHandler)
+		filesGroup.GET("/:file_id", pm.batchFileGetHandler)
+		filesGroup.GET("/:file_id/content", pm.batchFileContentHandler)
+		filesGroup.DELETE("/:file_id", pm.batchFileDeleteHandler)
+	}
+
+	batchesGroup := pm.ginEngine.Group("/v1/batches", pm.apiKeyAuth())
+	{
+		batchesGroup.POST("", pm.batchCreateHandler)
+		batchesGroup.GET("", pm.batchListHandler)
+		batchesGroup.GET("/:batch_id", pm.batchGetHandler)
+		batchesGroup.POST("/:batch_id/cancel", pm.batchCancelHandler)
+	}
+}
+
+func (pm *ProxyManager) batchFileUploadHandler(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if purpose != "batch" {
+		c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "purpose must be batch"))
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "file is required"))
+		return
+	}
+	content, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	defer content.Close()
+
+	file, err := pm.batches.createFile(header.Filename, purpose, content)
+	if err != nil {
+		pm.proxyLogger.Errorf("batches: unable to store file: %v", err)
+		c.JSON(http.StatusInternalServerError, openAIError(http.StatusInternalServerError, "unable to store file"))
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+func (pm *ProxyManager) batchFileListHandler(c *gin.Context) {
+	purpose := c.Query("purpose")
+	files := make([]batchFile, 0)
+	for _, file := range pm.batches.listFiles() {
+		if purpose == "" || file.Purpose == purpose {
+			files = append(files, file)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": files})
+}
+
+func (pm *ProxyManager) batchFileGetHandler(c *gin.Context) {
+	file, found := pm.batches.getFile(c.Param("file_id"))
+	if !found {
+		c.JSON(http.StatusNotFound, openAIError(http.StatusNotFound, "file not found"))
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+func (pm *ProxyManager) batchFileContentHandler(c *gin.Context) {
+	file, found := pm.batches.getFile(c.Param("file_id"))
+	if !found {
+		c.JSON(http.StatusNotFound, openAIError(http.StatusNotFound, "file not found"))
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=\""+file.Filename+"\"")
+	c.File(pm.batches.fileContentPath(file.ID))
+}
+
+func (pm *ProxyManager) batchFileDeleteHandler(c *gin.Context) {
+	id := c.Param("file_id")
+	if err := pm.batches.deleteFile(id); err != nil {
+		if errors.Is(err, errBatchNotFound) {
+			c.JSON(http.StatusNotFound, openAIError(http.StatusNotFound, "file not found"))
+		} else {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, err.Error()))
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "object": "file", "deleted": true})
+}
+
+func (pm *ProxyManager) batchCreateHandler(c *gin.Context) {
+	var req struct {
+		InputFileID      string            `json:"input_file_id"`
+		Endpoint         string            `json:"endpoint"`
+		CompletionWindow string            `json:"completion_window"`
+		Metadata         map[string]string `json:"metadata"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	if req.CompletionWindow == "" {
+		req.CompletionWindow = "24h"
+	}
+
+	batch, err := pm.batches.createBatch(req.InputFileID, req.Endpoint, req.CompletionWindow, req.Metadata)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+func (pm *ProxyManager) batchListHandler(c *gin.Context) {
+	limit := 20
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 100 {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "limit must be between 1 and 100"))
+			return
+		}
+		limit = parsed
+	}
+
+	batches := pm.batches.listBatches()
+	if after := c.Query("after"); after != "" {
+		for i, batch := range batches {
+			if batch.ID == after {
+				batches = batches[i+1:]
+				break
+			}
+		}
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+
+	var firstID, lastID *string
+	if len(batches) > 0 {
+		firstID, lastID = &batches[0].ID, &batches[len(batches)-1].ID
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     batches,
+		"first_id": firstID,
+		"last_id":  lastID,
+		"has_more": hasMore,
+	})
+}
+
+func (pm *ProxyManager) batchGetHandler(c *gin.Context) {
+	batch, found := pm.batches.getBatch(c.Param("batch_id"))
+	if !found {
+		c.JSON(http.StatusNotFound, openAIError(http.StatusNotFound, "batch not found"))
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+func (pm *ProxyManager) batchCancelHandler(c *gin.Context) {
+	batch, err := pm.batches.cancelBatch(c.Param("batch_id"))
+	if err != nil {
+		if errors.Is(err, errBatchNotFound) {
+			c.JSON(http.StatusNotFound, openAIError(http.StatusNotFound, "batch not found"))
+		} else {
+			c.JSON(http.StatusConflict, openAIError(http.StatusConflict, err.Error()))
+		}
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
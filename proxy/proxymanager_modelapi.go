@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/event"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+)
+
+// errModelExists is returned when creating a model that already exists
+var errModelExists = errors.New("already exists")
+
+// SetConfigPath sets the configuration file that model changes made through
+// the API are written back to when persistence is requested.
+func (pm *ProxyManager) SetConfigPath(path string) {
+	pm.Lock()
+	defer pm.Unlock()
+	pm.configPath = path
+}
+
+// ModelOverlays returns a copy of the ephemeral model changes made through the API.
+// A nil value means the model was deleted.
+func (pm *ProxyManager) ModelOverlays() map[string][]byte {
+	pm.Lock()
+	defer pm.Unlock()
+	overlays := make(map[string][]byte, len(pm.modelOverlays))
+	for modelID, data := range pm.modelOverlays {
+		overlays[modelID] = data
+	}
+	return overlays
+}
+
+// ApplyModelOverlays re-applies ephemeral model changes, typically after a
+// configuration reload, so they survive until llama-swap restarts.
+func (pm *ProxyManager) ApplyModelOverlays(overlays map[string][]byte) {
+	modelIDs := make([]string, 0, len(overlays))
+	for modelID := range overlays {
+		modelIDs = append(modelIDs, modelID)
+	}
+	sort.Strings(modelIDs)
+
+	for _, modelID := range modelIDs {
+		data := overlays[modelID]
+		var err error
+		if data == nil {
+			if _, exists := pm.snapshot.Load().config.Models[modelID]; !exists {
+				continue
+			}
+			err = pm.deleteModel(modelID, false)
+		} else {
+			err = pm.upsertModel(modelID, data, false, false)
+		}
+		if err != nil {
+			pm.proxyLogger.Warnf("<%s> unable to re-apply model overlay: %v", modelID, err)
+		}
+	}
+}
+
+func (pm *ProxyManager) apiCreateModelHandler(c *gin.Context) {
+	pm.apiUpsertModel(c, strings.TrimPrefix(c.Param("id"), "/"), true, http.StatusCreated)
+}
+
+func (pm *ProxyManager) apiReplaceModelHandler(c *gin.Context) {
+	pm.apiUpsertModel(c, strings.TrimPrefix(c.Param("id"), "/"), false, http.StatusOK)
+}
+
+func (pm *ProxyManager) apiUpsertModel(c *gin.Context, modelID string, createOnly bool, successStatus int) {
+	if modelID == "" {
+		pm.sendErrorResponse(c, http.StatusBadRequest, "model id required in path")
+		return
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, "could not read request body")
+		return
+	}
+
+	persist := c.Query("persist") == "true"
+	if err := pm.upsertModel(modelID, data, createOnly, persist); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errModelExists) {
+			status = http.StatusConflict
+		}
+		pm.sendErrorResponse(c, status, err.Error())
+		return
+	}
+
+	c.JSON(successStatus, gin.H{"msg": "ok", "model": modelID, "persisted": persist})
+}
+
+func (pm *ProxyManager) apiDeleteModelHandler(c *gin.Context) {
+	modelID := strings.TrimPrefix(c.Param("id"), "/")
+	if _, exists := pm.snapshot.Load().config.Models[modelID]; !exists {
+		pm.sendErrorResponse(c, http.StatusNotFound, "Model not found")
+		return
+	}
+
+	persist := c.Query("persist") == "true"
+	if err := pm.deleteModel(modelID, persist); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, config.ErrModelInUse) {
+			status = http.StatusConflict
+		}
+		pm.sendErrorResponse(c, status, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"msg": "ok", "model": modelID, "persisted": persist})
+}
+
+// upsertModel validates a model definition and swaps it in without reloading
+// any other model. With createOnly an existing model is not replaced. When
+// persist is true the definition is also written to the configuration file,
+// otherwise it is kept as an ephemeral overlay.
+func (pm *ProxyManager) upsertModel(modelID string, data []byte, createOnly, persist bool) error {
+	pm.modelsMu.Lock()
+	defer pm.modelsMu.Unlock()
+
+	pm.Lock()
+	configPath := pm.configPath
+	pm.Unlock()
+	base := pm.snapshot.Load().config
+
+	if _, exists := base.Models[modelID]; exists && createOnly {
+		return fmt.Errorf("model %s %w", modelID, errModelExists)
+	}
+
+	if persist && configPath == "" {
+		return fmt.Errorf("unable to persist model %s: configuration file path is not known", modelID)
+	}
+
+	modelConfig, err := config.ParseModelConfig(base, modelID, data)
+	if err != nil {
+		return fmt.Errorf("invalid model %s: %w", modelID, err)
+	}
+
+	newConfig, err := base.WithModel(modelID, modelConfig)
+	if err != nil {
+		return fmt.Errorf("invalid model %s: %w", modelID, err)
+	}
+
+	if persist {
+		if err := config.SaveModelToFile(configPath, modelID, data); err != nil {
+			return fmt.Errorf("unable to persist model %s: %w", modelID, err)
+		}
+	}
+
+	processGroup := NewProcessGroup(modelID, newConfig, pm.proxyLogger, pm.upstreamLogger)
+	processGroup.SetMemoryTracker(pm.memoryTracker)
+	processGroup.SetLoadTimeTracker(pm.loadTimeTracker)
+	if pm.scheduler != nil {
+		processGroup.SetScheduler(pm.scheduler)
+	} else if hasVramModels(map[string]config.ModelConfig{modelID: modelConfig}) {
+		pm.proxyLogger.Warnf("<%s> fitPolicy %s needs the scheduler which is not running, reload the configuration to enable it", modelID, modelConfig.FitPolicy)
+	}
+
+	pm.replaceProcessGroup(modelID, newConfig, processGroup, data, persist)
+	pm.proxyLogger.Infof("<%s> model configuration updated through API (persisted: %v)", modelID, persist)
+	return nil
+}
+
+// deleteModel stops and removes a model
+func (pm *ProxyManager) deleteModel(modelID string, persist bool) error {
+	pm.modelsMu.Lock()
+	defer pm.modelsMu.Unlock()
+
+	pm.Lock()
+	configPath := pm.configPath
+	pm.Unlock()
+	base := pm.snapshot.Load().config
+
+	if _, found := base.Models[modelID]; !found {
+		return fmt.Errorf("model %s not found", modelID)
+	}
+
+	newConfig, err := base.WithoutModel(modelID)
+	if err != nil {
+		return fmt.Errorf("unable to remove model %s: %w", modelID, err)
+	}
+
+	if persist {
+		if configPath == "" {
+			return fmt.Errorf("unable to persist model %s: configuration file path is not known", modelID)
+		}
+		if err := config.SaveModelToFile(configPath, modelID, nil); err != nil {
+			return fmt.Errorf("unable to persist model %s: %w", modelID, err)
+		}
+	}
+
+	pm.replaceProcessGroup(modelID, newConfig, nil, nil, persist)
+	pm.proxyLogger.Infof("<%s> model removed through API (persisted: %v)", modelID, persist)
+	return nil
+}
+
+// replaceProcessGroup stops the current process group for modelID, if any, and
+// publishes the new configuration and process group. A nil processGroup removes
+// the model. The caller holds pm.modelsMu.
+func (pm *ProxyManager) replaceProcessGroup(modelID string, newConfig config.Config, processGroup *ProcessGroup, data []byte, persist bool) {
+	current := pm.snapshot.Load()
+	oldGroup := current.processGroups[modelID]
+
+	// wait for in-flight requests so the old upstream releases its port before
+	// the new definition can be started
+	if oldGroup != nil {
+		oldGroup.StopProcesses(StopWaitForInflightRequest)
+	}
+
+	processGroups := make(map[string]*ProcessGroup, len(current.processGroups)+1)
+	for id, pg := range current.processGroups {
+		if id != modelID {
+			processGroups[id] = pg
+		}
+	}
+	if processGroup != nil {
+		processGroups[modelID] = processGroup
+	}
+
+	// a changed definition gets a batcher with its own embeddingBatch settings
+	embeddingBatchers := make(map[string]*embeddingBatcher, len(current.embeddingBatchers)+1)
+	for id, batcher := range current.embeddingBatchers {
+		if id != modelID {
+			embeddingBatchers[id] = batcher
+		}
+	}
+	if modelConfig, found := newConfig.Models[modelID]; found && modelConfig.EmbeddingBatch.Enabled {
+		embeddingBatchers[modelID] = newEmbeddingBatcher(modelConfig.EmbeddingBatch)
+	}
+
+	next := &proxySnapshot{
+		config:            newConfig,
+		processGroups:     processGroups,
+		embeddingBatchers: embeddingBatchers,
+		responseCache:     current.responseCache,
+	}
+	if next.responseCache == nil && newConfig.Models[modelID].ResponseCache {
+		cache, err := newResponseCache(newConfig.ResponseCache)
+		if err != nil {
+			pm.proxyLogger.Errorf("<%s> response cache disabled: %v", modelID, err)
+		} else {
+			next.responseCache = cache
+		}
+	}
+	pm.snapshot.Store(next)
+
+	pm.Lock()
+	if persist {
+		delete(pm.modelOverlays, modelID)
+	} else {
+		pm.modelOverlays[modelID] = data
+	}
+	pm.Unlock()
+
+	// a request may have restarted the old process while it was draining
+	if oldGroup != nil {
+		oldGroup.Shutdown()
+	}
+
+	event.Emit(ModelConfigChangedEvent{ModelID: modelID, Removed: processGroup == nil})
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func modelDefinitionJSON(message string) string {
+	port := getTestPort()
+	return fmt.Sprintf(`{"cmd": "%s --port %d --silent --respond %s", "proxy": "http://127.0.0.1:%d"}`,
+		filepath.ToSlash(simpleResponderPath), port, message, port)
+}
+
+func TestProxyManager_ModelAPI(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	do := func(method, path, body string) *TestResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	chat := func(model string) *TestResponseRecorder {
+		return do("POST", "/v1/chat/completions", fmt.Sprintf(`{"model":"%s"}`, model))
+	}
+
+	t.Run("create", func(t *testing.T) {
+		w := do("POST", "/api/config/models/model2", modelDefinitionJSON("model2"))
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		w = chat("model2")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "model2")
+
+		w = do("POST", "/api/config/models/model2", modelDefinitionJSON("model2"))
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		w := do("PUT", "/api/config/models/model2", modelDefinitionJSON("replaced"))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = chat("model2")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "replaced")
+
+		// other models are not affected
+		w = chat("model1")
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("invalid definition", func(t *testing.T) {
+		w := do("PUT", "/api/config/models/model3", `{"cmd": "${missing}"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		_, found := proxy.snapshot.Load().config.Models["model3"]
+		assert.False(t, found)
+	})
+
+	t.Run("persist without config path", func(t *testing.T) {
+		w := do("PUT", "/api/config/models/model3?persist=true", modelDefinitionJSON("model3"))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := do("DELETE", "/api/config/models/model2", "")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = chat("model2")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = do("DELETE", "/api/config/models/model2", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("concurrent changes", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for _, modelID := range []string{"model5", "model6", "model7"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				do("PUT", "/api/config/models/"+modelID, modelDefinitionJSON(modelID))
+				chat("model1")
+			}()
+		}
+		wg.Wait()
+
+		// no change is lost
+		for _, modelID := range []string{"model5", "model6", "model7"} {
+			_, found := proxy.snapshot.Load().config.Models[modelID]
+			assert.True(t, found, modelID)
+		}
+	})
+
+	t.Run("concurrent creates", func(t *testing.T) {
+		var wg sync.WaitGroup
+		codes := make(chan int, 2)
+		for _, respond := range []string{"first", "second"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes <- do("POST", "/api/config/models/model8", modelDefinitionJSON(respond)).Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		var got []int
+		for code := range codes {
+			got = append(got, code)
+		}
+		assert.ElementsMatch(t, []int{http.StatusCreated, http.StatusConflict}, got, "only one create wins")
+	})
+
+	t.Run("overlays", func(t *testing.T) {
+		do("PUT", "/api/config/models/model4", modelDefinitionJSON("model4"))
+		overlays := proxy.ModelOverlays()
+		assert.Contains(t, overlays, "model4")
+		assert.Contains(t, overlays, "model2")
+		assert.Nil(t, overlays["model2"])
+
+		reloaded := New(conf)
+		defer reloaded.StopProcesses(StopWaitForInflightRequest)
+		reloaded.ApplyModelOverlays(overlays)
+		_, found := reloaded.snapshot.Load().config.Models["model4"]
+		assert.True(t, found)
+	})
+}
+
+func TestProxyManager_ModelAPIPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("models: {}\n"), 0644))
+
+	conf := config.AddDefaultGroupToConfig(config.Config{HealthCheckTimeout: 15, LogLevel: "error"})
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+	proxy.SetConfigPath(path)
+
+	req := httptest.NewRequest("PUT", "/api/config/models/model1?persist=true", bytes.NewBufferString(modelDefinitionJSON("model1")))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, proxy.ModelOverlays())
+
+	loaded, err := config.LoadConfig(path)
+	require.NoError(t, err)
+	_, found := loaded.Models["model1"]
+	assert.True(t, found)
+}
+
+func TestProxyManager_ModelAPIDeleteInUse(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+  model2:
+    cmd: ./server --port ${PORT}
+pools:
+  pool1:
+    models: [model1, model2]
+`))
+	require.NoError(t, err)
+	proxy := New(cfg)
+
+	req := httptest.NewRequest("DELETE", "/api/config/models/model2", nil)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "pools.pool1: unknown model model2")
+
+	_, found := proxy.snapshot.Load().config.Models["model2"]
+	assert.True(t, found)
+}
+
+func TestProxyManager_ModelAPILoadingBackend(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+loadingProgress:
+  backends:
+    vllm:
+      cmd: vllm serve
+      milestones:
+        - phase: loading weights
+          regex: Loading weights
+          percent: 50
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+`))
+	require.NoError(t, err)
+	proxy := New(cfg)
+
+	req := httptest.NewRequest("POST", "/api/config/models/model2", strings.NewReader(`{"cmd": "./server --port ${PORT}", "loadingBackend": "vllm"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "vllm", proxy.snapshot.Load().config.Models["model2"].LoadingBackend)
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ollama compatible API. The routes are served under ollama.prefix so they do
+// not collide with llama-swap's own /api group. Inference requests are
+// translated and passed to proxyInferenceHandler so they use the same swap,
+// scheduling and metrics as the OpenAI endpoints.
+
+func addOllamaHandlers(pm *ProxyManager) {
+	ollamaGroup := pm.ginEngine.Group(pm.snapshot.Load().config.Ollama.Prefix, pm.apiKeyAuth())
+	{
+		ollamaGroup.GET("/api/tags", pm.ollamaTagsHandler)
+		ollamaGroup.POST("/api/show", pm.ollamaShowHandler)
+		ollamaGroup.GET("/api/ps", pm.ollamaPsHandler)
+		ollamaGroup.POST("/api/chat", pm.ollamaChatHandler)
+		ollamaGroup.POST("/api/generate", pm.ollamaGenerateHandler)
+		ollamaGroup.POST("/api/embed", pm.ollamaEmbedHandler)
+	}
+}
+
+// ServeOllamaHTTP serves the Ollama routes without the prefix. It is used for
+// the optional ollama.listen address.
+func (pm *ProxyManager) ServeOllamaHTTP(w http.ResponseWriter, r *http.Request) {
+	ollamaConfig := pm.snapshot.Load().config.Ollama
+	if !ollamaConfig.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	r.URL.Path = ollamaConfig.Prefix + r.URL.Path
+	r.URL.RawPath = ""
+	pm.ServeHTTP(w, r)
+}
+
+// ollamaModelName maps an Ollama model name to a name llama-swap knows.
+// Ollama clients often add the default :latest tag.
+func (pm *ProxyManager) ollamaModelName(name string) string {
+	snap := pm.snapshot.Load()
+	known := func(name string) bool {
+		if _, found := snap.config.RealModelName(name); found {
+			return true
+		}
+		if _, found := snap.config.Routes[name]; found {
+			return true
+		}
+		if _, found := snap.config.Pools[name]; found {
+			return true
+		}
+		return pm.peerProxy != nil && pm.peerProxy.HasPeerModel(name)
+	}
+	if known(name) {
+		return name
+	}
+	if trimmed, found := strings.CutSuffix(name, ":latest"); found && known(trimmed) {
+		return trimmed
+	}
+	return name
+}
+
+func ollamaModelDetails() gin.H {
+	return gin.H{
+		"parent_model":       "",
+		"format":             "gguf",
+		"family":             "",
+		"families":           nil,
+		"parameter_size":     "",
+		"quantization_level": "",
+	}
+}
+
+func (pm *ProxyManager) ollamaTagsHandler(c *gin.Context) {
+	snap := pm.snapshot.Load()
+	ids := make([]string, 0, len(snap.config.Models))
+	for id, modelConfig := range snap.config.Models {
+		for name, preset := range modelConfig.Presets {
+			if !preset.Unlisted {
+				ids = append(ids, name)
+			}
+		}
+		if modelConfig.Unlisted {
+			continue
+		}
+		ids = append(ids, id)
+		if snap.config.IncludeAliasesInList {
+			for _, alias := range modelConfig.Aliases {
+				if alias := strings.TrimSpace(alias); alias != "" {
+					ids = append(ids, alias)
+				}
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	modifiedAt := time.Now().UTC().Format(time.RFC3339)
+	models := make([]gin.H, 0, len(ids))
+	for _, id := range ids {
+		models = append(models, gin.H{
+			"name":        id,
+			"model":       id,
+			"modified_at": modifiedAt,
+			"size":        0,
+			"digest":      "",
+			"details":     ollamaModelDetails(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+func (pm *ProxyManager) ollamaShowHandler(c *gin.Context) {
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+
+	snap := pm.snapshot.Load()
+	modelID, found := snap.config.RealModelName(pm.ollamaModelName(name))
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "model '" + name + "' not found"})
+		return
+	}
+	modelConfig := snap.config.Models[modelID]
+
+	modelInfo := gin.H{}
+	if modelConfig.Name != "" {
+		modelInfo["general.name"] = modelConfig.Name
+	}
+	if modelConfig.Description != "" {
+		modelInfo["general.description"] = modelConfig.Description
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"modelfile":    "",
+		"parameters":   "",
+		"template":     "",
+		"details":      ollamaModelDetails(),
+		"model_info":   modelInfo,
+		"capabilities": []string{"completion"},
+		"modified_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (pm *ProxyManager) ollamaPsHandler(c *gin.Context) {
+	const mb = 1024 * 1024
+	models := make([]gin.H, 0)
+	for _, processGroup := range pm.snapshot.Load().processGroups {
+		for _, process := range processGroup.processes {
+			if process.CurrentState() != StateReady {
+				continue
+			}
+
+			// models without a ttl do not expire
+			expiresAt := time.Time{}
+			if ttl := process.config.UnloadAfter; ttl > 0 {
+				expiresAt = process.LastRequestHandled().Add(time.Duration(ttl) * time.Second)
+			}
+			vramMB := process.MeasuredVramMB()
+			models = append(models, gin.H{
+				"name":       process.ID,
+				"model":      process.ID,
+				"size":       (vramMB + process.MeasuredCpuMB()) * mb,
+				"size_vram":  vramMB * mb,
+				"digest":     "",
+				"details":    ollamaModelDetails(),
+				"expires_at": expiresAt.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i]["name"].(string) < models[j]["name"].(string)
+	})
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+func (pm *ProxyManager) ollamaChatHandler(c *gin.Context) {
+	pm.proxyOllamaRequest(c, ollamaChat)
+}
+
+func (pm *ProxyManager) ollamaGenerateHandler(c *gin.Context) {
+	pm.proxyOllamaRequest(c, ollamaGenerate)
+}
+
+func (pm *ProxyManager) ollamaEmbedHandler(c *gin.Context) {
+	pm.proxyOllamaRequest(c, ollamaEmbed)
+}
+
+// proxyOllamaRequest translates an Ollama inference request to the OpenAI
+// API and runs it through proxyInferenceHandler with a response translator
+func (pm *ProxyManager) proxyOllamaRequest(c *gin.Context, endpoint string) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "could not read request body"})
+		return
+	}
+	req, err := parseOllamaRequest(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	model := pm.ollamaModelName(req.Model)
+	path := "/v1/chat/completions"
+	switch endpoint {
+	case ollamaEmbed:
+		path = "/v1/embeddings"
+		body, err = ollamaEmbedToOpenAIRequest(req, model)
+	default:
+		body, err = ollamaChatToOpenAIRequest(req, model, endpoint == ollamaGenerate)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Request.URL.Path = path
+	c.Request.URL.RawPath = ""
+	// the translator needs an uncompressed response
+	c.Request.Header.Set("Accept-Encoding", "identity")
+	c.Request.Header.Set("Accept", "application/json")
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	c.Request.Header.Set("content-length", strconv.Itoa(len(body)))
+	c.Request.ContentLength = int64(len(body))
+
+	writer := newOllamaResponseWriter(c.Writer, endpoint, req.Model)
+	defer writer.finish()
+	c.Writer = writer
+	pm.proxyInferenceHandler(c)
+}
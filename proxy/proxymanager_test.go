@@ -488,7 +488,7 @@ func TestProxyManager_Unload(t *testing.T) {
 
 	require.Equal(t, http.StatusOK, w.Code, "request should succeed, got: %s", w.Body.String())
 
-	processGroup := proxy.processGroups["model1"]
+	processGroup := proxy.snapshot.Load().processGroups["model1"]
 	require.NotNil(t, processGroup, "process group should exist")
 	process := processGroup.processes["model1"]
 	require.NotNil(t, process, "model1 process should exist")
@@ -500,12 +500,12 @@ func TestProxyManager_Unload(t *testing.T) {
 	assert.Equal(t, w.Body.String(), "OK")
 
 	select {
-	case <-proxy.processGroups["model1"].processes["model1"].cmdWaitChan:
+	case <-proxy.snapshot.Load().processGroups["model1"].processes["model1"].cmdWaitChan:
 		// good
 	case <-time.After(2 * time.Second):
 		t.Fatal("timeout waiting for model1 to stop")
 	}
-	assert.Equal(t, proxy.processGroups["model1"].processes["model1"].CurrentState(), StateStopped)
+	assert.Equal(t, proxy.snapshot.Load().processGroups["model1"].processes["model1"].CurrentState(), StateStopped)
 }
 
 func TestProxyManager_UnloadSingleModel(t *testing.T) {
@@ -538,12 +538,12 @@ func TestProxyManager_UnloadSingleModel(t *testing.T) {
 	}
 
 	// Process groups are now keyed by model ID, not group ID
-	require.NotNil(t, proxy.processGroups["model1"], "model1 process group should exist")
-	require.NotNil(t, proxy.processGroups["model2"], "model2 process group should exist")
-	require.NotNil(t, proxy.processGroups["model1"].processes["model1"], "model1 process should exist")
-	require.NotNil(t, proxy.processGroups["model2"].processes["model2"], "model2 process should exist")
-	assert.Equal(t, StateReady, proxy.processGroups["model1"].processes["model1"].CurrentState())
-	assert.Equal(t, StateReady, proxy.processGroups["model2"].processes["model2"].CurrentState())
+	require.NotNil(t, proxy.snapshot.Load().processGroups["model1"], "model1 process group should exist")
+	require.NotNil(t, proxy.snapshot.Load().processGroups["model2"], "model2 process group should exist")
+	require.NotNil(t, proxy.snapshot.Load().processGroups["model1"].processes["model1"], "model1 process should exist")
+	require.NotNil(t, proxy.snapshot.Load().processGroups["model2"].processes["model2"], "model2 process should exist")
+	assert.Equal(t, StateReady, proxy.snapshot.Load().processGroups["model1"].processes["model1"].CurrentState())
+	assert.Equal(t, StateReady, proxy.snapshot.Load().processGroups["model2"].processes["model2"].CurrentState())
 
 	req := httptest.NewRequest("POST", "/api/models/unload/model1", nil)
 	w := CreateTestResponseRecorder()
@@ -554,14 +554,14 @@ func TestProxyManager_UnloadSingleModel(t *testing.T) {
 	}
 
 	select {
-	case <-proxy.processGroups["model1"].processes["model1"].cmdWaitChan:
+	case <-proxy.snapshot.Load().processGroups["model1"].processes["model1"].cmdWaitChan:
 		// good
 	case <-time.After(2 * time.Second):
 		t.Fatal("timeout waiting for model1 to stop")
 	}
 
-	assert.Equal(t, proxy.processGroups["model1"].processes["model1"].CurrentState(), StateStopped)
-	assert.Equal(t, proxy.processGroups["model2"].processes["model2"].CurrentState(), StateReady)
+	assert.Equal(t, proxy.snapshot.Load().processGroups["model1"].processes["model1"].CurrentState(), StateStopped)
+	assert.Equal(t, proxy.snapshot.Load().processGroups["model2"].processes["model2"].CurrentState(), StateReady)
 }
 
 // Test issue #61 `Listing the current list of models and the loaded model.`
@@ -681,12 +681,10 @@ func TestProxyManager_AudioTranscriptionHandler(t *testing.T) {
 
 	// Verify the response
 	assert.Equal(t, http.StatusOK, rec.Code)
-	var response map[string]string
-	err = json.Unmarshal(rec.Body.Bytes(), &response)
-	assert.NoError(t, err)
-	assert.Equal(t, "TheExpectedModel", response["model"])
-	assert.Equal(t, response["text"], fmt.Sprintf("The length of the file is %d bytes", contentLength)) // matches simple-responder
-	assert.Equal(t, strconv.Itoa(370+contentLength), response["h_content_length"])
+	response := rec.Body.String()
+	assert.Equal(t, "TheExpectedModel", gjson.Get(response, "model").String())
+	assert.Equal(t, gjson.Get(response, "text").String(), fmt.Sprintf("The length of the file is %d bytes", contentLength)) // matches simple-responder
+	assert.Equal(t, strconv.Itoa(370+contentLength), gjson.Get(response, "h_content_length").String())
 }
 
 // Test useModelName in configuration sends overrides what is sent to upstream
@@ -750,10 +748,7 @@ func TestProxyManager_UseModelName(t *testing.T) {
 
 		// Verify the response
 		assert.Equal(t, http.StatusOK, rec.Code)
-		var response map[string]string
-		err = json.Unmarshal(rec.Body.Bytes(), &response)
-		assert.NoError(t, err)
-		assert.Equal(t, upstreamModelName, response["model"])
+		assert.Equal(t, upstreamModelName, gjson.Get(rec.Body.String(), "model").String())
 	})
 }
 
@@ -1044,16 +1039,16 @@ models:
 		}
 	}
 	// make sure they are both loaded (process groups are keyed by model ID)
-	_, foundModel1 := proxy.processGroups["model1"]
-	_, foundModel2 := proxy.processGroups["model2"]
+	_, foundModel1 := proxy.snapshot.Load().processGroups["model1"]
+	_, foundModel2 := proxy.snapshot.Load().processGroups["model2"]
 	if !assert.True(t, foundModel1, "model1 process group should exist") {
 		return
 	}
 	if !assert.True(t, foundModel2, "model2 process group should exist") {
 		return
 	}
-	assert.Equal(t, StateReady, proxy.processGroups["model1"].processes["model1"].CurrentState())
-	assert.Equal(t, StateReady, proxy.processGroups["model2"].processes["model2"].CurrentState())
+	assert.Equal(t, StateReady, proxy.snapshot.Load().processGroups["model1"].processes["model1"].CurrentState())
+	assert.Equal(t, StateReady, proxy.snapshot.Load().processGroups["model2"].processes["model2"].CurrentState())
 }
 
 func TestProxyManager_StreamingEndpointsReturnNoBufferingHeader(t *testing.T) {
@@ -35,6 +35,9 @@ type UIModel struct {
 	Aliases     []string
 	State       string
 	Unlisted    bool
+
+	// phase and percentage while the model is starting
+	Loading string
 }
 
 type UIPeerModel struct {
@@ -73,11 +76,21 @@ type UIRunningProcess struct {
 	MeasuredCpuMB  string
 }
 
+type UIBatch struct {
+	ID        string
+	Endpoint  string
+	Status    string
+	Progress  string
+	Failed    int
+	CreatedAt string
+}
+
 type UIPageData struct {
 	NavItems                   []UINavigationItem
 	VersionInfo                UIVersionInfo
 	Models                     []UIModel
 	RunningProcesses           []UIRunningProcess
+	Batches                    []UIBatch
 	Logs                       string
 	PlaygroundTab              string
 	PlaygroundMock             bool
@@ -125,6 +138,7 @@ func (pm *ProxyManager) uiModelsPageHandler(c *gin.Context) {
 func (pm *ProxyManager) uiRunningPageHandler(c *gin.Context) {
 	data := pm.uiPageData("/ui/running")
 	data.RunningProcesses = pm.uiRunningList()
+	data.Batches = pm.uiBatchList()
 	pm.renderUITemplate(c, "pages/running", data)
 }
 
@@ -167,6 +181,7 @@ func (pm *ProxyManager) uiModelsPartialHandler(c *gin.Context) {
 func (pm *ProxyManager) uiRunningPartialHandler(c *gin.Context) {
 	data := pm.uiPageData("/ui/running")
 	data.RunningProcesses = pm.uiRunningList()
+	data.Batches = pm.uiBatchList()
 	pm.renderUITemplate(c, "partials/running", data)
 }
 
@@ -378,8 +393,9 @@ func recommendationForModel(fitPolicy string, initialCpuMB uint64, measuredCpuMB
 }
 
 func (pm *ProxyManager) uiRecommendationData() ([]UIRecommendationModel, []string) {
-	modelIDs := make([]string, 0, len(pm.config.Models))
-	for modelID := range pm.config.Models {
+	snap := pm.snapshot.Load()
+	modelIDs := make([]string, 0, len(snap.config.Models))
+	for modelID := range snap.config.Models {
 		modelIDs = append(modelIDs, modelID)
 	}
 	sort.Strings(modelIDs)
@@ -390,12 +406,12 @@ func (pm *ProxyManager) uiRecommendationData() ([]UIRecommendationModel, []strin
 	perGPUUsage := make(map[int]uint64)
 
 	for _, modelID := range modelIDs {
-		modelConfig := pm.config.Models[modelID]
+		modelConfig := snap.config.Models[modelID]
 		var measuredVram uint64
 		var measuredCpu uint64
 		assignedGPU := -1
 		hasMeasurements := false
-		processGroup := pm.findProcessGroupByModelID(modelID)
+		processGroup := snap.processGroups[modelID]
 		if processGroup != nil {
 			process := processGroup.processes[modelID]
 			if process != nil {
@@ -444,13 +460,13 @@ func (pm *ProxyManager) uiRecommendationData() ([]UIRecommendationModel, []strin
 	}
 
 	notes := []string{}
-	if pm.config.HostRamCapMB > 0 && totalMeasuredHost > pm.config.HostRamCapMB {
-		notes = append(notes, fmt.Sprintf("Host RAM cap is %d MB, but measured host usage totals %d MB for non-spill models.", pm.config.HostRamCapMB, totalMeasuredHost))
+	if snap.config.HostRamCapMB > 0 && totalMeasuredHost > snap.config.HostRamCapMB {
+		notes = append(notes, fmt.Sprintf("Host RAM cap is %d MB, but measured host usage totals %d MB for non-spill models.", snap.config.HostRamCapMB, totalMeasuredHost))
 	}
-	if pm.config.GpuVramCapMB > 0 && totalMeasuredVram > pm.config.GpuVramCapMB {
-		notes = append(notes, fmt.Sprintf("GPU VRAM cap is %d MB, but measured VRAM usage totals %d MB.", pm.config.GpuVramCapMB, totalMeasuredVram))
+	if snap.config.GpuVramCapMB > 0 && totalMeasuredVram > snap.config.GpuVramCapMB {
+		notes = append(notes, fmt.Sprintf("GPU VRAM cap is %d MB, but measured VRAM usage totals %d MB.", snap.config.GpuVramCapMB, totalMeasuredVram))
 	}
-	for index, capMB := range pm.config.GpuVramCapsMB {
+	for index, capMB := range snap.config.GpuVramCapsMB {
 		if capMB == 0 {
 			continue
 		}
@@ -476,6 +492,8 @@ type UIActivityMetric struct {
 	HasCapture       bool
 	HasCachedTokens  bool
 	CachedTokenValue int
+	CacheHit         bool
+	PeerOffload      string
 }
 
 type UIActivityCapture struct {
@@ -498,10 +516,11 @@ type UIActivityCapture struct {
 }
 
 func (pm *ProxyManager) uiModelsList() []UIModel {
-	models := make([]UIModel, 0, len(pm.config.Models))
-	for id, modelConfig := range pm.config.Models {
+	snap := pm.snapshot.Load()
+	models := make([]UIModel, 0, len(snap.config.Models))
+	for id, modelConfig := range snap.config.Models {
 		aliases := []string{}
-		if pm.config.IncludeAliasesInList {
+		if snap.config.IncludeAliasesInList {
 			for _, alias := range modelConfig.Aliases {
 				alias = strings.TrimSpace(alias)
 				if alias != "" {
@@ -512,7 +531,11 @@ func (pm *ProxyManager) uiModelsList() []UIModel {
 
 		// Determine model state
 		state := "stopped"
-		if process := pm.findProcessByModelName(id); process != nil {
+		loading := ""
+		if process := snap.findProcessByModelName(id); process != nil {
+			if progress, ok := process.LoadProgress(); ok {
+				loading = progress.String()
+			}
 			processState := process.CurrentState()
 			switch processState {
 			case StateReady:
@@ -534,6 +557,7 @@ func (pm *ProxyManager) uiModelsList() []UIModel {
 			Aliases:     aliases,
 			State:       state,
 			Unlisted:    modelConfig.Unlisted,
+			Loading:     loading,
 		})
 	}
 
@@ -559,7 +583,7 @@ func (pm *ProxyManager) uiPeerModelsList() []UIPeerModel {
 				PeerID:    peerID,
 				ModelID:   modelID,
 				Endpoint:  peer.Proxy,
-				Available: true, // Assume available if listed
+				Available: pm.peerProxy.PeerStatus(peerID) != PeerStatusDown,
 			})
 		}
 	}
@@ -576,7 +600,7 @@ func (pm *ProxyManager) uiPeerModelsList() []UIPeerModel {
 
 func (pm *ProxyManager) uiRunningList() []UIRunningProcess {
 	processes := make([]UIRunningProcess, 0)
-	for _, processGroup := range pm.processGroups {
+	for _, processGroup := range pm.snapshot.Load().processGroups {
 		for _, process := range processGroup.processes {
 			if process.CurrentState() != StateReady {
 				continue
@@ -605,6 +629,30 @@ func (pm *ProxyManager) uiRunningList() []UIRunningProcess {
 	return processes
 }
 
+func (pm *ProxyManager) uiBatchList() []UIBatch {
+	if pm.batches == nil {
+		return nil
+	}
+	batches := pm.batches.listBatches()
+	result := make([]UIBatch, 0, len(batches))
+	for _, batch := range batches {
+		counts := batch.RequestCounts
+		progress := fmt.Sprintf("%d / %d", counts.Completed+counts.Failed, counts.Total)
+		if counts.Total > 0 {
+			progress += fmt.Sprintf(" (%d%%)", (counts.Completed+counts.Failed)*100/counts.Total)
+		}
+		result = append(result, UIBatch{
+			ID:        batch.ID,
+			Endpoint:  batch.Endpoint,
+			Status:    batch.Status,
+			Progress:  progress,
+			Failed:    counts.Failed,
+			CreatedAt: time.Unix(batch.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result
+}
+
 func (pm *ProxyManager) uiActivityMetrics() []UIActivityMetric {
 	metrics := pm.metricsMonitor.getMetrics()
 	if len(metrics) == 0 {
@@ -633,6 +681,8 @@ func (pm *ProxyManager) uiActivityMetrics() []UIActivityMetric {
 			GenerationSpeed: formatSpeed(metric.TokensPerSecond),
 			Duration:        formatDuration(metric.DurationMs),
 			HasCapture:      metric.HasCapture,
+			CacheHit:        metric.CacheHit,
+			PeerOffload:     metric.PeerOffload,
 		})
 	}
 	return result
@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// realtimeFirstMessageTimeout is how long a session without a model in the
+// query string has to send its first message
+const realtimeFirstMessageTimeout = 10 * time.Second
+
+var realtimeUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true // requests are authorized with API keys
+	},
+}
+
+// realtimeTarget is the model a realtime session is proxied to
+type realtimeTarget struct {
+	modelID        string
+	requestedModel string
+	routeName      string
+	upstreamModel  string
+	peerOffload    string
+	next           func(modelID string, w http.ResponseWriter, r *http.Request) error
+}
+
+// proxyRealtimeHandler proxies OpenAI /v1/realtime style WebSocket sessions.
+// The model comes from the model query parameter or from the first message of
+// the session. The upstream connection is made through the same handlers as
+// HTTP requests so the model is swapped in, the swap scheduler is respected
+// and the session counts as an in flight request until it ends.
+func (pm *ProxyManager) proxyRealtimeHandler(c *gin.Context) {
+	pm.interactiveRequests.Add(1)
+	defer pm.interactiveRequests.Add(-1)
+
+	query := c.Request.URL.Query()
+
+	// with a model in the query string errors are sent before the upgrade
+	var target *realtimeTarget
+	if requestedModel := query.Get("model"); requestedModel != "" {
+		var status int
+		var err error
+		if target, status, err = pm.realtimeTarget(requestedModel, c.Request.URL.Path); err != nil {
+			pm.sendErrorResponse(c, status, err.Error())
+			return
+		}
+		query.Set("model", target.upstreamModel)
+	}
+
+	var responseHeader http.Header
+	if protocols := websocket.Subprotocols(c.Request); len(protocols) > 0 {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {protocols[0]}}
+	}
+	client, err := realtimeUpgrader.Upgrade(c.Writer, c.Request, responseHeader)
+	if err != nil {
+		// the upgrader already sent an error response
+		pm.proxyLogger.Debugf("realtime upgrade failed: %v", err)
+		return
+	}
+	defer client.Close()
+
+	var firstMessage []byte
+	if target == nil {
+		client.SetReadDeadline(time.Now().Add(realtimeFirstMessageTimeout))
+		if _, firstMessage, err = client.ReadMessage(); err != nil {
+			pm.proxyLogger.Debugf("realtime session closed before the first message: %v", err)
+			return
+		}
+		client.SetReadDeadline(time.Time{})
+
+		requestedModel := realtimeSessionModel(firstMessage)
+		if requestedModel == "" {
+			sendRealtimeError(client, "missing model in the model query parameter or the session's first message")
+			return
+		}
+		if target, _, err = pm.realtimeTarget(requestedModel, c.Request.URL.Path); err != nil {
+			sendRealtimeError(client, err.Error())
+			return
+		}
+		if target.upstreamModel != requestedModel {
+			firstMessage = setRealtimeSessionModel(firstMessage, target.upstreamModel)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	ctx = context.WithValue(ctx, proxyCtxKey("model"), target.modelID)
+	ctx = context.WithValue(ctx, proxyCtxKey("route"), target.routeName)
+	ctx = context.WithValue(ctx, proxyCtxKey("preset"), pm.presetName(target.requestedModel))
+	ctx = context.WithValue(ctx, proxyCtxKey("peerOffload"), target.peerOffload)
+	if priority, ok := pm.requestPriority(c.Request); ok {
+		ctx = context.WithValue(ctx, proxyCtxKey("priority"), priority)
+	}
+
+	upstreamURL := url.URL{Scheme: "ws", Host: "llama-swap", Path: c.Request.URL.Path, RawQuery: query.Encode()}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := target.next(target.modelID, w, r); err != nil {
+			http.Error(w, fmt.Sprintf("error proxying request: %s", err.Error()), http.StatusBadGateway)
+		}
+	})
+	upstream, closeUpstream, err := dialRealtime(ctx, handler, upstreamURL.String(), realtimeUpstreamHeader(c.Request.Header), websocket.Subprotocols(c.Request))
+	if err != nil {
+		pm.proxyLogger.Warnf("<%s> unable to open realtime session: %v", target.modelID, err)
+		sendRealtimeError(client, fmt.Sprintf("unable to open realtime session: %s", err.Error()))
+		return
+	}
+	defer closeUpstream()
+
+	pm.proxyLogger.Debugf("<%s> realtime session started", target.modelID)
+	sessionStart := time.Now()
+
+	if firstMessage != nil {
+		if err := upstream.WriteMessage(websocket.TextMessage, firstMessage); err != nil {
+			pm.proxyLogger.Warnf("<%s> unable to forward the first realtime message: %v", target.modelID, err)
+			return
+		}
+	}
+
+	var usage TokenMetrics
+	done := make(chan error, 2)
+	go func() { done <- pumpRealtime(upstream, client, nil) }()
+	go func() { done <- pumpRealtime(client, upstream, usage.addRealtimeUsage) }()
+	err = <-done
+	client.Close()
+	upstream.Close()
+	<-done
+	closeUpstream()
+
+	duration := time.Since(sessionStart)
+	pm.proxyLogger.Debugf("<%s> realtime session ended after %v: %v", target.modelID, duration, err)
+
+	if pm.metricsMonitor != nil {
+		model := target.modelID
+		if preset := pm.presetName(target.requestedModel); preset != "" {
+			model = preset
+		}
+		pm.metricsMonitor.addMetrics(TokenMetrics{
+			Timestamp:    time.Now(),
+			Model:        model,
+			Route:        target.routeName,
+			InputTokens:  usage.InputTokens,
+			OutputTokens: usage.OutputTokens,
+			CachedTokens: usage.CachedTokens,
+			DurationMs:   int(duration.Milliseconds()),
+			PeerOffload:  target.peerOffload,
+		})
+	}
+}
+
+// realtimeTarget resolves the model of a realtime session like an inference
+// request, local models are swapped in
+func (pm *ProxyManager) realtimeTarget(requestedModel, path string) (*realtimeTarget, int, error) {
+	target := &realtimeTarget{requestedModel: requestedModel}
+
+	if chosenModel, err := pm.resolveVirtualModel(requestedModel, path, nil); err != nil {
+		return nil, http.StatusBadRequest, err
+	} else if chosenModel != requestedModel {
+		target.routeName = requestedModel
+		target.requestedModel = chosenModel
+	}
+	target.upstreamModel = target.requestedModel
+
+	// the model is sent to a peer when starting it would evict other models
+	peerModel := target.requestedModel
+	snap := pm.snapshot.Load()
+	modelID, found := snap.config.RealModelName(target.requestedModel)
+	if found {
+		if offloadModel, reason, offload := pm.peerOffload(modelID, target.requestedModel); offload {
+			peerModel, target.peerOffload, found = offloadModel, reason, false
+			target.upstreamModel = offloadModel
+		}
+	}
+
+	if found {
+		processGroup, err := snap.swapProcessGroup(modelID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("error swapping process group: %s", err.Error())
+		}
+		// issue #69 allow custom model names to be sent to upstream
+		if useModelName := snap.config.Models[modelID].UseModelName; useModelName != "" {
+			target.upstreamModel = useModelName
+		}
+		target.modelID = modelID
+		target.next = pm.localHandler(modelID, processGroup)
+	} else if pm.peerProxy != nil && pm.peerProxy.HasPeerModel(peerModel) {
+		target.modelID = peerModel
+		target.next = pm.peerProxy.ProxyRequest
+	} else {
+		return nil, http.StatusBadRequest, fmt.Errorf("could not find suitable handler for %s", target.requestedModel)
+	}
+	return target, http.StatusOK, nil
+}
+
+// realtimeSessionModel returns the model of a session.update or a session's
+// first message
+func realtimeSessionModel(message []byte) string {
+	if model := gjson.GetBytes(message, "session.model").String(); model != "" {
+		return model
+	}
+	return gjson.GetBytes(message, "model").String()
+}
+
+func setRealtimeSessionModel(message []byte, model string) []byte {
+	path := "model"
+	if gjson.GetBytes(message, "session.model").Exists() {
+		path = "session.model"
+	}
+	if updated, err := sjson.SetBytes(message, path, model); err == nil {
+		return updated
+	}
+	return message
+}
+
+// sendRealtimeError sends an OpenAI realtime error event and closes the session
+func sendRealtimeError(conn *websocket.Conn, message string) {
+	conn.WriteJSON(gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    "invalid_request_error",
+			"message": message,
+		},
+	})
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseInternalServerErr, ""), time.Now().Add(time.Second))
+}
+
+// pumpRealtime copies messages from src to dst until src closes. observe sees
+// every text message.
+func pumpRealtime(dst, src *websocket.Conn, observe func(message []byte)) error {
+	for {
+		messageType, message, err := src.ReadMessage()
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				dst.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(closeErr.Code, closeErr.Text), time.Now().Add(time.Second))
+			}
+			return err
+		}
+		if observe != nil && messageType == websocket.TextMessage {
+			observe(message)
+		}
+		if err := dst.WriteMessage(messageType, message); err != nil {
+			return err
+		}
+	}
+}
+
+// addRealtimeUsage adds the usage of a response.done event
+func (tm *TokenMetrics) addRealtimeUsage(message []byte) {
+	if gjson.GetBytes(message, "type").String() != "response.done" {
+		return
+	}
+	usage := gjson.GetBytes(message, "response.usage")
+	tm.InputTokens += int(usage.Get("input_tokens").Int())
+	tm.OutputTokens += int(usage.Get("output_tokens").Int())
+	tm.CachedTokens += int(usage.Get("input_token_details.cached_tokens").Int())
+}
+
+// realtimeUpstreamHeader copies the client's headers without the ones the
+// WebSocket handshake sets
+func realtimeUpstreamHeader(header http.Header) http.Header {
+	upstream := header.Clone()
+	for _, name := range []string{
+		"Upgrade", "Connection", "Sec-Websocket-Key", "Sec-Websocket-Version",
+		"Sec-Websocket-Extensions", "Sec-Websocket-Protocol",
+	} {
+		upstream.Del(name)
+	}
+	return upstream
+}
+
+// dialRealtime opens a WebSocket through handler over an in memory connection.
+// The handler proxies the upgrade to the upstream and runs until the session
+// ends. The returned function closes the session and waits for the handler.
+func dialRealtime(ctx context.Context, handler http.Handler, target string, header http.Header, subprotocols []string) (*websocket.Conn, func(), error) {
+	clientEnd, serverEnd := net.Pipe()
+	listener := newConnListener(serverEnd)
+
+	var mu sync.Mutex
+	var started, closing bool
+	handled := make(chan struct{})
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			if closing {
+				mu.Unlock()
+				return
+			}
+			started = true
+			mu.Unlock()
+			defer close(handled)
+			handler.ServeHTTP(w, r)
+		}),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+		ErrorLog:    log.New(io.Discard, "", 0),
+	}
+	go server.Serve(listener)
+
+	var once sync.Once
+	closeSession := func() {
+		once.Do(func() {
+			clientEnd.Close()
+			server.Close()
+			mu.Lock()
+			closing = true
+			wait := started
+			mu.Unlock()
+			if wait {
+				<-handled
+			}
+		})
+	}
+
+	// model loading can take a while, ctx ends the handshake
+	dialer := websocket.Dialer{
+		NetDialContext: func(context.Context, string, string) (net.Conn, error) { return clientEnd, nil },
+		Subprotocols:   subprotocols,
+	}
+	conn, resp, err := dialer.DialContext(ctx, target, header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			err = fmt.Errorf("upstream status %d: %s", resp.StatusCode, body)
+		}
+		closeSession()
+		return nil, nil, err
+	}
+	return conn, closeSession, nil
+}
+
+// connListener is a net.Listener that accepts a single connection
+type connListener struct {
+	conn     net.Conn
+	accepted chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{conn: conn, accepted: make(chan net.Conn, 1), closed: make(chan struct{})}
+	l.accepted <- conn
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
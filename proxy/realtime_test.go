@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func newRealtimeTestServer(t *testing.T) (*ProxyManager, string) {
+	t.Helper()
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+    useModelName: upstream-model
+`, simpleResponderPath)))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	t.Cleanup(func() { proxy.StopProcesses(StopWaitForInflightRequest) })
+
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+	return proxy, "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/realtime"
+}
+
+func readRealtimeEvent(t *testing.T, conn *websocket.Conn) gjson.Result {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	return gjson.ParseBytes(message)
+}
+
+func TestProxyManager_RealtimeQueryModel(t *testing.T) {
+	proxy, url := newRealtimeTestServer(t)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"realtime"}}
+	conn, _, err := dialer.Dial(url+"?model=model1", http.Header{"Authorization": {"Bearer upstream-key"}})
+	require.NoError(t, err)
+	assert.Equal(t, "realtime", conn.Subprotocol())
+
+	created := readRealtimeEvent(t, conn)
+	assert.Equal(t, "session.created", created.Get("type").String())
+	assert.Equal(t, "upstream-model", created.Get("session.model").String(), "useModelName is sent to the upstream")
+	assert.Equal(t, "realtime", created.Get("protocol").String())
+	assert.Equal(t, "Bearer upstream-key", created.Get("auth").String())
+
+	process, _ := proxy.snapshot.Load().processGroups["model1"].GetMember("model1")
+	assert.Equal(t, int32(1), process.inFlightRequestsCount.Load(), "the session is in flight")
+
+	require.NoError(t, conn.WriteJSON(map[string]any{"type": "input_audio_buffer.append", "audio": "AAAA"}))
+	echo := readRealtimeEvent(t, conn)
+	assert.Equal(t, "input_audio_buffer.append", echo.Get("message.type").String())
+
+	for range 2 {
+		require.NoError(t, conn.WriteJSON(map[string]any{"type": "response.create"}))
+		assert.Equal(t, "response.done", readRealtimeEvent(t, conn).Get("type").String())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+
+	require.Eventually(t, func() bool {
+		return len(proxy.metricsMonitor.getMetrics()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	metric := proxy.metricsMonitor.getMetrics()[0]
+	assert.Equal(t, "model1", metric.Model)
+	assert.Equal(t, 50, metric.InputTokens)
+	assert.Equal(t, 20, metric.OutputTokens)
+	assert.GreaterOrEqual(t, metric.DurationMs, 50)
+	assert.Equal(t, int32(0), process.inFlightRequestsCount.Load())
+}
+
+func TestProxyManager_RealtimeFirstMessageModel(t *testing.T) {
+	_, url := newRealtimeTestServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteJSON(map[string]any{
+		"type":    "session.update",
+		"session": map[string]any{"model": "model1", "voice": "alloy"},
+	}))
+	assert.Equal(t, "session.created", readRealtimeEvent(t, conn).Get("type").String())
+
+	echo := readRealtimeEvent(t, conn)
+	assert.Equal(t, "session.update", echo.Get("message.type").String())
+	assert.Equal(t, "upstream-model", echo.Get("message.session.model").String())
+	assert.Equal(t, "alloy", echo.Get("message.session.voice").String())
+}
+
+func TestProxyManager_RealtimeUnknownModel(t *testing.T) {
+	_, url := newRealtimeTestServer(t)
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?model=unknown", nil)
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.WriteJSON(map[string]any{"type": "session.update", "session": map[string]any{"model": "unknown"}}))
+
+	event := readRealtimeEvent(t, conn)
+	assert.Equal(t, "error", event.Get("type").String())
+	assert.Contains(t, event.Get("error.message").String(), "unknown")
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseInternalServerErr))
+}
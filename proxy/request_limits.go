@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/tidwall/gjson"
+)
+
+// tokenizeTimeout bounds the /tokenize request used to count prompt tokens
+const tokenizeTimeout = 2 * time.Second
+
+// limitViolation is a request that is too large for the model
+type limitViolation struct {
+	message string
+	param   string
+}
+
+// checkRequestLimits returns the first limit of the model the request breaks
+// or nil. It is called before the model is swapped in.
+func (pm *ProxyManager) checkRequestLimits(ctx context.Context, modelID string, body []byte) *limitViolation {
+	limits := pm.snapshot.Load().config.Models[modelID].Limits
+	if limits == (config.LimitsConfig{}) {
+		return nil
+	}
+
+	if limits.MaxBodyBytes > 0 && int64(len(body)) > limits.MaxBodyBytes {
+		return &limitViolation{
+			message: fmt.Sprintf("request body of %d bytes exceeds the maximum of %d bytes for %s", len(body), limits.MaxBodyBytes, modelID),
+		}
+	}
+
+	if limits.MaxMessages > 0 {
+		if messages := gjson.GetBytes(body, "messages"); messages.IsArray() && len(messages.Array()) > limits.MaxMessages {
+			return &limitViolation{
+				message: fmt.Sprintf("%d messages exceed the maximum of %d messages for %s", len(messages.Array()), limits.MaxMessages, modelID),
+				param:   "messages",
+			}
+		}
+	}
+
+	if limits.MaxPromptTokens > 0 {
+		tokens := pm.countPromptTokens(ctx, modelID, body)
+		if tokens > limits.MaxPromptTokens {
+			return &limitViolation{
+				message: fmt.Sprintf("This model's maximum context length is %d tokens. However, your prompt is about %d tokens.", limits.MaxPromptTokens, tokens),
+				param:   "messages",
+			}
+		}
+	}
+	return nil
+}
+
+// maxRequestBodyBytes is the largest body a model accepts. Bodies are read
+// before the model is known so this bounds every request, it is 0 when a
+// model or a peer accepts bodies of any size.
+func (s *proxySnapshot) maxRequestBodyBytes() int64 {
+	if len(s.config.Peers) > 0 {
+		return 0
+	}
+	var largest int64
+	for _, modelConfig := range s.config.Models {
+		if modelConfig.Limits.MaxBodyBytes == 0 {
+			return 0
+		}
+		largest = max(largest, modelConfig.Limits.MaxBodyBytes)
+	}
+	return largest
+}
+
+// bodyTooLarge is the violation of a body that no model accepts
+func bodyTooLarge(maxBodyBytes int64) *limitViolation {
+	return &limitViolation{
+		message: fmt.Sprintf("request body exceeds the maximum of %d bytes", maxBodyBytes),
+	}
+}
+
+// countPromptTokens counts the prompt with the upstream tokenizer when the
+// model is loaded. Otherwise, or when that fails, it estimates the count from
+// the prompt length so an unloaded model is never loaded just to count.
+func (pm *ProxyManager) countPromptTokens(ctx context.Context, modelID string, body []byte) int {
+	prompt := promptText(body)
+	if processGroup, found := pm.snapshot.Load().processGroups[modelID]; found {
+		if process, found := processGroup.processes[modelID]; found && process.CurrentState() == StateReady {
+			tokens, err := tokenize(ctx, process.config.Proxy, prompt)
+			if err == nil {
+				return tokens
+			}
+			pm.proxyLogger.Debugf("<%s> /tokenize failed, estimating prompt tokens: %v", modelID, err)
+		}
+	}
+	return (len(prompt) + 3) / 4
+}
+
+// tokenize counts the tokens of text with the llama-server /tokenize endpoint
+func tokenize(ctx context.Context, upstream, text string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, tokenizeTimeout)
+	defer cancel()
+
+	payload, err := json.Marshal(map[string]any{"content": text})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(upstream, "/")+"/tokenize", bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Tokens []json.RawMessage `json:"tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return len(result.Tokens), nil
+}
+
+// promptText joins the text of the prompt fields of a request, images and
+// other non text content are left out
+func promptText(body []byte) string {
+	var text strings.Builder
+	if !gjson.ValidBytes(body) {
+		return ""
+	}
+	parsed := gjson.ParseBytes(body)
+	for _, key := range []string{"messages", "prompt", "input", "system", "instructions", "input_prefix", "input_suffix", "input_extra", "tools"} {
+		collectPromptText(parsed.Get(key), &text)
+	}
+	return text.String()
+}
+
+func collectPromptText(value gjson.Result, text *strings.Builder) {
+	switch {
+	case value.Type == gjson.String:
+		if text.Len() > 0 {
+			text.WriteString("\n")
+		}
+		text.WriteString(value.Str)
+	case value.IsArray():
+		for _, item := range value.Array() {
+			collectPromptText(item, text)
+		}
+	case value.IsObject():
+		// tool definitions count as their JSON
+		if value.Get("function").Exists() || value.Get("input_schema").Exists() {
+			if text.Len() > 0 {
+				text.WriteString("\n")
+			}
+			text.WriteString(value.Raw)
+			return
+		}
+		for _, key := range []string{"content", "text"} {
+			collectPromptText(value.Get(key), text)
+		}
+	}
+}
+
+// writeLimitViolation rejects the request with a context_length_exceeded
+// error in the format of the requested API
+func writeLimitViolation(c *gin.Context, violation *limitViolation) {
+	if isAnthropicPath(c.Request.URL.Path) {
+		c.JSON(http.StatusBadRequest, anthropicError(http.StatusBadRequest, violation.message))
+		return
+	}
+	body := openAIError(http.StatusBadRequest, violation.message)
+	details := body["error"].(gin.H)
+	details["code"] = "context_length_exceeded"
+	if violation.param != "" {
+		details["param"] = violation.param
+	}
+	c.JSON(http.StatusBadRequest, body)
+}
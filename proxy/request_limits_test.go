@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestPromptText(t *testing.T) {
+	body := []byte(`{
+		"system": "be brief",
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "hello"}, {"type": "image_url", "image_url": {"url": "data:..."}}]},
+			{"role": "assistant", "content": "hi"}
+		],
+		"tools": [{"type": "function", "function": {"name": "f"}}]
+	}`)
+	assert.Equal(t, "hello\nhi\nbe brief\n{\"type\": \"function\", \"function\": {\"name\": \"f\"}}", promptText(body))
+	assert.Empty(t, promptText([]byte("not json")))
+}
+
+func newLimitsTestProxy(t *testing.T) *ProxyManager {
+	t.Helper()
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+    limits:
+      maxBodyBytes: 4096
+      maxMessages: 2
+      maxPromptTokens: 10
+  model2:
+    cmd: %s --port ${PORT} --silent --respond model2
+    limits:
+      maxBodyBytes: 65536
+`, simpleResponderPath, simpleResponderPath)))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	t.Cleanup(func() { proxy.StopProcesses(StopWaitForInflightRequest) })
+	return proxy
+}
+
+func TestProxyManager_LimitsRejectBeforeSwap(t *testing.T) {
+	proxy := newLimitsTestProxy(t)
+	longPrompt := strings.Repeat("word ", 20)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		message string
+	}{
+		{"body bytes", "/v1/chat/completions", `{"model":"model1","messages":[{"role":"user","content":"` + strings.Repeat("x", 5000) + `"}]}`, "request body of"},
+		{"messages", "/v1/chat/completions", `{"model":"model1","messages":[{"role":"user","content":"a"},{"role":"assistant","content":"b"},{"role":"user","content":"c"}]}`, "3 messages exceed the maximum of 2"},
+		{"prompt tokens", "/v1/chat/completions", `{"model":"model1","messages":[{"role":"user","content":"` + longPrompt + `"}]}`, "maximum context length is 10 tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, bytes.NewBufferString(tt.body))
+			w := CreateTestResponseRecorder()
+			proxy.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, "context_length_exceeded", gjson.Get(w.Body.String(), "error.code").String())
+			assert.Contains(t, gjson.Get(w.Body.String(), "error.message").String(), tt.message)
+		})
+	}
+
+	req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"model":"model1","max_tokens":10,"messages":[{"role":"user","content":"`+longPrompt+`"}]}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_request_error", gjson.Get(w.Body.String(), "error.type").String(), "anthropic error format")
+
+	process, found := proxy.snapshot.Load().processGroups["model1"].GetMember("model1")
+	require.True(t, found)
+	assert.Equal(t, StateStopped, process.CurrentState(), "rejected requests do not load the model")
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestProxyManager_LimitsBoundBodyRead(t *testing.T) {
+	proxy := newLimitsTestProxy(t)
+	assert.Equal(t, int64(65536), proxy.snapshot.Load().maxRequestBodyBytes())
+	body := `{"model":"model2","messages":[{"role":"user","content":"` + strings.Repeat("x", 100000) + `"}]}`
+
+	t.Run("content length", func(t *testing.T) {
+		reader := &countingReader{Reader: strings.NewReader(body)}
+		req := httptest.NewRequest("POST", "/v1/chat/completions", reader)
+		req.ContentLength = int64(len(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, gjson.Get(w.Body.String(), "error.message").String(), "request body exceeds the maximum of 65536 bytes")
+		assert.Zero(t, reader.read, "the body is not read")
+	})
+
+	t.Run("chunked", func(t *testing.T) {
+		reader := &countingReader{Reader: strings.NewReader(body)}
+		req := httptest.NewRequest("POST", "/v1/chat/completions", reader)
+		req.ContentLength = -1
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, gjson.Get(w.Body.String(), "error.message").String(), "request body exceeds the maximum of 65536 bytes")
+		assert.LessOrEqual(t, reader.read, 65536+512*2, "the read stops at the limit")
+	})
+
+	t.Run("models without a limit", func(t *testing.T) {
+		require.NoError(t, proxy.upsertModel("model3", []byte(`{"cmd": "./server --port ${PORT}"}`), false, false))
+		assert.Zero(t, proxy.snapshot.Load().maxRequestBodyBytes())
+	})
+}
+
+func TestProxyManager_LimitsMultipart(t *testing.T) {
+	proxy := newLimitsTestProxy(t)
+
+	send := func(contentLength int64, fields ...string) *httptest.ResponseRecorder {
+		form, contentType := newTestForm(t, fields...)
+		req := httptest.NewRequest("POST", "/v1/audio/transcriptions", form)
+		req.Header.Set("Content-Type", contentType)
+		if contentLength != 0 {
+			req.ContentLength = contentLength
+		}
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	w := send(0, "model", "model1", "@file", strings.Repeat("x", 5000))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, gjson.Get(w.Body.String(), "error.message").String(), "exceeds the maximum of 4096 bytes for model1")
+
+	w = send(0, "model", "model2", "@file", strings.Repeat("x", 100000))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, gjson.Get(w.Body.String(), "error.message").String(), "request body exceeds the maximum of 65536 bytes")
+
+	process, _ := proxy.snapshot.Load().processGroups["model1"].GetMember("model1")
+	assert.Equal(t, StateStopped, process.CurrentState(), "rejected uploads do not load the model")
+
+	// uploads of unknown length are cut off while they are forwarded
+	w = send(-1, "model", "model1", "@file", strings.Repeat("x", 5000))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+
+	w = send(-1, "model", "model1", "@file", "audio")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestProxyManager_LimitsUseTokenizerWhenLoaded(t *testing.T) {
+	proxy := newLimitsTestProxy(t)
+
+	// 8 words, estimated at 13 tokens from the length but 8 by the tokenizer
+	body := `{"model":"model1","messages":[{"role":"user","content":"alpha bravo charlie delta echo foxtrot golf hotel"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "estimated while the model is not loaded")
+
+	req = httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1","messages":[{"role":"user","content":"hi"}]}`))
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	req = httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/tidwall/gjson"
+)
+
+// The response cache stores upstream responses of deterministic requests for
+// models with responseCache enabled. Hits are served without waking or
+// swapping the model.
+
+// paths whose responses only depend on the request body
+var responseCacheAlwaysPaths = map[string]bool{
+	"/v1/embeddings": true,
+	"/reranking":     true,
+	"/rerank":        true,
+	"/v1/rerank":     true,
+	"/v1/reranking":  true,
+}
+
+// paths that are cached when sampling is deterministic
+var responseCacheSamplingPaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+}
+
+// cachedResponse is an upstream response stored in the response cache
+type cachedResponse struct {
+	ContentType     string    `json:"content_type"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+	Body            []byte    `json:"body"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func (r cachedResponse) size() int {
+	return len(r.ContentType) + len(r.ContentEncoding) + len(r.Body)
+}
+
+// serve writes the cached response, it has the signature of the handlers
+// proxyInferenceHandler passes requests to
+func (r cachedResponse) serve(modelID string, w http.ResponseWriter, req *http.Request) error {
+	header := w.Header()
+	header.Set("Content-Type", r.ContentType)
+	if r.ContentEncoding != "" {
+		header.Set("Content-Encoding", r.ContentEncoding)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(r.Body); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+type responseCache interface {
+	Get(key string) (cachedResponse, bool)
+	Put(key string, response cachedResponse) error
+}
+
+func newResponseCache(cfg config.ResponseCacheConfig) (responseCache, error) {
+	maxBytes := int64(cfg.MaxSizeMB) * 1024 * 1024
+	ttl := time.Duration(cfg.TTL) * time.Second
+	switch cfg.Type {
+	case config.ResponseCacheDisk:
+		if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+			return nil, fmt.Errorf("unable to create response cache directory: %w", err)
+		}
+		return &diskResponseCache{dir: cfg.Path, maxBytes: maxBytes, ttl: ttl}, nil
+	default:
+		return newMemoryResponseCache(maxBytes, ttl), nil
+	}
+}
+
+// responseCacheKey returns the cache key of a request and false when the
+// request is not deterministic. The body is normalized so the order of JSON
+// keys and whitespace do not matter.
+func responseCacheKey(modelID, path string, body []byte) (string, bool) {
+	if !responseCacheAlwaysPaths[path] {
+		if !responseCacheSamplingPaths[path] {
+			return "", false
+		}
+		temperature := gjson.GetBytes(body, "temperature")
+		seed := gjson.GetBytes(body, "seed")
+		deterministic := (temperature.Type == gjson.Number && temperature.Float() == 0) ||
+			(seed.Type == gjson.Number && seed.Int() >= 0)
+		if !deterministic {
+			return "", false
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var parsed any
+	if err := decoder.Decode(&parsed); err != nil {
+		return "", false
+	}
+	normalized, err := json.Marshal(parsed)
+	if err != nil {
+		return "", false
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(modelID + "\n" + path + "\n"))
+	hash.Write(normalized)
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// responseCacheWriter copies a response so it can be stored once it is complete
+type responseCacheWriter struct {
+	gin.ResponseWriter
+	status          int
+	contentType     string
+	contentEncoding string
+	body            bytes.Buffer
+}
+
+func newResponseCacheWriter(w gin.ResponseWriter) *responseCacheWriter {
+	return &responseCacheWriter{ResponseWriter: w}
+}
+
+func (w *responseCacheWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+		w.contentType = w.Header().Get("Content-Type")
+		w.contentEncoding = w.Header().Get("Content-Encoding")
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseCacheWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseCacheWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// response returns the copied response and false when it should not be cached
+func (w *responseCacheWriter) response() (cachedResponse, bool) {
+	if w.status != http.StatusOK || w.body.Len() == 0 {
+		return cachedResponse{}, false
+	}
+	return cachedResponse{
+		ContentType:     w.contentType,
+		ContentEncoding: w.contentEncoding,
+		Body:            bytes.Clone(w.body.Bytes()),
+		CreatedAt:       time.Now(),
+	}, true
+}
+
+// memoryResponseCache is an LRU cache limited by the size of the responses
+type memoryResponseCache struct {
+	sync.Mutex
+	maxBytes int64
+	ttl      time.Duration
+	size     int64
+	order    *list.List // front is the most recently used
+	entries  map[string]*list.Element
+}
+
+type memoryResponseCacheEntry struct {
+	key      string
+	response cachedResponse
+}
+
+func newMemoryResponseCache(maxBytes int64, ttl time.Duration) *memoryResponseCache {
+	return &memoryResponseCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (s *memoryResponseCache) Get(key string) (cachedResponse, bool) {
+	s.Lock()
+	defer s.Unlock()
+	element, found := s.entries[key]
+	if !found {
+		return cachedResponse{}, false
+	}
+	entry := element.Value.(*memoryResponseCacheEntry)
+	if time.Since(entry.response.CreatedAt) > s.ttl {
+		s.remove(element)
+		return cachedResponse{}, false
+	}
+	s.order.MoveToFront(element)
+	return entry.response, true
+}
+
+func (s *memoryResponseCache) Put(key string, response cachedResponse) error {
+	if int64(response.size()) > s.maxBytes {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if element, found := s.entries[key]; found {
+		s.remove(element)
+	}
+	s.entries[key] = s.order.PushFront(&memoryResponseCacheEntry{key: key, response: response})
+	s.size += int64(response.size())
+	for s.size > s.maxBytes {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+func (s *memoryResponseCache) remove(element *list.Element) {
+	entry := s.order.Remove(element).(*memoryResponseCacheEntry)
+	delete(s.entries, entry.key)
+	s.size -= int64(entry.response.size())
+}
+
+// cache keys are sha256 hashes, anything else is never read from disk
+var responseCacheKeyRegex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// diskResponseCache writes one JSON file per response. The modification time
+// of a file is updated when it is used so the least recently used files are
+// removed first.
+type diskResponseCache struct {
+	sync.Mutex
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+}
+
+func (s *diskResponseCache) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+func (s *diskResponseCache) Get(key string) (cachedResponse, bool) {
+	if !responseCacheKeyRegex.MatchString(key) {
+		return cachedResponse{}, false
+	}
+	s.Lock()
+	defer s.Unlock()
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return cachedResponse{}, false
+	}
+	var response cachedResponse
+	if err := json.Unmarshal(data, &response); err != nil || time.Since(response.CreatedAt) > s.ttl {
+		os.Remove(s.path(key))
+		return cachedResponse{}, false
+	}
+	now := time.Now()
+	os.Chtimes(s.path(key), now, now)
+	return response, true
+}
+
+func (s *diskResponseCache) Put(key string, response cachedResponse) error {
+	if !responseCacheKeyRegex.MatchString(key) {
+		return fmt.Errorf("invalid cache key: %s", key)
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > s.maxBytes {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := os.WriteFile(s.path(key), data, 0644); err != nil {
+		return err
+	}
+	return s.prune()
+}
+
+// prune removes the least recently used responses once the cache is larger than maxBytes
+func (s *diskResponseCache) prune() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		path    string
+		size    int64
+		modTime int64
+	}
+	entries := make([]entry, 0, len(files))
+	var total int64
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			entries = append(entries, entry{file, info.Size(), info.ModTime().UnixNano()})
+			total += info.Size()
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime < entries[j].modTime })
+	for total > s.maxBytes && len(entries) > 0 {
+		if err := os.Remove(entries[0].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= entries[0].size
+		entries = entries[1:]
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	key, ok := responseCacheKey("model1", "/v1/embeddings", []byte(`{"model": "model1", "input": ["a"]}`))
+	require.True(t, ok)
+	same, ok := responseCacheKey("model1", "/v1/embeddings", []byte(`{"input":["a"],"model":"model1"}`))
+	require.True(t, ok)
+	assert.Equal(t, key, same, "key order and whitespace are ignored")
+
+	other, _ := responseCacheKey("model2", "/v1/embeddings", []byte(`{"model": "model1", "input": ["a"]}`))
+	assert.NotEqual(t, key, other)
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		cacheable bool
+	}{
+		{"rerank", "/v1/rerank", `{"query": "q"}`, true},
+		{"temperature 0", "/v1/chat/completions", `{"temperature": 0}`, true},
+		{"fixed seed", "/v1/completions", `{"temperature": 0.8, "seed": 42}`, true},
+		{"random seed", "/v1/chat/completions", `{"seed": -1}`, false},
+		{"sampled", "/v1/chat/completions", `{"temperature": 0.7}`, false},
+		{"default temperature", "/v1/chat/completions", `{}`, false},
+		{"other path", "/v1/audio/speech", `{"temperature": 0}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := responseCacheKey("model1", tt.path, []byte(tt.body))
+			assert.Equal(t, tt.cacheable, ok)
+		})
+	}
+}
+
+func TestMemoryResponseCache(t *testing.T) {
+	cache := newMemoryResponseCache(25, time.Hour)
+	response := func(body string) cachedResponse {
+		return cachedResponse{Body: []byte(body), CreatedAt: time.Now()}
+	}
+
+	require.NoError(t, cache.Put("a", response("0123456789")))
+	require.NoError(t, cache.Put("b", response("0123456789")))
+	_, found := cache.Get("a") // a is now the most recently used
+	require.True(t, found)
+	require.NoError(t, cache.Put("c", response("0123456789")))
+
+	_, found = cache.Get("b")
+	assert.False(t, found, "least recently used entry is removed")
+	_, found = cache.Get("a")
+	assert.True(t, found)
+
+	cache.ttl = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	_, found = cache.Get("c")
+	assert.False(t, found, "expired entries are not served")
+}
+
+func TestDiskResponseCache(t *testing.T) {
+	cfg := config.ResponseCacheConfig{Type: config.ResponseCacheDisk, Path: t.TempDir(), MaxSizeMB: 1, TTL: 60}
+	cache, err := newResponseCache(cfg)
+	require.NoError(t, err)
+
+	key, _ := responseCacheKey("model1", "/v1/embeddings", []byte(`{"input": "a"}`))
+	require.NoError(t, cache.Put(key, cachedResponse{ContentType: "application/json", Body: []byte(`{"data": []}`), CreatedAt: time.Now()}))
+
+	got, found := cache.Get(key)
+	require.True(t, found)
+	assert.Equal(t, "application/json", got.ContentType)
+	assert.Equal(t, `{"data": []}`, string(got.Body))
+
+	_, found = cache.Get("../../etc/passwd")
+	assert.False(t, found)
+	assert.Error(t, cache.Put("not-a-key", cachedResponse{}))
+
+	// a large response pushes out older ones
+	big := cachedResponse{Body: []byte(strings.Repeat("x", 500*1024)), CreatedAt: time.Now()}
+	other, _ := responseCacheKey("model1", "/v1/embeddings", []byte(`{"input": "b"}`))
+	third, _ := responseCacheKey("model1", "/v1/embeddings", []byte(`{"input": "c"}`))
+	require.NoError(t, cache.Put(other, big))
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, cache.Put(third, big))
+	_, found = cache.Get(other)
+	assert.False(t, found)
+	_, found = cache.Get(third)
+	assert.True(t, found)
+}
+
+func TestProxyManager_ResponseCache(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+    responseCache: true
+  model2:
+    cmd: %s --port ${PORT} --silent --respond model2
+`, simpleResponderPath, simpleResponderPath)))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	serve := func(path, body string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("hits do not start the model", func(t *testing.T) {
+		body := `{"model": "model1", "input": ["a", "b"]}`
+		w := serve("/v1/embeddings", body)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "MISS", w.Header().Get("X-Llama-Swap-Cache"))
+		first := w.Body.String()
+
+		proxy.StopProcesses(StopWaitForInflightRequest)
+		w = serve("/v1/embeddings", `{"input": ["a", "b"], "model": "model1"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "HIT", w.Header().Get("X-Llama-Swap-Cache"))
+		assert.Equal(t, first, w.Body.String())
+		assert.Equal(t, StateStopped, proxy.snapshot.Load().processGroups["model1"].processes["model1"].CurrentState())
+
+		metrics := proxy.metricsMonitor.getMetrics()
+		require.NotEmpty(t, metrics)
+		last := metrics[len(metrics)-1]
+		assert.True(t, last.CacheHit)
+		assert.Equal(t, 10, last.InputTokens)
+		assert.False(t, metrics[len(metrics)-2].CacheHit)
+	})
+
+	t.Run("streaming chat with temperature 0", func(t *testing.T) {
+		body := `{"model": "model1", "temperature": 0, "stream": true, "messages": []}`
+		w := serve("/v1/chat/completions?stream=true", body)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "MISS", w.Header().Get("X-Llama-Swap-Cache"))
+		first := w.Body.String()
+
+		w = serve("/v1/chat/completions?stream=true", body)
+		assert.Equal(t, "HIT", w.Header().Get("X-Llama-Swap-Cache"))
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/event-stream")
+		assert.Equal(t, first, w.Body.String())
+	})
+
+	t.Run("sampled requests are not cached", func(t *testing.T) {
+		w := serve("/v1/chat/completions", `{"model": "model1", "temperature": 0.7, "messages": []}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-Llama-Swap-Cache"))
+	})
+
+	t.Run("models without responseCache", func(t *testing.T) {
+		w := serve("/v1/embeddings", `{"model": "model2", "input": "a"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("X-Llama-Swap-Cache"))
+	})
+}
+
+func TestProxyManager_ResponseCacheModelAPI(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+models:
+  model1:
+    cmd: ./server --port ${PORT}
+`))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+	require.Nil(t, proxy.snapshot.Load().responseCache)
+
+	// the cache is created for the first model that uses it
+	port := getTestPort()
+	require.NoError(t, proxy.upsertModel("model2", []byte(fmt.Sprintf(
+		`{"cmd": "%s --port %d --silent --respond model2", "proxy": "http://127.0.0.1:%d", "responseCache": true}`,
+		filepath.ToSlash(simpleResponderPath), port, port)), false, false))
+	require.NotNil(t, proxy.snapshot.Load().responseCache)
+
+	for _, want := range []string{"MISS", "HIT"} {
+		req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"model": "model2", "input": ["a"]}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, want, w.Header().Get("X-Llama-Swap-Cache"))
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+)
+
+// responseStore keeps the conversation of emulated Responses API responses so
+// later requests can continue them with previous_response_id
+type responseStore interface {
+	// Get returns the chat messages of the conversation up to and including the response
+	Get(id string) ([]openAIMessage, bool)
+	Put(id string, messages []openAIMessage) error
+}
+
+// response ids are generated by llama-swap, anything else is never stored
+var responseIDRegex = regexp.MustCompile(`^resp_[0-9a-f]+$`)
+
+func newResponseStore(cfg config.ResponsesStoreConfig) (responseStore, error) {
+	switch cfg.Type {
+	case config.ResponsesStoreDisk:
+		if err := os.MkdirAll(cfg.Path, 0755); err != nil {
+			return nil, fmt.Errorf("unable to create responses store directory: %w", err)
+		}
+		return &diskResponseStore{dir: cfg.Path, maxEntries: cfg.MaxEntries}, nil
+	default:
+		return newMemoryResponseStore(cfg.MaxEntries), nil
+	}
+}
+
+type memoryResponseStore struct {
+	sync.Mutex
+	maxEntries int
+	entries    map[string][]openAIMessage
+	order      []string
+}
+
+func newMemoryResponseStore(maxEntries int) *memoryResponseStore {
+	return &memoryResponseStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string][]openAIMessage),
+	}
+}
+
+func (s *memoryResponseStore) Get(id string) ([]openAIMessage, bool) {
+	s.Lock()
+	defer s.Unlock()
+	messages, found := s.entries[id]
+	return messages, found
+}
+
+func (s *memoryResponseStore) Put(id string, messages []openAIMessage) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, found := s.entries[id]; !found {
+		s.order = append(s.order, id)
+	}
+	s.entries[id] = messages
+	for len(s.order) > s.maxEntries {
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil
+}
+
+// diskResponseStore writes one JSON file per response so conversations
+// survive a restart
+type diskResponseStore struct {
+	sync.Mutex
+	dir        string
+	maxEntries int
+}
+
+func (s *diskResponseStore) Get(id string) ([]openAIMessage, bool) {
+	if !responseIDRegex.MatchString(id) {
+		return nil, false
+	}
+	s.Lock()
+	defer s.Unlock()
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if err != nil {
+		return nil, false
+	}
+	var messages []openAIMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, false
+	}
+	return messages, true
+}
+
+func (s *diskResponseStore) Put(id string, messages []openAIMessage) error {
+	if !responseIDRegex.MatchString(id) {
+		return fmt.Errorf("invalid response id: %s", id)
+	}
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := os.WriteFile(filepath.Join(s.dir, id+".json"), data, 0644); err != nil {
+		return err
+	}
+	return s.prune()
+}
+
+// prune removes the oldest responses once there are more than maxEntries
+func (s *diskResponseStore) prune() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "resp_*.json"))
+	if err != nil || len(files) <= s.maxEntries {
+		return err
+	}
+
+	type entry struct {
+		path    string
+		modTime int64
+	}
+	entries := make([]entry, 0, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			entries = append(entries, entry{file, info.ModTime().UnixNano()})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime < entries[j].modTime })
+	for len(entries) > s.maxEntries {
+		if err := os.Remove(entries[0].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		entries = entries[1:]
+	}
+	return nil
+}
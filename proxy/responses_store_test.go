@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryResponseStore(t *testing.T) {
+	store := newMemoryResponseStore(2)
+	store.Put("resp_1", []openAIMessage{{Role: "user", Content: "1"}})
+	store.Put("resp_2", []openAIMessage{{Role: "user", Content: "2"}})
+	store.Put("resp_3", []openAIMessage{{Role: "user", Content: "3"}})
+
+	_, found := store.Get("resp_1")
+	assert.False(t, found, "oldest entry is removed")
+	messages, found := store.Get("resp_3")
+	require.True(t, found)
+	assert.Equal(t, "3", messages[0].Content)
+}
+
+func TestDiskResponseStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "responses")
+	store, err := newResponseStore(config.ResponsesStoreConfig{Type: config.ResponsesStoreDisk, Path: dir, MaxEntries: 2})
+	require.NoError(t, err)
+
+	require.NoError(t, store.Put("resp_01", []openAIMessage{{Role: "user", Content: "1"}}))
+	require.NoError(t, store.Put("resp_02", []openAIMessage{{Role: "user", Content: "2"}}))
+	require.NoError(t, store.Put("resp_03", []openAIMessage{{Role: "user", Content: "3"}}))
+	assert.Error(t, store.Put("../escape", nil))
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// a new store over the same directory sees the saved conversations
+	reopened, err := newResponseStore(config.ResponsesStoreConfig{Type: config.ResponsesStoreDisk, Path: dir, MaxEntries: 2})
+	require.NoError(t, err)
+	messages, found := reopened.Get("resp_03")
+	require.True(t, found)
+	assert.Equal(t, "3", messages[0].Content)
+
+	_, found = reopened.Get("../resp_03")
+	assert.False(t, found)
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/tidwall/gjson"
+)
+
+// newRouteRequest extracts the request features used by route rules from a
+// JSON request body. It understands OpenAI chat/completions/responses,
+// Anthropic messages and llama-server infill bodies.
+func newRouteRequest(path string, body []byte) config.RouteRequest {
+	req := config.RouteRequest{Path: path}
+	if len(body) == 0 || !gjson.ValidBytes(body) {
+		return req
+	}
+
+	parsed := gjson.ParseBytes(body)
+	for _, key := range []string{"messages", "prompt", "input", "system", "instructions", "input_prefix", "input_suffix", "input_extra"} {
+		countPromptContent(parsed.Get(key), &req)
+	}
+
+	tools := parsed.Get("tools")
+	functions := parsed.Get("functions")
+	req.HasTools = (tools.IsArray() && len(tools.Array()) > 0) || (functions.IsArray() && len(functions.Array()) > 0)
+
+	// a rough estimate, good enough to pick between short and long context models
+	req.PromptTokens = (req.PromptChars + 3) / 4
+	return req
+}
+
+// countPromptContent walks message content adding up text length and looking for images
+func countPromptContent(value gjson.Result, req *config.RouteRequest) {
+	switch {
+	case value.Type == gjson.String:
+		req.PromptChars += len(value.Str)
+	case value.IsArray():
+		for _, item := range value.Array() {
+			countPromptContent(item, req)
+		}
+	case value.IsObject():
+		switch value.Get("type").String() {
+		case "image_url", "input_image", "image":
+			req.HasImages = true
+			return
+		}
+		if value.Get("image_url").Exists() {
+			req.HasImages = true
+			return
+		}
+		for _, key := range []string{"content", "text"} {
+			countPromptContent(value.Get(key), req)
+		}
+	}
+}
+
+// resolveVirtualModel maps route and pool names to the model that should handle
+// the request. Names that are not virtual are returned unchanged.
+func (pm *ProxyManager) resolveVirtualModel(requestedModel, path string, body []byte) (string, error) {
+	snap := pm.snapshot.Load()
+	if route, found := snap.config.Routes[requestedModel]; found {
+		chosenModel, matched := route.Select(newRouteRequest(path, body))
+		if !matched {
+			return "", fmt.Errorf("no route rule matched for %s", requestedModel)
+		}
+		pm.proxyLogger.Debugf("<%s> route selected model: %s", requestedModel, chosenModel)
+		requestedModel = chosenModel
+	}
+
+	if pool, found := snap.config.Pools[requestedModel]; found {
+		chosenModel := pm.selectPoolModel(snap, pool)
+		pm.proxyLogger.Debugf("<%s> pool selected model: %s", requestedModel, chosenModel)
+		requestedModel = chosenModel
+	}
+
+	return requestedModel, nil
+}
+
+// selectPoolModel picks a pool member preferring one that is ready, then one
+// that is starting, then the one that is cheapest to start. Ties are broken
+// by the order of the pool's models.
+func (pm *ProxyManager) selectPoolModel(snap *proxySnapshot, pool config.PoolConfig) string {
+	type member struct {
+		modelID string
+		process *Process
+	}
+
+	members := make([]member, 0, len(pool.Models))
+	for _, name := range pool.Models {
+		modelID, found := snap.config.RealModelName(name)
+		if !found {
+			continue
+		}
+		if processGroup, found := snap.processGroups[modelID]; found {
+			if process, found := processGroup.GetMember(modelID); found {
+				members = append(members, member{modelID, process})
+			}
+		}
+	}
+	scheduler := pm.scheduler
+
+	if len(members) == 0 {
+		return pool.Models[0]
+	}
+
+	for _, state := range []ProcessState{StateReady, StateStarting} {
+		for _, m := range members {
+			if m.process.CurrentState() == state {
+				return m.modelID
+			}
+		}
+	}
+
+	if scheduler == nil {
+		return members[0].modelID
+	}
+
+	best := -1
+	var bestPlan StartPlan
+	for i, m := range members {
+		plan := scheduler.PlanProcess(m.process)
+		if !plan.Fits {
+			continue
+		}
+		if best == -1 || len(plan.Evict) < len(bestPlan.Evict) ||
+			(len(plan.Evict) == len(bestPlan.Evict) && plan.EvictMB < bestPlan.EvictMB) {
+			best, bestPlan = i, plan
+		}
+	}
+	if best == -1 {
+		// nothing fits, let the scheduler report why for the preferred model
+		return members[0].modelID
+	}
+	return members[best].modelID
+}
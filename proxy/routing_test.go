@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRouteRequest(t *testing.T) {
+	t.Run("chat with image and tools", func(t *testing.T) {
+		req := newRouteRequest("/v1/chat/completions", []byte(`{
+			"messages": [
+				{"role": "system", "content": "12345678"},
+				{"role": "user", "content": [
+					{"type": "text", "text": "1234"},
+					{"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}
+				]}
+			],
+			"tools": [{"type": "function", "function": {"name": "f"}}]
+		}`))
+		assert.Equal(t, "/v1/chat/completions", req.Path)
+		assert.Equal(t, 12, req.PromptChars)
+		assert.Equal(t, 3, req.PromptTokens)
+		assert.True(t, req.HasImages)
+		assert.True(t, req.HasTools)
+	})
+
+	t.Run("anthropic messages", func(t *testing.T) {
+		req := newRouteRequest("/v1/messages", []byte(`{
+			"system": "abc",
+			"messages": [{"role": "user", "content": [{"type": "image", "source": {}}, {"type": "text", "text": "de"}]}],
+			"tools": []
+		}`))
+		assert.Equal(t, 5, req.PromptChars)
+		assert.True(t, req.HasImages)
+		assert.False(t, req.HasTools)
+	})
+
+	t.Run("completion prompt", func(t *testing.T) {
+		req := newRouteRequest("/v1/completions", []byte(`{"prompt": "hello"}`))
+		assert.Equal(t, 5, req.PromptChars)
+		assert.False(t, req.HasImages)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		req := newRouteRequest("/infill", []byte(`not json`))
+		assert.Equal(t, config.RouteRequest{Path: "/infill"}, req)
+	})
+}
+
+func TestProxyManager_Routes(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  small:
+    cmd: %s --port ${PORT} --silent --respond small
+  vision:
+    cmd: %s --port ${PORT} --silent --respond vision
+routes:
+  auto:
+    rules:
+      - hasImages: true
+        model: vision
+      - paths: [/v1/audio/transcriptions]
+        model: vision
+    default: small
+  strict:
+    rules:
+      - hasTools: true
+        model: small
+`, simpleResponderPath, simpleResponderPath)))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"default", `{"model": "auto", "messages": [{"role": "user", "content": "hi"}]}`, "small"},
+		{"images", `{"model": "auto", "messages": [{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "x"}}]}]}`, "vision"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(tt.body))
+			w := CreateTestResponseRecorder()
+			proxy.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.expected)
+			assert.Equal(t, tt.expected, w.Header().Get("X-Llama-Swap-Model"))
+		})
+	}
+
+	t.Run("metrics record the route", func(t *testing.T) {
+		metrics := proxy.metricsMonitor.getMetrics()
+		require.NotEmpty(t, metrics)
+		last := metrics[len(metrics)-1]
+		assert.Equal(t, "vision", last.Model)
+		assert.Equal(t, "auto", last.Route)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model": "strict"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "no route rule matched for strict")
+	})
+
+	t.Run("listed in models", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/models", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Contains(t, w.Body.String(), `"id":"auto"`)
+	})
+}
+
+func TestProxyManager_Pools(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  coder-a:
+    cmd: %s --port ${PORT} --silent --respond coder-a
+  coder-b:
+    cmd: %s --port ${PORT} --silent --respond coder-b
+pools:
+  coder:
+    models: [coder-a, coder-b]
+`, simpleResponderPath, simpleResponderPath)))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	chat := func(model string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(fmt.Sprintf(`{"model": "%s"}`, model)))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w
+	}
+
+	// nothing is loaded, the first model is preferred
+	assert.Equal(t, "coder-a", proxy.selectPoolModel(proxy.snapshot.Load(), cfg.Pools["coder"]))
+
+	// once coder-b is loaded it is used instead of starting coder-a
+	chat("coder-b")
+	w := chat("coder")
+	assert.Equal(t, "coder-b", w.Header().Get("X-Llama-Swap-Model"))
+	assert.Contains(t, w.Body.String(), "coder-b")
+	assert.Equal(t, StateStopped, proxy.snapshot.Load().processGroups["coder-a"].processes["coder-a"].CurrentState())
+}
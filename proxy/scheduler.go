@@ -3,9 +3,12 @@ package proxy
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var ErrInsufficientVRAM = errors.New("insufficient vram for scheduling")
@@ -33,28 +36,78 @@ type Scheduler struct {
 	gpuVramCapsMB []uint64
 	hostRamCapMB  uint64
 
+	drainTimeout  time.Duration
+	maxWait       time.Duration
+	agingInterval time.Duration
+
+	// starts that stopped other processes and the processes they stopped
+	swaps     atomic.Int64
+	evictions atomic.Int64
+
 	missingHostRAMWarned map[string]struct{}
+
+	// the GPUs queried for plans and the running processes at that time, see planGPUs
+	planMu        sync.Mutex
+	planGPUs      []GPUInfo
+	planErr       error
+	planRunning   string
+	planQueriedAt time.Time
 }
 
+// how often a request that does not fit retries while it waits for memory
+var scheduleRetryInterval = time.Second
+
+// how long plans reuse a GPU query while the same processes are running.
+// Placements always query the GPUs.
+var planGPUsTTL = time.Second
+
 type SchedulerOptions struct {
 	GpuVramCapMB  uint64
 	GpuVramCapsMB []uint64
 	HostRamCapMB  uint64
+
+	// preemption of busy processes by higher priority requests, see config.PriorityConfig
+	DrainTimeout  time.Duration
+	MaxWait       time.Duration
+	AgingInterval time.Duration
 }
 
 func NewScheduler(allocator GPUAllocator, logger *LogMonitor, provider func() []*Process, opts SchedulerOptions) *Scheduler {
 	return &Scheduler{
-		allocator:     allocator,
-		logger:        logger,
-		provider:      provider,
-		gpuVramCapMB:  opts.GpuVramCapMB,
-		gpuVramCapsMB: append([]uint64(nil), opts.GpuVramCapsMB...),
-		hostRamCapMB:  opts.HostRamCapMB,
+		allocator:            allocator,
+		logger:               logger,
+		provider:             provider,
+		gpuVramCapMB:         opts.GpuVramCapMB,
+		gpuVramCapsMB:        append([]uint64(nil), opts.GpuVramCapsMB...),
+		hostRamCapMB:         opts.HostRamCapMB,
+		drainTimeout:         opts.DrainTimeout,
+		maxWait:              opts.MaxWait,
+		agingInterval:        opts.AgingInterval,
 		missingHostRAMWarned: make(map[string]struct{}),
 	}
 }
 
+// ScheduleProcess places process before it starts. When it does not fit it
+// waits up to maxWait for memory to be released, the priority of the waiting
+// requests grows while they wait so they can eventually preempt busy processes.
 func (s *Scheduler) ScheduleProcess(process *Process) error {
+	deadline := time.Now().Add(s.maxWait)
+	for {
+		err := s.scheduleProcess(process)
+		if !errors.Is(err, ErrInsufficientVRAM) && !errors.Is(err, ErrInsufficientHostRAM) {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return err
+		}
+		if _, waiting := process.WaitingPriority(s.agingInterval); !waiting {
+			return err
+		}
+		time.Sleep(scheduleRetryInterval)
+	}
+}
+
+func (s *Scheduler) scheduleProcess(process *Process) error {
 	fitPolicy := strings.ToLower(process.FitPolicy())
 	s.logger.Infof("<%s> scheduling decision start: fit_policy=%s", process.ID, fitPolicy)
 
@@ -96,19 +149,39 @@ func (s *Scheduler) ScheduleProcess(process *Process) error {
 
 	requiredMB := process.MeasuredVramMB()
 
+	busy, err := s.placeOnGPU(process, fitPolicy, requiredMB, nil)
+	if err != nil || len(busy) == 0 {
+		return err
+	}
+
+	// busy processes are drained without holding the lock, which takes up to
+	// drainTimeout, so other processes can be planned and scheduled meanwhile.
+	// The fit is checked again once they stopped.
+	for _, evicted := range busy {
+		evicted.Preempt(s.drainTimeout)
+	}
+	_, err = s.placeOnGPU(process, fitPolicy, requiredMB, busy)
+	return err
+}
+
+// placeOnGPU assigns process to the best GPU and stops the idle processes in
+// its way. When busy processes have to be preempted first they are returned
+// without placing the process. drained are the processes preempted for this
+// start already, another preemption is not started then.
+func (s *Scheduler) placeOnGPU(process *Process, fitPolicy string, requiredMB uint64, drained []*Process) ([]*Process, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	gpus, err := s.allocator.GetGPUs()
 	if err != nil {
 		s.logger.Infof("<%s> scheduling decision: not scheduled (unable to inspect GPUs: %v)", process.ID, err)
-		return err
+		return nil, err
 	}
 	gpus = s.applyVramCaps(gpus)
 	if len(gpus) == 0 {
 		err := fmt.Errorf("no GPUs detected for scheduling")
 		s.logger.Infof("<%s> scheduling decision: not scheduled (%v)", process.ID, err)
-		return err
+		return nil, err
 	}
 
 	if requiredMB == 0 {
@@ -122,38 +195,86 @@ func (s *Scheduler) ScheduleProcess(process *Process) error {
 		process.SetAssignedGPU(chosen.Index)
 		process.SetRuntimeEnv([]string{fmt.Sprintf("CUDA_VISIBLE_DEVICES=%d", chosen.Index)})
 		s.logger.Infof("<%s> scheduling decision: scheduled on GPU %d fit_policy=%s (missing VRAM footprint)", process.ID, chosen.Index, fitPolicy)
-		return nil
+		return nil, nil
+	}
+
+	candidates := s.gpuCandidates(process, gpus, requiredMB)
+	if len(candidates) == 0 {
+		s.logger.Infof("<%s> scheduling decision: not scheduled (%v required_vram_mb=%d)", process.ID, ErrInsufficientVRAM, requiredMB)
+		return nil, ErrInsufficientVRAM
+	}
+
+	chosen := candidates[0]
+	if chosen.preempted > 0 {
+		if drained != nil {
+			s.logger.Infof("<%s> scheduling decision: not scheduled (%v after preempting, required_vram_mb=%d)", process.ID, ErrInsufficientVRAM, requiredMB)
+			return nil, ErrInsufficientVRAM
+		}
+		var busy []*Process
+		for _, evicted := range chosen.evict {
+			if evicted.InFlightRequestsCount() > 0 {
+				busy = append(busy, evicted)
+			}
+		}
+		return busy, nil
 	}
 
-	type candidate struct {
-		gpuIndex int
-		evict    []*Process
-		freeMB   uint64
-		assigned int
+	evicted := append(append([]*Process(nil), drained...), chosen.evict...)
+	if len(evicted) > 0 {
+		s.swaps.Add(1)
+		s.evictions.Add(int64(len(evicted)))
+	}
+	for _, idle := range chosen.evict {
+		idle.StopImmediately()
 	}
+	process.recordEvictions(evicted)
 
+	process.SetAssignedGPU(chosen.gpuIndex)
+	process.SetRuntimeEnv([]string{fmt.Sprintf("CUDA_VISIBLE_DEVICES=%d", chosen.gpuIndex)})
+	s.logger.Infof("<%s> scheduling decision: scheduled on GPU %d fit_policy=%s evicted=%d preempted=%d required_vram_mb=%d", process.ID, chosen.gpuIndex, fitPolicy, len(evicted), len(drained), requiredMB)
+
+	return nil, nil
+}
+
+// gpuCandidate is a GPU the process fits on after evicting idle processes
+// and preempting processes that serve lower priority requests
+type gpuCandidate struct {
+	gpuIndex  int
+	evict     []*Process
+	preempted int
+	freeMB    uint64
+	assigned  int
+}
+
+// gpuCandidates returns the GPUs process can be placed on, best first
+func (s *Scheduler) gpuCandidates(process *Process, gpus []GPUInfo, requiredMB uint64) []gpuCandidate {
 	running := s.provider()
-	var candidates []candidate
+	var candidates []gpuCandidate
 	for _, gpu := range gpus {
 		assigned := processesOnGPU(running, gpu.Index)
 		evictable, ok := s.selectEvictions(process, assigned, gpu.FreeMB, requiredMB)
 		if !ok {
 			continue
 		}
-		candidates = append(candidates, candidate{
-			gpuIndex: gpu.Index,
-			evict:    evictable,
-			freeMB:   gpu.FreeMB,
-			assigned: len(assigned),
+		preempted := 0
+		for _, evicted := range evictable {
+			if evicted.InFlightRequestsCount() > 0 {
+				preempted++
+			}
+		}
+		candidates = append(candidates, gpuCandidate{
+			gpuIndex:  gpu.Index,
+			evict:     evictable,
+			preempted: preempted,
+			freeMB:    gpu.FreeMB,
+			assigned:  len(assigned),
 		})
 	}
 
-	if len(candidates) == 0 {
-		s.logger.Infof("<%s> scheduling decision: not scheduled (%v required_vram_mb=%d)", process.ID, ErrInsufficientVRAM, requiredMB)
-		return ErrInsufficientVRAM
-	}
-
 	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].preempted != candidates[j].preempted {
+			return candidates[i].preempted < candidates[j].preempted
+		}
 		if len(candidates[i].evict) != len(candidates[j].evict) {
 			return len(candidates[i].evict) < len(candidates[j].evict)
 		}
@@ -165,17 +286,89 @@ func (s *Scheduler) ScheduleProcess(process *Process) error {
 		}
 		return candidates[i].gpuIndex < candidates[j].gpuIndex
 	})
+	return candidates
+}
 
-	chosen := candidates[0]
-	for _, evicted := range chosen.evict {
-		evicted.StopImmediately()
+// SwapCounts returns the number of starts that stopped other processes and
+// the number of processes they stopped
+func (s *Scheduler) SwapCounts() (swaps, evictions int64) {
+	return s.swaps.Load(), s.evictions.Load()
+}
+
+// StartPlan is the result of a scheduling dry run
+type StartPlan struct {
+	// Fits is false when the process can not be started without first
+	// stopping busy processes or exceeding a memory cap
+	Fits bool
+
+	// Evict are the processes that would be stopped to make room, busy
+	// processes are only included for requests waiting with a higher priority
+	Evict []*Process
+
+	// EvictMB is the VRAM released by stopping Evict
+	EvictMB uint64
+}
+
+// PlanProcess reports what ScheduleProcess would do for process without
+// stopping anything or changing the process.
+func (s *Scheduler) PlanProcess(process *Process) StartPlan {
+	return s.planProcess(process, s.queryPlanGPUs)
+}
+
+// planProcess is PlanProcess with the GPUs returned by gpus, callers planning
+// many processes at once pass one query for all of them
+func (s *Scheduler) planProcess(process *Process, gpus func() ([]GPUInfo, error)) StartPlan {
+	if s.hostRamCapMB > 0 && shouldAccountHostRam(process) {
+		if requiredMB := process.MeasuredCpuMB(); requiredMB > 0 {
+			if used, _ := sumCpuMB(s.provider()); used+requiredMB > s.hostRamCapMB {
+				return StartPlan{}
+			}
+		}
 	}
 
-	process.SetAssignedGPU(chosen.gpuIndex)
-	process.SetRuntimeEnv([]string{fmt.Sprintf("CUDA_VISIBLE_DEVICES=%d", chosen.gpuIndex)})
-	s.logger.Infof("<%s> scheduling decision: scheduled on GPU %d fit_policy=%s evicted=%d required_vram_mb=%d", process.ID, chosen.gpuIndex, fitPolicy, len(chosen.evict), requiredMB)
+	requiredMB := process.MeasuredVramMB()
+	if !requiresGpuScheduling(process) || requiredMB == 0 {
+		return StartPlan{Fits: true}
+	}
 
-	return nil
+	planGPUs, err := gpus()
+	if err != nil {
+		return StartPlan{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	candidates := s.gpuCandidates(process, s.applyVramCaps(slices.Clone(planGPUs)), requiredMB)
+	if len(candidates) == 0 {
+		return StartPlan{}
+	}
+
+	plan := StartPlan{Fits: true, Evict: candidates[0].evict}
+	for _, evicted := range plan.Evict {
+		plan.EvictMB += evicted.MeasuredVramMB()
+	}
+	return plan
+}
+
+// queryPlanGPUs returns the GPUs for a plan. Plans are made for every listing
+// and held request, querying the GPUs for each of them would start a
+// nvidia-smi per plan, so one query is shared until a process starts or stops
+// or planGPUsTTL passed.
+func (s *Scheduler) queryPlanGPUs() ([]GPUInfo, error) {
+	var running strings.Builder
+	for _, process := range s.provider() {
+		fmt.Fprintf(&running, "%s:%s:%d,", process.ID, process.CurrentState(), process.AssignedGPU())
+	}
+
+	s.planMu.Lock()
+	defer s.planMu.Unlock()
+	if running.String() != s.planRunning || time.Since(s.planQueriedAt) >= planGPUsTTL {
+		s.planGPUs, s.planErr = s.allocator.GetGPUs()
+		s.planRunning = running.String()
+		s.planQueriedAt = time.Now()
+	}
+	return s.planGPUs, s.planErr
 }
 
 func (s *Scheduler) selectEvictions(process *Process, assigned []*Process, freeMB, requiredMB uint64) ([]*Process, bool) {
@@ -198,6 +391,17 @@ func (s *Scheduler) selectEvictions(process *Process, assigned []*Process, freeM
 		return evictable[i].LastRequestHandled().Before(evictable[j].LastRequestHandled())
 	})
 
+	// busy processes are preempted after the idle ones, lowest priority first
+	if priority, waiting := process.WaitingPriority(s.agingInterval); waiting {
+		preemptable := preemptableProcesses(assigned, priority)
+		sort.SliceStable(preemptable, func(i, j int) bool {
+			pi, _ := preemptable[i].BusyPriority()
+			pj, _ := preemptable[j].BusyPriority()
+			return pi < pj
+		})
+		evictable = append(evictable, preemptable...)
+	}
+
 	var evict []*Process
 	currentFree := freeMB
 	for _, candidate := range evictable {
@@ -231,6 +435,21 @@ func idleProcesses(processes []*Process) []*Process {
 	return idle
 }
 
+// preemptableProcesses returns the busy processes that only serve requests
+// with a lower priority than priority
+func preemptableProcesses(processes []*Process, priority int) []*Process {
+	var preemptable []*Process
+	for _, process := range processes {
+		if process.InFlightRequestsCount() == 0 {
+			continue
+		}
+		if busy, ok := process.BusyPriority(); ok && busy < priority {
+			preemptable = append(preemptable, process)
+		}
+	}
+	return preemptable
+}
+
 func (s *Scheduler) applyVramCaps(gpus []GPUInfo) []GPUInfo {
 	if s.gpuVramCapMB == 0 && len(s.gpuVramCapsMB) == 0 {
 		return gpus
@@ -405,3 +405,62 @@ func TestSchedulerShouldWarnMissingHostRAM_OncePerProcess(t *testing.T) {
 	require.False(t, scheduler.shouldWarnMissingHostRAM("qwen-30b"))
 	require.True(t, scheduler.shouldWarnMissingHostRAM("glm-flash-q4"))
 }
+
+func TestSchedulerPlanProcess(t *testing.T) {
+	tracker := NewMemoryTracker()
+	allocator := &fakeGPUAllocator{gpus: []GPUInfo{{Index: 0, FreeMB: 4000, TotalMB: 24576}}}
+
+	idle := newTestProcess(t, "idle", "evict_to_fit", 8000, 0, tracker)
+	readyOnGPU(idle, 0)
+
+	scheduler := NewScheduler(allocator, testLogger, func() []*Process {
+		return []*Process{idle}
+	}, SchedulerOptions{HostRamCapMB: 1000})
+
+	small := newTestProcess(t, "small", "evict_to_fit", 3000, 0, tracker)
+	plan := scheduler.PlanProcess(small)
+	require.True(t, plan.Fits)
+	require.Empty(t, plan.Evict)
+
+	large := newTestProcess(t, "large", "evict_to_fit", 10000, 0, tracker)
+	plan = scheduler.PlanProcess(large)
+	require.True(t, plan.Fits)
+	require.Equal(t, []*Process{idle}, plan.Evict)
+	require.Equal(t, uint64(8000), plan.EvictMB)
+
+	// a dry run does not change anything
+	require.Equal(t, StateReady, idle.CurrentState())
+	require.Equal(t, -1, large.AssignedGPU())
+
+	tooLarge := newTestProcess(t, "too-large", "evict_to_fit", 20000, 0, tracker)
+	require.False(t, scheduler.PlanProcess(tooLarge).Fits)
+
+	hostRam := newTestProcess(t, "host-ram", "default", 0, 2000, tracker)
+	require.False(t, scheduler.PlanProcess(hostRam).Fits)
+}
+
+func TestSchedulerPlanProcess_SharesGPUQuery(t *testing.T) {
+	tracker := NewMemoryTracker()
+	allocator := &fakeGPUAllocator{gpus: []GPUInfo{{Index: 0, FreeMB: 4000, TotalMB: 24576}}}
+
+	idle := newTestProcess(t, "idle", "evict_to_fit", 8000, 0, tracker)
+	readyOnGPU(idle, 0)
+	scheduler := NewScheduler(allocator, testLogger, func() []*Process {
+		return []*Process{idle}
+	}, SchedulerOptions{})
+
+	small := newTestProcess(t, "small", "evict_to_fit", 3000, 0, tracker)
+	for range 5 {
+		require.True(t, scheduler.PlanProcess(small).Fits)
+	}
+	require.Equal(t, 1, allocator.calls, "plans share one query while the running processes stay the same")
+
+	idle.forceState(StateStopped)
+	scheduler.PlanProcess(small)
+	require.Equal(t, 2, allocator.calls, "a process that stopped queries again")
+
+	defer func(ttl time.Duration) { planGPUsTTL = ttl }(planGPUsTTL)
+	planGPUsTTL = 0
+	scheduler.PlanProcess(small)
+	require.Equal(t, 3, allocator.calls)
+}
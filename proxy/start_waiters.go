@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// A start is shared by the requests waiting for it. When every one of them
+// disconnects before the process is ready the start is aborted so the process
+// does not sit loaded until its TTL for nobody. Preloads wait with a context
+// that is never cancelled and keep their start alive.
+
+// errStartAborted is returned by start when every waiting request disconnected
+var errStartAborted = errors.New("start aborted, every waiting request disconnected")
+
+// startFor starts the process on behalf of a request, the request stops
+// waiting for the start when ctx is done.
+func (p *Process) startFor(ctx context.Context) error {
+	p.startMutex.Lock()
+	if p.startWaiters == 0 {
+		p.startAbandoned = false
+	}
+	p.startWaiters++
+	p.startMutex.Unlock()
+
+	// the start goes on for the other waiters when this request leaves
+	result := make(chan error, 1)
+	go func() {
+		result <- p.start()
+	}()
+
+	select {
+	case err := <-result:
+		p.releaseStart(false)
+		return err
+	case <-ctx.Done():
+		p.releaseStart(true)
+		return context.Cause(ctx)
+	}
+}
+
+// releaseStart removes a waiter, disconnected is true when it left before the
+// start finished
+func (p *Process) releaseStart(disconnected bool) {
+	p.startMutex.Lock()
+	defer p.startMutex.Unlock()
+	p.startWaiters--
+	if p.startWaiters == 0 && disconnected && p.CurrentState() != StateReady {
+		p.startAbandoned = true
+	}
+}
+
+// isStartAbandoned returns true when every request waiting for the current
+// start disconnected
+func (p *Process) isStartAbandoned() bool {
+	p.startMutex.Lock()
+	defer p.startMutex.Unlock()
+	return p.startAbandoned
+}
+
+// recordEvictions remembers the processes the scheduler stopped to make room
+// for the current start
+func (p *Process) recordEvictions(evicted []*Process) {
+	p.startMutex.Lock()
+	defer p.startMutex.Unlock()
+	for _, process := range evicted {
+		p.startEvictions = append(p.startEvictions, process.ID)
+	}
+}
+
+// resetStartEvictions clears the evictions of the previous start
+func (p *Process) resetStartEvictions() {
+	p.startMutex.Lock()
+	defer p.startMutex.Unlock()
+	p.startEvictions = nil
+}
+
+// clearStartAbandoned is called when a start finished so a later start
+// without waiting requests is not aborted
+func (p *Process) clearStartAbandoned() {
+	p.startMutex.Lock()
+	defer p.startMutex.Unlock()
+	p.startAbandoned = false
+}
+
+// abortStart stops a start nobody waits for anymore, cmdStarted is false when
+// the upstream command was not run yet
+func (p *Process) abortStart(cmdStarted bool) error {
+	p.startMutex.Lock()
+	evictions := p.startEvictions
+	p.startMutex.Unlock()
+
+	if len(evictions) > 0 {
+		p.proxyLogger.Warnf("<%s> aborting start, every waiting request disconnected. Stopped to make room for it: %s", p.ID, strings.Join(evictions, ", "))
+	} else {
+		p.proxyLogger.Infof("<%s> aborting start, every waiting request disconnected", p.ID)
+	}
+
+	if !cmdStarted {
+		if _, err := p.swapState(StateStarting, StateStopped); err != nil {
+			p.forceState(StateStopped)
+		}
+		return errStartAborted
+	}
+
+	// waitForCmd moves the process to StateStopped once the command exited
+	p.swapState(StateStarting, StateStopping)
+	p.stopCommand()
+	return errStartAborted
+}
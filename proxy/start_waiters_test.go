@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newNeverReadyProcess returns a process whose health check never passes
+func newNeverReadyProcess(t *testing.T, id string) *Process {
+	t.Helper()
+	cfg := getTestSimpleResponderConfig(id)
+	cfg.CheckEndpoint = "/not-found"
+	process := NewProcess(id, 30, cfg, debugLogger, NewLogMonitorWriter(io.Discard))
+	process.healthCheckLoopInterval = 50 * time.Millisecond
+	return process
+}
+
+func waitingRequest(ctx context.Context, process *Process) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"m"}`)).WithContext(ctx)
+		w := httptest.NewRecorder()
+		process.ProxyRequest(w, req)
+		done <- w
+	}()
+	return done
+}
+
+func TestProcess_StartAbortedWhenWaitersDisconnect(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping start abort test")
+	}
+
+	process := newNeverReadyProcess(t, "abandoned")
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	firstDone := waitingRequest(first, process)
+	secondDone := waitingRequest(second, process)
+
+	require.Eventually(t, func() bool {
+		process.startMutex.Lock()
+		defer process.startMutex.Unlock()
+		return process.startWaiters == 2 && process.CurrentState() == StateStarting
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// one request is still waiting, the start goes on
+	cancelFirst()
+	<-firstDone
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, StateStarting, process.CurrentState())
+
+	begin := time.Now()
+	cancelSecond()
+	<-secondDone
+	assert.Less(t, time.Since(begin), 5*time.Second, "the start did not run until the health check timeout")
+	require.Eventually(t, func() bool {
+		return process.CurrentState() == StateStopped
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the next request starts the process again
+	third, cancelThird := context.WithCancel(context.Background())
+	thirdDone := waitingRequest(third, process)
+	require.Eventually(t, func() bool {
+		return process.CurrentState() == StateStarting
+	}, 5*time.Second, 10*time.Millisecond)
+	cancelThird()
+	<-thirdDone
+	require.Eventually(t, func() bool {
+		return process.CurrentState() == StateStopped
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProcess_AbortStartLogsEvictions(t *testing.T) {
+	logger := NewLogMonitorWriter(io.Discard)
+	process := NewProcess("starting", 5, getTestSimpleResponderConfig("starting"), logger, logger)
+	evicted := NewProcess("evicted", 5, getTestSimpleResponderConfig("evicted"), logger, logger)
+
+	_, err := process.swapState(StateStopped, StateStarting)
+	require.NoError(t, err)
+	process.recordEvictions([]*Process{evicted})
+
+	assert.ErrorIs(t, process.abortStart(false), errStartAborted)
+	assert.Equal(t, StateStopped, process.CurrentState())
+	assert.Contains(t, string(logger.GetHistory()), "Stopped to make room for it: evicted")
+}
+
+func TestProcess_StartWithoutWaitersIsNotAborted(t *testing.T) {
+	process := NewProcess("direct", 5, getTestSimpleResponderConfig("direct"), debugLogger, debugLogger)
+	defer process.StopImmediately()
+
+	// a waiter that left while the process was stopped
+	process.startWaiters++
+	process.releaseStart(true)
+	require.True(t, process.isStartAbandoned())
+
+	// a new waiter resets it
+	require.NoError(t, process.startFor(context.Background()))
+	assert.Equal(t, StateReady, process.CurrentState())
+	assert.False(t, process.isStartAbandoned())
+}
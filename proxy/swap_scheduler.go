@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/event"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+)
+
+// swapScheduler holds requests for models that are not loaded when loading
+// them would stop a model that is busy or was only loaded recently. The loaded
+// model serves its requests first and the held requests for a model are
+// released together, so clients alternating between models share one swap
+// instead of swapping on every request.
+type swapScheduler struct {
+	sync.Mutex
+	scheduler    *Scheduler
+	maxHold      time.Duration
+	minResidency time.Duration
+
+	// models with held requests
+	held map[string]*swapHold
+
+	// closed and replaced when a request finishes to wake the held requests
+	finished chan struct{}
+
+	heldRequests int64
+	holdTime     time.Duration
+	swapsAvoided int64
+	timeSaved    time.Duration
+}
+
+type swapHold struct {
+	requests int
+
+	// the loaded models the held requests would stop
+	plan swapPlan
+
+	// loaded models that served requests while requests were held, each one
+	// is a swap back that did not happen
+	served map[string]bool
+}
+
+// swapPlan is what loading a model that is not loaded would do
+type swapPlan struct {
+	// false when busy processes hold the memory
+	fits bool
+
+	// the processes that would be stopped, all running processes when it
+	// does not fit yet
+	evict map[string]*Process
+}
+
+// SwapSchedulerStats are the swap counters returned by /api/scheduler/stats
+type SwapSchedulerStats struct {
+	Enabled bool `json:"enabled"`
+
+	// starts that stopped other models and the number of models stopped
+	Swaps     int64 `json:"swaps"`
+	Evictions int64 `json:"evictions"`
+
+	// requests held for a model that was not loaded and the total time they waited
+	HeldRequests int64 `json:"held_requests"`
+	HoldTimeMs   int64 `json:"hold_time_ms"`
+
+	// swaps back to a loaded model that were avoided by holding requests and
+	// the load time of those models
+	SwapsAvoided int64 `json:"swaps_avoided"`
+	TimeSavedMs  int64 `json:"time_saved_ms"`
+
+	// requests held right now by model
+	Holding map[string]int `json:"holding"`
+}
+
+func newSwapScheduler(cfg config.SwapSchedulerConfig, scheduler *Scheduler) *swapScheduler {
+	return &swapScheduler{
+		scheduler:    scheduler,
+		maxHold:      time.Duration(cfg.MaxHoldMs) * time.Millisecond,
+		minResidency: time.Duration(cfg.MinResidencyMs) * time.Millisecond,
+		held:         make(map[string]*swapHold),
+		finished:     make(chan struct{}),
+	}
+}
+
+// handler returns a handler for proxyInferenceHandler that holds requests to
+// process before passing them to upstream
+func (s *swapScheduler) handler(process *Process, upstream func(modelID string, w http.ResponseWriter, r *http.Request) error) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		s.hold(r.Context(), process)
+		defer s.requestFinished()
+		return upstream(modelID, w, r)
+	}
+}
+
+// hold returns once process can be loaded, its hold time is up or ctx is done.
+// Held requests plan again when a process changes state, a request finishes or
+// the minimum residency of a process that would be stopped is up.
+func (s *swapScheduler) hold(ctx context.Context, process *Process) {
+	changed := make(chan struct{}, 1)
+	defer event.On(func(e ProcessStateChangeEvent) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})()
+
+	finished := s.finishedChan()
+	plan, needsSwap := s.plan(process)
+	if !needsSwap {
+		s.servedLoaded(process)
+		return
+	}
+
+	s.Lock()
+	h, found := s.held[process.ID]
+	if !found {
+		h = &swapHold{served: make(map[string]bool)}
+		s.held[process.ID] = h
+	}
+	h.requests++
+	h.plan = plan
+	s.heldRequests++
+	s.Unlock()
+
+	begin := time.Now()
+	defer func() {
+		s.Lock()
+		defer s.Unlock()
+		h.requests--
+		if h.requests == 0 {
+			delete(s.held, process.ID)
+		}
+		s.holdTime += time.Since(begin)
+	}()
+
+	maxHold := s.maxHold
+	if process.config.MaxHoldMs > 0 {
+		maxHold = time.Duration(process.config.MaxHoldMs) * time.Millisecond
+	}
+	deadline := begin.Add(maxHold)
+
+	for {
+		if !needsSwap || s.canSwap(plan) || !time.Now().Before(deadline) {
+			return
+		}
+		timer := time.NewTimer(s.replanAfter(plan, deadline))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-changed:
+		case <-finished:
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		finished = s.finishedChan()
+		plan, needsSwap = s.plan(process)
+		s.Lock()
+		h.plan = plan
+		s.Unlock()
+	}
+}
+
+// requestFinished wakes the held requests, the process that served the request
+// may be idle now
+func (s *swapScheduler) requestFinished() {
+	s.Lock()
+	defer s.Unlock()
+	close(s.finished)
+	s.finished = make(chan struct{})
+}
+
+func (s *swapScheduler) finishedChan() chan struct{} {
+	s.Lock()
+	defer s.Unlock()
+	return s.finished
+}
+
+// replanAfter returns when a held request has to plan again without being
+// woken: when its hold time or the minimum residency of a process it would
+// stop is up. When it does not fit it also retries like the scheduler does,
+// memory used outside llama-swap may have been freed.
+func (s *swapScheduler) replanAfter(plan swapPlan, deadline time.Time) time.Duration {
+	after := time.Until(deadline)
+	if !plan.fits {
+		return min(after, scheduleRetryInterval)
+	}
+	for _, evicted := range plan.evict {
+		if residency := s.minResidencyOf(evicted) - time.Since(evicted.ReadySince()); residency > 0 {
+			after = min(after, residency)
+		}
+	}
+	return after
+}
+
+// plan returns what loading process would do. False means it is loaded or
+// can be loaded without stopping anything.
+func (s *swapScheduler) plan(process *Process) (swapPlan, bool) {
+	if process.CurrentState() != StateStopped {
+		return swapPlan{}, false
+	}
+	startPlan := s.scheduler.PlanProcess(process)
+	if startPlan.Fits && len(startPlan.Evict) == 0 {
+		return swapPlan{}, false
+	}
+
+	plan := swapPlan{fits: startPlan.Fits, evict: make(map[string]*Process)}
+	evict := startPlan.Evict
+	if !plan.fits {
+		evict = s.scheduler.provider()
+	}
+	for _, evicted := range evict {
+		plan.evict[evicted.ID] = evicted
+	}
+	return plan, true
+}
+
+// canSwap returns true when every process that would be stopped is idle and
+// has been loaded for its minimum residency
+func (s *swapScheduler) canSwap(plan swapPlan) bool {
+	if !plan.fits {
+		return false
+	}
+	for _, evicted := range plan.evict {
+		if evicted.InFlightRequestsCount() > 0 {
+			return false
+		}
+		if time.Since(evicted.ReadySince()) < s.minResidencyOf(evicted) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *swapScheduler) minResidencyOf(process *Process) time.Duration {
+	if process.config.MinResidencyMs > 0 {
+		return time.Duration(process.config.MinResidencyMs) * time.Millisecond
+	}
+	return s.minResidency
+}
+
+// servedLoaded counts the swaps avoided by serving a loaded model while
+// requests that would stop it are held
+func (s *swapScheduler) servedLoaded(process *Process) {
+	if process.CurrentState() != StateReady {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, h := range s.held {
+		if _, stops := h.plan.evict[process.ID]; !stops || h.served[process.ID] {
+			continue
+		}
+		h.served[process.ID] = true
+		s.swapsAvoided++
+		s.timeSaved += process.LoadDuration()
+	}
+}
+
+func (s *swapScheduler) stats() SwapSchedulerStats {
+	s.Lock()
+	defer s.Unlock()
+	stats := SwapSchedulerStats{
+		Enabled:      true,
+		HeldRequests: s.heldRequests,
+		HoldTimeMs:   s.holdTime.Milliseconds(),
+		SwapsAvoided: s.swapsAvoided,
+		TimeSavedMs:  s.timeSaved.Milliseconds(),
+		Holding:      make(map[string]int, len(s.held)),
+	}
+	for modelID, h := range s.held {
+		stats.Holding[modelID] = h.requests
+	}
+	stats.Swaps, stats.Evictions = s.scheduler.SwapCounts()
+	return stats
+}
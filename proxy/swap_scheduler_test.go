@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSwapScheduler(t *testing.T, cfg config.SwapSchedulerConfig) (*swapScheduler, *Process, *Process) {
+	t.Helper()
+	tracker := NewMemoryTracker()
+	loaded := newTestProcess(t, "loaded", "evict_to_fit", 600, 0, tracker)
+	readyOnGPU(loaded, 0)
+	loaded.readyAt.Store(time.Now().Add(-time.Hour).UnixNano())
+	loaded.loadDuration.Store(int64(2 * time.Second))
+	other := newTestProcess(t, "other", "evict_to_fit", 600, 0, tracker)
+
+	allocator := &scenarioGPUAllocator{gpus: []GPUInfo{{Index: 0, TotalMB: 1000}}}
+	allocator.provider = func() []*Process { return []*Process{loaded} }
+	scheduler := NewScheduler(allocator, testLogger, allocator.provider, SchedulerOptions{})
+	return newSwapScheduler(cfg, scheduler), loaded, other
+}
+
+func TestSwapScheduler_ServesLoadedModelFirst(t *testing.T) {
+	swaps, loaded, other := newTestSwapScheduler(t, config.SwapSchedulerConfig{MaxHoldMs: 5000})
+	loaded.inFlightRequestsCount.Add(1)
+
+	released := make(chan struct{})
+	go func() {
+		swaps.hold(context.Background(), other)
+		close(released)
+	}()
+
+	require.Eventually(t, func() bool { return swaps.stats().Holding["other"] == 1 }, time.Second, 5*time.Millisecond)
+
+	// requests for the loaded model are not held
+	swaps.hold(context.Background(), loaded)
+	swaps.hold(context.Background(), loaded)
+
+	select {
+	case <-released:
+		t.Fatal("request released while the loaded model is busy")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	loaded.inFlightRequestsCount.Add(-1)
+	swaps.requestFinished()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("request not released once the loaded model is idle")
+	}
+
+	stats := swaps.stats()
+	assert.Equal(t, int64(1), stats.HeldRequests)
+	assert.Equal(t, int64(1), stats.SwapsAvoided, "one swap back per hold")
+	assert.Equal(t, int64(2000), stats.TimeSavedMs)
+	assert.GreaterOrEqual(t, stats.HoldTimeMs, int64(100))
+	assert.Empty(t, stats.Holding)
+}
+
+func TestSwapScheduler_MinResidency(t *testing.T) {
+	swaps, loaded, other := newTestSwapScheduler(t, config.SwapSchedulerConfig{MaxHoldMs: 5000})
+	loaded.config.MinResidencyMs = 150
+	loaded.readyAt.Store(time.Now().UnixNano())
+
+	begin := time.Now()
+	swaps.hold(context.Background(), other)
+	assert.GreaterOrEqual(t, time.Since(begin), 100*time.Millisecond)
+
+	// once the loaded model was resident long enough the request is not held
+	begin = time.Now()
+	swaps.hold(context.Background(), other)
+	assert.Less(t, time.Since(begin), 50*time.Millisecond)
+}
+
+func TestSwapScheduler_MaxHold(t *testing.T) {
+	swaps, loaded, other := newTestSwapScheduler(t, config.SwapSchedulerConfig{MaxHoldMs: 5000})
+	loaded.inFlightRequestsCount.Add(1)
+	defer loaded.inFlightRequestsCount.Add(-1)
+	other.config.MaxHoldMs = 50
+
+	begin := time.Now()
+	swaps.hold(context.Background(), other)
+	assert.Less(t, time.Since(begin), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	other.config.MaxHoldMs = 0
+	begin = time.Now()
+	swaps.hold(ctx, other)
+	assert.Less(t, time.Since(begin), time.Second, "held requests stop waiting when the client leaves")
+}
+
+func TestSwapScheduler_HoldWaitsForEvents(t *testing.T) {
+	swaps, loaded, other := newTestSwapScheduler(t, config.SwapSchedulerConfig{MaxHoldMs: 5000})
+	allocator := swaps.scheduler.allocator.(*scenarioGPUAllocator)
+	loaded.inFlightRequestsCount.Add(1)
+
+	released := make(chan struct{})
+	go func() {
+		swaps.hold(context.Background(), other)
+		close(released)
+	}()
+	require.Eventually(t, func() bool { return swaps.stats().Holding["other"] == 1 }, time.Second, 5*time.Millisecond)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, allocator.calls, "held requests do not plan until something changed")
+
+	loaded.inFlightRequestsCount.Add(-1)
+	swaps.requestFinished()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("request not released once a request finished")
+	}
+}
+
+func TestProxyManager_SchedulerStats(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+models:
+  model1:
+    cmd: server --port ${PORT}
+`))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("GET", "/api/scheduler/stats", nil)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var stats SwapSchedulerStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.False(t, stats.Enabled)
+	assert.Equal(t, int64(0), stats.Swaps)
+}
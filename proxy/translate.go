@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// OpenAI chat completion request types used when translating other APIs into
+// chat completions for upstreams that only implement the OpenAI API.
+
+type openAIChatRequest struct {
+	Model             string               `json:"model"`
+	Messages          []openAIMessage      `json:"messages"`
+	MaxTokens         *int                 `json:"max_tokens,omitempty"`
+	Temperature       *float64             `json:"temperature,omitempty"`
+	TopP              *float64             `json:"top_p,omitempty"`
+	TopK              *int                 `json:"top_k,omitempty"`
+	Stop              []string             `json:"stop,omitempty"`
+	Stream            bool                 `json:"stream,omitempty"`
+	StreamOptions     *openAIStreamOptions `json:"stream_options,omitempty"`
+	Tools             []openAITool         `json:"tools,omitempty"`
+	ToolChoice        any                  `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool                `json:"parallel_tool_calls,omitempty"`
+	User              string               `json:"user,omitempty"`
+	ResponseFormat    any                  `json:"response_format,omitempty"`
+	ReasoningEffort   string               `json:"reasoning_effort,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIMessage struct {
+	Role string `json:"role"`
+	// Content is a string, a []openAIContentPart or nil
+	Content    any              `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL string `json:"url"`
+}
+
+type openAIToolCall struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function openAIFunctionCall `json:"function"`
+}
+
+type openAIFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// openAIUsage holds the token counts of a chat completion usage object
+type openAIUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	CachedTokens     int
+}
+
+func parseOpenAIUsage(usage gjson.Result) openAIUsage {
+	return openAIUsage{
+		PromptTokens:     int(usage.Get("prompt_tokens").Int()),
+		CompletionTokens: int(usage.Get("completion_tokens").Int()),
+		CachedTokens:     int(usage.Get("prompt_tokens_details.cached_tokens").Int()),
+	}
+}
+
+// sseDataParser splits a Server-Sent Events stream that arrives in arbitrary
+// chunks into the payloads of its data: lines
+type sseDataParser struct {
+	pending []byte
+	onData  func(data []byte)
+}
+
+func (p *sseDataParser) Write(b []byte) {
+	p.pending = append(p.pending, b...)
+	for {
+		i := bytes.IndexByte(p.pending, '\n')
+		if i < 0 {
+			return
+		}
+		line := p.pending[:i]
+		p.pending = p.pending[i+1:]
+		p.line(line)
+	}
+}
+
+// Close handles a final line that was not terminated by a newline
+func (p *sseDataParser) Close() {
+	if len(p.pending) > 0 {
+		p.line(p.pending)
+		p.pending = nil
+	}
+}
+
+func (p *sseDataParser) line(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if data, found := bytes.CutPrefix(line, []byte("data:")); found {
+		p.onData(bytes.TrimSpace(data))
+	}
+}
+
+// responseTranslator converts an upstream OpenAI chat completion response into
+// another API's format
+type responseTranslator interface {
+	// handleChunk is called with the payload of each data: line of a stream
+	handleChunk(data []byte)
+	// finishStream is called once the upstream stream has ended
+	finishStream()
+	// translateBody converts a complete non streaming response or error
+	translateBody(status int, body []byte) []byte
+}
+
+// translatingResponseWriter sits between the upstream and the client. SSE
+// streams are passed to the translator as they arrive, other responses are
+// buffered and translated in finish().
+type translatingResponseWriter struct {
+	gin.ResponseWriter
+
+	translator responseTranslator
+	// overrides the Content-Type of translated streams when set
+	streamContentType string
+
+	status      int
+	wroteHeader bool
+	streaming   bool
+	body        bytes.Buffer
+	sse         sseDataParser
+}
+
+func newTranslatingResponseWriter(w gin.ResponseWriter, translator responseTranslator) *translatingResponseWriter {
+	tw := &translatingResponseWriter{
+		ResponseWriter: w,
+		translator:     translator,
+	}
+	tw.sse.onData = translator.handleChunk
+	return tw
+}
+
+func (w *translatingResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = statusCode
+
+	header := w.ResponseWriter.Header()
+	header.Del("Content-Length")
+	w.streaming = statusCode == http.StatusOK && strings.Contains(header.Get("Content-Type"), "text/event-stream")
+	if !w.streaming {
+		header.Set("Content-Type", "application/json")
+	} else if w.streamContentType != "" {
+		header.Set("Content-Type", w.streamContentType)
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *translatingResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.streaming {
+		w.sse.Write(b)
+	} else {
+		w.body.Write(b)
+	}
+	return len(b), nil
+}
+
+func (w *translatingResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *translatingResponseWriter) Flush() {
+	if w.streaming {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// finish writes the translated response once the upstream is done
+func (w *translatingResponseWriter) finish() {
+	if !w.wroteHeader {
+		return
+	}
+
+	if w.streaming {
+		w.sse.Close()
+		w.translator.finishStream()
+		w.ResponseWriter.Flush()
+		return
+	}
+	w.ResponseWriter.Write(w.translator.translateBody(w.status, w.body.Bytes()))
+}
+
+// rewriteToChatCompletions points a translated request at the upstream's
+// chat completions endpoint
+func rewriteToChatCompletions(r *http.Request) {
+	r.URL.Path = "/v1/chat/completions"
+	r.URL.RawPath = ""
+	// the translator needs an uncompressed response
+	r.Header.Set("Accept-Encoding", "identity")
+}
+
+// writeSSEEvent writes a named Server-Sent Event with a JSON payload
+func writeSSEEvent(w http.ResponseWriter, event string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(event)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// openAIError returns an OpenAI style error body
+func openAIError(status int, message string) gin.H {
+	errorType := "server_error"
+	if status >= 400 && status < 500 {
+		errorType = "invalid_request_error"
+	}
+	return gin.H{"error": gin.H{"message": message, "type": errorType, "code": nil, "param": nil}}
+}
+
+// upstreamErrorMessage extracts a human readable message from an upstream error body
+func upstreamErrorMessage(status int, body []byte) string {
+	if gjson.ValidBytes(body) {
+		parsed := gjson.ParseBytes(body)
+		if message := parsed.Get("error.message"); message.Type == gjson.String && message.Str != "" {
+			return message.Str
+		}
+		if message := parsed.Get("error"); message.Type == gjson.String && message.Str != "" {
+			return message.Str
+		}
+		if message := parsed.Get("message"); message.Type == gjson.String && message.Str != "" {
+			return message.Str
+		}
+	}
+	if message := string(bytes.TrimSpace(body)); message != "" {
+		return message
+	}
+	return http.StatusText(status)
+}
+
+// newTranslationID returns a random identifier with prefix for translated responses
+func newTranslationID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
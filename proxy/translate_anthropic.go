@@ -0,0 +1,557 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// Anthropic Messages API request types, only the fields that can be
+// translated to OpenAI chat completions are decoded
+
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	System        json.RawMessage      `json:"system"`
+	Messages      []anthropicMessage   `json:"messages"`
+	MaxTokens     *int                 `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature"`
+	TopP          *float64             `json:"top_p"`
+	TopK          *int                 `json:"top_k"`
+	StopSequences []string             `json:"stop_sequences"`
+	Stream        bool                 `json:"stream"`
+	Tools         []anthropicTool      `json:"tools"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice"`
+	Metadata      struct {
+		UserID string `json:"user_id"`
+	} `json:"metadata"`
+}
+
+type anthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text"`
+	Source    *anthropicSource `json:"source"`
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Input     json.RawMessage  `json:"input"`
+	ToolUseID string           `json:"tool_use_id"`
+	Content   json.RawMessage  `json:"content"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+	URL       string `json:"url"`
+}
+
+type anthropicTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use"`
+}
+
+// isAnthropicPath returns true for the Anthropic API endpoints llama-swap can translate
+func isAnthropicPath(path string) bool {
+	return path == "/v1/messages" || path == "/v1/messages/count_tokens"
+}
+
+// anthropicToOpenAIRequest converts an Anthropic Messages request body into
+// an OpenAI chat completions request body
+func anthropicToOpenAIRequest(body []byte) ([]byte, error) {
+	var req anthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid anthropic request: %w", err)
+	}
+
+	out := openAIChatRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		TopK:        req.TopK,
+		Stop:        req.StopSequences,
+		Stream:      req.Stream,
+		User:        req.Metadata.UserID,
+	}
+	if req.Stream {
+		// usage is needed in the stream for message_delta and for metrics
+		out.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+
+	if system, err := anthropicSystemText(req.System); err != nil {
+		return nil, err
+	} else if system != "" {
+		out.Messages = append(out.Messages, openAIMessage{Role: "system", Content: system})
+	}
+
+	for i, message := range req.Messages {
+		converted, err := anthropicMessageToOpenAI(message)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		out.Messages = append(out.Messages, converted...)
+	}
+
+	for _, tool := range req.Tools {
+		// server tools like web_search have a versioned type and can not be run by the upstream
+		if tool.Type != "" && tool.Type != "custom" {
+			continue
+		}
+		out.Tools = append(out.Tools, openAITool{
+			Type: "function",
+			Function: openAIFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
+	if req.ToolChoice != nil {
+		switch req.ToolChoice.Type {
+		case "auto":
+			out.ToolChoice = "auto"
+		case "any":
+			out.ToolChoice = "required"
+		case "none":
+			out.ToolChoice = "none"
+		case "tool":
+			out.ToolChoice = map[string]any{
+				"type":     "function",
+				"function": map[string]string{"name": req.ToolChoice.Name},
+			}
+		}
+		if req.ToolChoice.DisableParallelToolUse {
+			parallel := false
+			out.ParallelToolCalls = &parallel
+		}
+	}
+
+	return json.Marshal(out)
+}
+
+// anthropicSystemText returns the system prompt which is a string or a list of text blocks
+func anthropicSystemText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	text, err := anthropicContentText(raw)
+	if err != nil {
+		return "", fmt.Errorf("system: %w", err)
+	}
+	return text, nil
+}
+
+// anthropicContentText joins the text of a string or a list of content blocks,
+// other block types are dropped
+func anthropicContentText(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var blocks []anthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("content must be a string or a list of content blocks")
+	}
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// anthropicMessageToOpenAI converts a message to one or more OpenAI messages.
+// tool_result blocks become separate tool messages placed before the rest of
+// the user's content so they directly follow the assistant's tool calls.
+func anthropicMessageToOpenAI(message anthropicMessage) ([]openAIMessage, error) {
+	var text string
+	if err := json.Unmarshal(message.Content, &text); err == nil {
+		return []openAIMessage{{Role: message.Role, Content: text}}, nil
+	}
+
+	var blocks []anthropicContentBlock
+	if err := json.Unmarshal(message.Content, &blocks); err != nil {
+		return nil, fmt.Errorf("content must be a string or a list of content blocks")
+	}
+
+	var (
+		toolMessages []openAIMessage
+		parts        []openAIContentPart
+		toolCalls    []openAIToolCall
+		hasImages    bool
+	)
+
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, openAIContentPart{Type: "text", Text: block.Text})
+		case "image":
+			if block.Source == nil {
+				continue
+			}
+			url := block.Source.URL
+			if block.Source.Type == "base64" {
+				url = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+			}
+			parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url}})
+			hasImages = true
+		case "tool_use":
+			arguments := "{}"
+			if len(block.Input) > 0 {
+				arguments = string(block.Input)
+			}
+			toolCalls = append(toolCalls, openAIToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: block.Name, Arguments: arguments},
+			})
+		case "tool_result":
+			content := ""
+			if len(block.Content) > 0 {
+				var err error
+				if content, err = anthropicContentText(block.Content); err != nil {
+					return nil, fmt.Errorf("tool_result: %w", err)
+				}
+			}
+			toolMessages = append(toolMessages, openAIMessage{
+				Role:       "tool",
+				ToolCallID: block.ToolUseID,
+				Content:    content,
+			})
+		}
+		// thinking and other block types can not be sent to an OpenAI upstream
+	}
+
+	messages := toolMessages
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages, nil
+	}
+
+	converted := openAIMessage{Role: message.Role, ToolCalls: toolCalls}
+	if hasImages {
+		converted.Content = parts
+	} else {
+		// plain strings work with more upstreams than content parts
+		texts := make([]string, 0, len(parts))
+		for _, part := range parts {
+			texts = append(texts, part.Text)
+		}
+		converted.Content = strings.Join(texts, "\n\n")
+	}
+	return append(messages, converted), nil
+}
+
+// estimateAnthropicInputTokens is used to answer count_tokens for upstreams that
+// do not implement it. It is a rough estimate based on the length of the prompt.
+func estimateAnthropicInputTokens(body []byte) int {
+	req := newRouteRequest("", body)
+	tokens := req.PromptTokens
+	if tools := gjson.GetBytes(body, "tools"); tools.Exists() {
+		tokens += (len(tools.Raw) + 3) / 4
+	}
+	return tokens
+}
+
+// anthropicStopReason maps an OpenAI finish_reason to an Anthropic stop_reason
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// anthropicErrorType maps an HTTP status to an Anthropic error type
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+func anthropicError(status int, message string) gin.H {
+	return gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    anthropicErrorType(status),
+			"message": message,
+		},
+	}
+}
+
+// openAIToAnthropicResponse converts a chat completion response to an Anthropic message
+func openAIToAnthropicResponse(body []byte, model string) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("invalid JSON in chat completion response")
+	}
+	parsed := gjson.ParseBytes(body)
+	choice := parsed.Get("choices.0")
+	message := choice.Get("message")
+
+	content := []gin.H{}
+	if reasoning := firstString(message, "reasoning_content", "reasoning"); reasoning != "" {
+		content = append(content, gin.H{"type": "thinking", "thinking": reasoning, "signature": ""})
+	}
+	if text := message.Get("content").String(); text != "" {
+		content = append(content, gin.H{"type": "text", "text": text})
+	}
+	for _, call := range message.Get("tool_calls").Array() {
+		id := call.Get("id").String()
+		if id == "" {
+			id = newTranslationID("toolu_")
+		}
+		content = append(content, gin.H{
+			"type":  "tool_use",
+			"id":    id,
+			"name":  call.Get("function.name").String(),
+			"input": toolArguments(call.Get("function.arguments").String()),
+		})
+	}
+
+	usage := parseOpenAIUsage(parsed.Get("usage"))
+	id := parsed.Get("id").String()
+	if id == "" {
+		id = newTranslationID("msg_")
+	}
+
+	return json.Marshal(gin.H{
+		"id":            id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   anthropicStopReason(choice.Get("finish_reason").String()),
+		"stop_sequence": nil,
+		"usage":         anthropicUsage(usage),
+	})
+}
+
+func anthropicUsage(usage openAIUsage) gin.H {
+	return gin.H{
+		"input_tokens":            usage.PromptTokens - usage.CachedTokens,
+		"output_tokens":           usage.CompletionTokens,
+		"cache_read_input_tokens": usage.CachedTokens,
+	}
+}
+
+// toolArguments parses tool call arguments, invalid JSON becomes an empty object
+func toolArguments(arguments string) json.RawMessage {
+	if arguments != "" && json.Valid([]byte(arguments)) {
+		return json.RawMessage(arguments)
+	}
+	return json.RawMessage("{}")
+}
+
+func firstString(value gjson.Result, keys ...string) string {
+	for _, key := range keys {
+		if s := value.Get(key).String(); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// anthropicTranslator converts an OpenAI chat completion response, or SSE
+// stream, written by the upstream into an Anthropic Messages response.
+type anthropicTranslator struct {
+	out   gin.ResponseWriter
+	model string
+
+	// stream state
+	started    bool
+	finished   bool
+	blockOpen  bool
+	blockType  string
+	blockIndex int
+	toolBlocks map[int64]int
+	stopReason string
+	usage      openAIUsage
+}
+
+func newAnthropicResponseWriter(w gin.ResponseWriter, model string) *translatingResponseWriter {
+	return newTranslatingResponseWriter(w, &anthropicTranslator{
+		out:        w,
+		model:      model,
+		blockIndex: -1,
+		toolBlocks: make(map[int64]int),
+	})
+}
+
+func (w *anthropicTranslator) translateBody(status int, body []byte) []byte {
+	var out []byte
+	if status == http.StatusOK {
+		var err error
+		if out, err = openAIToAnthropicResponse(body, w.model); err != nil {
+			out, _ = json.Marshal(anthropicError(http.StatusBadGateway, err.Error()))
+		}
+	} else {
+		out, _ = json.Marshal(anthropicError(status, upstreamErrorMessage(status, body)))
+	}
+	return out
+}
+
+func (w *anthropicTranslator) event(event string, payload gin.H) {
+	payload["type"] = event
+	writeSSEEvent(w.out, event, payload)
+}
+
+func (w *anthropicTranslator) handleChunk(data []byte) {
+	if w.finished || len(data) == 0 {
+		return
+	}
+	if string(data) == "[DONE]" {
+		w.finishStream()
+		return
+	}
+	if !gjson.ValidBytes(data) {
+		return
+	}
+	chunk := gjson.ParseBytes(data)
+
+	if errValue := chunk.Get("error"); errValue.Exists() {
+		w.event("error", gin.H{"error": gin.H{"type": "api_error", "message": upstreamErrorMessage(http.StatusInternalServerError, data)}})
+		return
+	}
+
+	w.start(chunk.Get("id").String())
+
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		w.usage = parseOpenAIUsage(usage)
+	}
+
+	choice := chunk.Get("choices.0")
+	if !choice.Exists() {
+		return
+	}
+	delta := choice.Get("delta")
+
+	if reasoning := firstString(delta, "reasoning_content", "reasoning"); reasoning != "" {
+		w.openBlock("thinking", gin.H{"type": "thinking", "thinking": ""})
+		w.event("content_block_delta", gin.H{"index": w.blockIndex, "delta": gin.H{"type": "thinking_delta", "thinking": reasoning}})
+	}
+
+	if text := delta.Get("content").String(); text != "" {
+		w.openBlock("text", gin.H{"type": "text", "text": ""})
+		w.event("content_block_delta", gin.H{"index": w.blockIndex, "delta": gin.H{"type": "text_delta", "text": text}})
+	}
+
+	for _, call := range delta.Get("tool_calls").Array() {
+		callIndex := call.Get("index").Int()
+		blockIndex, known := w.toolBlocks[callIndex]
+		if !known || (call.Get("id").String() != "" && w.blockIndex != blockIndex) {
+			id := call.Get("id").String()
+			if id == "" {
+				id = newTranslationID("toolu_")
+			}
+			w.closeBlock()
+			w.openBlock("tool_use", gin.H{"type": "tool_use", "id": id, "name": call.Get("function.name").String(), "input": gin.H{}})
+			blockIndex = w.blockIndex
+			w.toolBlocks[callIndex] = blockIndex
+		}
+		if arguments := call.Get("function.arguments").String(); arguments != "" {
+			w.event("content_block_delta", gin.H{"index": blockIndex, "delta": gin.H{"type": "input_json_delta", "partial_json": arguments}})
+		}
+	}
+
+	if finishReason := choice.Get("finish_reason").String(); finishReason != "" {
+		w.stopReason = anthropicStopReason(finishReason)
+	}
+	w.out.Flush()
+}
+
+func (w *anthropicTranslator) start(id string) {
+	if w.started {
+		return
+	}
+	w.started = true
+	if id == "" {
+		id = newTranslationID("msg_")
+	}
+	w.event("message_start", gin.H{"message": gin.H{
+		"id":            id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         w.model,
+		"content":       []any{},
+		"stop_reason":   nil,
+		"stop_sequence": nil,
+		"usage":         gin.H{"input_tokens": 0, "output_tokens": 0},
+	}})
+}
+
+// openBlock starts a new content block unless one of blockType is already open
+func (w *anthropicTranslator) openBlock(blockType string, contentBlock gin.H) {
+	if w.blockOpen && w.blockType == blockType && blockType != "tool_use" {
+		return
+	}
+	w.closeBlock()
+	w.blockIndex++
+	w.blockOpen = true
+	w.blockType = blockType
+	w.event("content_block_start", gin.H{"index": w.blockIndex, "content_block": contentBlock})
+}
+
+func (w *anthropicTranslator) closeBlock() {
+	if !w.blockOpen {
+		return
+	}
+	w.blockOpen = false
+	w.event("content_block_stop", gin.H{"index": w.blockIndex})
+}
+
+func (w *anthropicTranslator) finishStream() {
+	if w.finished {
+		return
+	}
+	w.start("")
+	w.finished = true
+	w.closeBlock()
+
+	stopReason := w.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	w.event("message_delta", gin.H{
+		"delta": gin.H{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": anthropicUsage(w.usage),
+	})
+	w.event("message_stop", gin.H{})
+}
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestAnthropicToOpenAIRequest(t *testing.T) {
+	body := []byte(`{
+		"model": "claude",
+		"max_tokens": 100,
+		"system": [{"type": "text", "text": "be brief"}],
+		"stop_sequences": ["END"],
+		"stream": true,
+		"tools": [
+			{"name": "get_weather", "description": "weather", "input_schema": {"type": "object"}},
+			{"type": "web_search_20250305", "name": "web_search"}
+		],
+		"tool_choice": {"type": "tool", "name": "get_weather", "disable_parallel_tool_use": true},
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "what is this?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "hmm"},
+				{"type": "text", "text": "let me check"},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "text", "text": "thanks"},
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "sunny"}]}
+			]}
+		]
+	}`)
+
+	out, err := anthropicToOpenAIRequest(body)
+	require.NoError(t, err)
+	parsed := gjson.ParseBytes(out)
+
+	assert.Equal(t, "claude", parsed.Get("model").String())
+	assert.Equal(t, int64(100), parsed.Get("max_tokens").Int())
+	assert.Equal(t, "END", parsed.Get("stop.0").String())
+	assert.True(t, parsed.Get("stream_options.include_usage").Bool())
+
+	messages := parsed.Get("messages").Array()
+	require.Len(t, messages, 5)
+	assert.Equal(t, "system", messages[0].Get("role").String())
+	assert.Equal(t, "be brief", messages[0].Get("content").String())
+
+	assert.Equal(t, "text", messages[1].Get("content.0.type").String())
+	assert.Equal(t, "data:image/png;base64,AAAA", messages[1].Get("content.1.image_url.url").String())
+
+	assert.Equal(t, "assistant", messages[2].Get("role").String())
+	assert.Equal(t, "let me check", messages[2].Get("content").String())
+	assert.Equal(t, "toolu_1", messages[2].Get("tool_calls.0.id").String())
+	assert.JSONEq(t, `{"city": "Paris"}`, messages[2].Get("tool_calls.0.function.arguments").String())
+
+	// tool results come right after the assistant's tool calls
+	assert.Equal(t, "tool", messages[3].Get("role").String())
+	assert.Equal(t, "toolu_1", messages[3].Get("tool_call_id").String())
+	assert.Equal(t, "sunny", messages[3].Get("content").String())
+	assert.Equal(t, "user", messages[4].Get("role").String())
+	assert.Equal(t, "thanks", messages[4].Get("content").String())
+
+	assert.Len(t, parsed.Get("tools").Array(), 1)
+	assert.Equal(t, "get_weather", parsed.Get("tools.0.function.name").String())
+	assert.Equal(t, "get_weather", parsed.Get("tool_choice.function.name").String())
+	assert.False(t, parsed.Get("parallel_tool_calls").Bool())
+
+	_, err = anthropicToOpenAIRequest([]byte(`{"messages": [{"role": "user", "content": 1}]}`))
+	assert.ErrorContains(t, err, "messages[0]: content must be")
+}
+
+func TestOpenAIToAnthropicResponse(t *testing.T) {
+	out, err := openAIToAnthropicResponse([]byte(`{
+		"id": "chatcmpl-1",
+		"choices": [{
+			"finish_reason": "tool_calls",
+			"message": {
+				"role": "assistant",
+				"reasoning_content": "thinking",
+				"content": "calling",
+				"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "f", "arguments": "{\"a\":1}"}}]
+			}
+		}],
+		"usage": {"prompt_tokens": 20, "completion_tokens": 5, "prompt_tokens_details": {"cached_tokens": 8}}
+	}`), "claude")
+	require.NoError(t, err)
+	parsed := gjson.ParseBytes(out)
+
+	assert.Equal(t, "message", parsed.Get("type").String())
+	assert.Equal(t, "claude", parsed.Get("model").String())
+	assert.Equal(t, "tool_use", parsed.Get("stop_reason").String())
+	assert.Equal(t, "thinking", parsed.Get("content.0.type").String())
+	assert.Equal(t, "calling", parsed.Get("content.1.text").String())
+	assert.Equal(t, "call_1", parsed.Get("content.2.id").String())
+	assert.Equal(t, int64(1), parsed.Get("content.2.input.a").Int())
+	assert.Equal(t, int64(12), parsed.Get("usage.input_tokens").Int())
+	assert.Equal(t, int64(8), parsed.Get("usage.cache_read_input_tokens").Int())
+	assert.Equal(t, int64(5), parsed.Get("usage.output_tokens").Int())
+}
+
+// sseEvents returns the event names in an SSE body
+func sseEvents(body string) []string {
+	var events []string
+	for _, line := range strings.Split(body, "\n") {
+		if event, found := strings.CutPrefix(line, "event: "); found {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestAnthropicResponseWriter_Stream(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := newAnthropicResponseWriter(c.Writer, "claude")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	chunks := []string{
+		`{"id":"c1","choices":[{"delta":{"reasoning_content":"hm"}}]}`,
+		`{"id":"c1","choices":[{"delta":{"content":"Hel"}}]}`,
+		`{"id":"c1","choices":[{"delta":{"content":"lo"}}]}`,
+		`{"id":"c1","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"f","arguments":""}}]}}]}`,
+		`{"id":"c1","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"a\":1}"}}]}}]}`,
+		`{"id":"c1","choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"c1","choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3}}`,
+		`[DONE]`,
+	}
+	stream := ""
+	for _, chunk := range chunks {
+		stream += "data: " + chunk + "\n\n"
+	}
+	// split writes in the middle of lines
+	w.Write([]byte(stream[:17]))
+	w.Write([]byte(stream[17:]))
+	w.finish()
+
+	body := rec.Body.String()
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+		"content_block_start", "content_block_delta", "content_block_stop",
+		"message_delta", "message_stop",
+	}, sseEvents(body))
+	assert.Contains(t, body, `"type":"thinking_delta"`)
+	assert.Contains(t, body, `"partial_json":"{\"a\":1}"`)
+	assert.Contains(t, body, `"stop_reason":"tool_use"`)
+	assert.Contains(t, body, `"output_tokens":3`)
+}
+
+func TestAnthropicResponseWriter_Error(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := newAnthropicResponseWriter(c.Writer, "claude")
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(`{"error":{"message":"loading model"}}`))
+	w.finish()
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"overloaded_error","message":"loading model"}}`, rec.Body.String())
+}
+
+func TestProxyManager_AnthropicTranslation(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+    apiTranslation: [anthropic]
+`, simpleResponderPath)))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	t.Run("count_tokens is answered without the upstream", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/messages/count_tokens", bytes.NewBufferString(`{"model":"model1","messages":[{"role":"user","content":"12345678"}]}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"input_tokens":2}`, w.Body.String())
+		assert.Equal(t, StateStopped, proxy.snapshot.Load().processGroups["model1"].processes["model1"].CurrentState())
+	})
+
+	t.Run("non streaming", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(`{"model":"model1","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		parsed := gjson.Parse(w.Body.String())
+		assert.Equal(t, "message", parsed.Get("type").String())
+		assert.Equal(t, "model1", parsed.Get("model").String())
+		assert.Equal(t, int64(25), parsed.Get("usage.input_tokens").Int())
+	})
+
+	t.Run("streaming records metrics", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/messages?stream=true", bytes.NewBufferString(`{"model":"model1","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		events := sseEvents(w.Body.String())
+		require.NotEmpty(t, events)
+		assert.Equal(t, "message_start", events[0])
+		assert.Equal(t, "message_stop", events[len(events)-1])
+		assert.Contains(t, w.Body.String(), `"text":"asdf"`)
+
+		metrics := proxy.metricsMonitor.getMetrics()
+		require.NotEmpty(t, metrics)
+		assert.Equal(t, 10, metrics[len(metrics)-1].OutputTokens)
+	})
+}
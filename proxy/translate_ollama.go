@@ -0,0 +1,427 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Ollama API request types, only the fields that can be translated to OpenAI
+// style requests are decoded
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []openAITool    `json:"tools"`
+	Format   json.RawMessage `json:"format"`
+	Options  map[string]any  `json:"options"`
+	Stream   *bool           `json:"stream"`
+
+	// /api/generate
+	Prompt string   `json:"prompt"`
+	System string   `json:"system"`
+	Images []string `json:"images"`
+
+	// /api/embed
+	Input      json.RawMessage `json:"input"`
+	Dimensions *int            `json:"dimensions"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images"`
+	ToolCalls []ollamaToolCall `json:"tool_calls"`
+	ToolName  string           `json:"tool_name"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// Ollama options that are passed through to upstreams under the same name
+var ollamaPassthroughOptions = []string{"seed", "min_p", "typical_p", "repeat_penalty", "presence_penalty", "frequency_penalty"}
+
+func parseOllamaRequest(body []byte) (ollamaRequest, error) {
+	var req ollamaRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, fmt.Errorf("invalid request: %w", err)
+	}
+	if req.Model == "" {
+		return req, fmt.Errorf("model is required")
+	}
+	return req, nil
+}
+
+// streaming returns true unless the request turned it off, Ollama streams by default
+func (r ollamaRequest) streaming() bool {
+	return r.Stream == nil || *r.Stream
+}
+
+// ollamaChatToOpenAIRequest converts an /api/chat or /api/generate request
+// into an OpenAI chat completions request body for model
+func ollamaChatToOpenAIRequest(req ollamaRequest, model string, generate bool) ([]byte, error) {
+	out := openAIChatRequest{
+		Model:  model,
+		Stream: req.streaming(),
+		Tools:  req.Tools,
+	}
+	if out.Stream {
+		// usage is needed for the counts in the final message and for metrics
+		out.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+
+	if generate {
+		if req.System != "" {
+			out.Messages = append(out.Messages, openAIMessage{Role: "system", Content: req.System})
+		}
+		out.Messages = append(out.Messages, ollamaUserMessage(req.Prompt, req.Images))
+	} else {
+		out.Messages = ollamaMessagesToOpenAI(req.Messages)
+	}
+
+	if len(req.Format) > 0 {
+		format := gjson.ParseBytes(req.Format)
+		switch {
+		case format.Type == gjson.String && format.String() == "json":
+			out.ResponseFormat = gin.H{"type": "json_object"}
+		case format.IsObject():
+			out.ResponseFormat = gin.H{"type": "json_schema", "json_schema": gin.H{"name": "response", "schema": req.Format}}
+		}
+	}
+
+	options := req.Options
+	if value, ok := options["temperature"].(float64); ok {
+		out.Temperature = &value
+	}
+	if value, ok := options["top_p"].(float64); ok {
+		out.TopP = &value
+	}
+	if value, ok := options["top_k"].(float64); ok {
+		topK := int(value)
+		out.TopK = &topK
+	}
+	// a negative num_predict means no limit
+	if value, ok := options["num_predict"].(float64); ok && value > 0 {
+		maxTokens := int(value)
+		out.MaxTokens = &maxTokens
+	}
+	if stop, ok := options["stop"].([]any); ok {
+		for _, value := range stop {
+			if s, ok := value.(string); ok {
+				out.Stop = append(out.Stop, s)
+			}
+		}
+	}
+
+	body, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range ollamaPassthroughOptions {
+		if value, ok := options[key]; ok {
+			if body, err = sjson.SetBytes(body, key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return body, nil
+}
+
+// ollamaMessagesToOpenAI converts Ollama chat messages. Ollama tool results
+// refer to the tool by name, chat completions by call id, so ids are assigned
+// to tool calls and matched to the results that follow them.
+func ollamaMessagesToOpenAI(messages []ollamaMessage) []openAIMessage {
+	type pendingCall struct{ id, name string }
+	var pending []pendingCall
+	var out []openAIMessage
+	callCount := 0
+
+	for _, message := range messages {
+		switch message.Role {
+		case "assistant":
+			converted := openAIMessage{Role: "assistant", Content: message.Content}
+			pending = nil
+			for _, call := range message.ToolCalls {
+				callCount++
+				id := fmt.Sprintf("call_%d", callCount)
+				arguments := "{}"
+				if len(call.Function.Arguments) > 0 {
+					arguments = string(call.Function.Arguments)
+				}
+				converted.ToolCalls = append(converted.ToolCalls, openAIToolCall{
+					ID:       id,
+					Type:     "function",
+					Function: openAIFunctionCall{Name: call.Function.Name, Arguments: arguments},
+				})
+				pending = append(pending, pendingCall{id, call.Function.Name})
+			}
+			if converted.Content == "" && len(converted.ToolCalls) > 0 {
+				converted.Content = nil
+			}
+			out = append(out, converted)
+		case "tool":
+			id := ""
+			for i, call := range pending {
+				if message.ToolName == "" || call.name == message.ToolName {
+					id = call.id
+					pending = append(pending[:i], pending[i+1:]...)
+					break
+				}
+			}
+			out = append(out, openAIMessage{Role: "tool", ToolCallID: id, Content: message.Content})
+		case "user":
+			out = append(out, ollamaUserMessage(message.Content, message.Images))
+		default:
+			out = append(out, openAIMessage{Role: message.Role, Content: message.Content})
+		}
+	}
+	return out
+}
+
+func ollamaUserMessage(text string, images []string) openAIMessage {
+	if len(images) == 0 {
+		return openAIMessage{Role: "user", Content: text}
+	}
+	parts := []openAIContentPart{{Type: "text", Text: text}}
+	for _, image := range images {
+		parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: ollamaImageURL(image)}})
+	}
+	return openAIMessage{Role: "user", Content: parts}
+}
+
+// ollamaImageURL turns a base64 encoded image into a data URL. Ollama does not
+// send the media type so it is guessed from the first bytes.
+func ollamaImageURL(image string) string {
+	mediaType := "image/png"
+	switch {
+	case strings.HasPrefix(image, "/9j/"):
+		mediaType = "image/jpeg"
+	case strings.HasPrefix(image, "R0lG"):
+		mediaType = "image/gif"
+	case strings.HasPrefix(image, "UklG"):
+		mediaType = "image/webp"
+	}
+	return "data:" + mediaType + ";base64," + image
+}
+
+// ollamaEmbedToOpenAIRequest converts an /api/embed request into an OpenAI
+// embeddings request body for model
+func ollamaEmbedToOpenAIRequest(req ollamaRequest, model string) ([]byte, error) {
+	if len(req.Input) == 0 {
+		return nil, fmt.Errorf("input is required")
+	}
+	out := gin.H{"model": model, "input": req.Input}
+	if req.Dimensions != nil {
+		out["dimensions"] = *req.Dimensions
+	}
+	return json.Marshal(out)
+}
+
+const (
+	ollamaChat     = "chat"
+	ollamaGenerate = "generate"
+	ollamaEmbed    = "embed"
+)
+
+// ollamaTranslator converts an OpenAI response, or SSE stream, written by
+// the upstream into an Ollama response. Streams are written as NDJSON.
+type ollamaTranslator struct {
+	out      gin.ResponseWriter
+	endpoint string
+	model    string
+	started  time.Time
+
+	// stream state
+	finished     bool
+	toolCalls    []*openAIToolCall
+	finishReason string
+	usage        openAIUsage
+}
+
+func newOllamaResponseWriter(w gin.ResponseWriter, endpoint, model string) *translatingResponseWriter {
+	tw := newTranslatingResponseWriter(w, &ollamaTranslator{
+		out:      w,
+		endpoint: endpoint,
+		model:    model,
+		started:  time.Now(),
+	})
+	tw.streamContentType = "application/x-ndjson"
+	return tw
+}
+
+func (t *ollamaTranslator) translateBody(status int, body []byte) []byte {
+	var out []byte
+	if status != http.StatusOK {
+		out, _ = json.Marshal(gin.H{"error": upstreamErrorMessage(status, body)})
+		return out
+	}
+	if !gjson.ValidBytes(body) {
+		out, _ = json.Marshal(gin.H{"error": "upstream returned invalid JSON"})
+		return out
+	}
+	parsed := gjson.ParseBytes(body)
+	t.usage = parseOpenAIUsage(parsed.Get("usage"))
+
+	if t.endpoint == ollamaEmbed {
+		embeddings := []json.RawMessage{}
+		for _, item := range parsed.Get("data").Array() {
+			embeddings = append(embeddings, json.RawMessage(item.Get("embedding").Raw))
+		}
+		out, _ = json.Marshal(gin.H{
+			"model":             t.model,
+			"embeddings":        embeddings,
+			"total_duration":    time.Since(t.started).Nanoseconds(),
+			"prompt_eval_count": t.usage.PromptTokens,
+		})
+		return out
+	}
+
+	message := parsed.Get("choices.0.message")
+	for _, call := range message.Get("tool_calls").Array() {
+		t.toolCalls = append(t.toolCalls, &openAIToolCall{
+			Function: openAIFunctionCall{Name: call.Get("function.name").String(), Arguments: call.Get("function.arguments").String()},
+		})
+	}
+	t.finishReason = parsed.Get("choices.0.finish_reason").String()
+
+	response := t.chunk(message.Get("content").String(), firstString(message, "reasoning_content", "reasoning"))
+	if t.endpoint == ollamaChat && len(t.toolCalls) > 0 {
+		response["message"].(gin.H)["tool_calls"] = t.ollamaToolCalls()
+	}
+	t.addDone(response)
+	out, _ = json.Marshal(response)
+	return out
+}
+
+// chunk returns a response object with the generated text
+func (t *ollamaTranslator) chunk(content, thinking string) gin.H {
+	response := gin.H{
+		"model":      t.model,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"done":       false,
+	}
+	if t.endpoint == ollamaGenerate {
+		response["response"] = content
+		if thinking != "" {
+			response["thinking"] = thinking
+		}
+	} else {
+		message := gin.H{"role": "assistant", "content": content}
+		if thinking != "" {
+			message["thinking"] = thinking
+		}
+		response["message"] = message
+	}
+	return response
+}
+
+// addDone marks response as the final one and adds the token counts
+func (t *ollamaTranslator) addDone(response gin.H) {
+	doneReason := "stop"
+	if t.finishReason == "length" {
+		doneReason = "length"
+	}
+	response["done"] = true
+	response["done_reason"] = doneReason
+	response["total_duration"] = time.Since(t.started).Nanoseconds()
+	response["prompt_eval_count"] = t.usage.PromptTokens
+	response["eval_count"] = t.usage.CompletionTokens
+}
+
+func (t *ollamaTranslator) ollamaToolCalls() []gin.H {
+	calls := make([]gin.H, 0, len(t.toolCalls))
+	for _, call := range t.toolCalls {
+		calls = append(calls, gin.H{"function": gin.H{"name": call.Function.Name, "arguments": toolArguments(call.Function.Arguments)}})
+	}
+	return calls
+}
+
+func (t *ollamaTranslator) writeLine(payload gin.H) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	t.out.Write(append(data, '\n'))
+}
+
+func (t *ollamaTranslator) handleChunk(data []byte) {
+	if t.finished || len(data) == 0 {
+		return
+	}
+	if string(data) == "[DONE]" {
+		t.finishStream()
+		return
+	}
+	if !gjson.ValidBytes(data) {
+		return
+	}
+	chunk := gjson.ParseBytes(data)
+
+	if errValue := chunk.Get("error"); errValue.Exists() {
+		t.finished = true
+		t.writeLine(gin.H{"error": upstreamErrorMessage(http.StatusInternalServerError, data)})
+		t.out.Flush()
+		return
+	}
+
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		t.usage = parseOpenAIUsage(usage)
+	}
+
+	choice := chunk.Get("choices.0")
+	if !choice.Exists() {
+		return
+	}
+	delta := choice.Get("delta")
+
+	content := delta.Get("content").String()
+	thinking := firstString(delta, "reasoning_content", "reasoning")
+	if content != "" || thinking != "" {
+		t.writeLine(t.chunk(content, thinking))
+	}
+
+	// Ollama sends complete tool calls, the argument fragments are collected
+	// and sent when the stream ends
+	for _, call := range delta.Get("tool_calls").Array() {
+		index := int(call.Get("index").Int())
+		for len(t.toolCalls) <= index {
+			t.toolCalls = append(t.toolCalls, &openAIToolCall{})
+		}
+		if name := call.Get("function.name").String(); name != "" {
+			t.toolCalls[index].Function.Name = name
+		}
+		t.toolCalls[index].Function.Arguments += call.Get("function.arguments").String()
+	}
+
+	if finishReason := choice.Get("finish_reason").String(); finishReason != "" {
+		t.finishReason = finishReason
+	}
+	t.out.Flush()
+}
+
+func (t *ollamaTranslator) finishStream() {
+	if t.finished {
+		return
+	}
+	t.finished = true
+
+	if t.endpoint == ollamaChat && len(t.toolCalls) > 0 {
+		response := t.chunk("", "")
+		response["message"].(gin.H)["tool_calls"] = t.ollamaToolCalls()
+		t.writeLine(response)
+	}
+
+	response := t.chunk("", "")
+	t.addDone(response)
+	t.writeLine(response)
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestOllamaChatToOpenAIRequest(t *testing.T) {
+	req, err := parseOllamaRequest([]byte(`{
+		"model": "llama3:latest",
+		"format": "json",
+		"options": {"temperature": 0.5, "num_predict": 64, "stop": ["END"], "seed": 42},
+		"tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}],
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": "what is this?", "images": ["/9j/AAAA"]},
+			{"role": "assistant", "content": "", "tool_calls": [{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}}]},
+			{"role": "tool", "content": "sunny", "tool_name": "get_weather"}
+		]
+	}`))
+	require.NoError(t, err)
+	assert.True(t, req.streaming(), "ollama streams by default")
+
+	out, err := ollamaChatToOpenAIRequest(req, "llama3", false)
+	require.NoError(t, err)
+	parsed := gjson.ParseBytes(out)
+
+	assert.Equal(t, "llama3", parsed.Get("model").String())
+	assert.True(t, parsed.Get("stream").Bool())
+	assert.True(t, parsed.Get("stream_options.include_usage").Bool())
+	assert.Equal(t, 0.5, parsed.Get("temperature").Float())
+	assert.Equal(t, int64(64), parsed.Get("max_tokens").Int())
+	assert.Equal(t, "END", parsed.Get("stop.0").String())
+	assert.Equal(t, int64(42), parsed.Get("seed").Int())
+	assert.Equal(t, "json_object", parsed.Get("response_format.type").String())
+	assert.Equal(t, "get_weather", parsed.Get("tools.0.function.name").String())
+
+	messages := parsed.Get("messages").Array()
+	require.Len(t, messages, 4)
+	assert.Equal(t, "data:image/jpeg;base64,/9j/AAAA", messages[1].Get("content.1.image_url.url").String())
+	assert.JSONEq(t, `{"city": "Paris"}`, messages[2].Get("tool_calls.0.function.arguments").String())
+	assert.Equal(t, messages[2].Get("tool_calls.0.id").String(), messages[3].Get("tool_call_id").String())
+
+	generate, err := parseOllamaRequest([]byte(`{"model": "llama3", "system": "sys", "prompt": "hi", "stream": false}`))
+	require.NoError(t, err)
+	out, err = ollamaChatToOpenAIRequest(generate, "llama3", true)
+	require.NoError(t, err)
+	parsed = gjson.ParseBytes(out)
+	assert.False(t, parsed.Get("stream").Exists())
+	assert.Equal(t, "sys", parsed.Get("messages.0.content").String())
+	assert.Equal(t, "hi", parsed.Get("messages.1.content").String())
+
+	_, err = parseOllamaRequest([]byte(`{"prompt": "hi"}`))
+	assert.ErrorContains(t, err, "model is required")
+}
+
+// ndjsonLines returns the parsed lines of an NDJSON body
+func ndjsonLines(t *testing.T, body string) []gjson.Result {
+	var lines []gjson.Result
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		require.True(t, gjson.Valid(scanner.Text()), scanner.Text())
+		lines = append(lines, gjson.Parse(scanner.Text()))
+	}
+	return lines
+}
+
+func TestOllamaResponseWriter_Stream(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := newOllamaResponseWriter(c.Writer, ollamaChat, "llama3:latest")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	chunks := []string{
+		`{"choices":[{"delta":{"reasoning_content":"hm"}}]}`,
+		`{"choices":[{"delta":{"content":"Hello"}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"f","arguments":"{\"a\""}}]}}]}`,
+		`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":":1}"}}]}}]}`,
+		`{"choices":[{"delta":{},"finish_reason":"length"}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3}}`,
+		`[DONE]`,
+	}
+	for _, chunk := range chunks {
+		w.Write([]byte("data: " + chunk + "\n\n"))
+	}
+	w.finish()
+
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	lines := ndjsonLines(t, rec.Body.String())
+	require.Len(t, lines, 4)
+	assert.Equal(t, "hm", lines[0].Get("message.thinking").String())
+	assert.Equal(t, "Hello", lines[1].Get("message.content").String())
+	assert.Equal(t, "llama3:latest", lines[1].Get("model").String())
+	assert.Equal(t, int64(1), lines[2].Get("message.tool_calls.0.function.arguments.a").Int())
+
+	done := lines[3]
+	assert.True(t, done.Get("done").Bool())
+	assert.Equal(t, "length", done.Get("done_reason").String())
+	assert.Equal(t, int64(7), done.Get("prompt_eval_count").Int())
+	assert.Equal(t, int64(3), done.Get("eval_count").Int())
+}
+
+func TestOllamaResponseWriter_Error(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := newOllamaResponseWriter(c.Writer, ollamaGenerate, "llama3")
+
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(`could not find suitable inference handler for nope`))
+	w.finish()
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":"could not find suitable inference handler for nope"}`, rec.Body.String())
+}
+
+func TestProxyManager_Ollama(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+ollama:
+  enabled: true
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+  hidden:
+    cmd: %s --port ${PORT} --silent --respond hidden
+    unlisted: true
+`, simpleResponderPath, simpleResponderPath)))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	serve := func(method, path, body string) *TestResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("tags", func(t *testing.T) {
+		w := serve("GET", "/ollama/api/tags", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		models := gjson.Get(w.Body.String(), "models").Array()
+		require.Len(t, models, 1)
+		assert.Equal(t, "model1", models[0].Get("name").String())
+	})
+
+	t.Run("show", func(t *testing.T) {
+		w := serve("POST", "/ollama/api/show", `{"model": "model1:latest"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		w = serve("POST", "/ollama/api/show", `{"model": "nope"}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("chat stream", func(t *testing.T) {
+		w := serve("POST", "/ollama/api/chat?stream=true", `{"model": "model1:latest", "messages": [{"role": "user", "content": "hi"}]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		lines := ndjsonLines(t, w.Body.String())
+		require.Len(t, lines, 11)
+		assert.Equal(t, "asdf", lines[0].Get("message.content").String())
+		assert.True(t, lines[10].Get("done").Bool())
+		assert.Equal(t, int64(10), lines[10].Get("eval_count").Int())
+
+		metrics := proxy.metricsMonitor.getMetrics()
+		require.NotEmpty(t, metrics)
+		assert.Equal(t, "model1", metrics[len(metrics)-1].Model)
+		assert.Equal(t, 10, metrics[len(metrics)-1].OutputTokens)
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		w := serve("POST", "/ollama/api/generate", `{"model": "model1", "prompt": "hi", "stream": false}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		parsed := gjson.Parse(w.Body.String())
+		assert.True(t, parsed.Get("done").Bool())
+		assert.Equal(t, int64(25), parsed.Get("prompt_eval_count").Int())
+	})
+
+	t.Run("embed", func(t *testing.T) {
+		w := serve("POST", "/ollama/api/embed", `{"model": "model1", "input": ["a", "b"]}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		parsed := gjson.Parse(w.Body.String())
+		assert.Len(t, parsed.Get("embeddings").Array(), 2)
+		assert.Equal(t, 0.2, parsed.Get("embeddings.0.1").Float())
+		assert.Equal(t, int64(10), parsed.Get("prompt_eval_count").Int())
+	})
+
+	t.Run("ps", func(t *testing.T) {
+		w := serve("GET", "/ollama/api/ps", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		models := gjson.Get(w.Body.String(), "models").Array()
+		require.Len(t, models, 1)
+		assert.Equal(t, "model1", models[0].Get("name").String())
+	})
+
+	t.Run("unknown model", func(t *testing.T) {
+		w := serve("POST", "/ollama/api/chat", `{"model": "nope", "messages": []}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, gjson.Get(w.Body.String(), "error").String(), "nope")
+	})
+
+	t.Run("served without prefix on the ollama listener", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/tags", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeOllamaHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "model1")
+	})
+}
@@ -0,0 +1,649 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// OpenAI Responses API request types, only the fields that can be translated
+// to chat completions are decoded
+
+type responsesRequest struct {
+	Model              string          `json:"model"`
+	Input              json.RawMessage `json:"input"`
+	Instructions       string          `json:"instructions"`
+	PreviousResponseID string          `json:"previous_response_id"`
+	MaxOutputTokens    *int            `json:"max_output_tokens"`
+	Temperature        *float64        `json:"temperature"`
+	TopP               *float64        `json:"top_p"`
+	Stream             bool            `json:"stream"`
+	Store              *bool           `json:"store"`
+	Tools              []responsesTool `json:"tools"`
+	ToolChoice         json.RawMessage `json:"tool_choice"`
+	ParallelToolCalls  *bool           `json:"parallel_tool_calls"`
+	User               string          `json:"user"`
+	Metadata           map[string]any  `json:"metadata"`
+	Text               *struct {
+		Format responsesTextFormat `json:"format"`
+	} `json:"text"`
+	Reasoning *struct {
+		Effort string `json:"effort"`
+	} `json:"reasoning"`
+}
+
+type responsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type responsesTextFormat struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict *bool           `json:"strict"`
+}
+
+var errPreviousResponseNotFound = errors.New("previous response not found")
+
+// responsesConversation is what the response translator needs to know about
+// the request it is answering
+type responsesConversation struct {
+	request responsesRequest
+
+	// the previous conversation and the new input, without instructions
+	messages []openAIMessage
+}
+
+// responsesToOpenAIRequest converts a Responses API request body into an
+// OpenAI chat completions request body. The conversation of
+// previous_response_id is loaded from store.
+func responsesToOpenAIRequest(body []byte, store responseStore) ([]byte, *responsesConversation, error) {
+	var req responsesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, nil, fmt.Errorf("invalid responses request: %w", err)
+	}
+
+	conversation := &responsesConversation{request: req}
+	if req.PreviousResponseID != "" {
+		previous, found := store.Get(req.PreviousResponseID)
+		if !found {
+			return nil, nil, fmt.Errorf("%w: %s", errPreviousResponseNotFound, req.PreviousResponseID)
+		}
+		conversation.messages = append(conversation.messages, previous...)
+	}
+
+	input, err := responsesInputToMessages(req.Input)
+	if err != nil {
+		return nil, nil, err
+	}
+	conversation.messages = append(conversation.messages, input...)
+
+	out := openAIChatRequest{
+		Model:             req.Model,
+		MaxTokens:         req.MaxOutputTokens,
+		Temperature:       req.Temperature,
+		TopP:              req.TopP,
+		Stream:            req.Stream,
+		ParallelToolCalls: req.ParallelToolCalls,
+		User:              req.User,
+	}
+	if req.Stream {
+		// usage is needed in the stream for response.completed and for metrics
+		out.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+	if req.Reasoning != nil {
+		out.ReasoningEffort = req.Reasoning.Effort
+	}
+
+	// instructions only apply to the current response and are not carried
+	// over to responses that continue it
+	if req.Instructions != "" {
+		out.Messages = append(out.Messages, openAIMessage{Role: "system", Content: req.Instructions})
+	}
+	out.Messages = append(out.Messages, conversation.messages...)
+
+	for _, tool := range req.Tools {
+		// hosted tools like web_search and file_search can not be run by the upstream
+		if tool.Type != "function" {
+			continue
+		}
+		out.Tools = append(out.Tools, openAITool{
+			Type: "function",
+			Function: openAIFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	if len(req.ToolChoice) > 0 {
+		choice := gjson.ParseBytes(req.ToolChoice)
+		switch {
+		case choice.Type == gjson.String:
+			out.ToolChoice = choice.String()
+		case choice.Get("type").String() == "function":
+			out.ToolChoice = gin.H{"type": "function", "function": gin.H{"name": choice.Get("name").String()}}
+		}
+	}
+
+	if req.Text != nil {
+		switch req.Text.Format.Type {
+		case "json_object":
+			out.ResponseFormat = gin.H{"type": "json_object"}
+		case "json_schema":
+			schema := gin.H{"name": req.Text.Format.Name, "schema": req.Text.Format.Schema}
+			if req.Text.Format.Strict != nil {
+				schema["strict"] = *req.Text.Format.Strict
+			}
+			out.ResponseFormat = gin.H{"type": "json_schema", "json_schema": schema}
+		}
+	}
+
+	outBody, err := json.Marshal(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	return outBody, conversation, nil
+}
+
+// responsesInputToMessages converts Responses API input, a string or a list
+// of input items, into chat messages
+func responsesInputToMessages(input json.RawMessage) ([]openAIMessage, error) {
+	if len(input) == 0 {
+		return nil, fmt.Errorf("input is required")
+	}
+
+	parsed := gjson.ParseBytes(input)
+	if parsed.Type == gjson.String {
+		return []openAIMessage{{Role: "user", Content: parsed.String()}}, nil
+	}
+	if !parsed.IsArray() {
+		return nil, fmt.Errorf("input must be a string or an array of items")
+	}
+
+	var messages []openAIMessage
+	for i, item := range parsed.Array() {
+		itemType := item.Get("type").String()
+		if itemType == "" && item.Get("role").Exists() {
+			itemType = "message"
+		}
+
+		switch itemType {
+		case "message":
+			message, err := responsesInputMessage(item)
+			if err != nil {
+				return nil, fmt.Errorf("input[%d]: %w", i, err)
+			}
+			messages = append(messages, message)
+		case "function_call":
+			call := openAIToolCall{
+				ID:   item.Get("call_id").String(),
+				Type: "function",
+				Function: openAIFunctionCall{
+					Name:      item.Get("name").String(),
+					Arguments: item.Get("arguments").String(),
+				},
+			}
+			// parallel calls of one turn belong to a single assistant message
+			if last := len(messages) - 1; last >= 0 && messages[last].Role == "assistant" {
+				messages[last].ToolCalls = append(messages[last].ToolCalls, call)
+			} else {
+				messages = append(messages, openAIMessage{Role: "assistant", ToolCalls: []openAIToolCall{call}})
+			}
+		case "function_call_output":
+			output := item.Get("output")
+			content := output.String()
+			if output.IsArray() {
+				var texts []string
+				for _, part := range output.Array() {
+					texts = append(texts, part.Get("text").String())
+				}
+				content = strings.Join(texts, "\n")
+			}
+			messages = append(messages, openAIMessage{Role: "tool", ToolCallID: item.Get("call_id").String(), Content: content})
+		case "reasoning":
+			// reasoning is not sent back to chat completion upstreams
+		default:
+			return nil, fmt.Errorf("input[%d]: unsupported item type %q", i, itemType)
+		}
+	}
+	return messages, nil
+}
+
+func responsesInputMessage(item gjson.Result) (openAIMessage, error) {
+	role := item.Get("role").String()
+	switch role {
+	case "user", "assistant", "system":
+	case "developer":
+		role = "system"
+	default:
+		return openAIMessage{}, fmt.Errorf("unsupported role %q", role)
+	}
+
+	content := item.Get("content")
+	if content.Type == gjson.String {
+		return openAIMessage{Role: role, Content: content.String()}, nil
+	}
+	if !content.IsArray() {
+		return openAIMessage{}, fmt.Errorf("content must be a string or an array of content parts")
+	}
+
+	var parts []openAIContentPart
+	var texts []string
+	hasImages := false
+	for _, part := range content.Array() {
+		switch partType := part.Get("type").String(); partType {
+		case "input_text", "output_text", "text":
+			parts = append(parts, openAIContentPart{Type: "text", Text: part.Get("text").String()})
+			texts = append(texts, part.Get("text").String())
+		case "input_image":
+			url := firstString(part, "image_url.url", "image_url")
+			if url == "" {
+				return openAIMessage{}, fmt.Errorf("input_image needs an image_url, file_id is not supported")
+			}
+			parts = append(parts, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url}})
+			hasImages = true
+		case "refusal":
+			texts = append(texts, part.Get("refusal").String())
+		default:
+			return openAIMessage{}, fmt.Errorf("unsupported content type %q", partType)
+		}
+	}
+
+	// plain text is the most widely supported form of content
+	if !hasImages {
+		return openAIMessage{Role: role, Content: strings.Join(texts, "")}, nil
+	}
+	return openAIMessage{Role: role, Content: parts}, nil
+}
+
+// responsesOutputItem is an item of a response's output list
+type responsesOutputItem struct {
+	kind   string // reasoning, message or function_call
+	id     string
+	callID string
+	name   string
+	text   strings.Builder
+}
+
+func (item *responsesOutputItem) toJSON(status string) gin.H {
+	switch item.kind {
+	case "reasoning":
+		summary := []any{}
+		if item.text.Len() > 0 {
+			summary = append(summary, gin.H{"type": "summary_text", "text": item.text.String()})
+		}
+		return gin.H{"type": "reasoning", "id": item.id, "summary": summary}
+	case "function_call":
+		return gin.H{
+			"type":      "function_call",
+			"id":        item.id,
+			"call_id":   item.callID,
+			"name":      item.name,
+			"arguments": item.text.String(),
+			"status":    status,
+		}
+	default:
+		content := []any{}
+		if status != "in_progress" {
+			content = append(content, responsesTextPart(item.text.String()))
+		}
+		return gin.H{"type": "message", "id": item.id, "status": status, "role": "assistant", "content": content}
+	}
+}
+
+func responsesTextPart(text string) gin.H {
+	return gin.H{"type": "output_text", "text": text, "annotations": []any{}}
+}
+
+// responsesTranslator converts an OpenAI chat completion response, or SSE
+// stream, written by the upstream into a Responses API response and saves the
+// conversation so it can be continued.
+type responsesTranslator struct {
+	out          gin.ResponseWriter
+	model        string
+	conversation *responsesConversation
+	store        responseStore
+	logger       *LogMonitor
+
+	id        string
+	createdAt int64
+
+	// stream state
+	sequence     int
+	started      bool
+	finished     bool
+	output       []*responsesOutputItem
+	current      *responsesOutputItem
+	toolItems    map[int64]*responsesOutputItem
+	finishReason string
+	usage        openAIUsage
+}
+
+func newResponsesResponseWriter(w gin.ResponseWriter, model string, conversation *responsesConversation, store responseStore, logger *LogMonitor) *translatingResponseWriter {
+	return newTranslatingResponseWriter(w, &responsesTranslator{
+		out:          w,
+		model:        model,
+		conversation: conversation,
+		store:        store,
+		logger:       logger,
+		id:           newTranslationID("resp_"),
+		createdAt:    time.Now().Unix(),
+		toolItems:    make(map[int64]*responsesOutputItem),
+	})
+}
+
+func (t *responsesTranslator) translateBody(status int, body []byte) []byte {
+	var out []byte
+	if status != http.StatusOK {
+		out, _ = json.Marshal(openAIError(status, upstreamErrorMessage(status, body)))
+		return out
+	}
+	if !gjson.ValidBytes(body) {
+		out, _ = json.Marshal(openAIError(http.StatusBadGateway, "upstream returned invalid JSON"))
+		return out
+	}
+
+	parsed := gjson.ParseBytes(body)
+	message := parsed.Get("choices.0.message")
+	if reasoning := firstString(message, "reasoning_content", "reasoning"); reasoning != "" {
+		t.addItem("reasoning").text.WriteString(reasoning)
+	}
+	if text := message.Get("content").String(); text != "" {
+		t.addItem("message").text.WriteString(text)
+	}
+	for _, call := range message.Get("tool_calls").Array() {
+		item := t.addItem("function_call")
+		item.callID = call.Get("id").String()
+		item.name = call.Get("function.name").String()
+		item.text.WriteString(call.Get("function.arguments").String())
+	}
+	t.finishReason = parsed.Get("choices.0.finish_reason").String()
+	t.usage = parseOpenAIUsage(parsed.Get("usage"))
+
+	t.save()
+	out, _ = json.Marshal(t.response(t.status()))
+	return out
+}
+
+// addItem appends a new output item of kind
+func (t *responsesTranslator) addItem(kind string) *responsesOutputItem {
+	prefix := map[string]string{"reasoning": "rs_", "message": "msg_", "function_call": "fc_"}[kind]
+	item := &responsesOutputItem{kind: kind, id: newTranslationID(prefix)}
+	if kind == "function_call" {
+		item.callID = newTranslationID("call_")
+	}
+	t.output = append(t.output, item)
+	return item
+}
+
+func (t *responsesTranslator) outputIndex(item *responsesOutputItem) int {
+	for i, candidate := range t.output {
+		if candidate == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// status returns completed or incomplete depending on why the upstream stopped
+func (t *responsesTranslator) status() string {
+	if t.finishReason == "length" || t.finishReason == "content_filter" {
+		return "incomplete"
+	}
+	return "completed"
+}
+
+func (t *responsesTranslator) response(status string) gin.H {
+	request := t.conversation.request
+	output := make([]any, 0, len(t.output))
+	if status != "in_progress" {
+		for _, item := range t.output {
+			output = append(output, item.toJSON("completed"))
+		}
+	}
+
+	response := gin.H{
+		"id":                   t.id,
+		"object":               "response",
+		"created_at":           t.createdAt,
+		"status":               status,
+		"error":                nil,
+		"incomplete_details":   nil,
+		"instructions":         nil,
+		"max_output_tokens":    request.MaxOutputTokens,
+		"model":                t.model,
+		"output":               output,
+		"parallel_tool_calls":  request.ParallelToolCalls == nil || *request.ParallelToolCalls,
+		"previous_response_id": nil,
+		"store":                t.storeEnabled(),
+		"temperature":          request.Temperature,
+		"top_p":                request.TopP,
+		"metadata":             request.Metadata,
+		"usage":                nil,
+	}
+	if request.Instructions != "" {
+		response["instructions"] = request.Instructions
+	}
+	if request.PreviousResponseID != "" {
+		response["previous_response_id"] = request.PreviousResponseID
+	}
+	if request.Metadata == nil {
+		response["metadata"] = gin.H{}
+	}
+	if status == "incomplete" {
+		reason := "max_output_tokens"
+		if t.finishReason == "content_filter" {
+			reason = "content_filter"
+		}
+		response["incomplete_details"] = gin.H{"reason": reason}
+	}
+	if status == "completed" || status == "incomplete" {
+		response["usage"] = gin.H{
+			"input_tokens":          t.usage.PromptTokens,
+			"input_tokens_details":  gin.H{"cached_tokens": t.usage.CachedTokens},
+			"output_tokens":         t.usage.CompletionTokens,
+			"output_tokens_details": gin.H{"reasoning_tokens": 0},
+			"total_tokens":          t.usage.PromptTokens + t.usage.CompletionTokens,
+		}
+	}
+	return response
+}
+
+func (t *responsesTranslator) storeEnabled() bool {
+	return t.conversation.request.Store == nil || *t.conversation.request.Store
+}
+
+// save stores the conversation including the assistant's reply under the response id
+func (t *responsesTranslator) save() {
+	if !t.storeEnabled() || t.store == nil {
+		return
+	}
+
+	reply := openAIMessage{Role: "assistant"}
+	var text strings.Builder
+	for _, item := range t.output {
+		switch item.kind {
+		case "message":
+			text.WriteString(item.text.String())
+		case "function_call":
+			reply.ToolCalls = append(reply.ToolCalls, openAIToolCall{
+				ID:       item.callID,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: item.name, Arguments: item.text.String()},
+			})
+		}
+	}
+	if text.Len() > 0 {
+		reply.Content = text.String()
+	}
+
+	messages := make([]openAIMessage, 0, len(t.conversation.messages)+1)
+	messages = append(messages, t.conversation.messages...)
+	messages = append(messages, reply)
+	if err := t.store.Put(t.id, messages); err != nil && t.logger != nil {
+		t.logger.Errorf("Unable to store response %s: %v", t.id, err)
+	}
+}
+
+func (t *responsesTranslator) event(event string, payload gin.H) {
+	payload["type"] = event
+	payload["sequence_number"] = t.sequence
+	t.sequence++
+	writeSSEEvent(t.out, event, payload)
+}
+
+func (t *responsesTranslator) handleChunk(data []byte) {
+	if t.finished || len(data) == 0 {
+		return
+	}
+	if string(data) == "[DONE]" {
+		t.finishStream()
+		return
+	}
+	if !gjson.ValidBytes(data) {
+		return
+	}
+	chunk := gjson.ParseBytes(data)
+	t.start()
+
+	if errValue := chunk.Get("error"); errValue.Exists() {
+		t.fail(upstreamErrorMessage(http.StatusInternalServerError, data))
+		return
+	}
+
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		t.usage = parseOpenAIUsage(usage)
+	}
+
+	choice := chunk.Get("choices.0")
+	if !choice.Exists() {
+		return
+	}
+	delta := choice.Get("delta")
+
+	if reasoning := firstString(delta, "reasoning_content", "reasoning"); reasoning != "" {
+		item := t.openItem("reasoning")
+		item.text.WriteString(reasoning)
+		t.event("response.reasoning_summary_text.delta", gin.H{"item_id": item.id, "output_index": t.outputIndex(item), "summary_index": 0, "delta": reasoning})
+	}
+
+	if text := delta.Get("content").String(); text != "" {
+		item := t.openItem("message")
+		item.text.WriteString(text)
+		t.event("response.output_text.delta", gin.H{"item_id": item.id, "output_index": t.outputIndex(item), "content_index": 0, "delta": text})
+	}
+
+	for _, call := range delta.Get("tool_calls").Array() {
+		callIndex := call.Get("index").Int()
+		item, known := t.toolItems[callIndex]
+		if !known || (call.Get("id").String() != "" && t.current != item) {
+			t.closeItem()
+			item = t.addItem("function_call")
+			if id := call.Get("id").String(); id != "" {
+				item.callID = id
+			}
+			item.name = call.Get("function.name").String()
+			t.current = item
+			t.toolItems[callIndex] = item
+			t.event("response.output_item.added", gin.H{"output_index": t.outputIndex(item), "item": item.toJSON("in_progress")})
+		}
+		if arguments := call.Get("function.arguments").String(); arguments != "" {
+			item.text.WriteString(arguments)
+			t.event("response.function_call_arguments.delta", gin.H{"item_id": item.id, "output_index": t.outputIndex(item), "delta": arguments})
+		}
+	}
+
+	if finishReason := choice.Get("finish_reason").String(); finishReason != "" {
+		t.finishReason = finishReason
+	}
+	t.out.Flush()
+}
+
+func (t *responsesTranslator) start() {
+	if t.started {
+		return
+	}
+	t.started = true
+	t.event("response.created", gin.H{"response": t.response("in_progress")})
+	t.event("response.in_progress", gin.H{"response": t.response("in_progress")})
+}
+
+// openItem starts a new output item unless one of kind is already open
+func (t *responsesTranslator) openItem(kind string) *responsesOutputItem {
+	if t.current != nil && t.current.kind == kind && kind != "function_call" {
+		return t.current
+	}
+	t.closeItem()
+	item := t.addItem(kind)
+	t.current = item
+	index := t.outputIndex(item)
+
+	t.event("response.output_item.added", gin.H{"output_index": index, "item": item.toJSON("in_progress")})
+	switch kind {
+	case "reasoning":
+		t.event("response.reasoning_summary_part.added", gin.H{"item_id": item.id, "output_index": index, "summary_index": 0, "part": gin.H{"type": "summary_text", "text": ""}})
+	case "message":
+		t.event("response.content_part.added", gin.H{"item_id": item.id, "output_index": index, "content_index": 0, "part": responsesTextPart("")})
+	}
+	return item
+}
+
+func (t *responsesTranslator) closeItem() {
+	item := t.current
+	if item == nil {
+		return
+	}
+	t.current = nil
+	index := t.outputIndex(item)
+	text := item.text.String()
+
+	switch item.kind {
+	case "reasoning":
+		t.event("response.reasoning_summary_text.done", gin.H{"item_id": item.id, "output_index": index, "summary_index": 0, "text": text})
+		t.event("response.reasoning_summary_part.done", gin.H{"item_id": item.id, "output_index": index, "summary_index": 0, "part": gin.H{"type": "summary_text", "text": text}})
+	case "message":
+		t.event("response.output_text.done", gin.H{"item_id": item.id, "output_index": index, "content_index": 0, "text": text})
+		t.event("response.content_part.done", gin.H{"item_id": item.id, "output_index": index, "content_index": 0, "part": responsesTextPart(text)})
+	case "function_call":
+		t.event("response.function_call_arguments.done", gin.H{"item_id": item.id, "output_index": index, "arguments": text})
+	}
+	t.event("response.output_item.done", gin.H{"output_index": index, "item": item.toJSON("completed")})
+}
+
+// fail ends the stream after the upstream reported an error
+func (t *responsesTranslator) fail(message string) {
+	t.finished = true
+	t.event("error", gin.H{"code": "server_error", "message": message, "param": nil})
+	response := t.response("failed")
+	response["error"] = gin.H{"code": "server_error", "message": message}
+	t.event("response.failed", gin.H{"response": response})
+	t.out.Flush()
+}
+
+func (t *responsesTranslator) finishStream() {
+	if t.finished {
+		return
+	}
+	t.start()
+	t.finished = true
+	t.closeItem()
+
+	t.save()
+	status := t.status()
+	event := "response.completed"
+	if status == "incomplete" {
+		event = "response.incomplete"
+	}
+	t.event(event, gin.H{"response": t.response(status)})
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestResponsesToOpenAIRequest(t *testing.T) {
+	store := newMemoryResponseStore(10)
+	store.Put("resp_1", []openAIMessage{
+		{Role: "user", Content: "earlier"},
+		{Role: "assistant", Content: "reply"},
+	})
+
+	body := []byte(`{
+		"model": "gpt",
+		"instructions": "be brief",
+		"previous_response_id": "resp_1",
+		"max_output_tokens": 100,
+		"stream": true,
+		"reasoning": {"effort": "low"},
+		"text": {"format": {"type": "json_schema", "name": "out", "schema": {"type": "object"}, "strict": true}},
+		"tools": [
+			{"type": "function", "name": "get_weather", "parameters": {"type": "object"}},
+			{"type": "web_search"}
+		],
+		"tool_choice": {"type": "function", "name": "get_weather"},
+		"input": [
+			{"role": "developer", "content": "use tools"},
+			{"type": "message", "role": "user", "content": [
+				{"type": "input_text", "text": "what is this?"},
+				{"type": "input_image", "image_url": "data:image/png;base64,AAAA"}
+			]},
+			{"type": "reasoning", "summary": []},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{}"},
+			{"type": "function_call", "call_id": "call_2", "name": "get_weather", "arguments": "{}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "sunny"}
+		]
+	}`)
+
+	out, conversation, err := responsesToOpenAIRequest(body, store)
+	require.NoError(t, err)
+	parsed := gjson.ParseBytes(out)
+
+	assert.Equal(t, int64(100), parsed.Get("max_tokens").Int())
+	assert.True(t, parsed.Get("stream_options.include_usage").Bool())
+	assert.Equal(t, "low", parsed.Get("reasoning_effort").String())
+	assert.Equal(t, "json_schema", parsed.Get("response_format.type").String())
+	assert.True(t, parsed.Get("response_format.json_schema.strict").Bool())
+	assert.Len(t, parsed.Get("tools").Array(), 1)
+	assert.Equal(t, "get_weather", parsed.Get("tool_choice.function.name").String())
+
+	messages := parsed.Get("messages").Array()
+	require.Len(t, messages, 7)
+	assert.Equal(t, "be brief", messages[0].Get("content").String())
+	assert.Equal(t, "earlier", messages[1].Get("content").String())
+	assert.Equal(t, "reply", messages[2].Get("content").String())
+	assert.Equal(t, "system", messages[3].Get("role").String())
+	assert.Equal(t, "data:image/png;base64,AAAA", messages[4].Get("content.1.image_url.url").String())
+	assert.Equal(t, "assistant", messages[5].Get("role").String())
+	assert.Len(t, messages[5].Get("tool_calls").Array(), 2)
+	assert.Equal(t, "tool", messages[6].Get("role").String())
+	assert.Equal(t, "call_1", messages[6].Get("tool_call_id").String())
+
+	// instructions are not part of the stored conversation
+	assert.Len(t, conversation.messages, 6)
+
+	_, _, err = responsesToOpenAIRequest([]byte(`{"input": "hi", "previous_response_id": "resp_2"}`), store)
+	assert.ErrorIs(t, err, errPreviousResponseNotFound)
+
+	_, _, err = responsesToOpenAIRequest([]byte(`{"input": [{"type": "item_reference", "id": "x"}]}`), store)
+	assert.ErrorContains(t, err, `input[0]: unsupported item type "item_reference"`)
+}
+
+func TestResponsesResponseWriter_Stream(t *testing.T) {
+	store := newMemoryResponseStore(10)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	conversation := &responsesConversation{messages: []openAIMessage{{Role: "user", Content: "hi"}}}
+	w := newResponsesResponseWriter(c.Writer, "gpt", conversation, store, nil)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	chunks := []string{
+		`{"id":"c1","choices":[{"delta":{"reasoning_content":"hm"}}]}`,
+		`{"id":"c1","choices":[{"delta":{"content":"Hel"}}]}`,
+		`{"id":"c1","choices":[{"delta":{"content":"lo"}}]}`,
+		`{"id":"c1","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"f","arguments":""}}]}}]}`,
+		`{"id":"c1","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"a\":1}"}}]}}]}`,
+		`{"id":"c1","choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"c1","choices":[],"usage":{"prompt_tokens":7,"completion_tokens":3}}`,
+		`[DONE]`,
+	}
+	for _, chunk := range chunks {
+		w.Write([]byte("data: " + chunk + "\n\n"))
+	}
+	w.finish()
+
+	body := rec.Body.String()
+	assert.Equal(t, []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.reasoning_summary_part.added", "response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.done", "response.reasoning_summary_part.done", "response.output_item.done",
+		"response.output_item.added", "response.content_part.added", "response.output_text.delta", "response.output_text.delta",
+		"response.output_text.done", "response.content_part.done", "response.output_item.done",
+		"response.output_item.added", "response.function_call_arguments.delta",
+		"response.function_call_arguments.done", "response.output_item.done",
+		"response.completed",
+	}, sseEvents(body))
+
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	completed := gjson.Parse(strings.TrimPrefix(lines[len(lines)-1], "data: "))
+	assert.Equal(t, "completed", completed.Get("response.status").String())
+	assert.Equal(t, "Hello", completed.Get("response.output.1.content.0.text").String())
+	assert.Equal(t, `{"a":1}`, completed.Get("response.output.2.arguments").String())
+	assert.Equal(t, int64(10), completed.Get("response.usage.total_tokens").Int())
+	assert.Equal(t, int64(19), completed.Get("sequence_number").Int())
+
+	messages, found := store.Get(completed.Get("response.id").String())
+	require.True(t, found)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "Hello", messages[1].Content)
+	assert.Equal(t, "call_1", messages[1].ToolCalls[0].ID)
+}
+
+func TestResponsesResponseWriter_Error(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	w := newResponsesResponseWriter(c.Writer, "gpt", &responsesConversation{}, newMemoryResponseStore(10), nil)
+
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(`{"error":{"message":"context too long"}}`))
+	w.finish()
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":{"message":"context too long","type":"invalid_request_error","code":null,"param":null}}`, rec.Body.String())
+}
+
+func TestProxyManager_ResponsesTranslation(t *testing.T) {
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+sendLoadingState: true
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+    apiTranslation: [responses]
+`, simpleResponderPath)))
+	require.NoError(t, err)
+
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	var responseID string
+	t.Run("streaming includes loading state", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/responses?stream=true", bytes.NewBufferString(`{"model":"model1","stream":true,"input":"hi"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		events := sseEvents(w.Body.String())
+		require.NotEmpty(t, events)
+		assert.Equal(t, "response.created", events[0])
+		assert.Equal(t, "response.completed", events[len(events)-1])
+		assert.Contains(t, events, "response.reasoning_summary_text.delta")
+		assert.Contains(t, w.Body.String(), `"delta":"asdf"`)
+
+		metrics := proxy.metricsMonitor.getMetrics()
+		require.NotEmpty(t, metrics)
+		assert.Equal(t, 10, metrics[len(metrics)-1].OutputTokens)
+	})
+
+	t.Run("non streaming", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/responses", bytes.NewBufferString(`{"model":"model1","input":"hi"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		parsed := gjson.Parse(w.Body.String())
+		assert.Equal(t, "response", parsed.Get("object").String())
+		assert.Equal(t, "model1", parsed.Get("model").String())
+		assert.Equal(t, int64(25), parsed.Get("usage.input_tokens").Int())
+		responseID = parsed.Get("id").String()
+	})
+
+	t.Run("previous_response_id", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/responses", bytes.NewBufferString(fmt.Sprintf(`{"model":"model1","input":"again","previous_response_id":"%s"}`, responseID)))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, responseID, gjson.Get(w.Body.String(), "previous_response_id").String())
+
+		messages, found := proxy.responseStore.Get(gjson.Get(w.Body.String(), "id").String())
+		require.True(t, found)
+		require.Len(t, messages, 4)
+		assert.Equal(t, "hi", messages[0].Content)
+		assert.Equal(t, "again", messages[2].Content)
+	})
+
+	t.Run("unknown previous_response_id", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/responses", bytes.NewBufferString(`{"model":"model1","input":"hi","previous_response_id":"resp_00"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "invalid_request_error", gjson.Get(w.Body.String(), "error.type").String())
+	})
+}
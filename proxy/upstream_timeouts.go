@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+)
+
+// newUpstreamTransport returns the transport for requests to an upstream with
+// the connection and pool settings of timeouts
+func newUpstreamTransport(timeouts config.TimeoutsConfig) *http.Transport {
+	durationOr := func(value, fallback time.Duration) time.Duration {
+		if value > 0 {
+			return value
+		}
+		return fallback
+	}
+	intOr := func(value, fallback int) int {
+		if value > 0 {
+			return value
+		}
+		return fallback
+	}
+
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   durationOr(timeouts.ConnectTimeout(), 30*time.Second),
+			KeepAlive: durationOr(timeouts.KeepAliveInterval(), 30*time.Second),
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: timeouts.ResponseHeaderTimeout(),
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          intOr(timeouts.MaxIdleConns, 100),
+		MaxIdleConnsPerHost:   intOr(timeouts.MaxIdleConnsPerHost, 10),
+		MaxConnsPerHost:       timeouts.MaxConnsPerHost,
+		IdleConnTimeout:       durationOr(timeouts.IdleConnTimeoutDuration(), 90*time.Second),
+	}
+}
+
+// upstreamTimeoutError is the cancel cause of requests that ran into the idle
+// or total timeout
+type upstreamTimeoutError struct {
+	limit string
+	after time.Duration
+}
+
+func (e *upstreamTimeoutError) Error() string {
+	if e.limit == "idle" {
+		return fmt.Sprintf("upstream timeout, no response data for %v", e.after)
+	}
+	return fmt.Sprintf("upstream timeout, request took longer than %v", e.after)
+}
+
+// upstreamTimeouts cancels requests that run longer than total or whose
+// response pauses for longer than idle. Cancelling the request closes the
+// upstream connection so the server stops generating.
+type upstreamTimeouts struct {
+	idle  time.Duration
+	total time.Duration
+}
+
+func newUpstreamTimeouts(timeouts config.TimeoutsConfig) upstreamTimeouts {
+	return upstreamTimeouts{
+		idle:  timeouts.IdleTimeout(),
+		total: timeouts.TotalTimeout(),
+	}
+}
+
+// serve runs the request through handler. Timeouts before the response
+// started are reported by the ErrorHandler of the reverse proxy, a timeout of
+// a started response ends a streamed response with an error event and is
+// returned.
+func (t upstreamTimeouts) serve(handler http.Handler, w http.ResponseWriter, r *http.Request) (err error) {
+	// the idle timer can not see the traffic of upgraded connections
+	if r.Header.Get("Upgrade") != "" {
+		t.idle = 0
+	}
+	if t.idle <= 0 && t.total <= 0 {
+		handler.ServeHTTP(w, r)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	if t.total > 0 {
+		timer := time.AfterFunc(t.total, func() {
+			cancel(&upstreamTimeoutError{limit: "total", after: t.total})
+		})
+		defer timer.Stop()
+	}
+
+	tw := &timeoutResponseWriter{ResponseWriter: w, idle: t.idle, cancel: cancel}
+	defer tw.stop()
+
+	defer func() {
+		var timeout *upstreamTimeoutError
+		if !errors.As(context.Cause(ctx), &timeout) || !tw.started {
+			return
+		}
+		// the reverse proxy aborts the handler when copying the body failed
+		if rec := recover(); rec != nil && rec != http.ErrAbortHandler {
+			panic(rec)
+		}
+		writeStreamTimeout(tw, r, timeout)
+		err = timeout
+	}()
+
+	handler.ServeHTTP(tw, r.WithContext(ctx))
+	return nil
+}
+
+// timeoutResponseWriter cancels the request when the response pauses for
+// longer than idle
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+	idle    time.Duration
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	started bool
+}
+
+func (w *timeoutResponseWriter) touch() {
+	w.started = true
+	if w.idle <= 0 {
+		return
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.idle, func() {
+			w.cancel(&upstreamTimeoutError{limit: "idle", after: w.idle})
+		})
+		return
+	}
+	w.timer.Reset(w.idle)
+}
+
+func (w *timeoutResponseWriter) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+func (w *timeoutResponseWriter) WriteHeader(statusCode int) {
+	w.touch()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *timeoutResponseWriter) Write(b []byte) (int, error) {
+	w.touch()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutResponseWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// upstreamTimeoutCause returns the timeout that failed a proxied request or
+// nil when err is not a timeout
+func upstreamTimeoutCause(r *http.Request, err error) error {
+	var timeout *upstreamTimeoutError
+	if errors.As(context.Cause(r.Context()), &timeout) {
+		return timeout
+	}
+	// connect and response header timeouts of the transport
+	var netErr net.Error
+	if r.Context().Err() == nil && errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("upstream timeout: %w", err)
+	}
+	return nil
+}
+
+// upstreamTimeoutBody returns the error body in the API format of the request
+func upstreamTimeoutBody(r *http.Request, err error) gin.H {
+	if isAnthropicPath(r.URL.Path) {
+		return anthropicError(http.StatusGatewayTimeout, err.Error())
+	}
+	return openAIError(http.StatusGatewayTimeout, err.Error())
+}
+
+// writeUpstreamTimeout writes a 504 in the API format of the request
+func writeUpstreamTimeout(w http.ResponseWriter, r *http.Request, err error) {
+	body, _ := json.Marshal(upstreamTimeoutBody(r, err))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	w.Write(body)
+}
+
+// writeStreamTimeout ends a streamed response with an error event, other
+// responses can only be cut off
+func writeStreamTimeout(w http.ResponseWriter, r *http.Request, err error) {
+	if !strings.Contains(strings.ToLower(w.Header().Get("Content-Type")), "text/event-stream") {
+		return
+	}
+	event := ""
+	if isAnthropicPath(r.URL.Path) {
+		event = "error"
+	}
+	if writeSSEEvent(w, event, upstreamTimeoutBody(r, err)) == nil {
+		http.NewResponseController(w).Flush()
+	}
+}
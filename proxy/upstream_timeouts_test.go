@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+// newTimeoutPeer returns a peer proxy for upstream and a channel that is
+// closed when the upstream request is cancelled
+func newTimeoutPeer(t *testing.T, timeouts config.TimeoutsConfig, handler http.HandlerFunc) (*PeerProxy, <-chan struct{}) {
+	t.Helper()
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server notices the disconnect once the body was read
+		io.ReadAll(r.Body)
+		handler(w, r)
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	t.Cleanup(upstream.Close)
+
+	proxyURL, _ := url.Parse(upstream.URL)
+	peerProxy, err := NewPeerProxy(config.PeerDictionaryConfig{
+		"peer1": {Proxy: upstream.URL, ProxyURL: proxyURL, Models: []string{"model1"}, Timeouts: timeouts},
+	}, testLogger)
+	require.NoError(t, err)
+	return peerProxy, cancelled
+}
+
+func waitCancelled(t *testing.T, cancelled <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func TestUpstreamTimeouts_ResponseHeader(t *testing.T) {
+	peerProxy, cancelled := newTimeoutPeer(t, config.TimeoutsConfig{ResponseHeader: 1}, func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model1"}`))
+	w := httptest.NewRecorder()
+	require.NoError(t, peerProxy.ProxyRequest("model1", w, req))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, "server_error", gjson.Get(w.Body.String(), "error.type").String())
+	assert.Contains(t, gjson.Get(w.Body.String(), "error.message").String(), "timeout awaiting response headers")
+	waitCancelled(t, cancelled)
+}
+
+func TestUpstreamTimeouts_Total(t *testing.T) {
+	peerProxy, cancelled := newTimeoutPeer(t, config.TimeoutsConfig{Total: 1}, func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(`{"model":"model1"}`))
+	w := httptest.NewRecorder()
+	require.NoError(t, peerProxy.ProxyRequest("model1", w, req))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, "error", gjson.Get(w.Body.String(), "type").String(), "anthropic error format")
+	assert.Equal(t, "upstream timeout, request took longer than 1s", gjson.Get(w.Body.String(), "error.message").String())
+	waitCancelled(t, cancelled)
+}
+
+func TestUpstreamTimeouts_IdleStream(t *testing.T) {
+	peerProxy, cancelled := newTimeoutPeer(t, config.TimeoutsConfig{Idle: 1}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[]}\n\n"))
+		w.(http.Flusher).Flush()
+	})
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model1","stream":true}`))
+	w := httptest.NewRecorder()
+	require.NoError(t, peerProxy.ProxyRequest("model1", w, req))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	events := sseData(w.Body.String())
+	require.Len(t, events, 2)
+	assert.Equal(t, "upstream timeout, no response data for 1s", events[1].Get("error.message").String())
+	waitCancelled(t, cancelled)
+}
+
+func TestUpstreamTimeouts_Disabled(t *testing.T) {
+	var timeouts upstreamTimeouts
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, r.Context().Done(), "no context is added without timeouts")
+		w.Write([]byte("ok"))
+	})
+	w := httptest.NewRecorder()
+	require.NoError(t, timeouts.serve(handler, w, httptest.NewRequest("GET", "/", nil)))
+	assert.Equal(t, "ok", w.Body.String())
+}
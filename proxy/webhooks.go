@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/tidwall/gjson"
+)
+
+// webhookPayload is sent to the preRequest and postRequest webhooks
+type webhookPayload struct {
+	Event          string `json:"event"`
+	Model          string `json:"model"`
+	RequestedModel string `json:"requested_model"`
+	Path           string `json:"path"`
+
+	// identifies the API key without revealing it
+	APIKey string `json:"api_key,omitempty"`
+
+	// the request body, preRequest only
+	Body json.RawMessage `json:"body,omitempty"`
+
+	// postRequest only
+	Status     int           `json:"status,omitempty"`
+	DurationMs int64         `json:"duration_ms,omitempty"`
+	Metrics    *TokenMetrics `json:"metrics,omitempty"`
+}
+
+// webhookDecision is the reply of the preRequest webhook. An empty reply
+// allows the request unchanged.
+type webhookDecision struct {
+	// "allow" or "deny"
+	Action string `json:"action"`
+
+	// replaces the request body when set
+	Body json.RawMessage `json:"body"`
+
+	// the status and message sent to the client of a denied request
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// apiKeyIdentity returns a stable identifier for an API key that can be
+// shared with webhooks
+func apiKeyIdentity(r *http.Request) string {
+	apiKey, ok := r.Context().Value(proxyCtxKey("apiKey")).(string)
+	if !ok || apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// callWebhook posts payload to the hook and decodes the reply into reply
+// when it is not nil
+func callWebhook(ctx context.Context, hook config.WebhookConfig, payload webhookPayload, reply any) error {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout())
+	defer cancel()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if reply == nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, reply)
+}
+
+// preRequestWebhook asks the preRequest webhook about a request before it is
+// forwarded. It returns the body to forward, or false when the request was
+// rejected and a response was written. The model is already resolved so a
+// replacement body that changes the model is treated like a failed hook.
+func (pm *ProxyManager) preRequestWebhook(c *gin.Context, modelID, requestedModel string, body []byte) ([]byte, bool) {
+	hook := pm.snapshot.Load().config.WebhooksFor(modelID).PreRequest
+	if !hook.Enabled() {
+		return body, true
+	}
+
+	payload := webhookPayload{
+		Event:          "preRequest",
+		Model:          modelID,
+		RequestedModel: requestedModel,
+		Path:           c.Request.URL.Path,
+		APIKey:         apiKeyIdentity(c.Request),
+	}
+	if gjson.ValidBytes(body) {
+		payload.Body = body
+	}
+
+	var decision webhookDecision
+	if err := callWebhook(c.Request.Context(), hook, payload, &decision); err != nil {
+		if hook.FailOpen() {
+			pm.proxyLogger.Warnf("<%s> preRequest webhook failed, forwarding request: %v", modelID, err)
+			return body, true
+		}
+		pm.proxyLogger.Errorf("<%s> preRequest webhook failed, rejecting request: %v", modelID, err)
+		writeWebhookError(c, http.StatusServiceUnavailable, "request rejected, the policy service is unavailable")
+		return nil, false
+	}
+
+	switch decision.Action {
+	case "", "allow":
+	case "deny":
+		status := decision.Status
+		if status < 400 || status > 599 {
+			status = http.StatusForbidden
+		}
+		message := decision.Message
+		if message == "" {
+			message = "request denied by policy"
+		}
+		pm.proxyLogger.Infof("<%s> preRequest webhook denied request: %s", modelID, message)
+		writeWebhookError(c, status, message)
+		return nil, false
+	default:
+		pm.proxyLogger.Errorf("<%s> preRequest webhook replied with unknown action %q", modelID, decision.Action)
+		if !hook.FailOpen() {
+			writeWebhookError(c, http.StatusServiceUnavailable, "request rejected, the policy service is unavailable")
+			return nil, false
+		}
+		return body, true
+	}
+
+	if len(decision.Body) > 0 && !bytes.Equal(decision.Body, []byte("null")) {
+		if gjson.GetBytes(decision.Body, "model").String() != gjson.GetBytes(body, "model").String() {
+			pm.proxyLogger.Errorf("<%s> preRequest webhook changed the model of the request", modelID)
+			if !hook.FailOpen() {
+				writeWebhookError(c, http.StatusServiceUnavailable, "request rejected, the policy service is unavailable")
+				return nil, false
+			}
+			return body, true
+		}
+		pm.proxyLogger.Debugf("<%s> preRequest webhook rewrote the request body", modelID)
+		return decision.Body, true
+	}
+	return body, true
+}
+
+// postRequestWebhook reports a completed request without delaying the response.
+// path is the endpoint the client called, before any API translation.
+func (pm *ProxyManager) postRequestWebhook(c *gin.Context, modelID, requestedModel, path string, begin time.Time, metrics *TokenMetrics) {
+	hook := pm.snapshot.Load().config.WebhooksFor(modelID).PostRequest
+	if !hook.Enabled() {
+		return
+	}
+
+	payload := webhookPayload{
+		Event:          "postRequest",
+		Model:          modelID,
+		RequestedModel: requestedModel,
+		Path:           path,
+		APIKey:         apiKeyIdentity(c.Request),
+		Status:         c.Writer.Status(),
+		DurationMs:     time.Since(begin).Milliseconds(),
+	}
+	if metrics != nil && !metrics.Timestamp.IsZero() {
+		payload.Metrics = metrics
+	}
+
+	go func() {
+		if err := callWebhook(context.Background(), hook, payload, nil); err != nil {
+			pm.proxyLogger.Warnf("<%s> postRequest webhook failed: %v", modelID, err)
+		}
+	}()
+}
+
+// writeWebhookError writes an error in the format of the requested API
+func writeWebhookError(c *gin.Context, status int, message string) {
+	if isAnthropicPath(c.Request.URL.Path) {
+		c.JSON(status, anthropicError(status, message))
+		return
+	}
+	c.JSON(status, openAIError(status, message))
+}
@@ -0,0 +1,278 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mostlygeek/llama-swap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+// policyService is an httptest stand-in for a webhook receiver
+type policyService struct {
+	server   *httptest.Server
+	payloads chan webhookPayload
+}
+
+func newPolicyService(t *testing.T, reply func(payload webhookPayload) (int, string)) *policyService {
+	t.Helper()
+	service := &policyService{payloads: make(chan webhookPayload, 10)}
+	service.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Policy-Token"))
+		var payload webhookPayload
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &payload))
+		service.payloads <- payload
+		status, response := reply(payload)
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(service.server.Close)
+	return service
+}
+
+func (s *policyService) next(t *testing.T) webhookPayload {
+	t.Helper()
+	select {
+	case payload := <-s.payloads:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+		return webhookPayload{}
+	}
+}
+
+func newWebhookTestProxy(t *testing.T, webhooks string) *ProxyManager {
+	t.Helper()
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+apiKeys: ["sk-team-a"]
+webhooks:
+%s
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+`, webhooks, simpleResponderPath)))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	t.Cleanup(func() { proxy.StopProcesses(StopWaitForInflightRequest) })
+	return proxy
+}
+
+func webhookRequest(proxy *ProxyManager, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.Header.Set("Authorization", "Bearer sk-team-a")
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	return w.ResponseRecorder
+}
+
+func TestWebhooks_PreRequestDeny(t *testing.T) {
+	service := newPolicyService(t, func(payload webhookPayload) (int, string) {
+		return http.StatusOK, `{"action":"deny","status":402,"message":"quota exceeded"}`
+	})
+	proxy := newWebhookTestProxy(t, fmt.Sprintf(`
+  preRequest:
+    url: %s
+    headers:
+      X-Policy-Token: secret`, service.server.URL))
+
+	w := webhookRequest(proxy, "/v1/chat/completions", `{"model":"model1","messages":[{"role":"user","content":"hi"}]}`)
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Equal(t, "quota exceeded", gjson.Get(w.Body.String(), "error.message").String())
+
+	payload := service.next(t)
+	assert.Equal(t, "preRequest", payload.Event)
+	assert.Equal(t, "model1", payload.Model)
+	assert.Equal(t, "/v1/chat/completions", payload.Path)
+	assert.True(t, strings.HasPrefix(payload.APIKey, "sha256:"))
+	assert.NotContains(t, payload.APIKey, "sk-team-a")
+	assert.Equal(t, "hi", gjson.GetBytes(payload.Body, "messages.0.content").String())
+
+	w = webhookRequest(proxy, "/v1/messages", `{"model":"model1","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Equal(t, "error", gjson.Get(w.Body.String(), "type").String(), "anthropic error format")
+
+	process, _ := proxy.snapshot.Load().processGroups["model1"].GetMember("model1")
+	assert.Equal(t, StateStopped, process.CurrentState(), "denied requests do not load the model")
+}
+
+func TestWebhooks_PreRequestRewriteAndPostRequest(t *testing.T) {
+	service := newPolicyService(t, func(payload webhookPayload) (int, string) {
+		if payload.Event == "preRequest" {
+			return http.StatusOK, `{"action":"allow","body":{"model":"model1","messages":[{"role":"user","content":"rewritten"}]}}`
+		}
+		return http.StatusNoContent, ""
+	})
+	proxy := newWebhookTestProxy(t, fmt.Sprintf(`
+  preRequest:
+    url: %[1]s
+    headers:
+      X-Policy-Token: secret
+  postRequest:
+    url: %[1]s
+    headers:
+      X-Policy-Token: secret`, service.server.URL))
+
+	w := webhookRequest(proxy, "/v1/chat/completions", `{"model":"model1","messages":[{"role":"user","content":"original"}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	forwarded := gjson.Get(w.Body.String(), "request_body").String()
+	assert.Equal(t, "rewritten", gjson.Get(forwarded, "messages.0.content").String())
+
+	assert.Equal(t, "preRequest", service.next(t).Event)
+	post := service.next(t)
+	assert.Equal(t, "postRequest", post.Event)
+	assert.Equal(t, http.StatusOK, post.Status)
+	assert.Empty(t, post.Body)
+	require.NotNil(t, post.Metrics)
+	assert.Equal(t, 25, post.Metrics.InputTokens)
+	assert.Equal(t, 10, post.Metrics.OutputTokens)
+}
+
+func TestWebhooks_PreRequestModelRewrite(t *testing.T) {
+	service := newPolicyService(t, func(payload webhookPayload) (int, string) {
+		return http.StatusOK, `{"action":"allow","body":{"model":"other","messages":[]}}`
+	})
+	proxy := newWebhookTestProxy(t, fmt.Sprintf(`
+  preRequest:
+    url: %s
+    headers:
+      X-Policy-Token: secret`, service.server.URL))
+
+	w := webhookRequest(proxy, "/v1/chat/completions", `{"model":"model1","messages":[{"role":"user","content":"hi"}]}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "the model can not be changed")
+	service.next(t)
+}
+
+func TestWebhooks_PostRequestClientPath(t *testing.T) {
+	service := newPolicyService(t, func(payload webhookPayload) (int, string) {
+		return http.StatusNoContent, ""
+	})
+	cfg, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+webhooks:
+  postRequest:
+    url: %s
+    headers:
+      X-Policy-Token: secret
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond model1
+    apiTranslation: [anthropic]
+`, service.server.URL, simpleResponderPath)))
+	require.NoError(t, err)
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	webhookRequest(proxy, "/v1/messages", `{"model":"model1","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	post := service.next(t)
+	assert.Equal(t, "postRequest", post.Event)
+	assert.Equal(t, "/v1/messages", post.Path, "the path before translation")
+}
+
+func TestWebhooks_PreRequestFailMode(t *testing.T) {
+	service := newPolicyService(t, func(payload webhookPayload) (int, string) {
+		return http.StatusInternalServerError, ""
+	})
+
+	for _, tt := range []struct {
+		failMode string
+		status   int
+	}{
+		{"closed", http.StatusServiceUnavailable},
+		{"open", http.StatusOK},
+	} {
+		t.Run(tt.failMode, func(t *testing.T) {
+			proxy := newWebhookTestProxy(t, fmt.Sprintf(`
+  preRequest:
+    url: %s
+    failMode: %s
+    headers:
+      X-Policy-Token: secret`, service.server.URL, tt.failMode))
+
+			w := webhookRequest(proxy, "/v1/chat/completions", `{"model":"model1","messages":[{"role":"user","content":"hi"}]}`)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			service.next(t)
+		})
+	}
+}
+
+func TestWebhooks_PreRequestTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	service := newPolicyService(t, func(payload webhookPayload) (int, string) {
+		<-block
+		return http.StatusOK, ""
+	})
+	proxy := newWebhookTestProxy(t, fmt.Sprintf(`
+  preRequest:
+    url: %s
+    timeoutMs: 100
+    headers:
+      X-Policy-Token: secret`, service.server.URL))
+
+	begin := time.Now()
+	w := webhookRequest(proxy, "/v1/chat/completions", `{"model":"model1","messages":[{"role":"user","content":"hi"}]}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Less(t, time.Since(begin), 2*time.Second)
+}
+
+func TestWebhooks_Multipart(t *testing.T) {
+	var allow atomic.Bool
+	service := newPolicyService(t, func(payload webhookPayload) (int, string) {
+		if payload.Event == "preRequest" && !allow.Load() {
+			return http.StatusOK, `{"action":"deny","message":"no audio"}`
+		}
+		return http.StatusNoContent, ""
+	})
+	proxy := newWebhookTestProxy(t, fmt.Sprintf(`
+  preRequest:
+    url: %[1]s
+    headers:
+      X-Policy-Token: secret
+  postRequest:
+    url: %[1]s
+    headers:
+      X-Policy-Token: secret`, service.server.URL))
+
+	send := func() *httptest.ResponseRecorder {
+		form, contentType := newTestForm(t, "model", "model1", "@file", "audio")
+		req := httptest.NewRequest("POST", "/v1/audio/transcriptions", form)
+		req.Header.Set("Authorization", "Bearer sk-team-a")
+		req.Header.Set("Content-Type", contentType)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	w := send()
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "no audio", gjson.Get(w.Body.String(), "error.message").String())
+
+	pre := service.next(t)
+	assert.Equal(t, "preRequest", pre.Event)
+	assert.Equal(t, "model1", pre.Model)
+	assert.Equal(t, "/v1/audio/transcriptions", pre.Path)
+	assert.Empty(t, pre.Body, "the form is not sent to the policy service")
+
+	process, _ := proxy.snapshot.Load().processGroups["model1"].GetMember("model1")
+	assert.Equal(t, StateStopped, process.CurrentState(), "denied requests do not load the model")
+
+	allow.Store(true)
+	w = send()
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "preRequest", service.next(t).Event)
+	post := service.next(t)
+	assert.Equal(t, "postRequest", post.Event)
+	assert.Equal(t, http.StatusOK, post.Status)
+}